@@ -2,14 +2,17 @@ package main
 
 import (
 	"context"
-	"log"
+	"flag"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/Feokrat/user-balance-api/internal/server"
+	"github.com/Feokrat/user-balance-api/internal/sink"
 
+	"github.com/Feokrat/user-balance-api/internal/delivery/grpc"
 	"github.com/Feokrat/user-balance-api/internal/delivery/http"
 	"github.com/Feokrat/user-balance-api/internal/service"
 
@@ -18,53 +21,154 @@ import (
 	"github.com/Feokrat/user-balance-api/internal/database"
 
 	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
 )
 
-const configFile = "configs/config"
-
 func main() {
-	logger := log.New(os.Stdout, "logger: ", log.Lshortfile)
-	cfg, err := config.Init(configFile, logger)
+	configFile := flag.String("config", "configs/config.yml", "path to the configuration file")
+	flag.Parse()
+
+	log := logger.NewDefault()
+	cfg, err := config.Init(*configFile, log)
+	if err != nil {
+		log.Fatalf("failed to load application configuration: %s", err)
+	}
+
+	log, err = logger.New(cfg.Logger.Level, cfg.Logger.Format)
 	if err != nil {
-		logger.Fatalf("failed to load application configuration: %s", err)
+		logger.NewDefault().Fatalf("failed to create logger: %s", err)
 	}
 
-	db, err := database.NewPostgresDB(cfg.Postgresql, logger)
+	db, err := database.NewPostgresDB(cfg.Postgresql, log)
 	if err != nil {
-		logger.Fatalf("error with database: %s", err)
+		log.Fatalf("error with database: %s", err)
 	}
 	defer database.ClosePostgresDB(db)
 
-	repos := repository.NewRepositories(db, logger)
-	services := service.NewServices(repos, logger)
+	repos := repository.NewRepositories(db, log)
+	services := service.NewServices(repos, cfg, log)
+
+	if flag.Arg(0) == "reconcile" {
+		code := reconcile(flag.Args()[1:], services, log)
+		database.ClosePostgresDB(db)
+		os.Exit(code)
+	}
+
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	runWorker := func(run func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(workersCtx)
+		}()
+	}
+
+	if cfg.Outbox.Enabled {
+		eventSink, err := sink.New(cfg.Outbox.Sink, cfg.Outbox.FilePath)
+		if err != nil {
+			log.Fatalf("failed to create outbox sink: %s", err)
+		}
+		defer eventSink.Close()
 
-	handlers := http.NewHandler(services, logger)
+		sinks := sink.Multi{eventSink}
+		if cfg.Webhook.Enabled {
+			sinks = append(sinks, services.Webhook)
+
+			deliverer := service.NewWebhookDeliverer(repos.Webhook, repos.Transactor, cfg.Webhook, log)
+			runWorker(deliverer.Run)
+		}
+
+		dispatcher := service.NewOutboxDispatcher(repos.Outbox, repos.Transactor, sinks, cfg.Outbox, log)
+		runWorker(dispatcher.Run)
+	}
+
+	if cfg.Scheduler.Enabled {
+		scheduler := service.NewScheduledTransferWorker(repos.ScheduledTransfer, services.UserBalance,
+			repos.Transactor, cfg.Scheduler, log)
+		runWorker(scheduler.Run)
+	}
+
+	if cfg.Reconciliation.Enabled {
+		reconciliation := service.NewReconciliationService(repos.Reconciliation, repos.UserBalance,
+			repos.TransactionLog, repos.Transactor, cfg.Reconciliation, log)
+		runWorker(reconciliation.Run)
+	}
+
+	if cfg.Bonus.ExpiryEnabled {
+		bonusExpiry := service.NewBonusExpiryJob(repos.Bonus, services.Bonus, cfg.Bonus, log)
+		runWorker(bonusExpiry.Run)
+	}
 
-	server := server.NewHTTPserver(cfg, handlers.Init())
+	if cfg.PaymentRequests.ExpiryEnabled {
+		requestExpiry := service.NewPaymentRequestExpiryJob(repos.PaymentRequest, repos.Transactor,
+			cfg.PaymentRequests, log)
+		runWorker(requestExpiry.Run)
+	}
+
+	if cfg.PendingTransfers.ExpiryEnabled {
+		pendingExpiry := service.NewPendingTransferExpiryJob(repos.PendingTransfer, services.PendingTransfers,
+			cfg.PendingTransfers, log)
+		runWorker(pendingExpiry.Run)
+	}
+
+	if cfg.Snapshots.Enabled {
+		snapshots := service.NewBalanceSnapshotJob(repos.BalanceSnapshot, repos.Transactor, cfg.Snapshots, log)
+		runWorker(snapshots.Run)
+	}
+
+	handlers := http.NewHandler(services, cfg.Pagination, cfg.Admin, cfg.Audit, log)
+
+	httpServer := server.NewHTTPserver(cfg, handlers.Init())
+	go func() {
+		if err := httpServer.Run(); err != nil {
+			log.Errorf("error occurred while running http server: %s", err.Error())
+		}
+	}()
+
+	grpcHandlers := grpc.NewHandler(services, cfg.GRPC, cfg.Pagination, cfg.Audit, log)
+
+	grpcServer := server.NewGRPCserver(cfg, grpcHandlers.Init())
 	go func() {
-		if err := server.Run(); err != nil {
-			logger.Printf("error occurred while running http server: %s\n", err.Error())
+		if err := grpcServer.Run(); err != nil {
+			log.Errorf("error occurred while running grpc server: %s", err.Error())
 		}
 	}()
 
-	logger.Printf("Server started")
+	log.Infof("Server started")
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 	<-quit
 
-	logger.Println("Gracefully shutting down...")
+	log.Infof("Gracefully shutting down...")
 
-	const timeout = 5 * time.Second
+	services.SetShuttingDown()
+	time.Sleep(cfg.Health.ShutdownDelay)
 
-	ctx, shutdown := context.WithTimeout(context.Background(), timeout)
+	ctx, shutdown := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
 	defer shutdown()
 
-	if err := server.Stop(ctx); err != nil {
-		logger.Printf("error occurred on server shutting down: %s", err.Error())
-	}
+	var servers sync.WaitGroup
+	servers.Add(2)
+	go func() {
+		defer servers.Done()
+		if err := httpServer.Stop(ctx); err != nil {
+			log.Errorf("error occurred on http server shutting down: %s", err.Error())
+		}
+	}()
+	go func() {
+		defer servers.Done()
+		if err := grpcServer.Stop(ctx); err != nil {
+			log.Errorf("error occurred on grpc server shutting down: %s", err.Error())
+		}
+	}()
+	servers.Wait()
+
+	stopWorkers()
+	workers.Wait()
 
 	if err := db.Close(); err != nil {
-		logger.Printf("error occurred on db connection close: %s", err.Error())
+		log.Errorf("error occurred on db connection close: %s", err.Error())
 	}
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/service"
+	"github.com/google/uuid"
+)
+
+// reconcile runs a reconciliation for `reconcile [-sample N]` and prints its report as JSON. It returns
+// the exit code: 1 when the run failed, 2 when it found mismatches.
+func reconcile(args []string, services *service.Services, log logger.Logger) int {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	sample := flags.Int("sample", 0, "number of random accounts to compare, 0 compares every account")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := services.Reconcile(ctx, model.ReconciliationTriggerCLI, *sample)
+	if err != nil {
+		log.Errorf("reconciliation failed: %s", err)
+		if report.Run.Id == uuid.Nil {
+			return 1
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Errorf("could not print reconciliation report: %s", err)
+		return 1
+	}
+
+	switch {
+	case err != nil:
+		return 1
+	case report.Run.Mismatched > 0:
+		return 2
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are the parts of field names whose values are never stored, matched ignoring case.
+var sensitiveKeys = []string{"secret", "password", "token", "apikey", "authorization", "signature"}
+
+// SanitizeBody returns the JSON request body with the values of sensitive fields redacted, at any
+// depth. A body that is empty or not JSON gives nil, one whose sanitized form exceeds maxBytes gives a
+// note of its size instead.
+func SanitizeBody(body []byte, maxBytes int) json.RawMessage {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil
+	}
+
+	sanitized, err := json.Marshal(redact(value))
+	if err != nil {
+		return nil
+	}
+	if len(sanitized) > maxBytes {
+		sanitized, _ = json.Marshal(map[string]interface{}{
+			"truncated": true,
+			"size":      len(sanitized),
+		})
+	}
+
+	return sanitized
+}
+
+// TruncatedBody returns the note stored instead of a body longer than the audit keeps, which is not read to
+// the end to be sanitized. size is the length of the body when it is known and 0 otherwise.
+func TruncatedBody(size int64) json.RawMessage {
+	note := map[string]interface{}{"truncated": true}
+	if size > 0 {
+		note["size"] = size
+	}
+
+	truncated, _ := json.Marshal(note)
+	return truncated
+}
+
+func redact(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if sensitive(key) {
+				value[key] = redacted
+			} else {
+				value[key] = redact(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redact(item)
+		}
+	}
+
+	return value
+}
+
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, part := range sensitiveKeys {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		maxBytes int
+		want     string
+	}{
+		{
+			name:     "redacts sensitive fields at any depth",
+			body:     `{"url":"https://example.com","secret":"s3cr3t","nested":[{"apiKey":"k","amount":10.50}]}`,
+			maxBytes: 1024,
+			want:     `{"url":"https://example.com","secret":"[REDACTED]","nested":[{"apiKey":"[REDACTED]","amount":10.50}]}`,
+		},
+		{
+			name:     "keeps other fields",
+			body:     `{"idempotencyKey":"batch-1","operatorId":"ops"}`,
+			maxBytes: 1024,
+			want:     `{"idempotencyKey":"batch-1","operatorId":"ops"}`,
+		},
+		{
+			name:     "notes the size of big bodies",
+			body:     `{"commentary":"0123456789"}`,
+			maxBytes: 10,
+			want:     `{"size":27,"truncated":true}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.JSONEq(t, tt.want, string(SanitizeBody([]byte(tt.body), tt.maxBytes)))
+		})
+	}
+
+	assert.Nil(t, SanitizeBody(nil, 1024))
+	assert.Nil(t, SanitizeBody([]byte("  "), 1024))
+	assert.Nil(t, SanitizeBody([]byte("userId=1"), 1024))
+}
+
+func TestTruncatedBody(t *testing.T) {
+	assert.JSONEq(t, `{"size":4096,"truncated":true}`, string(TruncatedBody(4096)))
+	// chunked bodies come without a length
+	assert.JSONEq(t, `{"truncated":true}`, string(TruncatedBody(-1)))
+}
@@ -0,0 +1,98 @@
+// Package audit collects what an API call did while it is handled, so the call can be recorded
+// in the audit log once it is done.
+package audit
+
+import (
+	"context"
+	"sync"
+)
+
+type trailKey struct{}
+
+// Trail collects the transaction log entries created while handling a call. The entries of a
+// database transaction go to a pending trail, merged into its parent only when the transaction
+// commits, so rolled back entries are never linked to the call. Every method is safe to call on
+// a nil trail, which collects nothing.
+type Trail struct {
+	mu                sync.Mutex
+	parent            *Trail
+	admin             bool
+	transactionLogIds []int32
+}
+
+func NewTrail() *Trail {
+	return &Trail{}
+}
+
+// ContextWithTrail returns a copy of ctx carrying the given trail.
+func ContextWithTrail(ctx context.Context, trail *Trail) context.Context {
+	return context.WithValue(ctx, trailKey{}, trail)
+}
+
+// FromContext returns the trail stored in ctx or nil.
+func FromContext(ctx context.Context) *Trail {
+	trail, _ := ctx.Value(trailKey{}).(*Trail)
+	return trail
+}
+
+// MarkAdmin records that the call was authorized with the admin key.
+func (t *Trail) MarkAdmin() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.admin = true
+}
+
+func (t *Trail) Admin() bool {
+	if t == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.admin
+}
+
+func (t *Trail) AddTransactionLog(id int32) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.transactionLogIds = append(t.transactionLogIds, id)
+}
+
+// TransactionLogIds returns the ids of the collected entries in creation order.
+func (t *Trail) TransactionLogIds() []int32 {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]int32(nil), t.transactionLogIds...)
+}
+
+// Pending returns a trail collecting the entries of a transaction until Commit.
+func (t *Trail) Pending() *Trail {
+	if t == nil {
+		return nil
+	}
+
+	return &Trail{parent: t}
+}
+
+// Commit merges the entries of a pending trail into its parent.
+func (t *Trail) Commit() {
+	if t == nil || t.parent == nil {
+		return
+	}
+
+	for _, id := range t.TransactionLogIds() {
+		t.parent.AddTransactionLog(id)
+	}
+}
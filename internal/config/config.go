@@ -1,68 +1,696 @@
 package config
 
 import (
-	"log"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/google/uuid"
 	_ "github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
+const (
+	envPrefix = "UBA"
+	// fileEnvSuffix marks env vars holding a path to a file with the value, e.g. UBA_POSTGRES_PASSWORD_FILE.
+	fileEnvSuffix = "_FILE"
+)
+
 type (
 	Config struct {
-		HTTP       HTTPConfig
-		Postgresql PGConfig
+		HTTP             HTTPConfig             `mapstructure:"http"`
+		GRPC             GRPCConfig             `mapstructure:"grpc"`
+		Postgresql       PGConfig               `mapstructure:"postgres"`
+		Logger           LoggerConfig           `mapstructure:"logger"`
+		Health           HealthConfig           `mapstructure:"health"`
+		ExchangeRate     ExchangeRateConfig     `mapstructure:"exchangeRate"`
+		Pagination       PaginationConfig       `mapstructure:"pagination"`
+		Outbox           OutboxConfig           `mapstructure:"outbox"`
+		Webhook          WebhookConfig          `mapstructure:"webhook"`
+		Batch            BatchConfig            `mapstructure:"batch"`
+		Scheduler        SchedulerConfig        `mapstructure:"scheduler"`
+		Admin            AdminConfig            `mapstructure:"admin"`
+		Limits           LimitsConfig           `mapstructure:"limits"`
+		Fees             FeesConfig             `mapstructure:"fees"`
+		Bonus            BonusConfig            `mapstructure:"bonus"`
+		PaymentRequests  PaymentRequestsConfig  `mapstructure:"paymentRequests"`
+		PendingTransfers PendingTransfersConfig `mapstructure:"pendingTransfers"`
+		Escrow           EscrowConfig           `mapstructure:"escrow"`
+		Overdraft        OverdraftConfig        `mapstructure:"overdraft"`
+		Accounts         AccountsConfig         `mapstructure:"accounts"`
+		Snapshots        SnapshotsConfig        `mapstructure:"snapshots"`
+		Reconciliation   ReconciliationConfig   `mapstructure:"reconciliation"`
+		Audit            AuditConfig            `mapstructure:"audit"`
 	}
 
 	HTTPConfig struct {
+		Host            string        `mapstructure:"host"`
+		Port            string        `mapstructure:"port"`
+		ReadTimeout     time.Duration `mapstructure:"readTimeout"`
+		WriteTimeout    time.Duration `mapstructure:"writeTimeout"`
+		IdleTimeout     time.Duration `mapstructure:"idleTimeout"`
+		ShutdownTimeout time.Duration `mapstructure:"shutdownTimeout"`
+	}
+
+	GRPCConfig struct {
 		Host string `mapstructure:"host"`
 		Port string `mapstructure:"port"`
+		// Reflection exposes the server reflection service used by tools like grpcurl.
+		Reflection bool `mapstructure:"reflection"`
 	}
 
 	PGConfig struct {
+		// URL is a full DSN or postgres:// URL used instead of the individual connection fields.
+		URL      string `mapstructure:"url"`
 		Host     string `mapstructure:"host"`
 		Port     string `mapstructure:"port"`
 		Username string `mapstructure:"user"`
 		Password string `mapstructure:"password"`
 		DBName   string `mapstructure:"dbname"`
 		SSLMode  string `mapstructure:"ssl"`
+
+		ApplicationName  string        `mapstructure:"applicationName"`
+		StatementTimeout time.Duration `mapstructure:"statementTimeout"`
+		MaxOpenConns     int           `mapstructure:"maxOpenConns"`
+		MaxIdleConns     int           `mapstructure:"maxIdleConns"`
+		ConnMaxLifetime  time.Duration `mapstructure:"connMaxLifetime"`
+		ConnMaxIdleTime  time.Duration `mapstructure:"connMaxIdleTime"`
+
+		// ConnectTimeout bounds how long startup keeps retrying to reach the database.
+		ConnectTimeout       time.Duration `mapstructure:"connectTimeout"`
+		RetryInitialInterval time.Duration `mapstructure:"retryInitialInterval"`
+		RetryMaxInterval     time.Duration `mapstructure:"retryMaxInterval"`
+	}
+
+	HealthConfig struct {
+		CheckTimeout  time.Duration `mapstructure:"checkTimeout"`
+		ShutdownDelay time.Duration `mapstructure:"shutdownDelay"`
+	}
+
+	LoggerConfig struct {
+		Level  string `mapstructure:"level"`
+		Format string `mapstructure:"format"`
+	}
+
+	ExchangeRateConfig struct {
+		URL          string        `mapstructure:"url"`
+		APIKey       string        `mapstructure:"apiKey"`
+		BaseCurrency string        `mapstructure:"baseCurrency"`
+		Timeout      time.Duration `mapstructure:"timeout"`
+	}
+
+	PaginationConfig struct {
+		DefaultPageSize int `mapstructure:"defaultPageSize"`
+		MaxPageSize     int `mapstructure:"maxPageSize"`
+	}
+
+	OutboxConfig struct {
+		Enabled      bool          `mapstructure:"enabled"`
+		PollInterval time.Duration `mapstructure:"pollInterval"`
+		BatchSize    int           `mapstructure:"batchSize"`
+		// Sink is either stdout or file.
+		Sink     string `mapstructure:"sink"`
+		FilePath string `mapstructure:"filePath"`
+	}
+
+	WebhookConfig struct {
+		Enabled        bool          `mapstructure:"enabled"`
+		PollInterval   time.Duration `mapstructure:"pollInterval"`
+		BatchSize      int           `mapstructure:"batchSize"`
+		RequestTimeout time.Duration `mapstructure:"requestTimeout"`
+		// MaxAttempts is the number of attempts after which a delivery is moved to the dead state.
+		MaxAttempts    int           `mapstructure:"maxAttempts"`
+		InitialBackoff time.Duration `mapstructure:"initialBackoff"`
+		MaxBackoff     time.Duration `mapstructure:"maxBackoff"`
+	}
+
+	BatchConfig struct {
+		// MaxSize is the maximum number of operations in a batch.
+		MaxSize int `mapstructure:"maxSize"`
+	}
+
+	SchedulerConfig struct {
+		Enabled      bool          `mapstructure:"enabled"`
+		PollInterval time.Duration `mapstructure:"pollInterval"`
+		BatchSize    int           `mapstructure:"batchSize"`
+		// InsufficientFunds is retry, to try an occurrence again after RetryInterval up to MaxRetries
+		// times, or skip, to give it up and wait for the next one.
+		InsufficientFunds string        `mapstructure:"insufficientFunds"`
+		RetryInterval     time.Duration `mapstructure:"retryInterval"`
+		MaxRetries        int           `mapstructure:"maxRetries"`
+	}
+
+	LimitsConfig struct {
+		// DefaultTier is the tier of users without a tier of their own.
+		DefaultTier string `mapstructure:"defaultTier"`
+		// Timezone is where daily and monthly outgoing totals reset at midnight.
+		Timezone string                     `mapstructure:"timezone"`
+		Tiers    map[string]LimitTierConfig `mapstructure:"tiers"`
+	}
+
+	// LimitTierConfig holds the limits of a tier, a zero limit is no limit.
+	LimitTierConfig struct {
+		MaxTransfer      float64 `mapstructure:"maxTransfer"`
+		DailyOutgoing    float64 `mapstructure:"dailyOutgoing"`
+		MonthlyOutgoing  float64 `mapstructure:"monthlyOutgoing"`
+		MaxBalance       float64 `mapstructure:"maxBalance"`
+		TransfersPerHour int     `mapstructure:"transfersPerHour"`
+	}
+
+	FeesConfig struct {
+		// RevenueAccounts maps a currency to the id of the account collecting the fees charged in it.
+		RevenueAccounts map[string]string `mapstructure:"revenueAccounts"`
+		Rules           []FeeRuleConfig   `mapstructure:"rules"`
+	}
+
+	// FeeRuleConfig is the fee of an operation from EffectiveFrom until EffectiveTo: Flat plus Percent of
+	// the amount, kept between Min and Max, a zero Max is no cap. Users of FreeTiers are not charged.
+	FeeRuleConfig struct {
+		Name string `mapstructure:"name"`
+		// Operation is transfer or withdrawal.
+		Operation string   `mapstructure:"operation"`
+		Percent   float64  `mapstructure:"percent"`
+		Flat      float64  `mapstructure:"flat"`
+		Min       float64  `mapstructure:"min"`
+		Max       float64  `mapstructure:"max"`
+		FreeTiers []string `mapstructure:"freeTiers"`
+		// EffectiveFrom and EffectiveTo are RFC3339 times, an empty EffectiveTo keeps the rule in effect
+		// until a rule with a later EffectiveFrom replaces it.
+		EffectiveFrom string `mapstructure:"effectiveFrom"`
+		EffectiveTo   string `mapstructure:"effectiveTo"`
+	}
+
+	BonusConfig struct {
+		// SpendOrder is the order debits spend the bonus grants of a user in, all before the real funds:
+		// expiringFirst or grantedFirst.
+		SpendOrder string `mapstructure:"spendOrder"`
+		// ExpiryEnabled takes back what is left of expired grants every PollInterval, BatchSize users
+		// per round.
+		ExpiryEnabled bool          `mapstructure:"expiryEnabled"`
+		PollInterval  time.Duration `mapstructure:"pollInterval"`
+		BatchSize     int           `mapstructure:"batchSize"`
+	}
+
+	PaymentRequestsConfig struct {
+		// DefaultExpiry is how long a payment request created without an expiry stays pending, MaxExpiry
+		// bounds the expiry it can be given.
+		DefaultExpiry time.Duration `mapstructure:"defaultExpiry"`
+		MaxExpiry     time.Duration `mapstructure:"maxExpiry"`
+		// ExpiryEnabled marks pending requests expired every PollInterval, BatchSize per round.
+		ExpiryEnabled bool          `mapstructure:"expiryEnabled"`
+		PollInterval  time.Duration `mapstructure:"pollInterval"`
+		BatchSize     int           `mapstructure:"batchSize"`
+	}
+
+	PendingTransfersConfig struct {
+		// TTL is how long a pending transfer stays authorized before it is voided.
+		TTL time.Duration `mapstructure:"ttl"`
+		// ExpiryEnabled voids pending transfers past their TTL every PollInterval, BatchSize per round.
+		ExpiryEnabled bool          `mapstructure:"expiryEnabled"`
+		PollInterval  time.Duration `mapstructure:"pollInterval"`
+		BatchSize     int           `mapstructure:"batchSize"`
+	}
+
+	EscrowConfig struct {
+		// Accounts maps a currency to the id of the account holding the money escrowed in it.
+		Accounts map[string]string `mapstructure:"accounts"`
+	}
+
+	OverdraftConfig struct {
+		// GracePeriod is how long an overdrawn balance stays interest-free, counted from when it went
+		// below zero.
+		GracePeriod time.Duration `mapstructure:"gracePeriod"`
+	}
+
+	AccountsConfig struct {
+		// ImplicitCreate keeps the legacy behaviour of opening an account on the first credit of an
+		// unknown user and reporting a zero balance for users without an account.
+		ImplicitCreate bool `mapstructure:"implicitCreate"`
+		// DefaultCurrency is the currency of accounts opened without one.
+		DefaultCurrency string `mapstructure:"defaultCurrency"`
+	}
+
+	SnapshotsConfig struct {
+		Enabled bool `mapstructure:"enabled"`
+		// Cutoff is the time of day, counted from midnight in Timezone, at which a day closes: every
+		// transaction logged up to it belongs to that day. 24h closes days at midnight.
+		Cutoff   time.Duration `mapstructure:"cutoff"`
+		Timezone string        `mapstructure:"timezone"`
+		// Delay postpones the snapshot after the cut-off so transactions still in flight at the cut-off
+		// are committed before it is taken.
+		Delay        time.Duration `mapstructure:"delay"`
+		PollInterval time.Duration `mapstructure:"pollInterval"`
+		// BackfillDays is how many days before the last closed one are snapshotted when there are no
+		// snapshots yet; after that every day missed while no instance ran is backfilled.
+		BackfillDays int `mapstructure:"backfillDays"`
+	}
+
+	ReconciliationConfig struct {
+		// Enabled runs a reconciliation every Interval, instances checking every PollInterval whether one
+		// is due.
+		Enabled      bool          `mapstructure:"enabled"`
+		Interval     time.Duration `mapstructure:"interval"`
+		PollInterval time.Duration `mapstructure:"pollInterval"`
+		// SampleSize is how many random accounts scheduled runs compare, 0 compares every account.
+		SampleSize int `mapstructure:"sampleSize"`
+		// BatchSize is how many accounts are compared per query.
+		BatchSize int `mapstructure:"batchSize"`
+	}
+
+	AuditConfig struct {
+		// Enabled records every mutating API call in the audit log.
+		Enabled bool `mapstructure:"enabled"`
+		// MaxBodyBytes is the largest sanitized request body stored with an entry, bigger bodies are
+		// replaced by a note of their size.
+		MaxBodyBytes int `mapstructure:"maxBodyBytes"`
+	}
+
+	AdminConfig struct {
+		// APIKey authorizes the /api/v1/admin endpoints, which are disabled while it is empty.
+		APIKey string `mapstructure:"apiKey"`
 	}
 )
 
-func Init(path string, logger *log.Logger) (*Config, error) {
+// Init reads the configuration file at path, applies UBA_* env overrides and secrets from
+// UBA_*_FILE files and validates the result.
+func Init(path string, logger logger.Logger) (*Config, error) {
+	setDefaults()
+
 	if err := parseConfigFile(path); err != nil {
-		logger.Printf("failed to parse path to config file: %s", err)
+		logger.Errorf("failed to parse path to config file: %s", err)
+		return nil, err
+	}
+
+	if err := loadSecretFiles(); err != nil {
+		logger.Errorf("failed to load secrets from files: %s", err)
 		return nil, err
 	}
 
 	var cfg Config
 	if err := unmarshal(&cfg, logger); err != nil {
-		logger.Printf("failed to unmarshal config: %s", err)
+		logger.Errorf("failed to unmarshal config: %s", err)
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
 
 	return &cfg, nil
 }
 
-func unmarshal(cfg *Config, logger *log.Logger) error {
-	if err := viper.UnmarshalKey("http", &cfg.HTTP); err != nil {
-		logger.Printf("failed to unmarshal http key in config: %s", err)
+// unmarshal decodes all settings at once, as unmarshalling a single key would skip
+// env overrides of its nested keys.
+func unmarshal(cfg *Config, logger logger.Logger) error {
+	if err := viper.Unmarshal(cfg); err != nil {
+		logger.Errorf("failed to unmarshal settings: %s", err)
 		return err
 	}
 
-	if err := viper.UnmarshalKey("postgres", &cfg.Postgresql); err != nil {
-		logger.Printf("failed to unmarshal postgres key in config: %s", err)
-		return err
+	return nil
+}
+
+// setDefaults registers every key, which also makes it overridable by env vars when the
+// config file does not mention it.
+func setDefaults() {
+	viper.SetDefault("http.host", "0.0.0.0")
+	viper.SetDefault("http.port", "8080")
+	viper.SetDefault("http.readTimeout", 10*time.Second)
+	viper.SetDefault("http.writeTimeout", 10*time.Second)
+	viper.SetDefault("http.idleTimeout", 60*time.Second)
+	viper.SetDefault("http.shutdownTimeout", 5*time.Second)
+
+	viper.SetDefault("grpc.host", "0.0.0.0")
+	viper.SetDefault("grpc.port", "9000")
+	viper.SetDefault("grpc.reflection", true)
+
+	viper.SetDefault("postgres.url", "")
+	viper.SetDefault("postgres.host", "localhost")
+	viper.SetDefault("postgres.port", "5432")
+	viper.SetDefault("postgres.user", "")
+	viper.SetDefault("postgres.password", "")
+	viper.SetDefault("postgres.dbname", "")
+	viper.SetDefault("postgres.ssl", "disable")
+	viper.SetDefault("postgres.applicationName", "user-balance-api")
+	viper.SetDefault("postgres.statementTimeout", 30*time.Second)
+	viper.SetDefault("postgres.maxOpenConns", 25)
+	viper.SetDefault("postgres.maxIdleConns", 25)
+	viper.SetDefault("postgres.connMaxLifetime", 30*time.Minute)
+	viper.SetDefault("postgres.connMaxIdleTime", 5*time.Minute)
+	viper.SetDefault("postgres.connectTimeout", 30*time.Second)
+	viper.SetDefault("postgres.retryInitialInterval", 500*time.Millisecond)
+	viper.SetDefault("postgres.retryMaxInterval", 5*time.Second)
+
+	viper.SetDefault("logger.level", "info")
+	viper.SetDefault("logger.format", "json")
+
+	viper.SetDefault("health.checkTimeout", 2*time.Second)
+	viper.SetDefault("health.shutdownDelay", 5*time.Second)
+
+	viper.SetDefault("exchangeRate.url", "https://free.currconv.com/api/v7/convert")
+	viper.SetDefault("exchangeRate.apiKey", "")
+	viper.SetDefault("exchangeRate.baseCurrency", "RUB")
+	viper.SetDefault("exchangeRate.timeout", 5*time.Second)
+
+	viper.SetDefault("pagination.defaultPageSize", 1000)
+	viper.SetDefault("pagination.maxPageSize", 1000)
+
+	viper.SetDefault("outbox.enabled", true)
+	viper.SetDefault("outbox.pollInterval", time.Second)
+	viper.SetDefault("outbox.batchSize", 100)
+	viper.SetDefault("outbox.sink", "stdout")
+	viper.SetDefault("outbox.filePath", "")
+
+	viper.SetDefault("webhook.enabled", true)
+	viper.SetDefault("webhook.pollInterval", time.Second)
+	viper.SetDefault("webhook.batchSize", 50)
+	viper.SetDefault("webhook.requestTimeout", 5*time.Second)
+	viper.SetDefault("webhook.maxAttempts", 8)
+	viper.SetDefault("webhook.initialBackoff", 10*time.Second)
+	viper.SetDefault("webhook.maxBackoff", time.Hour)
+
+	viper.SetDefault("batch.maxSize", 100)
+
+	viper.SetDefault("scheduler.enabled", true)
+	viper.SetDefault("scheduler.pollInterval", 10*time.Second)
+	viper.SetDefault("scheduler.batchSize", 50)
+	viper.SetDefault("scheduler.insufficientFunds", "retry")
+	viper.SetDefault("scheduler.retryInterval", time.Hour)
+	viper.SetDefault("scheduler.maxRetries", 3)
+
+	viper.SetDefault("admin.apiKey", "")
+
+	viper.SetDefault("limits.defaultTier", "standard")
+	viper.SetDefault("limits.timezone", "UTC")
+	viper.SetDefault("limits.tiers", map[string]interface{}{
+		"standard": map[string]interface{}{
+			"maxTransfer":      0,
+			"dailyOutgoing":    0,
+			"monthlyOutgoing":  0,
+			"maxBalance":       0,
+			"transfersPerHour": 0,
+		},
+	})
+
+	viper.SetDefault("fees.revenueAccounts", map[string]interface{}{})
+	viper.SetDefault("fees.rules", []interface{}{})
+
+	viper.SetDefault("bonus.spendOrder", "expiringFirst")
+	viper.SetDefault("bonus.expiryEnabled", true)
+	viper.SetDefault("bonus.pollInterval", time.Minute)
+	viper.SetDefault("bonus.batchSize", 100)
+
+	viper.SetDefault("paymentRequests.defaultExpiry", 7*24*time.Hour)
+	viper.SetDefault("paymentRequests.maxExpiry", 30*24*time.Hour)
+	viper.SetDefault("paymentRequests.expiryEnabled", true)
+	viper.SetDefault("paymentRequests.pollInterval", time.Minute)
+	viper.SetDefault("paymentRequests.batchSize", 100)
+
+	viper.SetDefault("pendingTransfers.ttl", 7*24*time.Hour)
+	viper.SetDefault("pendingTransfers.expiryEnabled", true)
+	viper.SetDefault("pendingTransfers.pollInterval", time.Minute)
+	viper.SetDefault("pendingTransfers.batchSize", 100)
+
+	viper.SetDefault("escrow.accounts", map[string]interface{}{})
+
+	viper.SetDefault("overdraft.gracePeriod", 30*24*time.Hour)
+
+	viper.SetDefault("accounts.implicitCreate", false)
+	viper.SetDefault("accounts.defaultCurrency", "RUB")
+
+	viper.SetDefault("snapshots.enabled", true)
+	viper.SetDefault("snapshots.cutoff", 24*time.Hour)
+	viper.SetDefault("snapshots.timezone", "UTC")
+	viper.SetDefault("snapshots.delay", time.Minute)
+	viper.SetDefault("snapshots.pollInterval", time.Minute)
+	viper.SetDefault("snapshots.backfillDays", 31)
+
+	viper.SetDefault("reconciliation.enabled", true)
+	viper.SetDefault("reconciliation.interval", 24*time.Hour)
+	viper.SetDefault("reconciliation.pollInterval", 5*time.Minute)
+	viper.SetDefault("reconciliation.sampleSize", 0)
+	viper.SetDefault("reconciliation.batchSize", 1000)
+
+	viper.SetDefault("audit.enabled", true)
+	viper.SetDefault("audit.maxBodyBytes", 16384)
+}
+
+func parseConfigFile(path string) error {
+	viper.SetConfigFile(path)
+
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
+	return viper.ReadInConfig()
+}
+
+// loadSecretFiles overrides a key with the content of the file named by its
+// UBA_<KEY>_FILE env var, e.g. UBA_POSTGRES_PASSWORD_FILE=/run/secrets/pg_password.
+func loadSecretFiles() error {
+	for _, key := range viper.AllKeys() {
+		envKey := envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_")) + fileEnvSuffix
+
+		path, ok := os.LookupEnv(envKey)
+		if !ok || path == "" {
+			continue
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read %s from %s: %w", key, envKey, err)
+		}
+
+		viper.Set(key, strings.TrimSpace(string(content)))
 	}
 
 	return nil
 }
 
-func parseConfigFile(filepath string) error {
-	path := strings.Split(filepath, "/")
+// ValidationError lists every invalid or missing configuration field.
+type ValidationError struct {
+	Problems []string
+}
 
-	viper.AddConfigPath(path[0])
-	viper.SetConfigName(path[1])
+func (e ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
 
-	return viper.ReadInConfig()
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(validPort(c.HTTP.Port), "http.port %q is not a valid port", c.HTTP.Port)
+	check(c.HTTP.ReadTimeout > 0, "http.readTimeout must be positive")
+	check(c.HTTP.WriteTimeout > 0, "http.writeTimeout must be positive")
+	check(c.HTTP.IdleTimeout > 0, "http.idleTimeout must be positive")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdownTimeout must be positive")
+
+	check(validPort(c.GRPC.Port), "grpc.port %q is not a valid port", c.GRPC.Port)
+	check(c.GRPC.Port != c.HTTP.Port || c.GRPC.Host != c.HTTP.Host, "grpc.port must differ from http.port")
+
+	if c.Postgresql.URL == "" {
+		check(c.Postgresql.Host != "", "postgres.host is required")
+		check(validPort(c.Postgresql.Port), "postgres.port %q is not a valid port", c.Postgresql.Port)
+		check(c.Postgresql.Username != "", "postgres.user is required")
+		check(c.Postgresql.Password != "", "postgres.password is required")
+		check(c.Postgresql.DBName != "", "postgres.dbname is required")
+		check(oneOf(c.Postgresql.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full"),
+			"postgres.ssl %q is not a valid sslmode", c.Postgresql.SSLMode)
+	}
+	check(c.Postgresql.StatementTimeout >= 0, "postgres.statementTimeout must not be negative")
+	check(c.Postgresql.MaxOpenConns >= 0, "postgres.maxOpenConns must not be negative")
+	check(c.Postgresql.MaxIdleConns >= 0, "postgres.maxIdleConns must not be negative")
+	check(c.Postgresql.MaxOpenConns == 0 || c.Postgresql.MaxIdleConns <= c.Postgresql.MaxOpenConns,
+		"postgres.maxIdleConns must not exceed postgres.maxOpenConns")
+	check(c.Postgresql.ConnMaxLifetime >= 0, "postgres.connMaxLifetime must not be negative")
+	check(c.Postgresql.ConnMaxIdleTime >= 0, "postgres.connMaxIdleTime must not be negative")
+	check(c.Postgresql.ConnectTimeout > 0, "postgres.connectTimeout must be positive")
+	check(c.Postgresql.RetryInitialInterval > 0, "postgres.retryInitialInterval must be positive")
+	check(c.Postgresql.RetryMaxInterval >= c.Postgresql.RetryInitialInterval,
+		"postgres.retryMaxInterval must not be less than postgres.retryInitialInterval")
+
+	check(oneOf(c.Logger.Level, "trace", "debug", "info", "warn", "warning", "error", "fatal", "panic"),
+		"logger.level %q is not a valid level", c.Logger.Level)
+	check(oneOf(c.Logger.Format, logger.FormatJSON, logger.FormatText),
+		"logger.format %q must be json or text", c.Logger.Format)
+
+	check(c.Health.CheckTimeout > 0, "health.checkTimeout must be positive")
+	check(c.Health.ShutdownDelay >= 0, "health.shutdownDelay must not be negative")
+
+	exchangeURL, err := url.Parse(c.ExchangeRate.URL)
+	check(err == nil && exchangeURL.Scheme != "" && exchangeURL.Host != "",
+		"exchangeRate.url %q is not a valid url", c.ExchangeRate.URL)
+	check(c.ExchangeRate.APIKey != "", "exchangeRate.apiKey is required")
+	check(len(c.ExchangeRate.BaseCurrency) == 3, "exchangeRate.baseCurrency %q is not a currency code",
+		c.ExchangeRate.BaseCurrency)
+	check(c.ExchangeRate.Timeout > 0, "exchangeRate.timeout must be positive")
+
+	check(c.Pagination.DefaultPageSize > 0, "pagination.defaultPageSize must be positive")
+	check(c.Pagination.MaxPageSize >= c.Pagination.DefaultPageSize,
+		"pagination.maxPageSize must not be less than pagination.defaultPageSize")
+
+	if c.Outbox.Enabled {
+		check(c.Outbox.PollInterval > 0, "outbox.pollInterval must be positive")
+		check(c.Outbox.BatchSize > 0, "outbox.batchSize must be positive")
+		check(oneOf(c.Outbox.Sink, "stdout", "file"), "outbox.sink %q must be stdout or file", c.Outbox.Sink)
+		check(c.Outbox.Sink != "file" || c.Outbox.FilePath != "", "outbox.filePath is required for the file sink")
+	}
+
+	if c.Webhook.Enabled {
+		check(c.Outbox.Enabled, "webhook.enabled requires outbox.enabled")
+		check(c.Webhook.PollInterval > 0, "webhook.pollInterval must be positive")
+		check(c.Webhook.BatchSize > 0, "webhook.batchSize must be positive")
+		check(c.Webhook.RequestTimeout > 0, "webhook.requestTimeout must be positive")
+		check(c.Webhook.MaxAttempts > 0, "webhook.maxAttempts must be positive")
+		check(c.Webhook.InitialBackoff > 0, "webhook.initialBackoff must be positive")
+		check(c.Webhook.MaxBackoff >= c.Webhook.InitialBackoff,
+			"webhook.maxBackoff must not be less than webhook.initialBackoff")
+	}
+
+	check(c.Batch.MaxSize > 0, "batch.maxSize must be positive")
+
+	if c.Scheduler.Enabled {
+		check(c.Scheduler.PollInterval > 0, "scheduler.pollInterval must be positive")
+		check(c.Scheduler.BatchSize > 0, "scheduler.batchSize must be positive")
+		check(oneOf(c.Scheduler.InsufficientFunds, "retry", "skip"),
+			"scheduler.insufficientFunds %q must be retry or skip", c.Scheduler.InsufficientFunds)
+		if c.Scheduler.InsufficientFunds == "retry" {
+			check(c.Scheduler.RetryInterval > 0, "scheduler.retryInterval must be positive")
+			check(c.Scheduler.MaxRetries > 0, "scheduler.maxRetries must be positive")
+		}
+	}
+
+	_, ok := c.Limits.Tiers[c.Limits.DefaultTier]
+	check(ok, "limits.defaultTier %q is not one of limits.tiers", c.Limits.DefaultTier)
+	_, err = time.LoadLocation(c.Limits.Timezone)
+	check(err == nil, "limits.timezone %q is not a valid timezone", c.Limits.Timezone)
+	for name, tier := range c.Limits.Tiers {
+		check(tier.MaxTransfer >= 0 && tier.DailyOutgoing >= 0 && tier.MonthlyOutgoing >= 0 &&
+			tier.MaxBalance >= 0 && tier.TransfersPerHour >= 0, "limits.tiers.%s must not be negative", name)
+	}
+
+	for currency, accountId := range c.Fees.RevenueAccounts {
+		check(validCurrency(strings.ToUpper(currency)), "fees.revenueAccounts key %q is not a currency code",
+			currency)
+		_, err = uuid.Parse(accountId)
+		check(err == nil, "fees.revenueAccounts.%s %q is not a valid account id", currency, accountId)
+	}
+	check(len(c.Fees.Rules) == 0 || len(c.Fees.RevenueAccounts) > 0,
+		"fees.revenueAccounts must not be empty when there are fee rules")
+	ruleNames := map[string]bool{}
+	for i, rule := range c.Fees.Rules {
+		check(rule.Name != "" && !ruleNames[rule.Name], "fees.rules[%d].name must be set and unique", i)
+		ruleNames[rule.Name] = true
+		check(oneOf(rule.Operation, "transfer", "withdrawal"),
+			"fees.rules[%d].operation must be transfer or withdrawal, got %q", i, rule.Operation)
+		check(rule.Percent >= 0 && rule.Percent <= 100, "fees.rules[%d].percent must be between 0 and 100", i)
+		check(rule.Flat >= 0 && rule.Min >= 0 && rule.Max >= 0, "fees.rules[%d] must not be negative", i)
+		check(rule.Max == 0 || rule.Max >= rule.Min, "fees.rules[%d].max must not be below its min", i)
+		for _, tier := range rule.FreeTiers {
+			_, ok := c.Limits.Tiers[strings.ToLower(tier)]
+			check(ok, "fees.rules[%d].freeTiers %q is not one of limits.tiers", i, tier)
+		}
+		from, err := time.Parse(time.RFC3339, rule.EffectiveFrom)
+		check(err == nil, "fees.rules[%d].effectiveFrom %q is not an RFC3339 time", i, rule.EffectiveFrom)
+		if rule.EffectiveTo != "" {
+			to, err := time.Parse(time.RFC3339, rule.EffectiveTo)
+			check(err == nil && to.After(from), "fees.rules[%d].effectiveTo %q is not an RFC3339 time after "+
+				"its effectiveFrom", i, rule.EffectiveTo)
+		}
+	}
+
+	check(oneOf(c.Bonus.SpendOrder, "expiringFirst", "grantedFirst"),
+		"bonus.spendOrder %q must be expiringFirst or grantedFirst", c.Bonus.SpendOrder)
+	if c.Bonus.ExpiryEnabled {
+		check(c.Bonus.PollInterval > 0, "bonus.pollInterval must be positive")
+		check(c.Bonus.BatchSize > 0, "bonus.batchSize must be positive")
+	}
+
+	check(c.PaymentRequests.DefaultExpiry > 0, "paymentRequests.defaultExpiry must be positive")
+	check(c.PaymentRequests.MaxExpiry >= c.PaymentRequests.DefaultExpiry,
+		"paymentRequests.maxExpiry must not be shorter than paymentRequests.defaultExpiry")
+	if c.PaymentRequests.ExpiryEnabled {
+		check(c.PaymentRequests.PollInterval > 0, "paymentRequests.pollInterval must be positive")
+		check(c.PaymentRequests.BatchSize > 0, "paymentRequests.batchSize must be positive")
+	}
+
+	check(c.PendingTransfers.TTL > 0, "pendingTransfers.ttl must be positive")
+	if c.PendingTransfers.ExpiryEnabled {
+		check(c.PendingTransfers.PollInterval > 0, "pendingTransfers.pollInterval must be positive")
+		check(c.PendingTransfers.BatchSize > 0, "pendingTransfers.batchSize must be positive")
+	}
+
+	for currency, accountId := range c.Escrow.Accounts {
+		check(validCurrency(strings.ToUpper(currency)), "escrow.accounts key %q is not a currency code", currency)
+		_, err = uuid.Parse(accountId)
+		check(err == nil, "escrow.accounts.%s %q is not a valid account id", currency, accountId)
+	}
+
+	check(c.Overdraft.GracePeriod >= 0, "overdraft.gracePeriod must not be negative")
+
+	check(validCurrency(c.Accounts.DefaultCurrency), "accounts.defaultCurrency %q is not a currency code",
+		c.Accounts.DefaultCurrency)
+
+	check(c.Snapshots.Cutoff > 0 && c.Snapshots.Cutoff <= 24*time.Hour,
+		"snapshots.cutoff must be between 0 and 24h, 0 excluded")
+	_, err = time.LoadLocation(c.Snapshots.Timezone)
+	check(err == nil, "snapshots.timezone %q is not a valid timezone", c.Snapshots.Timezone)
+	check(c.Snapshots.Delay >= 0, "snapshots.delay must not be negative")
+	if c.Snapshots.Enabled {
+		check(c.Snapshots.PollInterval > 0, "snapshots.pollInterval must be positive")
+		check(c.Snapshots.BackfillDays >= 0, "snapshots.backfillDays must not be negative")
+	}
+
+	check(c.Reconciliation.SampleSize >= 0, "reconciliation.sampleSize must not be negative")
+	check(c.Reconciliation.BatchSize > 0, "reconciliation.batchSize must be positive")
+	if c.Reconciliation.Enabled {
+		check(c.Reconciliation.Interval > 0, "reconciliation.interval must be positive")
+		check(c.Reconciliation.PollInterval > 0, "reconciliation.pollInterval must be positive")
+	}
+
+	check(c.Audit.MaxBodyBytes > 0, "audit.maxBodyBytes must be positive")
+
+	if len(problems) > 0 {
+		return ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+func validPort(port string) bool {
+	p, err := strconv.Atoi(port)
+	return err == nil && p > 0 && p < 65536
+}
+
+// validCurrency reports whether code looks like an ISO 4217 currency code.
+func validCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+
+	return false
 }
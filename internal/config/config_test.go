@@ -0,0 +1,73 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInit(t *testing.T) {
+	dir := t.TempDir()
+
+	configPath := filepath.Join(dir, "nested", "config.yml")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(configPath), 0o755))
+	assert.NoError(t, os.WriteFile(configPath, []byte("http:\n  port: \"8080\"\npostgres:\n  user: \"postgres\"\n"+
+		"  dbname: \"user_balance\"\n"), 0o644))
+
+	secretPath := filepath.Join(dir, "pg_password")
+	assert.NoError(t, os.WriteFile(secretPath, []byte("s3cret\n"), 0o600))
+
+	t.Setenv("UBA_HTTP_PORT", "9090")
+	t.Setenv("UBA_POSTGRES_PASSWORD_FILE", secretPath)
+	t.Setenv("UBA_EXCHANGERATE_APIKEY", "key")
+
+	cfg, err := Init(configPath, logger.NewDefault())
+	assert.NoError(t, err)
+	assert.Equal(t, "9090", cfg.HTTP.Port)
+	assert.Equal(t, "s3cret", cfg.Postgresql.Password)
+	assert.Equal(t, "key", cfg.ExchangeRate.APIKey)
+	assert.Equal(t, "RUB", cfg.ExchangeRate.BaseCurrency)
+	assert.Equal(t, 1000, cfg.Pagination.DefaultPageSize)
+	assert.Equal(t, "expiringFirst", cfg.Bonus.SpendOrder)
+	assert.Equal(t, 7*24*time.Hour, cfg.PaymentRequests.DefaultExpiry)
+	assert.Equal(t, 7*24*time.Hour, cfg.PendingTransfers.TTL)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	err := Config{
+		HTTP: HTTPConfig{Port: "http"},
+		Postgresql: PGConfig{
+			Host:    "localhost",
+			Port:    "5432",
+			SSLMode: "disable",
+		},
+		Fees: FeesConfig{
+			Rules: []FeeRuleConfig{{Name: "transfer", Operation: "deposit", EffectiveFrom: "2021-01-01"}},
+		},
+		Bonus:           BonusConfig{SpendOrder: "newestFirst", ExpiryEnabled: true},
+		PaymentRequests: PaymentRequestsConfig{DefaultExpiry: 48 * time.Hour, MaxExpiry: 24 * time.Hour},
+		Escrow:          EscrowConfig{Accounts: map[string]string{"rub": "escrow"}},
+	}.Validate()
+
+	var validationErr ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Contains(t, validationErr.Problems, `http.port "http" is not a valid port`)
+	assert.Contains(t, validationErr.Problems, "postgres.user is required")
+	assert.Contains(t, validationErr.Problems, "postgres.password is required")
+	assert.Contains(t, validationErr.Problems, "exchangeRate.apiKey is required")
+	assert.NotContains(t, validationErr.Problems, "postgres.host is required")
+	assert.Contains(t, validationErr.Problems, "fees.revenueAccounts must not be empty when there are fee rules")
+	assert.Contains(t, validationErr.Problems,
+		`fees.rules[0].operation must be transfer or withdrawal, got "deposit"`)
+	assert.Contains(t, validationErr.Problems, `fees.rules[0].effectiveFrom "2021-01-01" is not an RFC3339 time`)
+	assert.Contains(t, validationErr.Problems, `bonus.spendOrder "newestFirst" must be expiringFirst or grantedFirst`)
+	assert.Contains(t, validationErr.Problems, "bonus.pollInterval must be positive")
+	assert.Contains(t, validationErr.Problems,
+		"paymentRequests.maxExpiry must not be shorter than paymentRequests.defaultExpiry")
+	assert.Contains(t, validationErr.Problems, "pendingTransfers.ttl must be positive")
+	assert.Contains(t, validationErr.Problems, `escrow.accounts.rub "escrow" is not a valid account id`)
+}
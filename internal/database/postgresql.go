@@ -1,33 +1,143 @@
 package database
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
 	"github.com/jmoiron/sqlx"
 
 	_ "github.com/lib/pq"
 )
 
-func NewPostgresDB(cfg config.PGConfig, logger *log.Logger) (*sqlx.DB, error) {
-	db, err := sqlx.Open("postgres", fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s "+
-		"sslmode=%s",
-		cfg.Host, cfg.Port, cfg.Username, cfg.Password, cfg.DBName, cfg.SSLMode))
+// NewPostgresDB opens a connection pool and retries to reach the database with exponential
+// backoff until cfg.ConnectTimeout passes, so the service survives starting before Postgres.
+func NewPostgresDB(cfg config.PGConfig, logger logger.Logger) (*sqlx.DB, error) {
+	dsn, err := buildDSN(cfg)
 	if err != nil {
-		logger.Printf("failed to open connection to database: %s", err)
+		logger.Errorf("failed to build database connection string: %s", err)
 		return nil, err
 	}
 
-	err = db.Ping()
+	db, err := sqlx.Open("postgres", dsn)
 	if err != nil {
-		logger.Printf("failed to connect database: %s", err)
+		logger.Errorf("failed to open connection to database: %s", err)
 		return nil, err
 	}
 
-	return db, err
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	defer cancel()
+
+	if err = pingWithRetry(ctx, db, cfg.RetryInitialInterval, cfg.RetryMaxInterval, logger); err != nil {
+		logger.Errorf("failed to connect database: %s", err)
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
 }
 
 func ClosePostgresDB(db *sqlx.DB) {
 	db.Close()
 }
+
+func pingWithRetry(ctx context.Context, db *sqlx.DB, interval time.Duration, maxInterval time.Duration,
+	logger logger.Logger) error {
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+
+		logger.WithField("attempt", attempt).
+			Warnf("database is not reachable, retrying in %s, error: %s", interval, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		case <-time.After(interval):
+		}
+
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// buildDSN returns cfg.URL when it is set, otherwise a key/value connection string built from
+// the individual fields. application_name and statement_timeout are added unless already present.
+func buildDSN(cfg config.PGConfig) (string, error) {
+	params := map[string]string{}
+	if cfg.ApplicationName != "" {
+		params["application_name"] = cfg.ApplicationName
+	}
+	if cfg.StatementTimeout > 0 {
+		params["statement_timeout"] = fmt.Sprint(cfg.StatementTimeout.Milliseconds())
+	}
+
+	if cfg.URL == "" {
+		params["host"] = cfg.Host
+		params["port"] = cfg.Port
+		params["user"] = cfg.Username
+		params["password"] = cfg.Password
+		params["dbname"] = cfg.DBName
+		params["sslmode"] = cfg.SSLMode
+
+		return keyValueDSN("", params), nil
+	}
+
+	if strings.HasPrefix(cfg.URL, "postgres://") || strings.HasPrefix(cfg.URL, "postgresql://") {
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return "", fmt.Errorf("postgres.url is not a valid url: %w", err)
+		}
+
+		query := u.Query()
+		for key, value := range params {
+			if query.Get(key) == "" {
+				query.Set(key, value)
+			}
+		}
+		u.RawQuery = query.Encode()
+
+		return u.String(), nil
+	}
+
+	for key := range params {
+		if strings.Contains(cfg.URL, key+"=") {
+			delete(params, key)
+		}
+	}
+
+	return keyValueDSN(cfg.URL, params), nil
+}
+
+func keyValueDSN(base string, params map[string]string) string {
+	parts := []string{}
+	if base != "" {
+		parts = append(parts, base)
+	}
+
+	for _, key := range []string{"host", "port", "user", "password", "dbname", "sslmode",
+		"application_name", "statement_timeout"} {
+		value, ok := params[key]
+		if !ok {
+			continue
+		}
+
+		value = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+		parts = append(parts, fmt.Sprintf("%s='%s'", key, value))
+	}
+
+	return strings.Join(parts, " ")
+}
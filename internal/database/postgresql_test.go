@@ -0,0 +1,72 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildDSN(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         config.PGConfig
+		expectedOut string
+		expectedErr bool
+	}{
+		{
+			name: "Fields",
+			cfg: config.PGConfig{
+				Host:             "localhost",
+				Port:             "5432",
+				Username:         "postgres",
+				Password:         "it's secret",
+				DBName:           "user_balance",
+				SSLMode:          "disable",
+				ApplicationName:  "user-balance-api",
+				StatementTimeout: 5 * time.Second,
+			},
+			expectedOut: `host='localhost' port='5432' user='postgres' password='it\'s secret' dbname='user_balance' ` +
+				`sslmode='disable' application_name='user-balance-api' statement_timeout='5000'`,
+		},
+		{
+			name: "URL",
+			cfg: config.PGConfig{
+				URL:              "postgres://postgres:pass@db:5432/user_balance?sslmode=disable&application_name=custom",
+				Host:             "ignored",
+				ApplicationName:  "user-balance-api",
+				StatementTimeout: time.Second,
+			},
+			expectedOut: "postgres://postgres:pass@db:5432/user_balance?" +
+				"application_name=custom&sslmode=disable&statement_timeout=1000",
+		},
+		{
+			name: "Key value DSN",
+			cfg: config.PGConfig{
+				URL:             "host=db user=postgres application_name=custom",
+				ApplicationName: "user-balance-api",
+			},
+			expectedOut: "host=db user=postgres application_name=custom",
+		},
+		{
+			name: "Invalid URL",
+			cfg: config.PGConfig{
+				URL: "postgres://db:port/user_balance",
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := buildDSN(test.cfg)
+			if test.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedOut, got)
+			}
+		})
+	}
+}
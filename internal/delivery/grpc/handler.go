@@ -0,0 +1,91 @@
+package grpc
+
+import (
+	"errors"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/Feokrat/user-balance-api/internal/service"
+	userbalancev1 "github.com/Feokrat/user-balance-api/pkg/api/userbalance/v1"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+type Handler struct {
+	userbalancev1.UnimplementedUserBalanceServiceServer
+
+	services   *service.Services
+	grpcConfig config.GRPCConfig
+	pagination config.PaginationConfig
+	audit      config.AuditConfig
+	logger     logger.Logger
+}
+
+func NewHandler(services *service.Services, grpcConfig config.GRPCConfig, pagination config.PaginationConfig,
+	audit config.AuditConfig, logger logger.Logger) *Handler {
+	return &Handler{
+		services:   services,
+		grpcConfig: grpcConfig,
+		pagination: pagination,
+		audit:      audit,
+		logger:     logger,
+	}
+}
+
+func (h *Handler) Init() *grpc.Server {
+	interceptors := []grpc.UnaryServerInterceptor{
+		recovery(h.logger),
+		requestId(),
+		requestLogger(h.logger),
+	}
+	if h.audit.Enabled {
+		interceptors = append(interceptors, auditLog(h.services, h.audit))
+	}
+
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+
+	userbalancev1.RegisterUserBalanceServiceServer(server, h)
+	grpc_health_v1.RegisterHealthServer(server, newHealthServer(h.services))
+	if h.grpcConfig.Reflection {
+		reflection.Register(server)
+	}
+
+	return server
+}
+
+// errorStatus maps an error returned by the services to a gRPC status, like the REST
+// errorStatus maps it to an HTTP status.
+func errorStatus(err error) error {
+	var code codes.Code
+	switch {
+	case errors.As(err, &schemas.ErrorUserBalanceNotFound{}):
+		code = codes.NotFound
+	case errors.As(err, &schemas.ErrorNotEnoughFunds{}), errors.As(err, &schemas.ErrorAccountFrozen{}),
+		errors.As(err, &schemas.ErrorAccountClosed{}), errors.As(err, &schemas.ErrorCurrencyMismatch{}):
+		code = codes.FailedPrecondition
+	case errors.As(err, &schemas.ErrorLimitExceeded{}):
+		code = codes.ResourceExhausted
+	case errors.As(err, &schemas.ErrorAmountToSendNegative{}):
+		code = codes.InvalidArgument
+	default:
+		code = codes.Internal
+	}
+
+	return status.Error(code, err.Error())
+}
+
+// parseUUID parses the uuid field name of a request, returning an invalid argument status
+// when it is malformed.
+func parseUUID(name string, value string) (uuid.UUID, error) {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.UUID{}, status.Errorf(codes.InvalidArgument, "wrong %s format: %s", name, err.Error())
+	}
+
+	return id, nil
+}
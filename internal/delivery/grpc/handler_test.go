@@ -0,0 +1,201 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/Feokrat/user-balance-api/internal/service"
+	userbalancev1 "github.com/Feokrat/user-balance-api/pkg/api/userbalance/v1"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fakeUserBalance struct {
+	balances map[uuid.UUID]float64
+}
+
+func (f fakeUserBalance) GetBalanceByUserId(_ context.Context, userId uuid.UUID) (float64, error) {
+	return f.balances[userId], nil
+}
+
+func (f fakeUserBalance) ChangeUserBalanceByUserId(_ context.Context, userId uuid.UUID,
+	changeAmount float64) (bool, error) {
+	_, ok := f.balances[userId]
+	if !ok && changeAmount < 0 {
+		return false, schemas.ErrorUserBalanceNotFound{Message: "user balance not found"}
+	}
+	f.balances[userId] += changeAmount
+	return !ok, nil
+}
+
+func (f fakeUserBalance) ApplyTransaction(_ context.Context, senderId uuid.UUID, receiverId uuid.UUID,
+	amount float64) error {
+	if f.balances[senderId] < amount {
+		return schemas.ErrorNotEnoughFunds{Message: "not enough funds"}
+	}
+	f.balances[senderId] -= amount
+	f.balances[receiverId] += amount
+	return nil
+}
+
+type fakeAudit struct {
+	entries []model.AuditEntry
+}
+
+func (f *fakeAudit) RecordAuditEntry(_ context.Context, entry model.AuditEntry) error {
+	f.entries = append(f.entries, entry)
+	return nil
+}
+
+func (f *fakeAudit) GetAuditEntries(context.Context, model.AuditFilter, int, int) ([]model.AuditEntry, error) {
+	return f.entries, nil
+}
+
+type fakeHealth struct {
+	ready bool
+}
+
+func (f fakeHealth) Readiness(context.Context) (bool, map[string]model.ComponentHealth) {
+	return f.ready, nil
+}
+
+func (f fakeHealth) SetShuttingDown() {}
+
+func newTestClient(t *testing.T, services *service.Services) *grpc.ClientConn {
+	listener := bufconn.Listen(1 << 20)
+	server := NewHandler(services, config.GRPCConfig{Reflection: true},
+		config.PaginationConfig{DefaultPageSize: 10, MaxPageSize: 100},
+		config.AuditConfig{Enabled: true, MaxBodyBytes: 1024}, logger.NewDefault()).Init()
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithInsecure())
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func TestHandler_UserBalance(t *testing.T) {
+	sender, receiver := uuid.New(), uuid.New()
+	audits := &fakeAudit{}
+	conn := newTestClient(t, &service.Services{
+		UserBalance: fakeUserBalance{balances: map[uuid.UUID]float64{sender: 100}},
+		Audit:       audits,
+		Health:      fakeHealth{ready: true},
+	})
+	client := userbalancev1.NewUserBalanceServiceClient(conn)
+	ctx := context.Background()
+
+	created, err := client.ChangeBalance(ctx, &userbalancev1.ChangeBalanceRequest{
+		UserId:       receiver.String(),
+		ChangeAmount: 10,
+	})
+	assert.NoError(t, err)
+	assert.True(t, created.GetCreated())
+
+	_, err = client.ChangeBalance(ctx, &userbalancev1.ChangeBalanceRequest{
+		UserId:       uuid.New().String(),
+		ChangeAmount: -10,
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.Transfer(ctx, &userbalancev1.TransferRequest{
+		SenderId:   sender.String(),
+		ReceiverId: receiver.String(),
+		Amount:     1000,
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = client.Transfer(ctx, &userbalancev1.TransferRequest{
+		SenderId:   sender.String(),
+		ReceiverId: receiver.String(),
+		Amount:     -1,
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	var header metadata.MD
+	_, err = client.Transfer(metadata.AppendToOutgoingContext(ctx, requestIdMetadata, "req-1",
+		callerIdMetadata, "billing"),
+		&userbalancev1.TransferRequest{
+			SenderId:   sender.String(),
+			ReceiverId: receiver.String(),
+			Amount:     40,
+		}, grpc.Header(&header))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"req-1"}, header.Get(requestIdMetadata))
+
+	balance, err := client.GetBalance(ctx, &userbalancev1.GetBalanceRequest{UserId: receiver.String()})
+	assert.NoError(t, err)
+	assert.Equal(t, 50.0, balance.GetBalance())
+
+	_, err = client.GetBalance(ctx, &userbalancev1.GetBalanceRequest{UserId: "not-a-uuid"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.ListTransactionLogs(ctx, &userbalancev1.ListTransactionLogsRequest{
+		UserId:    receiver.String(),
+		SortField: "id; DROP TABLE transaction_log",
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// only the calls changing balances are audited
+	if assert.Len(t, audits.entries, 5) {
+		assert.Equal(t, "ChangeBalance", audits.entries[0].Method)
+		assert.Equal(t, int(codes.NotFound), audits.entries[1].Status)
+
+		transfer := audits.entries[4]
+		assert.Equal(t, model.AuditProtocolGRPC, transfer.Protocol)
+		assert.Equal(t, "/userbalance.v1.UserBalanceService/Transfer", transfer.Route)
+		assert.Equal(t, "billing", transfer.CallerId)
+		assert.Equal(t, "req-1", transfer.RequestId)
+		assert.Equal(t, int(codes.OK), transfer.Status)
+		assert.JSONEq(t, `{"senderId":"`+sender.String()+`","receiverId":"`+receiver.String()+`","amount":40}`,
+			string(transfer.Body))
+	}
+}
+
+func TestHandler_Health(t *testing.T) {
+	tests := []struct {
+		name    string
+		ready   bool
+		service string
+		status  grpc_health_v1.HealthCheckResponse_ServingStatus
+		code    codes.Code
+	}{
+		{name: "ready", ready: true, status: grpc_health_v1.HealthCheckResponse_SERVING},
+		{name: "not ready", ready: false, status: grpc_health_v1.HealthCheckResponse_NOT_SERVING},
+		{
+			name:    "named service",
+			ready:   true,
+			service: userbalancev1.UserBalanceService_ServiceDesc.ServiceName,
+			status:  grpc_health_v1.HealthCheckResponse_SERVING,
+		},
+		{name: "unknown service", ready: true, service: "unknown", code: codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newTestClient(t, &service.Services{Health: fakeHealth{ready: tt.ready}})
+
+			resp, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(),
+				&grpc_health_v1.HealthCheckRequest{Service: tt.service})
+			assert.Equal(t, tt.code, status.Code(err))
+			assert.Equal(t, tt.status, resp.GetStatus())
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/Feokrat/user-balance-api/internal/service"
+	userbalancev1 "github.com/Feokrat/user-balance-api/pkg/api/userbalance/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// healthServer implements the standard gRPC health check on top of the same readiness
+// checks as /readyz, so it reports NOT_SERVING once the service starts shutting down.
+type healthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+
+	services *service.Services
+}
+
+func newHealthServer(services *service.Services) *healthServer {
+	return &healthServer{services: services}
+}
+
+func (s *healthServer) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (
+	*grpc_health_v1.HealthCheckResponse, error) {
+	switch req.GetService() {
+	case "", userbalancev1.UserBalanceService_ServiceDesc.ServiceName:
+	default:
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+
+	ready, _ := s.services.Readiness(ctx)
+	if !ready {
+		return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_NOT_SERVING}, nil
+	}
+
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
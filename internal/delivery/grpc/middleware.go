@@ -0,0 +1,152 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/audit"
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/service"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// requestIdMetadata is the metadata key carrying the request id, the counterpart of X-Request-ID.
+const requestIdMetadata = "x-request-id"
+
+// callerIdMetadata is the metadata key carrying the identity of the caller, the counterpart of X-Caller-Id.
+const callerIdMetadata = "x-caller-id"
+
+// maxCallerIdLength is the longest caller id recorded, longer ones are truncated.
+const maxCallerIdLength = 255
+
+// auditedMethods are the methods changing balances, they are recorded in the audit log.
+var auditedMethods = map[string]bool{
+	"/userbalance.v1.UserBalanceService/ChangeBalance": true,
+	"/userbalance.v1.UserBalanceService/Transfer":      true,
+}
+
+// requestId takes the request id from the x-request-id metadata or generates a new one,
+// echoes it back in the response header and stores it in the context.
+func requestId() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		var requestId string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(requestIdMetadata); len(values) > 0 {
+				requestId = values[0]
+			}
+		}
+		if requestId == "" || len(requestId) > 64 {
+			requestId = uuid.New().String()
+		}
+
+		_ = grpc.SetHeader(ctx, metadata.Pairs(requestIdMetadata, requestId))
+
+		return handler(logger.ContextWithRequestID(ctx, requestId), req)
+	}
+}
+
+func requestLogger(log logger.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		log.WithContext(ctx).WithFields(logger.Fields{
+			"method":  info.FullMethod,
+			"code":    status.Code(err).String(),
+			"latency": time.Since(start).String(),
+		}).Infof("handled request")
+
+		return resp, err
+	}
+}
+
+// recovery turns a panic in a handler into an internal error instead of crashing the server.
+func recovery(log logger.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.WithContext(ctx).Errorf("panic in %s: %v", info.FullMethod, r)
+				err = status.Error(codes.Internal, "internal error")
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// auditLog records every call of the audited methods in the audit log once it is handled, with the
+// transaction log entries it committed, like the REST audit middleware.
+func auditLog(services *service.Services, cfg config.AuditConfig) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		if !auditedMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		start := time.Now()
+		trail := audit.NewTrail()
+
+		resp, err := handler(audit.ContextWithTrail(ctx, trail), req)
+
+		var callerId, userAgent string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(callerIdMetadata); len(values) > 0 {
+				callerId = values[0]
+			}
+			if values := md.Get("user-agent"); len(values) > 0 {
+				userAgent = values[0]
+			}
+		}
+		if len(callerId) > maxCallerIdLength {
+			callerId = callerId[:maxCallerIdLength]
+		}
+
+		var sourceIp string
+		if p, ok := peer.FromContext(ctx); ok {
+			sourceIp = p.Addr.String()
+			if host, _, err := net.SplitHostPort(sourceIp); err == nil {
+				sourceIp = host
+			}
+		}
+
+		var body []byte
+		if message, ok := req.(proto.Message); ok {
+			body, _ = protojson.Marshal(message)
+		}
+
+		// the entry is recorded even when the caller has gone away
+		requestId := logger.RequestID(ctx)
+		_ = services.RecordAuditEntry(logger.ContextWithRequestID(context.Background(), requestId),
+			model.AuditEntry{
+				Protocol:          model.AuditProtocolGRPC,
+				Method:            info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:],
+				Route:             info.FullMethod,
+				Path:              info.FullMethod,
+				CallerId:          callerId,
+				SourceIp:          sourceIp,
+				UserAgent:         userAgent,
+				RequestId:         requestId,
+				Body:              audit.SanitizeBody(body, cfg.MaxBodyBytes),
+				Status:            int(status.Code(err)),
+				TransactionLogIds: trail.TransactionLogIds(),
+				CreatedAt:         start,
+			})
+
+		return resp, err
+	}
+}
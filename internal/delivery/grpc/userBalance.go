@@ -0,0 +1,147 @@
+package grpc
+
+import (
+	"context"
+	"math"
+
+	userbalancev1 "github.com/Feokrat/user-balance-api/pkg/api/userbalance/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// transactionLogSortFields are the columns transaction logs can be sorted by.
+var transactionLogSortFields = map[string]bool{"date": true, "amount": true}
+
+func (h *Handler) GetBalance(ctx context.Context, req *userbalancev1.GetBalanceRequest) (
+	*userbalancev1.GetBalanceResponse, error) {
+	userId, err := parseUUID("user_id", req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	userBalance, err := h.services.GetBalanceByUserId(ctx, userId)
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("could not get balance of user %v, error: %s", userId, err.Error())
+		return nil, errorStatus(err)
+	}
+
+	if req.GetCurrency() == "" {
+		return &userbalancev1.GetBalanceResponse{Balance: userBalance}, nil
+	}
+
+	exchangeRate, err := h.services.GetExchangeRate(ctx, "", req.GetCurrency())
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("could not get exchange rates, error: %s", err.Error())
+		return nil, errorStatus(err)
+	}
+
+	return &userbalancev1.GetBalanceResponse{
+		Balance: math.Ceil(userBalance*exchangeRate*100) / 100,
+	}, nil
+}
+
+func (h *Handler) ChangeBalance(ctx context.Context, req *userbalancev1.ChangeBalanceRequest) (
+	*userbalancev1.ChangeBalanceResponse, error) {
+	userId, err := parseUUID("user_id", req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := h.services.ChangeUserBalanceByUserId(ctx, userId, req.GetChangeAmount())
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("could not change balance of user %v, error: %s", userId, err.Error())
+		return nil, errorStatus(err)
+	}
+
+	if created {
+		h.logger.WithContext(ctx).WithField("user_id", userId).Infof("created a new account balance")
+	}
+
+	return &userbalancev1.ChangeBalanceResponse{Created: created}, nil
+}
+
+func (h *Handler) Transfer(ctx context.Context, req *userbalancev1.TransferRequest) (
+	*userbalancev1.TransferResponse, error) {
+	senderId, err := parseUUID("sender_id", req.GetSenderId())
+	if err != nil {
+		return nil, err
+	}
+
+	receiverId, err := parseUUID("receiver_id", req.GetReceiverId())
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetAmount() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "amount of sending money is negative: %v < 0",
+			req.GetAmount())
+	}
+
+	if err = h.services.ApplyTransaction(ctx, senderId, receiverId, req.GetAmount()); err != nil {
+		h.logger.WithContext(ctx).Errorf("could not apply transaction from user %v to user %v, error: %s",
+			senderId, receiverId, err.Error())
+		return nil, errorStatus(err)
+	}
+
+	return &userbalancev1.TransferResponse{}, nil
+}
+
+func (h *Handler) ListTransactionLogs(ctx context.Context, req *userbalancev1.ListTransactionLogsRequest) (
+	*userbalancev1.ListTransactionLogsResponse, error) {
+	userId, err := parseUUID("user_id", req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	pageNum := int(req.GetPageNum())
+	if pageNum == 0 {
+		pageNum = 1
+	}
+	pageSize := int(req.GetPageSize())
+	if pageSize == 0 {
+		pageSize = h.pagination.DefaultPageSize
+	}
+	if pageNum < 1 || pageSize < 1 || pageSize > h.pagination.MaxPageSize {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"page_num must be positive and page_size must be between 1 and %v", h.pagination.MaxPageSize)
+	}
+
+	sortField := req.GetSortField()
+	if sortField == "" {
+		sortField = "date"
+	}
+	if !transactionLogSortFields[sortField] {
+		return nil, status.Errorf(codes.InvalidArgument, "sort_field %q must be date or amount", sortField)
+	}
+
+	logs, err := h.services.GetAllUserLogs(ctx, userId, sortField, pageNum-1, pageSize)
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("could not get all transaction logs of user %v", userId)
+		return nil, errorStatus(err)
+	}
+
+	countAll, err := h.services.CountUserLogs(ctx, userId)
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("could not count all transaction logs of user %v", userId)
+		return nil, errorStatus(err)
+	}
+
+	items := make([]*userbalancev1.TransactionLog, 0, len(logs))
+	for _, log := range logs {
+		items = append(items, &userbalancev1.TransactionLog{
+			Id:         log.Id,
+			UserId:     log.UserId.String(),
+			Date:       timestamppb.New(log.Date),
+			Amount:     log.Amount,
+			Commentary: log.Commentary,
+			RequestId:  log.RequestId,
+		})
+	}
+
+	return &userbalancev1.ListTransactionLogsResponse{
+		Items: items,
+		Len:   int32(len(items)),
+		All:   int32(countAll),
+	}, nil
+}
@@ -1,35 +1,46 @@
 package http
 
 import (
-	"log"
 	"net/http"
 
+	"github.com/Feokrat/user-balance-api/internal/config"
 	v1 "github.com/Feokrat/user-balance-api/internal/delivery/http/v1"
+	"github.com/Feokrat/user-balance-api/internal/logger"
 
 	"github.com/Feokrat/user-balance-api/internal/service"
 	"github.com/gin-gonic/gin"
 )
 
 type Handler struct {
-	services *service.Services
-	logger   *log.Logger
+	services   *service.Services
+	pagination config.PaginationConfig
+	admin      config.AdminConfig
+	audit      config.AuditConfig
+	logger     logger.Logger
 }
 
-func NewHandler(services *service.Services, logger *log.Logger) *Handler {
-	return &Handler{services: services, logger: logger}
+func NewHandler(services *service.Services, pagination config.PaginationConfig, admin config.AdminConfig,
+	audit config.AuditConfig, logger logger.Logger) *Handler {
+	return &Handler{services: services, pagination: pagination, admin: admin, audit: audit, logger: logger}
 }
 
 func (h *Handler) Init() *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
 
 	router.Use(
 		gin.Recovery(),
-		gin.Logger(),
+		requestId(),
+		requestLogger(h.logger),
 	)
+	if h.audit.Enabled {
+		router.Use(auditLog(h.services, h.audit, h.logger))
+	}
 
 	router.GET("/ping", func(c *gin.Context) {
 		c.String(http.StatusOK, "pong")
 	})
+	router.GET("/healthz", h.healthz)
+	router.GET("/readyz", h.readyz)
 
 	h.initAPI(router)
 
@@ -37,7 +48,7 @@ func (h *Handler) Init() *gin.Engine {
 }
 
 func (h *Handler) initAPI(router *gin.Engine) {
-	handlerV1 := v1.NewHandler(h.services, h.logger)
+	handlerV1 := v1.NewHandler(h.services, h.pagination, h.admin, h.logger)
 	api := router.Group("/api")
 	{
 		handlerV1.Init(api)
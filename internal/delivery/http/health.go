@@ -0,0 +1,28 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) healthz(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, schemas.HealthResponse{Status: "ok"})
+}
+
+func (h *Handler) readyz(ctx *gin.Context) {
+	ready, components := h.services.Readiness(ctx.Request.Context())
+	if !ready {
+		ctx.JSON(http.StatusServiceUnavailable, schemas.ReadinessResponse{
+			Status:     "not ready",
+			Components: components,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schemas.ReadinessResponse{
+		Status:     "ready",
+		Components: components,
+	})
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/audit"
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const requestIdHeader = "X-Request-ID"
+
+// callerIdHeader carries the identity of the caller, it is recorded in the audit log.
+const callerIdHeader = "X-Caller-Id"
+
+// maxCallerIdLength is the longest caller id recorded, longer ones are truncated.
+const maxCallerIdLength = 255
+
+// requestId takes the request id from the X-Request-ID header or generates a new one,
+// echoes it back and stores it in the request context.
+func requestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestId := c.GetHeader(requestIdHeader)
+		if requestId == "" || len(requestId) > 64 {
+			requestId = uuid.New().String()
+		}
+
+		c.Header(requestIdHeader, requestId)
+		c.Request = c.Request.WithContext(logger.ContextWithRequestID(c.Request.Context(), requestId))
+
+		c.Next()
+	}
+}
+
+func requestLogger(log logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		log.WithContext(c.Request.Context()).WithFields(logger.Fields{
+			"method":    c.Request.Method,
+			"path":      c.Request.URL.Path,
+			"status":    c.Writer.Status(),
+			"latency":   time.Since(start).String(),
+			"client_ip": c.ClientIP(),
+		}).Infof("handled request")
+	}
+}
+
+// auditLog records every mutating call in the audit log once it is handled, with the transaction log
+// entries it committed. A call whose entry could not be recorded is still answered.
+func auditLog(services *service.Services, cfg config.AuditConfig, log logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+
+		// only as much of the body as the audit keeps is buffered, one byte more telling it is longer, and
+		// the handler reads it again followed by the rest
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(c.Request.Body, int64(cfg.MaxBodyBytes)+1))
+			if err != nil {
+				log.WithContext(c.Request.Context()).Warnf("could not read request body to audit, error: %s",
+					err.Error())
+			}
+			c.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), c.Request.Body), c.Request.Body}
+		}
+
+		trail := audit.NewTrail()
+		c.Request = c.Request.WithContext(audit.ContextWithTrail(c.Request.Context(), trail))
+
+		c.Next()
+
+		sanitized := audit.SanitizeBody(body, cfg.MaxBodyBytes)
+		if len(body) > cfg.MaxBodyBytes {
+			sanitized = audit.TruncatedBody(c.Request.ContentLength)
+		}
+
+		callerId := c.GetHeader(callerIdHeader)
+		if len(callerId) > maxCallerIdLength {
+			callerId = callerId[:maxCallerIdLength]
+		}
+
+		// the entry is recorded even when the caller has gone away
+		requestId := logger.RequestID(c.Request.Context())
+		_ = services.RecordAuditEntry(logger.ContextWithRequestID(context.Background(), requestId),
+			model.AuditEntry{
+				Protocol:          model.AuditProtocolHTTP,
+				Method:            c.Request.Method,
+				Route:             c.FullPath(),
+				Path:              c.Request.URL.Path,
+				CallerId:          callerId,
+				Admin:             trail.Admin(),
+				SourceIp:          c.ClientIP(),
+				UserAgent:         c.Request.UserAgent(),
+				RequestId:         requestId,
+				Body:              sanitized,
+				Status:            c.Writer.Status(),
+				TransactionLogIds: trail.TransactionLogIds(),
+				CreatedAt:         start,
+			})
+	}
+}
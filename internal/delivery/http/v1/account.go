@@ -0,0 +1,102 @@
+package v1
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func (h *Handler) initAccountRoutes(api *gin.RouterGroup) {
+	accounts := api.Group("/accounts")
+	{
+		accounts.POST("", h.openAccount)
+		accounts.GET("/:id", h.getAccount)
+	}
+}
+
+func (h Handler) openAccount(ctx *gin.Context) {
+	// the body is optional, without it an account with a generated id is opened in the default currency
+	var requestModel schemas.OpenAccountRequest
+
+	if err := ctx.ShouldBindJSON(&requestModel); err != nil && !errors.Is(err, io.EOF) {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	userId := uuid.Nil
+	if requestModel.UserId != nil {
+		userId = *requestModel.UserId
+	}
+
+	account, err := h.services.OpenAccount(ctx.Request.Context(), userId, requestModel.Currency,
+		requestModel.Metadata)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not open account, error: %s", err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, account)
+}
+
+func (h Handler) getAccount(ctx *gin.Context) {
+	userId, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	account, err := h.services.GetAccount(ctx.Request.Context(), userId)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not get account %v, error: %s",
+			userId, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, account)
+}
+
+func (h Handler) closeAccount(ctx *gin.Context) {
+	userId, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	var requestModel schemas.CloseAccountRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	closure, err := h.services.CloseAccount(ctx.Request.Context(), userId, requestModel.PayoutTo,
+		requestModel.Reason, requestModel.OperatorId)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not close account %v, error: %s",
+			userId, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, closure)
+}
@@ -0,0 +1,258 @@
+package v1
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/Feokrat/user-balance-api/internal/audit"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/gin-gonic/gin"
+)
+
+const adminKeyHeader = "X-Admin-Key"
+
+func (h *Handler) initAdminRoutes(api *gin.RouterGroup) {
+	admin := api.Group("/admin", h.adminAuth)
+	{
+		accounts := admin.Group("/accounts")
+		{
+			accounts.GET("", h.searchAccounts)
+			accounts.PUT("/:id/status", h.changeAccountStatus)
+			accounts.GET("/:id/status-history", h.getAccountStatusHistory)
+			accounts.GET("/:id/limits", h.getAccountLimits)
+			accounts.PUT("/:id/limits", h.updateAccountLimits)
+			accounts.PUT("/:id/overdraft", h.setOverdraftLimit)
+			accounts.POST("/:id/close", h.closeAccount)
+		}
+
+		h.initOperationRoutes(admin)
+		h.initWebhookRoutes(admin)
+		h.initBonusRoutes(admin)
+		h.initReconciliationRoutes(admin)
+		h.initAuditRoutes(admin)
+		h.initEscrowSettlementRoutes(admin)
+	}
+}
+
+// adminAuth lets through only requests carrying the configured admin key, every request is refused
+// while no key is configured.
+func (h Handler) adminAuth(ctx *gin.Context) {
+	if h.admin.APIKey == "" {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, schemas.ErrorResponse{
+			Message: "admin API is disabled",
+		})
+		return
+	}
+
+	key := ctx.GetHeader(adminKeyHeader)
+	if subtle.ConstantTimeCompare([]byte(key), []byte(h.admin.APIKey)) != 1 {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("refused admin request with a wrong %s",
+			adminKeyHeader)
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, schemas.ErrorResponse{
+			Message: "wrong or missing " + adminKeyHeader,
+		})
+		return
+	}
+	audit.FromContext(ctx.Request.Context()).MarkAdmin()
+
+	ctx.Next()
+}
+
+func (h Handler) searchAccounts(ctx *gin.Context) {
+	var requestModel schemas.SearchAccountsRequest
+
+	if err := ctx.ShouldBindQuery(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("query params in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong query params",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	pageSize := requestModel.PageSize
+	if pageSize == 0 {
+		pageSize = h.pagination.DefaultPageSize
+	}
+	if pageSize < 1 || pageSize > h.pagination.MaxPageSize {
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "pagination params out of range",
+			Errors:  fmt.Sprintf("pageSize must be between 1 and %v", h.pagination.MaxPageSize),
+		})
+		return
+	}
+
+	page, err := h.services.SearchAccounts(ctx.Request.Context(), model.AccountFilter{
+		MinBalance:  requestModel.MinBalance,
+		MaxBalance:  requestModel.MaxBalance,
+		Status:      requestModel.Status,
+		Currency:    requestModel.Currency,
+		CreatedFrom: requestModel.CreatedFrom,
+		CreatedTo:   requestModel.CreatedTo,
+	}, requestModel.SortField, requestModel.Order != "asc", requestModel.Cursor, pageSize)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not search accounts, error: %s", err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schemas.AccountsResponse{
+		Items:      page.Items,
+		Len:        len(page.Items),
+		NextCursor: page.NextCursor,
+		Totals:     page.Totals,
+	})
+}
+
+func (h Handler) changeAccountStatus(ctx *gin.Context) {
+	userId, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	var requestModel schemas.ChangeAccountStatusRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	account, err := h.services.ChangeAccountStatus(ctx.Request.Context(), userId, requestModel.Status,
+		requestModel.Reason, requestModel.OperatorId)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not change status of account %v, error: %s",
+			userId, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, account)
+}
+
+func (h Handler) getAccountStatusHistory(ctx *gin.Context) {
+	userId, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+	pageNum, pageSize, ok := h.parsePagination(ctx)
+	if !ok {
+		return
+	}
+
+	changes, err := h.services.GetAccountStatusHistory(ctx.Request.Context(), userId, pageNum-1, pageSize)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not get status history of account %v, error: %s",
+			userId, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schemas.AccountStatusChangesResponse{
+		Items: changes,
+		Len:   len(changes),
+	})
+}
+
+func (h Handler) getAccountLimits(ctx *gin.Context) {
+	userId, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	limits, err := h.services.GetAccountLimits(ctx.Request.Context(), userId)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Errorf("could not get limits of account %v, error: %s",
+			userId, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, limits)
+}
+
+func (h Handler) updateAccountLimits(ctx *gin.Context) {
+	userId, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	var requestModel schemas.UpdateLimitsRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	limits, err := h.services.UpdateAccountLimits(ctx.Request.Context(), userId, model.UserLimits{
+		Tier:             requestModel.Tier,
+		MaxTransfer:      requestModel.MaxTransfer,
+		DailyOutgoing:    requestModel.DailyOutgoing,
+		MonthlyOutgoing:  requestModel.MonthlyOutgoing,
+		MaxBalance:       requestModel.MaxBalance,
+		TransfersPerHour: requestModel.TransfersPerHour,
+		UpdatedBy:        requestModel.OperatorId,
+	})
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not update limits of account %v, error: %s",
+			userId, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, limits)
+}
+
+func (h Handler) setOverdraftLimit(ctx *gin.Context) {
+	userId, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	var requestModel schemas.SetOverdraftLimitRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	account, err := h.services.SetOverdraftLimit(ctx.Request.Context(), userId, *requestModel.OverdraftLimit,
+		requestModel.OperatorId)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not set overdraft limit of account %v, error: %s",
+			userId, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, account)
+}
@@ -0,0 +1,55 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) initAuditRoutes(admin *gin.RouterGroup) {
+	admin.GET("/audit", h.getAuditEntries)
+}
+
+func (h Handler) getAuditEntries(ctx *gin.Context) {
+	var requestModel schemas.AuditEntriesRequest
+
+	if err := ctx.ShouldBindQuery(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("query params in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong query params",
+			Errors:  err.Error(),
+		})
+		return
+	}
+	pageNum, pageSize, ok := h.parsePagination(ctx)
+	if !ok {
+		return
+	}
+
+	entries, err := h.services.GetAuditEntries(ctx.Request.Context(), model.AuditFilter{
+		CallerId:         requestModel.CallerId,
+		Protocol:         requestModel.Protocol,
+		Method:           requestModel.Method,
+		Route:            requestModel.Route,
+		Status:           requestModel.Status,
+		RequestId:        requestModel.RequestId,
+		TransactionLogId: requestModel.TransactionLogId,
+		From:             requestModel.From,
+		To:               requestModel.To,
+	}, pageNum-1, pageSize)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not get audit entries, error: %s", err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schemas.AuditEntriesResponse{
+		Items: entries,
+		Len:   len(entries),
+	})
+}
@@ -0,0 +1,67 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+func (h *Handler) initBatchRoutes(api *gin.RouterGroup) {
+	batches := api.Group("/batches")
+	{
+		batches.POST("", h.executeBatch)
+	}
+}
+
+func (h Handler) executeBatch(ctx *gin.Context) {
+	idempotencyKey := ctx.GetHeader(idempotencyKeyHeader)
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("idempotency key is too long")
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong idempotency key",
+			Errors:  "Idempotency-Key must not be longer than 255 characters",
+		})
+		return
+	}
+
+	var requestModel schemas.BatchRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	batch, err := h.services.ExecuteBatch(ctx.Request.Context(), idempotencyKey, requestModel.Operations)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not execute batch, error: %s", err.Error())
+		if batch.Status == "" {
+			ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+				Message: err.Error(),
+			})
+			return
+		}
+
+		ctx.JSON(errorStatus(err), schemas.BatchErrorResponse{
+			Message: err.Error(),
+			Batch:   batch,
+		})
+		return
+	}
+
+	if batch.Replayed {
+		ctx.Header(idempotentReplayedHeader, "true")
+	}
+	ctx.JSON(http.StatusOK, batch)
+}
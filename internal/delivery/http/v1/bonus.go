@@ -0,0 +1,37 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) initBonusRoutes(admin *gin.RouterGroup) {
+	admin.POST("/bonuses", h.grantBonus)
+}
+
+func (h Handler) grantBonus(ctx *gin.Context) {
+	var requestModel schemas.GrantBonusRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	grant, err := h.services.GrantBonus(ctx.Request.Context(), requestModel.UserId, requestModel.Amount,
+		requestModel.ExpiresAt, requestModel.Reason)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not grant bonus to user %v, error: %s",
+			requestModel.UserId, err.Error())
+		ctx.JSON(errorStatus(err), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, grant)
+}
@@ -0,0 +1,148 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func (h *Handler) initEscrowRoutes(api *gin.RouterGroup) {
+	escrows := api.Group("/escrows")
+	{
+		escrows.POST("", h.fundEscrow)
+		escrows.GET("", h.getEscrowByDealId)
+		escrows.GET("/:id", h.getEscrow)
+	}
+}
+
+// initEscrowSettlementRoutes registers the routes settling escrows, only authorized callers can move
+// escrowed money.
+func (h *Handler) initEscrowSettlementRoutes(admin *gin.RouterGroup) {
+	escrows := admin.Group("/escrows")
+	{
+		escrows.POST("/:id/release", h.releaseEscrow)
+		escrows.POST("/:id/refund", h.refundEscrow)
+		escrows.POST("/:id/split", h.splitEscrow)
+	}
+}
+
+// fundEscrow takes the money of a deal from its buyer into escrow.
+func (h Handler) fundEscrow(ctx *gin.Context) {
+	var requestModel schemas.FundEscrowRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	escrow, err := h.services.FundEscrow(ctx.Request.Context(), requestModel.DealId, requestModel.BuyerId,
+		requestModel.SellerId, requestModel.Amount)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not fund escrow of deal %q, error: %s",
+			requestModel.DealId, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, escrow)
+}
+
+func (h Handler) getEscrowByDealId(ctx *gin.Context) {
+	dealId := ctx.Query("dealId")
+	if dealId == "" {
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong query params",
+			Errors:  "dealId is required",
+		})
+		return
+	}
+
+	escrow, err := h.services.GetEscrowByDealId(ctx.Request.Context(), dealId)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not get escrow of deal %q, error: %s",
+			dealId, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, escrow)
+}
+
+func (h Handler) getEscrow(ctx *gin.Context) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	escrow, err := h.services.GetEscrow(ctx.Request.Context(), id)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not get escrow %v, error: %s", id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, escrow)
+}
+
+func (h Handler) releaseEscrow(ctx *gin.Context) {
+	h.settleEscrow(ctx, func(id uuid.UUID) (model.Escrow, error) {
+		return h.services.ReleaseEscrow(ctx.Request.Context(), id)
+	})
+}
+
+func (h Handler) refundEscrow(ctx *gin.Context) {
+	h.settleEscrow(ctx, func(id uuid.UUID) (model.Escrow, error) {
+		return h.services.RefundEscrow(ctx.Request.Context(), id)
+	})
+}
+
+func (h Handler) splitEscrow(ctx *gin.Context) {
+	var requestModel schemas.SplitEscrowRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	h.settleEscrow(ctx, func(id uuid.UUID) (model.Escrow, error) {
+		return h.services.SplitEscrow(ctx.Request.Context(), id, requestModel.SellerAmount)
+	})
+}
+
+// settleEscrow responds with the escrow settled by settle.
+func (h Handler) settleEscrow(ctx *gin.Context, settle func(id uuid.UUID) (model.Escrow, error)) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	escrow, err := settle(id)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not settle escrow %v, error: %s", id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, escrow)
+}
@@ -0,0 +1,53 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func (h *Handler) initFeeRoutes(api *gin.RouterGroup) {
+	fees := api.Group("/fees")
+	{
+		fees.GET("/quote", h.quoteFee)
+	}
+}
+
+// quoteFee returns the fee an operation would be charged if it was made now.
+func (h Handler) quoteFee(ctx *gin.Context) {
+	var requestModel schemas.FeeQuoteRequest
+
+	if err := ctx.ShouldBindQuery(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("query params in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong query params",
+			Errors:  err.Error(),
+		})
+		return
+	}
+	userId, err := uuid.Parse(requestModel.UserId)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not parse userId %v, error: %s",
+			requestModel.UserId, err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong userId format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	quote, err := h.services.QuoteFee(ctx.Request.Context(), requestModel.Operation, userId, requestModel.Amount)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not quote %s fee of user %v, error: %s",
+			requestModel.Operation, userId, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, quote)
+}
@@ -1,21 +1,33 @@
 package v1
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
 
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
 	"github.com/Feokrat/user-balance-api/internal/service"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type Handler struct {
-	services *service.Services
-	logger   *log.Logger
+	services   *service.Services
+	pagination config.PaginationConfig
+	admin      config.AdminConfig
+	logger     logger.Logger
 }
 
-func NewHandler(services *service.Services, logger *log.Logger) *Handler {
+func NewHandler(services *service.Services, pagination config.PaginationConfig, admin config.AdminConfig,
+	logger logger.Logger) *Handler {
 	return &Handler{
-		services: services,
-		logger:   logger,
+		services:   services,
+		pagination: pagination,
+		admin:      admin,
+		logger:     logger,
 	}
 }
 
@@ -23,5 +35,154 @@ func (h *Handler) Init(api *gin.RouterGroup) {
 	v1 := api.Group("/v1")
 	{
 		h.initUserBalanceRoutes(v1)
+		h.initAccountRoutes(v1)
+		h.initBatchRoutes(v1)
+		h.initScheduledTransferRoutes(v1)
+		h.initPaymentRequestRoutes(v1)
+		h.initPendingTransferRoutes(v1)
+		h.initEscrowRoutes(v1)
+		h.initFeeRoutes(v1)
+		h.initAdminRoutes(v1)
 	}
 }
+
+// errorStatus maps an error returned by the services to the response status.
+func errorStatus(err error) int {
+	switch {
+	case errors.As(err, &schemas.ErrorUserBalanceNotFound{}):
+		return http.StatusNotFound
+	case errors.As(err, &schemas.ErrorNotEnoughFunds{}):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &schemas.ErrorTransactionLogNotFound{}):
+		return http.StatusNotFound
+	case errors.As(err, &schemas.ErrorInvalidReversal{}):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &schemas.ErrorAccountFrozen{}), errors.As(err, &schemas.ErrorAccountClosed{}),
+		errors.As(err, &schemas.ErrorInvalidAccountStatus{}):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &schemas.ErrorLimitExceeded{}):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &schemas.ErrorInvalidOverdraft{}):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &schemas.ErrorAccountExists{}):
+		return http.StatusConflict
+	case errors.As(err, &schemas.ErrorInvalidAccount{}):
+		return http.StatusBadRequest
+	case errors.As(err, &schemas.ErrorCurrencyMismatch{}):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &schemas.ErrorInvalidLimits{}), errors.As(err, &schemas.ErrorInvalidDateRange{}):
+		return http.StatusBadRequest
+	case errors.As(err, &schemas.ErrorInvalidFeeQuote{}), errors.As(err, &schemas.ErrorInvalidBonus{}):
+		return http.StatusBadRequest
+	case errors.As(err, &schemas.ErrorInvalidSplitTransfer{}):
+		return http.StatusBadRequest
+	case errors.As(err, &schemas.ErrorReconciliationNotFound{}):
+		return http.StatusNotFound
+	case errors.As(err, &schemas.ErrorInvalidReconciliation{}):
+		return http.StatusBadRequest
+	case errors.As(err, &schemas.ErrorInvalidCorrection{}):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &schemas.ErrorWebhookSubscriptionNotFound{}):
+		return http.StatusNotFound
+	case errors.As(err, &schemas.ErrorInvalidWebhookSubscription{}):
+		return http.StatusBadRequest
+	case errors.As(err, &schemas.ErrorInvalidBatch{}):
+		return http.StatusBadRequest
+	case errors.As(err, &schemas.ErrorScheduledTransferNotFound{}):
+		return http.StatusNotFound
+	case errors.As(err, &schemas.ErrorInvalidScheduledTransfer{}):
+		return http.StatusBadRequest
+	case errors.As(err, &schemas.ErrorPaymentRequestNotFound{}):
+		return http.StatusNotFound
+	case errors.As(err, &schemas.ErrorInvalidPaymentRequest{}):
+		return http.StatusBadRequest
+	case errors.As(err, &schemas.ErrorPendingTransferNotFound{}):
+		return http.StatusNotFound
+	case errors.As(err, &schemas.ErrorInvalidPendingTransfer{}):
+		return http.StatusBadRequest
+	case errors.As(err, &schemas.ErrorEscrowNotFound{}):
+		return http.StatusNotFound
+	case errors.As(err, &schemas.ErrorInvalidEscrow{}):
+		return http.StatusBadRequest
+	case errors.As(err, &schemas.ErrorBatchFailed{}), errors.As(err, &schemas.ErrorIdempotencyKeyReused{}):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorResponse is the response body of an error returned by the services, errors carrying details
+// beyond their message are returned as they are.
+func errorResponse(err error) interface{} {
+	var limitErr schemas.ErrorLimitExceeded
+	if errors.As(err, &limitErr) {
+		return limitErr
+	}
+
+	return schemas.ErrorResponse{
+		Message: err.Error(),
+	}
+}
+
+// parsePagination reads the pageNum and pageSize query params, it writes a bad request response
+// and reports false when they are invalid.
+func (h *Handler) parsePagination(ctx *gin.Context) (int, int, bool) {
+	pageNum := 1
+	pageSize := h.pagination.DefaultPageSize
+	var err error
+
+	pageNumStr := ctx.Query("pageNum")
+	if pageNumStr != "" {
+		pageNum, err = strconv.Atoi(pageNumStr)
+		if err != nil {
+			h.logger.WithContext(ctx.Request.Context()).Warnf("could not convert pageNum param to int")
+			ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+				Message: "could not convert pageNum param to int",
+				Errors:  err.Error(),
+			})
+			return 0, 0, false
+		}
+	}
+	pageSizeStr := ctx.Query("pageSize")
+	if pageSizeStr != "" {
+		pageSize, err = strconv.Atoi(pageSizeStr)
+		if err != nil {
+			h.logger.WithContext(ctx.Request.Context()).Warnf("could not convert pageSize param to int")
+			ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+				Message: "could not convert pageSize param to int",
+				Errors:  err.Error(),
+			})
+			return 0, 0, false
+		}
+	}
+	if pageNum < 1 || pageSize < 1 || pageSize > h.pagination.MaxPageSize {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("pagination params out of range, pageNum: %v, pageSize: %v",
+			pageNum, pageSize)
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "pagination params out of range",
+			Errors: fmt.Sprintf("pageNum must be positive and pageSize must be between 1 and %v",
+				h.pagination.MaxPageSize),
+		})
+		return 0, 0, false
+	}
+
+	return pageNum, pageSize, true
+}
+
+// parseUUIDParam parses the uuid path param name, it writes a bad request response and reports
+// false when the param is malformed.
+func (h *Handler) parseUUIDParam(ctx *gin.Context, name string) (uuid.UUID, bool) {
+	value := ctx.Param(name)
+	id, err := uuid.Parse(value)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not parse %s %v, error: %s",
+			name, value, err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: fmt.Sprintf("wrong %s format", name),
+			Errors:  err.Error(),
+		})
+		return uuid.UUID{}, false
+	}
+
+	return id, true
+}
@@ -0,0 +1,58 @@
+package v1
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) initOperationRoutes(api *gin.RouterGroup) {
+	operations := api.Group("/operations")
+	{
+		operations.POST("/:id/reverse", h.reverseOperation)
+	}
+}
+
+func (h Handler) reverseOperation(ctx *gin.Context) {
+	idStr := ctx.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 32)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not parse operation id %v, error: %s",
+			idStr, err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong id format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	// the body is optional, without it the whole operation is reversed
+	var requestModel schemas.ReverseOperationRequest
+
+	if err := ctx.ShouldBindJSON(&requestModel); err != nil && !errors.Is(err, io.EOF) {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	reversal, err := h.services.ReverseOperation(ctx.Request.Context(), int32(id), requestModel.Amount,
+		requestModel.Reason)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not reverse operation %v, error: %s",
+			id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, reversal)
+}
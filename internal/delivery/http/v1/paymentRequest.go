@@ -0,0 +1,195 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func (h *Handler) initPaymentRequestRoutes(api *gin.RouterGroup) {
+	paymentRequests := api.Group("/payment-requests")
+	{
+		paymentRequests.POST("", h.createPaymentRequest)
+		paymentRequests.GET("", h.getPaymentRequests)
+		paymentRequests.GET("/:id", h.getPaymentRequest)
+		paymentRequests.POST("/:id/accept", h.acceptPaymentRequest)
+		paymentRequests.POST("/:id/decline", h.declinePaymentRequest)
+		paymentRequests.POST("/:id/cancel", h.cancelPaymentRequest)
+	}
+}
+
+func (h Handler) createPaymentRequest(ctx *gin.Context) {
+	var requestModel schemas.CreatePaymentRequestRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	request, err := h.services.CreatePaymentRequest(ctx.Request.Context(), requestModel.RequesterId,
+		requestModel.PayerId, requestModel.Amount, requestModel.Note, requestModel.ExpiresAt)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not create payment request, error: %s",
+			err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, request)
+}
+
+// getPaymentRequests lists the incoming requests of a payer or the outgoing ones of a requester.
+func (h Handler) getPaymentRequests(ctx *gin.Context) {
+	var requestModel schemas.PaymentRequestsQuery
+
+	if err := ctx.ShouldBindQuery(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("query params in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong query params",
+			Errors:  err.Error(),
+		})
+		return
+	}
+	if requestModel.PayerId == "" && requestModel.RequesterId == "" {
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong query params",
+			Errors:  "payerId or requesterId is required",
+		})
+		return
+	}
+
+	// parse parses an optional user id query param, it reports false once it wrote a bad request response
+	parse := func(name string, value string) (*uuid.UUID, bool) {
+		if value == "" {
+			return nil, true
+		}
+		userId, err := uuid.Parse(value)
+		if err != nil {
+			h.logger.WithContext(ctx.Request.Context()).Warnf("could not parse %s %v, error: %s",
+				name, value, err.Error())
+			ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+				Message: "wrong " + name + " format",
+				Errors:  err.Error(),
+			})
+			return nil, false
+		}
+		return &userId, true
+	}
+
+	payerId, ok := parse("payerId", requestModel.PayerId)
+	if !ok {
+		return
+	}
+	requesterId, ok := parse("requesterId", requestModel.RequesterId)
+	if !ok {
+		return
+	}
+
+	pageNum, pageSize, ok := h.parsePagination(ctx)
+	if !ok {
+		return
+	}
+
+	requests, err := h.services.GetPaymentRequests(ctx.Request.Context(), model.PaymentRequestFilter{
+		PayerId:     payerId,
+		RequesterId: requesterId,
+		Status:      requestModel.Status,
+	}, pageNum-1, pageSize)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not get payment requests, error: %s",
+			err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schemas.PaymentRequestsResponse{
+		Items: requests,
+		Len:   len(requests),
+	})
+}
+
+func (h Handler) getPaymentRequest(ctx *gin.Context) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	request, err := h.services.GetPaymentRequest(ctx.Request.Context(), id)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not get payment request %v, error: %s",
+			id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, request)
+}
+
+func (h Handler) acceptPaymentRequest(ctx *gin.Context) {
+	h.actOnPaymentRequest(ctx, func(id uuid.UUID, action schemas.PaymentRequestActionRequest) (
+		model.PaymentRequest, error) {
+		return h.services.AcceptPaymentRequest(ctx.Request.Context(), id, action.UserId)
+	})
+}
+
+func (h Handler) declinePaymentRequest(ctx *gin.Context) {
+	h.actOnPaymentRequest(ctx, func(id uuid.UUID, action schemas.PaymentRequestActionRequest) (
+		model.PaymentRequest, error) {
+		return h.services.DeclinePaymentRequest(ctx.Request.Context(), id, action.UserId, action.Reason)
+	})
+}
+
+func (h Handler) cancelPaymentRequest(ctx *gin.Context) {
+	h.actOnPaymentRequest(ctx, func(id uuid.UUID, action schemas.PaymentRequestActionRequest) (
+		model.PaymentRequest, error) {
+		return h.services.CancelPaymentRequest(ctx.Request.Context(), id, action.UserId, action.Reason)
+	})
+}
+
+// actOnPaymentRequest binds the request naming the acting user and responds with the request changed by act.
+func (h Handler) actOnPaymentRequest(ctx *gin.Context,
+	act func(id uuid.UUID, action schemas.PaymentRequestActionRequest) (model.PaymentRequest, error)) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	var requestModel schemas.PaymentRequestActionRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	request, err := act(id, requestModel)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not change payment request %v, error: %s",
+			id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, request)
+}
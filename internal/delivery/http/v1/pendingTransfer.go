@@ -0,0 +1,127 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) initPendingTransferRoutes(api *gin.RouterGroup) {
+	pendingTransfers := api.Group("/pending-transfers")
+	{
+		pendingTransfers.POST("", h.authorizeTransfer)
+		pendingTransfers.GET("/:id", h.getPendingTransfer)
+		pendingTransfers.POST("/:id/capture", h.captureTransfer)
+		pendingTransfers.POST("/:id/void", h.voidTransfer)
+	}
+}
+
+// authorizeTransfer holds the amount from the sender until the transfer is captured or voided.
+func (h Handler) authorizeTransfer(ctx *gin.Context) {
+	var requestModel schemas.AuthorizeTransferRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	transfer, err := h.services.AuthorizeTransfer(ctx.Request.Context(), requestModel.SenderId,
+		requestModel.ReceiverId, requestModel.Amount, requestModel.Reference)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not authorize transfer, error: %s",
+			err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, transfer)
+}
+
+func (h Handler) getPendingTransfer(ctx *gin.Context) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	transfer, err := h.services.GetPendingTransfer(ctx.Request.Context(), id)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not get pending transfer %v, error: %s",
+			id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, transfer)
+}
+
+func (h Handler) captureTransfer(ctx *gin.Context) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	var requestModel schemas.CaptureTransferRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	transfer, err := h.services.CaptureTransfer(ctx.Request.Context(), id, requestModel.Amount)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not capture pending transfer %v, error: %s",
+			id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, transfer)
+}
+
+func (h Handler) voidTransfer(ctx *gin.Context) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	var requestModel schemas.VoidTransferRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	transfer, err := h.services.VoidTransfer(ctx.Request.Context(), id, requestModel.Reason)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not void pending transfer %v, error: %s",
+			id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, transfer)
+}
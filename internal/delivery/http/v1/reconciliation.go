@@ -0,0 +1,128 @@
+package v1
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) initReconciliationRoutes(admin *gin.RouterGroup) {
+	reconciliations := admin.Group("/reconciliations")
+	{
+		reconciliations.POST("", h.reconcile)
+		reconciliations.GET("", h.getReconciliations)
+		reconciliations.GET("/:id", h.getReconciliation)
+		reconciliations.POST("/mismatches/:id/approve", h.approveCorrection)
+	}
+}
+
+// reconcile runs a reconciliation and responds with its report once it is done.
+func (h Handler) reconcile(ctx *gin.Context) {
+	// the body is optional, without it every account is compared
+	var requestModel schemas.ReconcileRequest
+
+	if err := ctx.ShouldBindJSON(&requestModel); err != nil && !errors.Is(err, io.EOF) {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	report, err := h.services.Reconcile(ctx.Request.Context(), model.ReconciliationTriggerAdmin,
+		requestModel.SampleSize)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Errorf("could not reconcile balances, error: %s", err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+func (h Handler) getReconciliations(ctx *gin.Context) {
+	pageNum, pageSize, ok := h.parsePagination(ctx)
+	if !ok {
+		return
+	}
+
+	runs, err := h.services.GetReconciliations(ctx.Request.Context(), pageNum-1, pageSize)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Errorf("could not get reconciliations, error: %s", err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schemas.ReconciliationsResponse{
+		Items: runs,
+		Len:   len(runs),
+	})
+}
+
+func (h Handler) getReconciliation(ctx *gin.Context) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	report, err := h.services.GetReconciliation(ctx.Request.Context(), id)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not get reconciliation %v, error: %s",
+			id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+func (h Handler) approveCorrection(ctx *gin.Context) {
+	idStr := ctx.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not parse mismatch id %v, error: %s",
+			idStr, err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong id format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	var requestModel schemas.ApproveCorrectionRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	mismatch, err := h.services.ApproveCorrection(ctx.Request.Context(), id, requestModel.OperatorId)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not approve correction of mismatch %v, error: %s",
+			id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, mismatch)
+}
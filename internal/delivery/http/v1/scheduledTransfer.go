@@ -0,0 +1,166 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) initScheduledTransferRoutes(api *gin.RouterGroup) {
+	scheduledTransfers := api.Group("/scheduled-transfers")
+	{
+		scheduledTransfers.POST("", h.createScheduledTransfer)
+		scheduledTransfers.GET("", h.getScheduledTransfers)
+		scheduledTransfers.GET("/:id", h.getScheduledTransfer)
+		scheduledTransfers.PUT("/:id", h.updateScheduledTransfer)
+		scheduledTransfers.DELETE("/:id", h.deleteScheduledTransfer)
+		scheduledTransfers.GET("/:id/executions", h.getScheduledTransferExecutions)
+	}
+}
+
+func (h Handler) createScheduledTransfer(ctx *gin.Context) {
+	var requestModel schemas.CreateScheduledTransferRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	transfer, err := h.services.CreateScheduledTransfer(ctx.Request.Context(), requestModel.SenderId,
+		requestModel.ReceiverId, requestModel.Amount, requestModel.StartAt, requestModel.Recurrence,
+		requestModel.DayOfMonth)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not create scheduled transfer, error: %s",
+			err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, transfer)
+}
+
+func (h Handler) getScheduledTransfers(ctx *gin.Context) {
+	pageNum, pageSize, ok := h.parsePagination(ctx)
+	if !ok {
+		return
+	}
+
+	transfers, err := h.services.GetAllScheduledTransfers(ctx.Request.Context(), pageNum-1, pageSize)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Errorf("could not get scheduled transfers, error: %s",
+			err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schemas.ScheduledTransfersResponse{
+		Items: transfers,
+		Len:   len(transfers),
+	})
+}
+
+func (h Handler) getScheduledTransfer(ctx *gin.Context) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	transfer, err := h.services.GetScheduledTransfer(ctx.Request.Context(), id)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not get scheduled transfer %v, error: %s",
+			id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, transfer)
+}
+
+func (h Handler) updateScheduledTransfer(ctx *gin.Context) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	var requestModel schemas.UpdateScheduledTransferRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	transfer, err := h.services.UpdateScheduledTransfer(ctx.Request.Context(), id, requestModel.Amount,
+		requestModel.Recurrence, requestModel.DayOfMonth, requestModel.NextRunAt, requestModel.Active)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not update scheduled transfer %v, error: %s",
+			id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, transfer)
+}
+
+func (h Handler) deleteScheduledTransfer(ctx *gin.Context) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.services.DeleteScheduledTransfer(ctx.Request.Context(), id); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not delete scheduled transfer %v, error: %s",
+			id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h Handler) getScheduledTransferExecutions(ctx *gin.Context) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	pageNum, pageSize, ok := h.parsePagination(ctx)
+	if !ok {
+		return
+	}
+
+	executions, err := h.services.GetScheduledTransferExecutions(ctx.Request.Context(), id, pageNum-1, pageSize)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not get executions of scheduled transfer %v, "+
+			"error: %s", id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schemas.ScheduledTransferExecutionsResponse{
+		Items: executions,
+		Len:   len(executions),
+	})
+}
@@ -4,19 +4,25 @@ import (
 	"fmt"
 	"math"
 	"net/http"
-	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Feokrat/user-balance-api/internal/schemas"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+// dayFormat is the format of the dates of daily balance series.
+const dayFormat = "2006-01-02"
+
 func (h *Handler) initUserBalanceRoutes(api *gin.RouterGroup) {
 	userBalances := api.Group("/balances")
 	{
 		userBalances.GET("/:id", h.getUserBalance)
+		userBalances.GET("/:id/daily", h.getDailyBalances)
 		userBalances.PUT("/", h.changeUserBalance)
 		userBalances.POST("/send/", h.sendMoneyFromUserToUser)
+		userBalances.POST("/split/", h.splitMoneyBetweenUsers)
 		userBalances.GET("/transactionLogs/:id", h.getTransactionLogs)
 	}
 }
@@ -25,7 +31,7 @@ func (h Handler) getTransactionLogs(ctx *gin.Context) {
 	userIdStr := ctx.Param("id")
 	userId, err := uuid.Parse(userIdStr)
 	if err != nil {
-		h.logger.Printf("could not parse user id %v, error: %s",
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not parse user id %v, error: %s",
 			userIdStr, err.Error())
 		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
 			Message: "wrong user id format",
@@ -33,41 +39,19 @@ func (h Handler) getTransactionLogs(ctx *gin.Context) {
 		})
 		return
 	}
-	pageNum := 1
-	pageSize := 1000
-
-	pageNumStr := ctx.Query("pageNum")
-	if pageNumStr != "" {
-		pageNum, err = strconv.Atoi(pageNumStr)
-		if err != nil {
-			h.logger.Printf("could not convert pageNum param to int")
-			ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
-				Message: "could not convert pageNum param to int",
-				Errors:  err.Error(),
-			})
-			return
-		}
-	}
-	pageSizeStr := ctx.Query("pageSize")
-	if pageSizeStr != "" {
-		pageSize, err = strconv.Atoi(pageSizeStr)
-		if err != nil {
-			h.logger.Printf("could not convert pageSize param to int")
-			ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
-				Message: "could not convert pageSize param to int",
-				Errors:  err.Error(),
-			})
-			return
-		}
+	pageNum, pageSize, ok := h.parsePagination(ctx)
+	if !ok {
+		return
 	}
+
 	sortField := ctx.Query("sortField")
 	if sortField == "" {
 		sortField = "date"
 	}
 
-	logs, err := h.services.GetAllUserLogs(userId, sortField, pageNum-1, pageSize)
+	logs, err := h.services.GetAllUserLogs(ctx.Request.Context(), userId, sortField, pageNum-1, pageSize)
 	if err != nil {
-		h.logger.Printf("could not get all transaction logs of user %v",
+		h.logger.WithContext(ctx.Request.Context()).Errorf("could not get all transaction logs of user %v",
 			userId)
 		ctx.JSON(http.StatusInternalServerError, schemas.ErrorResponse{
 			Message: err.Error(),
@@ -75,9 +59,9 @@ func (h Handler) getTransactionLogs(ctx *gin.Context) {
 		return
 	}
 
-	countAll, err := h.services.CountUserLogs(userId)
+	countAll, err := h.services.CountUserLogs(ctx.Request.Context(), userId)
 	if err != nil {
-		h.logger.Printf("could not count all transaction logs of user %v",
+		h.logger.WithContext(ctx.Request.Context()).Errorf("could not count all transaction logs of user %v",
 			userId)
 		ctx.JSON(http.StatusInternalServerError, schemas.ErrorResponse{
 			Message: err.Error(),
@@ -85,9 +69,9 @@ func (h Handler) getTransactionLogs(ctx *gin.Context) {
 		return
 	}
 	ctx.JSON(http.StatusOK, schemas.TransactionLogResponse{
-		Items:   logs,
-		Len:     len(logs),
-		All:     countAll,
+		Items: logs,
+		Len:   len(logs),
+		All:   countAll,
 	})
 }
 
@@ -95,7 +79,7 @@ func (h Handler) getUserBalance(ctx *gin.Context) {
 	userIdStr := ctx.Param("id")
 	userId, err := uuid.Parse(userIdStr)
 	if err != nil {
-		h.logger.Printf("could not parse user id %v, error: %s",
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not parse user id %v, error: %s",
 			userIdStr, err.Error())
 		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
 			Message: "wrong user id format",
@@ -105,11 +89,27 @@ func (h Handler) getUserBalance(ctx *gin.Context) {
 		return
 	}
 
-	userBalance, err := h.services.GetBalanceByUserId(userId)
+	if atStr := ctx.Query("at"); atStr != "" {
+		h.getUserBalanceAt(ctx, userId, atStr)
+		return
+	}
+
+	userBalance, err := h.services.GetAccountBalance(ctx.Request.Context(), userId)
 	if err != nil {
-		h.logger.Printf("could not get balance of user %v, error: %s",
+		h.logger.WithContext(ctx.Request.Context()).Errorf("could not get balance of user %v, error: %s",
 			userId, err.Error())
-		ctx.JSON(http.StatusInternalServerError, schemas.ErrorResponse{
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+
+		return
+	}
+
+	breakdown, err := h.services.GetBalanceBreakdown(ctx.Request.Context(), userId)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Errorf("could not get bonus of user %v, error: %s",
+			userId, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
 			Message: err.Error(),
 		})
 
@@ -118,11 +118,21 @@ func (h Handler) getUserBalance(ctx *gin.Context) {
 
 	currencyConvert := ctx.Query("currency")
 	if currencyConvert == "" {
-		ctx.JSON(http.StatusOK, schemas.UserBalanceResponse{Balance: userBalance})
+		ctx.JSON(http.StatusOK, schemas.UserBalanceResponse{
+			Balance:         userBalance.Balance,
+			OverdraftLimit:  userBalance.OverdraftLimit,
+			Available:       userBalance.Available(),
+			Real:            breakdown.Real,
+			Bonus:           breakdown.Bonus,
+			BonusGrants:     breakdown.Grants,
+			Pending:         breakdown.Pending,
+			PendingIncoming: breakdown.PendingIncoming,
+		})
 	} else {
-		exchangeRate, err := h.services.GetExchangeRate("", currencyConvert)
+		exchangeRate, err := h.services.GetExchangeRate(ctx.Request.Context(), userBalance.Currency,
+			currencyConvert)
 		if err != nil {
-			h.logger.Printf("could not get exchange rates, error: %s",
+			h.logger.WithContext(ctx.Request.Context()).Errorf("could not get exchange rates, error: %s",
 				err.Error())
 			ctx.JSON(http.StatusInternalServerError, schemas.ErrorResponse{
 				Message: err.Error(),
@@ -130,19 +140,108 @@ func (h Handler) getUserBalance(ctx *gin.Context) {
 			return
 		}
 
-		h.logger.Println(userBalance * exchangeRate)
 		ctx.JSON(http.StatusOK, schemas.UserBalanceResponse{
-			Balance: math.Ceil(userBalance*exchangeRate*100) / 100,
+			Balance:         math.Ceil(userBalance.Balance*exchangeRate*100) / 100,
+			OverdraftLimit:  math.Ceil(userBalance.OverdraftLimit*exchangeRate*100) / 100,
+			Available:       math.Ceil(userBalance.Available()*exchangeRate*100) / 100,
+			Real:            math.Ceil(breakdown.Real*exchangeRate*100) / 100,
+			Bonus:           math.Ceil(breakdown.Bonus*exchangeRate*100) / 100,
+			Pending:         math.Ceil(breakdown.Pending*exchangeRate*100) / 100,
+			PendingIncoming: math.Ceil(breakdown.PendingIncoming*exchangeRate*100) / 100,
 		})
 		return
 	}
 }
 
+// getUserBalanceAt responds with the balance the user had at the time given in RFC3339, converted to the
+// currency query parameter at the current exchange rate when it is set.
+func (h Handler) getUserBalanceAt(ctx *gin.Context, userId uuid.UUID, atStr string) {
+	at, err := time.Parse(time.RFC3339, atStr)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not parse time %v, error: %s", atStr, err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong at format, expected RFC3339",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	balance, err := h.services.GetBalanceAt(ctx.Request.Context(), userId, at)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Errorf("could not get balance of user %v at %v, error: %s",
+			userId, at, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	currencyConvert := ctx.Query("currency")
+	if currencyConvert != "" {
+		exchangeRate, err := h.services.GetExchangeRate(ctx.Request.Context(), balance.Currency, currencyConvert)
+		if err != nil {
+			h.logger.WithContext(ctx.Request.Context()).Errorf("could not get exchange rates, error: %s",
+				err.Error())
+			ctx.JSON(http.StatusInternalServerError, schemas.ErrorResponse{
+				Message: err.Error(),
+			})
+			return
+		}
+
+		balance.Balance = math.Ceil(balance.Balance*exchangeRate*100) / 100
+		balance.Currency = strings.ToUpper(currencyConvert)
+	}
+
+	ctx.JSON(http.StatusOK, schemas.HistoricalBalanceResponse{
+		Balance:  balance.Balance,
+		Currency: balance.Currency,
+		At:       balance.At,
+	})
+}
+
+func (h Handler) getDailyBalances(ctx *gin.Context) {
+	userId, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	var dates [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		date, err := time.Parse(dayFormat, ctx.Query(name))
+		if err != nil {
+			h.logger.WithContext(ctx.Request.Context()).Warnf("could not parse %s date %v, error: %s", name,
+				ctx.Query(name), err.Error())
+			ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+				Message: fmt.Sprintf("wrong %s format, expected %s", name, dayFormat),
+				Errors:  err.Error(),
+			})
+			return
+		}
+		dates[i] = date
+	}
+
+	series, err := h.services.GetDailyBalances(ctx.Request.Context(), userId, dates[0], dates[1])
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Errorf("could not get daily balances of user %v, error: %s",
+			userId, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schemas.DailyBalancesResponse{
+		Items:    series.Items,
+		Len:      len(series.Items),
+		Currency: series.Currency,
+	})
+}
+
 func (h Handler) changeUserBalance(ctx *gin.Context) {
 	var requestModel schemas.ChangeBalanceRequest
 
 	if err := ctx.BindJSON(&requestModel); err != nil {
-		h.logger.Printf("request body in wrong format, error: %s",
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
 			err.Error())
 		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
 			Message: "wrong request format",
@@ -151,18 +250,18 @@ func (h Handler) changeUserBalance(ctx *gin.Context) {
 		return
 	}
 
-	created, err := h.services.ChangeUserBalanceByUserId(requestModel.UserId, requestModel.ChangeAmount)
+	created, err := h.services.ChangeUserBalanceByUserId(ctx.Request.Context(), requestModel.UserId,
+		requestModel.ChangeAmount)
 	if err != nil {
-		h.logger.Printf("could not change balance of user %v, error: %s",
+		h.logger.WithContext(ctx.Request.Context()).Errorf("could not change balance of user %v, error: %s",
 			requestModel.UserId, err.Error())
-		ctx.JSON(http.StatusInternalServerError, schemas.ErrorResponse{
-			Message: err.Error(),
-		})
+		ctx.JSON(errorStatus(err), errorResponse(err))
 		return
 	}
 
 	if created {
-		h.logger.Printf("created a new account balance")
+		h.logger.WithContext(ctx.Request.Context()).WithField("user_id", requestModel.UserId).
+			Infof("created a new account balance")
 		ctx.JSON(http.StatusCreated, "")
 	}
 }
@@ -171,7 +270,7 @@ func (h Handler) sendMoneyFromUserToUser(ctx *gin.Context) {
 	var requestModel schemas.TransactionRequest
 
 	if err := ctx.BindJSON(&requestModel); err != nil {
-		h.logger.Printf("request body in wrong format, error: %s",
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
 			err.Error())
 		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
 			Message: "wrong request model",
@@ -192,13 +291,43 @@ func (h Handler) sendMoneyFromUserToUser(ctx *gin.Context) {
 		return
 	}
 
-	err := h.services.ApplyTransaction(requestModel.SenderId, requestModel.ReceiverId, requestModel.Amount)
+	apply := h.services.ApplyTransaction
+	if requestModel.ExcludeBonus {
+		apply = h.services.ApplyTransactionExcludingBonus
+	}
+
+	err := apply(ctx.Request.Context(), requestModel.SenderId, requestModel.ReceiverId, requestModel.Amount)
 	if err != nil {
-		h.logger.Printf("could not apply transaction from user %v to user %v, error: %s",
+		h.logger.WithContext(ctx.Request.Context()).Errorf(
+			"could not apply transaction from user %v to user %v, error: %s",
 			requestModel.SenderId, requestModel.ReceiverId, err.Error())
-		ctx.JSON(http.StatusInternalServerError, schemas.ErrorResponse{
-			Message: err.Error(),
+		ctx.JSON(errorStatus(err), errorResponse(err))
+		return
+	}
+}
+
+func (h Handler) splitMoneyBetweenUsers(ctx *gin.Context) {
+	var requestModel schemas.SplitTransferRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request model",
+			Errors:  err.Error(),
 		})
 		return
 	}
+
+	split, err := h.services.ApplySplitTransaction(ctx.Request.Context(), requestModel.SenderId,
+		requestModel.Amount, requestModel.Receivers, requestModel.ExcludeBonus)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Errorf(
+			"could not split money of user %v between %d users, error: %s",
+			requestModel.SenderId, len(requestModel.Receivers), err.Error())
+		ctx.JSON(errorStatus(err), errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, split)
 }
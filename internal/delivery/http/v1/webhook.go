@@ -0,0 +1,157 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) initWebhookRoutes(api *gin.RouterGroup) {
+	webhooks := api.Group("/webhooks")
+	{
+		webhooks.POST("", h.createWebhook)
+		webhooks.GET("", h.getWebhooks)
+		webhooks.GET("/:id", h.getWebhook)
+		webhooks.PUT("/:id", h.updateWebhook)
+		webhooks.DELETE("/:id", h.deleteWebhook)
+		webhooks.GET("/:id/deliveries", h.getWebhookDeliveries)
+	}
+}
+
+func (h Handler) createWebhook(ctx *gin.Context) {
+	var requestModel schemas.CreateWebhookRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	subscription, err := h.services.CreateSubscription(ctx.Request.Context(), requestModel.URL,
+		requestModel.EventTypes, requestModel.Secret)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Errorf("could not create webhook subscription, error: %s",
+			err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, subscription)
+}
+
+func (h Handler) getWebhooks(ctx *gin.Context) {
+	subscriptions, err := h.services.GetAllSubscriptions(ctx.Request.Context())
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Errorf("could not get webhook subscriptions, error: %s",
+			err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schemas.WebhookSubscriptionsResponse{Items: subscriptions})
+}
+
+func (h Handler) getWebhook(ctx *gin.Context) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	subscription, err := h.services.GetSubscription(ctx.Request.Context(), id)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not get webhook subscription %v, error: %s",
+			id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, subscription)
+}
+
+func (h Handler) updateWebhook(ctx *gin.Context) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	var requestModel schemas.UpdateWebhookRequest
+
+	if err := ctx.BindJSON(&requestModel); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("request body in wrong format, error: %s",
+			err.Error())
+		ctx.JSON(http.StatusBadRequest, schemas.ValidationErrorResponse{
+			Message: "wrong request format",
+			Errors:  err.Error(),
+		})
+		return
+	}
+
+	subscription, err := h.services.UpdateSubscription(ctx.Request.Context(), id, requestModel.URL,
+		requestModel.EventTypes, requestModel.Active)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not update webhook subscription %v, error: %s",
+			id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, subscription)
+}
+
+func (h Handler) deleteWebhook(ctx *gin.Context) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.services.DeleteSubscription(ctx.Request.Context(), id); err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not delete webhook subscription %v, error: %s",
+			id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h Handler) getWebhookDeliveries(ctx *gin.Context) {
+	id, ok := h.parseUUIDParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	pageNum, pageSize, ok := h.parsePagination(ctx)
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.services.GetDeliveries(ctx.Request.Context(), id, pageNum-1, pageSize)
+	if err != nil {
+		h.logger.WithContext(ctx.Request.Context()).Warnf("could not get deliveries of webhook subscription %v, "+
+			"error: %s", id, err.Error())
+		ctx.JSON(errorStatus(err), schemas.ErrorResponse{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schemas.WebhookDeliveriesResponse{
+		Items: deliveries,
+		Len:   len(deliveries),
+	})
+}
@@ -0,0 +1,16 @@
+package logger
+
+import "context"
+
+type requestIdKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the given request id.
+func ContextWithRequestID(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// RequestID returns the request id stored in ctx or an empty string.
+func RequestID(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
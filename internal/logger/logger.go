@@ -0,0 +1,104 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+type Fields map[string]interface{}
+
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+
+	WithField(key string, value interface{}) Logger
+	WithFields(fields Fields) Logger
+	// WithContext attaches the request id carried by ctx, if any.
+	WithContext(ctx context.Context) Logger
+}
+
+type logrusLogger struct {
+	entry *logrus.Entry
+}
+
+// New builds a logger writing to stdout with the given level and format.
+func New(level string, format string) (Logger, error) {
+	return newLogger(os.Stdout, level, format)
+}
+
+// NewDefault builds a JSON info-level logger, used before the configuration is loaded.
+func NewDefault() Logger {
+	l, _ := newLogger(os.Stdout, logrus.InfoLevel.String(), FormatJSON)
+	return l
+}
+
+func newLogger(out io.Writer, level string, format string) (Logger, error) {
+	l := logrus.New()
+	l.SetOutput(out)
+
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	l.SetLevel(lvl)
+
+	switch format {
+	case FormatJSON, "":
+		l.SetFormatter(&logrus.JSONFormatter{})
+	case FormatText:
+		l.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	return &logrusLogger{entry: logrus.NewEntry(l)}, nil
+}
+
+func (l *logrusLogger) Debugf(format string, args ...interface{}) {
+	l.entry.Debugf(format, args...)
+}
+
+func (l *logrusLogger) Infof(format string, args ...interface{}) {
+	l.entry.Infof(format, args...)
+}
+
+func (l *logrusLogger) Warnf(format string, args ...interface{}) {
+	l.entry.Warnf(format, args...)
+}
+
+func (l *logrusLogger) Errorf(format string, args ...interface{}) {
+	l.entry.Errorf(format, args...)
+}
+
+func (l *logrusLogger) Fatalf(format string, args ...interface{}) {
+	l.entry.Fatalf(format, args...)
+}
+
+func (l *logrusLogger) WithField(key string, value interface{}) Logger {
+	return &logrusLogger{entry: l.entry.WithField(key, value)}
+}
+
+func (l *logrusLogger) WithFields(fields Fields) Logger {
+	return &logrusLogger{entry: l.entry.WithFields(logrus.Fields(fields))}
+}
+
+func (l *logrusLogger) WithContext(ctx context.Context) Logger {
+	requestId := RequestID(ctx)
+	if requestId == "" {
+		return l
+	}
+
+	return l.WithField("request_id", requestId)
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	AuditProtocolHTTP = "http"
+	AuditProtocolGRPC = "grpc"
+)
+
+// TransactionLogIds is stored as a jsonb column.
+type TransactionLogIds []int32
+
+func (ids TransactionLogIds) Value() (driver.Value, error) {
+	if ids == nil {
+		return []byte("[]"), nil
+	}
+
+	return json.Marshal(ids)
+}
+
+func (ids *TransactionLogIds) Scan(src interface{}) error {
+	data, ok := src.([]byte)
+	if !ok {
+		return errors.New("transaction log ids must be scanned from []byte")
+	}
+
+	return json.Unmarshal(data, ids)
+}
+
+// AuditEntry records a mutating API call: who made it, what it asked for and what it did.
+type AuditEntry struct {
+	Id       int64  `json:"id" db:"id"`
+	Protocol string `json:"protocol" db:"protocol"`
+	// Method is the HTTP method of REST calls and the method name of gRPC calls.
+	Method string `json:"method" db:"method"`
+	// Route is the matched route template of REST calls and the full method of gRPC calls.
+	Route string `json:"route" db:"route"`
+	Path  string `json:"path" db:"path"`
+	// CallerId is the identity the caller presented, empty when it presented none.
+	CallerId string `json:"callerId" db:"caller_id"`
+	// Admin marks calls authorized with the admin key.
+	Admin     bool   `json:"admin" db:"admin"`
+	SourceIp  string `json:"sourceIp" db:"source_ip"`
+	UserAgent string `json:"userAgent" db:"user_agent"`
+	RequestId string `json:"requestId" db:"request_id"`
+	// Body is the request body with secrets redacted, nil when there was no JSON body.
+	Body json.RawMessage `json:"body" db:"body"`
+	// Status is the HTTP status of REST calls and the gRPC status code of gRPC calls.
+	Status int `json:"status" db:"status"`
+	// TransactionLogIds are the transaction log entries committed by the call.
+	TransactionLogIds TransactionLogIds `json:"transactionLogIds" db:"transaction_log_ids"`
+	CreatedAt         time.Time         `json:"createdAt" db:"created_at"`
+}
+
+// AuditFilter selects audit entries, empty fields match every entry.
+type AuditFilter struct {
+	CallerId         string
+	Protocol         string
+	Method           string
+	Route            string
+	Status           *int
+	RequestId        string
+	TransactionLogId *int32
+	From             *time.Time
+	To               *time.Time
+}
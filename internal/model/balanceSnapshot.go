@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BalanceSnapshot is the balance of a user at the end of a day, Day being the date at midnight UTC. It
+// includes every transaction log entry dated up to AsOf and is where historical balances are
+// reconstructed from.
+type BalanceSnapshot struct {
+	UserId    uuid.UUID `json:"userId" db:"user_id"`
+	Day       time.Time `json:"day" db:"day"`
+	AsOf      time.Time `json:"asOf" db:"as_of"`
+	Balance   float64   `json:"balance" db:"balance"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// HistoricalBalance is the balance of a user at a point in time.
+type HistoricalBalance struct {
+	UserId   uuid.UUID `json:"userId"`
+	At       time.Time `json:"at"`
+	Balance  float64   `json:"balance"`
+	Currency string    `json:"currency"`
+}
+
+// DailyBalance is the closing balance of a day, Day being formatted as 2006-01-02.
+type DailyBalance struct {
+	Day     string    `json:"day"`
+	AsOf    time.Time `json:"asOf"`
+	Balance float64   `json:"balance"`
+}
+
+// BalanceSeries holds the closing balances of a user over consecutive days.
+type BalanceSeries struct {
+	UserId   uuid.UUID      `json:"userId"`
+	Currency string         `json:"currency"`
+	Items    []DailyBalance `json:"items"`
+}
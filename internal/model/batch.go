@@ -0,0 +1,86 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	BatchOperationCredit   = "credit"
+	BatchOperationDebit    = "debit"
+	BatchOperationTransfer = "transfer"
+)
+
+const (
+	BatchApplied = "applied"
+	// BatchFailed marks a batch rolled back because one of its operations failed.
+	BatchFailed = "failed"
+	// BatchRejected marks a batch that failed validation, none of its operations ran.
+	BatchRejected = "rejected"
+)
+
+const (
+	BatchItemApplied = "applied"
+	BatchItemFailed  = "failed"
+	BatchItemInvalid = "invalid"
+	// BatchItemRolledBack marks an operation that succeeded but was undone as a later one failed.
+	BatchItemRolledBack = "rolled_back"
+	// BatchItemSkipped marks an operation that did not run as the batch was rejected or failed before it.
+	BatchItemSkipped = "skipped"
+)
+
+// BatchOperation is a credit or debit of UserId, or a transfer from SenderId to ReceiverId.
+type BatchOperation struct {
+	Type       string    `json:"type"`
+	UserId     uuid.UUID `json:"userId,omitempty"`
+	SenderId   uuid.UUID `json:"senderId,omitempty"`
+	ReceiverId uuid.UUID `json:"receiverId,omitempty"`
+	Amount     float64   `json:"amount"`
+}
+
+type BatchItemResult struct {
+	Index  int    `json:"index"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	// Created reports that a credit created the balance of the user.
+	Created bool `json:"created,omitempty"`
+	// Balance is the balance of the user after a credit or debit.
+	Balance *float64 `json:"balance,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// BatchItemResults is stored as a jsonb column.
+type BatchItemResults []BatchItemResult
+
+func (r BatchItemResults) Value() (driver.Value, error) {
+	if r == nil {
+		return []byte("[]"), nil
+	}
+
+	return json.Marshal(r)
+}
+
+func (r *BatchItemResults) Scan(src interface{}) error {
+	data, ok := src.([]byte)
+	if !ok {
+		return errors.New("batch item results must be scanned from []byte")
+	}
+
+	return json.Unmarshal(data, r)
+}
+
+// Batch is an applied batch, failed batches are rolled back together with their operations.
+type Batch struct {
+	Id             uuid.UUID        `json:"id" db:"id"`
+	IdempotencyKey *string          `json:"idempotencyKey,omitempty" db:"idempotency_key"`
+	RequestHash    string           `json:"-" db:"request_hash"`
+	Status         string           `json:"status" db:"-"`
+	Items          BatchItemResults `json:"items" db:"result"`
+	// Replayed reports that the response is the stored result of an earlier request with the same key.
+	Replayed  bool      `json:"replayed" db:"-"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// BonusSpendExpiringFirst spends the grants expiring soonest first.
+	BonusSpendExpiringFirst = "expiringFirst"
+	// BonusSpendGrantedFirst spends the oldest grants first.
+	BonusSpendGrantedFirst = "grantedFirst"
+)
+
+// BonusGrant is promotional money granted to a user. It is part of the balance, spent before the
+// real funds, and whatever is left of it is taken back once it expires.
+type BonusGrant struct {
+	Id        int64     `json:"id" db:"id"`
+	UserId    uuid.UUID `json:"userId" db:"user_id"`
+	Amount    float64   `json:"amount" db:"amount"`
+	Remaining float64   `json:"remaining" db:"remaining"`
+	Reason    string    `json:"reason" db:"reason"`
+	ExpiresAt time.Time `json:"expiresAt" db:"expires_at"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	// TransactionLogId is the entry crediting the grant.
+	TransactionLogId int32 `json:"transactionLogId" db:"transaction_log_id"`
+	// ExpiredAt is when what was left of the grant was taken back, ExpiredAmount how much that was.
+	ExpiredAt     *time.Time `json:"expiredAt,omitempty" db:"expired_at"`
+	ExpiredAmount float64    `json:"expiredAmount" db:"expired_amount"`
+}
+
+// Due reports whether the grant expired by the given time, it can no longer be spent then.
+func (g BonusGrant) Due(at time.Time) bool {
+	return !g.ExpiresAt.After(at)
+}
+
+// BalanceBreakdown splits the balance of a user into real and bonus funds. Grants are the unexpired
+// ones in the order they are spent. Pending is held for outgoing pending transfers and PendingIncoming
+// is what incoming ones will credit once captured.
+type BalanceBreakdown struct {
+	UserId          uuid.UUID    `json:"userId"`
+	Real            float64      `json:"real"`
+	Bonus           float64      `json:"bonus"`
+	Grants          []BonusGrant `json:"grants"`
+	Pending         float64      `json:"pending"`
+	PendingIncoming float64      `json:"pendingIncoming"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// EscrowFunded marks an escrow holding the money of its buyer until it is settled.
+	EscrowFunded   = "funded"
+	EscrowReleased = "released"
+	EscrowRefunded = "refunded"
+	// EscrowSplit marks an escrow settled partly to the seller and partly back to the buyer.
+	EscrowSplit = "split"
+)
+
+// Escrow is the money of a deal taken from its buyer and held in the escrow account of its currency, which
+// belongs to neither party, until it is released to the seller, refunded to the buyer or split between them.
+type Escrow struct {
+	Id       uuid.UUID `json:"id" db:"id"`
+	DealId   string    `json:"dealId" db:"deal_id"`
+	BuyerId  uuid.UUID `json:"buyerId" db:"buyer_id"`
+	SellerId uuid.UUID `json:"sellerId" db:"seller_id"`
+	// AccountId is the escrow account holding the money.
+	AccountId      uuid.UUID `json:"accountId" db:"account_id"`
+	Amount         float64   `json:"amount" db:"amount"`
+	ReleasedAmount float64   `json:"releasedAmount" db:"released_amount"`
+	RefundedAmount float64   `json:"refundedAmount" db:"refunded_amount"`
+	Status         string    `json:"status" db:"status"`
+	// FundingLogId is the entry that took the money from the buyer, every entry of the escrow shares its
+	// id as correlation id.
+	FundingLogId *int32     `json:"fundingLogId,omitempty" db:"funding_log_id"`
+	CreatedAt    time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updatedAt" db:"updated_at"`
+	SettledAt    *time.Time `json:"settledAt,omitempty" db:"settled_at"`
+}
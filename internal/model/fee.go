@@ -0,0 +1,23 @@
+package model
+
+import "github.com/google/uuid"
+
+const (
+	FeeOperationTransfer   = "transfer"
+	FeeOperationWithdrawal = "withdrawal"
+)
+
+// FeeQuote is the fee a user is charged for an operation of Amount, on top of it.
+type FeeQuote struct {
+	Operation string    `json:"operation"`
+	UserId    uuid.UUID `json:"userId"`
+	Amount    float64   `json:"amount"`
+	Fee       float64   `json:"fee"`
+	// Total is what the operation takes from the balance of the user, the amount and the fee.
+	Total    float64 `json:"total"`
+	Currency string  `json:"currency"`
+	// Rule is the name of the fee rule in effect, empty when there is none.
+	Rule string `json:"rule,omitempty"`
+	// FreeTier marks fees waived because of the tier of the user.
+	FreeTier bool `json:"freeTier,omitempty"`
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	LimitMaxTransfer      = "maxTransfer"
+	LimitDailyOutgoing    = "dailyOutgoing"
+	LimitMonthlyOutgoing  = "monthlyOutgoing"
+	LimitMaxBalance       = "maxBalance"
+	LimitTransfersPerHour = "transfersPerHour"
+)
+
+// Limits are the limits applying to an account, a zero limit is no limit.
+type Limits struct {
+	MaxTransfer      float64 `json:"maxTransfer"`
+	DailyOutgoing    float64 `json:"dailyOutgoing"`
+	MonthlyOutgoing  float64 `json:"monthlyOutgoing"`
+	MaxBalance       float64 `json:"maxBalance"`
+	TransfersPerHour int     `json:"transfersPerHour"`
+}
+
+// UserLimits overrides the limits of the tier of a user, every nil field is taken from the tier.
+type UserLimits struct {
+	UserId           uuid.UUID `json:"userId" db:"user_id"`
+	Tier             *string   `json:"tier" db:"tier"`
+	MaxTransfer      *float64  `json:"maxTransfer" db:"max_transfer"`
+	DailyOutgoing    *float64  `json:"dailyOutgoing" db:"daily_outgoing"`
+	MonthlyOutgoing  *float64  `json:"monthlyOutgoing" db:"monthly_outgoing"`
+	MaxBalance       *float64  `json:"maxBalance" db:"max_balance"`
+	TransfersPerHour *int      `json:"transfersPerHour" db:"transfers_per_hour"`
+	UpdatedBy        string    `json:"updatedBy" db:"updated_by"`
+	UpdatedAt        time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// AccountLimits describes the limits of a user: its tier, its overrides and the resulting limits.
+type AccountLimits struct {
+	UserId    uuid.UUID  `json:"userId"`
+	Tier      string     `json:"tier"`
+	Overrides UserLimits `json:"overrides"`
+	Effective Limits     `json:"effective"`
+}
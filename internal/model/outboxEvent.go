@@ -0,0 +1,83 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EventAccountCreated       = "account.created"
+	EventBalanceCredited      = "balance.credited"
+	EventBalanceDebited       = "balance.debited"
+	EventTransferCompleted    = "transfer.completed"
+	EventSplitCompleted       = "transfer.split_completed"
+	EventOperationReversed    = "operation.reversed"
+	EventAccountStatusChanged = "account.status_changed"
+)
+
+// EventTypes lists every event type written to the outbox.
+var EventTypes = []string{
+	EventAccountCreated,
+	EventBalanceCredited,
+	EventBalanceDebited,
+	EventTransferCompleted,
+	EventSplitCompleted,
+	EventOperationReversed,
+	EventAccountStatusChanged,
+}
+
+// OutboxEvent is a balance change written in the same transaction as the change itself
+// and delivered to downstream services by the outbox dispatcher.
+type OutboxEvent struct {
+	Id           int64           `json:"id" db:"id"`
+	UserId       uuid.UUID       `json:"userId" db:"user_id"`
+	EventType    string          `json:"eventType" db:"event_type"`
+	Payload      json.RawMessage `json:"payload" db:"payload"`
+	RequestId    string          `json:"requestId" db:"request_id"`
+	CreatedAt    time.Time       `json:"createdAt" db:"created_at"`
+	DispatchedAt *time.Time      `json:"dispatchedAt,omitempty" db:"dispatched_at"`
+	Attempts     int             `json:"-" db:"attempts"`
+	LastError    string          `json:"-" db:"last_error"`
+}
+
+type BalanceChangedEvent struct {
+	UserId           uuid.UUID `json:"userId"`
+	Amount           float64   `json:"amount"`
+	Balance          float64   `json:"balance"`
+	TransactionLogId int32     `json:"transactionLogId"`
+	Commentary       string    `json:"commentary"`
+}
+
+type TransferCompletedEvent struct {
+	SenderId   uuid.UUID `json:"senderId"`
+	ReceiverId uuid.UUID `json:"receiverId"`
+	Amount     float64   `json:"amount"`
+	// Fee is what the sender paid on top of the amount.
+	Fee float64 `json:"fee,omitempty"`
+}
+
+type SplitCompletedEvent struct {
+	CorrelationId uuid.UUID     `json:"correlationId"`
+	SenderId      uuid.UUID     `json:"senderId"`
+	Amount        float64       `json:"amount"`
+	Fee           float64       `json:"fee,omitempty"`
+	Credits       []SplitCredit `json:"credits"`
+}
+
+type OperationReversedEvent struct {
+	TransactionLogId int32   `json:"transactionLogId"`
+	Amount           float64 `json:"amount"`
+	ReversedAmount   float64 `json:"reversedAmount"`
+	ReversalStatus   string  `json:"reversalStatus"`
+	Reason           string  `json:"reason,omitempty"`
+}
+
+type AccountStatusChangedEvent struct {
+	UserId     uuid.UUID `json:"userId"`
+	FromStatus string    `json:"fromStatus"`
+	ToStatus   string    `json:"toStatus"`
+	Reason     string    `json:"reason"`
+	OperatorId string    `json:"operatorId"`
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	PaymentRequestPending = "pending"
+	// PaymentRequestAccepted marks a request the payer paid.
+	PaymentRequestAccepted  = "accepted"
+	PaymentRequestDeclined  = "declined"
+	PaymentRequestCancelled = "cancelled"
+	// PaymentRequestExpired marks a request left pending past its expiry.
+	PaymentRequestExpired = "expired"
+)
+
+// PaymentRequest is a request of a user for money from another user, paid only if the payer accepts it.
+type PaymentRequest struct {
+	Id          uuid.UUID `json:"id" db:"id"`
+	RequesterId uuid.UUID `json:"requesterId" db:"requester_id"`
+	PayerId     uuid.UUID `json:"payerId" db:"payer_id"`
+	Amount      float64   `json:"amount" db:"amount"`
+	Note        string    `json:"note,omitempty" db:"note"`
+	Status      string    `json:"status" db:"status"`
+	ExpiresAt   time.Time `json:"expiresAt" db:"expires_at"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt   time.Time `json:"updatedAt" db:"updated_at"`
+	// Events is the history of the request, only filled in when a single request is asked for.
+	Events []PaymentRequestEvent `json:"events,omitempty" db:"-"`
+}
+
+// PaymentRequestEvent is an entry of the history of a payment request, one per change of its status.
+type PaymentRequestEvent struct {
+	Id               int64     `json:"id" db:"id"`
+	PaymentRequestId uuid.UUID `json:"paymentRequestId" db:"payment_request_id"`
+	// FromStatus is empty for the creation of the request.
+	FromStatus string `json:"fromStatus" db:"from_status"`
+	ToStatus   string `json:"toStatus" db:"to_status"`
+	// ActorId is the user who changed the status, nil when the request expired.
+	ActorId   *uuid.UUID `json:"actorId,omitempty" db:"actor_id"`
+	Reason    string     `json:"reason,omitempty" db:"reason"`
+	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
+}
+
+// PaymentRequestFilter selects payment requests, empty fields match every request.
+type PaymentRequestFilter struct {
+	RequesterId *uuid.UUID
+	PayerId     *uuid.UUID
+	Status      string
+}
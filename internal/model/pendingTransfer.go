@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// PendingTransferAuthorized marks a transfer whose amount is held from the sender until it is captured
+	// or voided.
+	PendingTransferAuthorized = "authorized"
+	PendingTransferCaptured   = "captured"
+	PendingTransferVoided     = "voided"
+)
+
+// PendingTransfer is a transfer authorized now and captured or voided later. While it is authorized its
+// amount and fee are held from the available balance of the sender and the receiver can not spend it yet.
+type PendingTransfer struct {
+	Id         uuid.UUID `json:"id" db:"id"`
+	SenderId   uuid.UUID `json:"senderId" db:"sender_id"`
+	ReceiverId uuid.UUID `json:"receiverId" db:"receiver_id"`
+	// Amount is the amount authorized.
+	Amount float64 `json:"amount" db:"amount"`
+	// Fee is the transfer fee quoted at authorization, charged on capture in proportion to what is captured.
+	Fee float64 `json:"fee" db:"fee"`
+	// HeldAmount is what is held from the sender, the amount and the fee.
+	HeldAmount float64 `json:"heldAmount" db:"held_amount"`
+	// CapturedAmount is what was transferred on capture, at most Amount.
+	CapturedAmount float64 `json:"capturedAmount,omitempty" db:"captured_amount"`
+	// Reference is an identifier of the caller, an order number for instance.
+	Reference  string `json:"reference,omitempty" db:"reference"`
+	Status     string `json:"status" db:"status"`
+	VoidReason string `json:"voidReason,omitempty" db:"void_reason"`
+	// TransactionLogId is the outgoing entry of the transfer made on capture.
+	TransactionLogId *int32    `json:"transactionLogId,omitempty" db:"transaction_log_id"`
+	ExpiresAt        time.Time `json:"expiresAt" db:"expires_at"`
+	CreatedAt        time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt        time.Time `json:"updatedAt" db:"updated_at"`
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ReconciliationTriggerSchedule = "schedule"
+	ReconciliationTriggerCLI      = "cli"
+	ReconciliationTriggerAdmin    = "admin"
+)
+
+const (
+	ReconciliationRunning   = "running"
+	ReconciliationCompleted = "completed"
+	ReconciliationFailed    = "failed"
+)
+
+const (
+	MismatchOpen = "open"
+	// MismatchCorrected mismatches were fixed by a correcting entry once approved.
+	MismatchCorrected = "corrected"
+	// MismatchResolved mismatches were gone by the time they were approved.
+	MismatchResolved = "resolved"
+)
+
+// ReconciliationRun compares the balance of every account, or of SampleSize random ones, with the net of
+// its logged movements.
+type ReconciliationRun struct {
+	Id         uuid.UUID `json:"id" db:"id"`
+	Trigger    string    `json:"trigger" db:"trigger"`
+	SampleSize int       `json:"sampleSize" db:"sample_size"`
+	Status     string    `json:"status" db:"status"`
+	Checked    int       `json:"checked" db:"checked"`
+	Mismatched int       `json:"mismatched" db:"mismatched"`
+	// TotalDifference sums the differences of the mismatches, balances minus logged balances.
+	TotalDifference float64    `json:"totalDifference" db:"total_difference"`
+	Error           string     `json:"error,omitempty" db:"error"`
+	StartedAt       time.Time  `json:"startedAt" db:"started_at"`
+	FinishedAt      *time.Time `json:"finishedAt,omitempty" db:"finished_at"`
+}
+
+// BalanceComparison is the balance of an account next to the net of its logged movements.
+type BalanceComparison struct {
+	UserId        uuid.UUID `db:"user_id"`
+	Balance       float64   `db:"balance"`
+	LoggedBalance float64   `db:"logged_balance"`
+}
+
+// ReconciliationMismatch is an account whose balance differed from its logged movements.
+type ReconciliationMismatch struct {
+	Id            int64     `json:"id" db:"id"`
+	RunId         uuid.UUID `json:"runId" db:"run_id"`
+	UserId        uuid.UUID `json:"userId" db:"user_id"`
+	Balance       float64   `json:"balance" db:"balance"`
+	LoggedBalance float64   `json:"loggedBalance" db:"logged_balance"`
+	// Difference is the balance minus the logged balance, what a correcting entry credits to the log.
+	Difference      float64    `json:"difference" db:"difference"`
+	Status          string     `json:"status" db:"status"`
+	ResolvedBy      string     `json:"resolvedBy,omitempty" db:"resolved_by"`
+	ResolvedAt      *time.Time `json:"resolvedAt,omitempty" db:"resolved_at"`
+	CorrectionLogId *int32     `json:"correctionLogId,omitempty" db:"correction_log_id"`
+}
+
+// ReconciliationReport is a run with the mismatches it found.
+type ReconciliationReport struct {
+	Run        ReconciliationRun        `json:"run"`
+	Mismatches []ReconciliationMismatch `json:"mismatches"`
+}
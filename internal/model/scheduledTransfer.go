@@ -0,0 +1,60 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	RecurrenceOnce    = "once"
+	RecurrenceDaily   = "daily"
+	RecurrenceWeekly  = "weekly"
+	RecurrenceMonthly = "monthly"
+)
+
+const (
+	ScheduledTransferActive = "active"
+	ScheduledTransferPaused = "paused"
+	// ScheduledTransferCompleted marks a one-off transfer that was executed.
+	ScheduledTransferCompleted = "completed"
+	// ScheduledTransferFailed marks a one-off transfer that was skipped or failed.
+	ScheduledTransferFailed = "failed"
+)
+
+const (
+	ExecutionSucceeded = "succeeded"
+	ExecutionFailed    = "failed"
+	// ExecutionSkipped marks an occurrence given up for insufficient funds.
+	ExecutionSkipped = "skipped"
+	// ExecutionRetrying marks an attempt that failed for insufficient funds and will be retried.
+	ExecutionRetrying = "retrying"
+)
+
+type ScheduledTransfer struct {
+	Id         uuid.UUID `json:"id" db:"id"`
+	SenderId   uuid.UUID `json:"senderId" db:"sender_id"`
+	ReceiverId uuid.UUID `json:"receiverId" db:"receiver_id"`
+	Amount     float64   `json:"amount" db:"amount"`
+	Recurrence string    `json:"recurrence" db:"recurrence"`
+	// DayOfMonth is the day monthly transfers run on, the last day of shorter months is used instead.
+	DayOfMonth int `json:"dayOfMonth,omitempty" db:"day_of_month"`
+	// NextRunAt is the time of the next occurrence.
+	NextRunAt time.Time `json:"nextRunAt" db:"next_run_at"`
+	// RetryAt is set while an occurrence waits for a retry after insufficient funds.
+	RetryAt   *time.Time `json:"retryAt,omitempty" db:"retry_at"`
+	Retries   int        `json:"retries" db:"retries"`
+	Status    string     `json:"status" db:"status"`
+	LastError string     `json:"lastError,omitempty" db:"last_error"`
+	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time  `json:"updatedAt" db:"updated_at"`
+}
+
+type ScheduledTransferExecution struct {
+	Id                  int64     `json:"id" db:"id"`
+	ScheduledTransferId uuid.UUID `json:"scheduledTransferId" db:"scheduled_transfer_id"`
+	ScheduledFor        time.Time `json:"scheduledFor" db:"scheduled_for"`
+	ExecutedAt          time.Time `json:"executedAt" db:"executed_at"`
+	Status              string    `json:"status" db:"status"`
+	Error               string    `json:"error,omitempty" db:"error"`
+}
@@ -0,0 +1,28 @@
+package model
+
+import "github.com/google/uuid"
+
+// SplitShare is what a receiver of a split transfer gets, either a fixed Amount or a Percent of the total.
+type SplitShare struct {
+	ReceiverId uuid.UUID `json:"receiverId"`
+	Amount     float64   `json:"amount,omitempty"`
+	Percent    float64   `json:"percent,omitempty"`
+}
+
+// SplitCredit is the money a receiver of a split transfer got and the entry crediting it.
+type SplitCredit struct {
+	ReceiverId       uuid.UUID `json:"receiverId"`
+	Amount           float64   `json:"amount"`
+	TransactionLogId int32     `json:"transactionLogId"`
+}
+
+// SplitTransfer is a transfer debiting the sender once and crediting several receivers. Every entry it
+// logs shares CorrelationId.
+type SplitTransfer struct {
+	CorrelationId    uuid.UUID     `json:"correlationId"`
+	SenderId         uuid.UUID     `json:"senderId"`
+	Amount           float64       `json:"amount"`
+	Fee              float64       `json:"fee,omitempty"`
+	TransactionLogId int32         `json:"transactionLogId"`
+	Credits          []SplitCredit `json:"credits"`
+}
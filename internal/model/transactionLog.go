@@ -1,14 +1,94 @@
 package model
 
 import (
-	"github.com/google/uuid"
 	"time"
+
+	"github.com/google/uuid"
 )
 
+const (
+	OperationCredit         = "credit"
+	OperationDebit          = "debit"
+	OperationTransferOut    = "transfer_out"
+	OperationTransferIn     = "transfer_in"
+	OperationReversalCredit = "reversal_credit"
+	OperationReversalDebit  = "reversal_debit"
+	// OperationPayout takes the remaining balance out of a closed account.
+	OperationPayout = "payout"
+	// OperationCorrectionCredit and OperationCorrectionDebit bring the log in line with a balance that
+	// drifted from it, they are written after a reconciliation and leave the balance as it is.
+	OperationCorrectionCredit = "correction_credit"
+	OperationCorrectionDebit  = "correction_debit"
+	// OperationFee takes the fee of an operation from the paying user, OperationFeeRevenue adds it to
+	// the revenue account.
+	OperationFee        = "fee"
+	OperationFeeRevenue = "fee_revenue"
+	// OperationBonusCredit adds a bonus grant to the balance, OperationBonusExpiry takes back what is
+	// left of it once it expires.
+	OperationBonusCredit = "bonus_credit"
+	OperationBonusExpiry = "bonus_expiry"
+	// OperationSplitOut takes a split transfer from its sender at once, an OperationSplitIn entry adds
+	// the share of each receiver.
+	OperationSplitOut = "split_out"
+	OperationSplitIn  = "split_in"
+	// OperationEscrowFund takes the money of a deal from its buyer and an OperationEscrowHold entry adds
+	// it to the escrow account. An OperationEscrowPayout entry takes it out of the escrow account again,
+	// to the seller with an OperationEscrowRelease entry or back to the buyer with an OperationEscrowRefund one.
+	OperationEscrowFund    = "escrow_fund"
+	OperationEscrowHold    = "escrow_hold"
+	OperationEscrowPayout  = "escrow_payout"
+	OperationEscrowRelease = "escrow_release"
+	OperationEscrowRefund  = "escrow_refund"
+)
+
+const (
+	ReversalStatusPartial  = "partially_reversed"
+	ReversalStatusReversed = "reversed"
+)
+
+// TransactionLog is an entry of the balance history. Amount is always positive, the operation
+// type tells whether it was added to or taken from the balance.
 type TransactionLog struct {
-	Id int32 `json:"id" db:"id"`
-	UserId uuid.UUID `json:"userId" db:"user_id"`
-	Date time.Time `json:"date" db:"date"`
-	Amount float64 `json:"amount" db:"amount"`
-	Commentary string `json:"commentary" db:"commentary"`
+	Id            int32     `json:"id" db:"id"`
+	UserId        uuid.UUID `json:"userId" db:"user_id"`
+	Date          time.Time `json:"date" db:"date"`
+	Amount        float64   `json:"amount" db:"amount"`
+	Commentary    string    `json:"commentary" db:"commentary"`
+	RequestId     string    `json:"requestId" db:"request_id"`
+	OperationType string    `json:"operationType" db:"operation_type"`
+	// CounterpartyId is the other user of a transfer.
+	CounterpartyId *uuid.UUID `json:"counterpartyId,omitempty" db:"counterparty_id"`
+	// RelatedLogId links the incoming entries of a transfer to its outgoing entry, and the revenue entry
+	// of a fee to its fee entry.
+	RelatedLogId *int32 `json:"relatedLogId,omitempty" db:"related_log_id"`
+	// ReversalOf is the entry a compensating entry reverses.
+	ReversalOf     *int32  `json:"reversalOf,omitempty" db:"reversal_of"`
+	ReversedAmount float64 `json:"reversedAmount" db:"reversed_amount"`
+	ReversalStatus string  `json:"reversalStatus,omitempty" db:"reversal_status"`
+	// FeeOf is the entry of the operation a fee entry was charged for.
+	FeeOf *int32 `json:"feeOf,omitempty" db:"fee_of"`
+	// CorrelationId is shared by the entries of a split transfer.
+	CorrelationId *uuid.UUID `json:"correlationId,omitempty" db:"correlation_id"`
+	// GraceEndsAt is set on entries logged while the balance was overdrawn, it is when the interest-free
+	// grace period of the overdraft ends.
+	GraceEndsAt *time.Time `json:"graceEndsAt,omitempty" db:"grace_ends_at"`
+}
+
+// Credit reports whether the entry added its amount to the balance.
+func (t TransactionLog) Credit() bool {
+	switch t.OperationType {
+	case OperationCredit, OperationTransferIn, OperationReversalCredit, OperationCorrectionCredit,
+		OperationFeeRevenue, OperationBonusCredit, OperationSplitIn, OperationEscrowHold, OperationEscrowRelease,
+		OperationEscrowRefund:
+		return true
+	default:
+		return false
+	}
+}
+
+// Reversal is the result of reversing a transaction log entry: the original with its updated
+// reversal state and the compensating entries written for it.
+type Reversal struct {
+	Original TransactionLog   `json:"original"`
+	Entries  []TransactionLog `json:"entries"`
 }
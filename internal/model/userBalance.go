@@ -1,8 +1,127 @@
 package model
 
-import "github.com/google/uuid"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// AccountActive accounts can be credited and debited.
+	AccountActive = "active"
+	// AccountFrozen accounts can still be credited, but nothing can be debited from them.
+	AccountFrozen = "frozen"
+	// AccountClosed accounts are closed permanently, they can be neither credited nor debited.
+	AccountClosed = "closed"
+)
+
+// AccountMetadata holds free-form labels of an account, it is stored as a jsonb column.
+type AccountMetadata map[string]string
+
+func (m AccountMetadata) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+
+	return json.Marshal(m)
+}
+
+func (m *AccountMetadata) Scan(src interface{}) error {
+	data, ok := src.([]byte)
+	if !ok {
+		return errors.New("account metadata must be scanned from []byte")
+	}
+
+	return json.Unmarshal(data, m)
+}
 
 type UserBalance struct {
 	UserId  uuid.UUID `json:"userId" db:"user_id"`
 	Balance float64   `json:"balance" db:"balance"`
+	Status  string    `json:"status" db:"status"`
+	// Currency is the ISO 4217 code of the currency the balance is kept in.
+	Currency  string          `json:"currency" db:"currency"`
+	Metadata  AccountMetadata `json:"metadata" db:"metadata"`
+	CreatedAt time.Time       `json:"createdAt" db:"created_at"`
+	// OverdraftLimit is the credit line of the account, how far its balance may go below zero.
+	OverdraftLimit float64 `json:"overdraftLimit" db:"overdraft_limit"`
+	// OverdrawnSince is when the balance went below zero, it is empty while the balance is not negative.
+	OverdrawnSince *time.Time `json:"overdrawnSince,omitempty" db:"overdrawn_since"`
+	// Held is the part of the balance authorized to pending transfers, which can not be spent until they
+	// are voided.
+	Held float64 `json:"held" db:"held"`
+}
+
+// Available returns the funds that can be spent: the balance plus the credit left, less what is held.
+func (u UserBalance) Available() float64 {
+	return u.Balance + u.OverdraftLimit - u.Held
+}
+
+// AccountStatusChange is an entry of the status history of an account.
+type AccountStatusChange struct {
+	Id         int64     `json:"id" db:"id"`
+	UserId     uuid.UUID `json:"userId" db:"user_id"`
+	FromStatus string    `json:"fromStatus" db:"from_status"`
+	ToStatus   string    `json:"toStatus" db:"to_status"`
+	Reason     string    `json:"reason" db:"reason"`
+	OperatorId string    `json:"operatorId" db:"operator_id"`
+	ChangedAt  time.Time `json:"changedAt" db:"changed_at"`
+}
+
+// AccountClosure is the result of closing an account: the closed account and the entry paying out
+// its remaining balance, if there was any.
+type AccountClosure struct {
+	Account UserBalance     `json:"account"`
+	Payout  *TransactionLog `json:"payout,omitempty"`
+}
+
+const (
+	AccountSortBalance   = "balance"
+	AccountSortCreatedAt = "createdAt"
+)
+
+// AccountFilter selects accounts, empty fields match every account.
+type AccountFilter struct {
+	MinBalance  *float64
+	MaxBalance  *float64
+	Status      string
+	Currency    string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+}
+
+// AccountCursor is the position of the last account of a page, the next page starts after it.
+type AccountCursor struct {
+	Balance   float64   `json:"balance"`
+	CreatedAt time.Time `json:"createdAt"`
+	UserId    uuid.UUID `json:"userId"`
+}
+
+// AccountQuery is a page of filtered accounts sorted by SortField, ties are broken by user id.
+type AccountQuery struct {
+	Filter     AccountFilter
+	SortField  string
+	Descending bool
+	After      *AccountCursor
+	Limit      int
+}
+
+// AccountTotals aggregates the filtered accounts of a currency.
+type AccountTotals struct {
+	Currency     string  `json:"currency" db:"currency"`
+	Count        int     `json:"count" db:"count"`
+	TotalBalance float64 `json:"totalBalance" db:"total_balance"`
+	MinBalance   float64 `json:"minBalance" db:"min_balance"`
+	MaxBalance   float64 `json:"maxBalance" db:"max_balance"`
+}
+
+// AccountPage is a page of accounts with the cursor of the next page, empty on the last page, and the
+// totals of all filtered accounts.
+type AccountPage struct {
+	Items      []UserBalance
+	NextCursor string
+	Totals     []AccountTotals
 }
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	// WebhookDeliveryDead marks a delivery that ran out of attempts.
+	WebhookDeliveryDead = "dead"
+)
+
+type WebhookSubscription struct {
+	Id  uuid.UUID `json:"id" db:"id"`
+	URL string    `json:"url" db:"url"`
+	// EventTypes filters delivered events, an empty list subscribes to every event.
+	EventTypes pq.StringArray `json:"eventTypes" db:"event_types"`
+	Secret     string         `json:"secret,omitempty" db:"secret"`
+	Active     bool           `json:"active" db:"active"`
+	CreatedAt  time.Time      `json:"createdAt" db:"created_at"`
+	UpdatedAt  time.Time      `json:"updatedAt" db:"updated_at"`
+}
+
+func (s WebhookSubscription) Matches(eventType string) bool {
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+type WebhookDelivery struct {
+	Id             int64                    `json:"id" db:"id"`
+	SubscriptionId uuid.UUID                `json:"subscriptionId" db:"subscription_id"`
+	EventId        int64                    `json:"eventId" db:"event_id"`
+	EventType      string                   `json:"eventType" db:"event_type"`
+	Payload        json.RawMessage          `json:"payload" db:"payload"`
+	Status         string                   `json:"status" db:"status"`
+	Attempts       int                      `json:"attempts" db:"attempts"`
+	NextAttemptAt  time.Time                `json:"nextAttemptAt" db:"next_attempt_at"`
+	LastError      string                   `json:"lastError,omitempty" db:"last_error"`
+	CreatedAt      time.Time                `json:"createdAt" db:"created_at"`
+	DeliveredAt    *time.Time               `json:"deliveredAt,omitempty" db:"delivered_at"`
+	AttemptHistory []WebhookDeliveryAttempt `json:"attemptHistory,omitempty" db:"-"`
+}
+
+type WebhookDeliveryAttempt struct {
+	Id          int64     `json:"id" db:"id"`
+	DeliveryId  int64     `json:"deliveryId" db:"delivery_id"`
+	AttemptedAt time.Time `json:"attemptedAt" db:"attempted_at"`
+	StatusCode  int       `json:"statusCode" db:"status_code"`
+	Error       string    `json:"error,omitempty" db:"error"`
+	DurationMs  int64     `json:"durationMs" db:"duration_ms"`
+}
+
+// WebhookPayload is the body posted to subscribers.
+type WebhookPayload struct {
+	EventId   int64           `json:"eventId"`
+	EventType string          `json:"eventType"`
+	UserId    uuid.UUID       `json:"userId"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
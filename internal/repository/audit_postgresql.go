@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const auditEntryColumns = "al.id, al.protocol, al.method, al.route, al.path, al.caller_id, al.admin, al.source_ip, " +
+	"al.user_agent, al.request_id, al.body, al.status, al.transaction_log_ids, al.created_at"
+
+type AuditPostgres struct {
+	db     *sqlx.DB
+	logger logger.Logger
+}
+
+func NewAuditPostgres(db *sqlx.DB, logger logger.Logger) *AuditPostgres {
+	return &AuditPostgres{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r AuditPostgres) Create(ctx context.Context, entry model.AuditEntry) error {
+	query := "INSERT INTO audit_log (protocol, method, route, path, caller_id, admin, source_ip, user_agent, " +
+		"request_id, body, status, transaction_log_ids, created_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)"
+
+	_, err := executor(ctx, r.db).ExecContext(ctx, query, entry.Protocol, entry.Method, entry.Route, entry.Path,
+		entry.CallerId, entry.Admin, entry.SourceIp, entry.UserAgent, entry.RequestId, []byte(entry.Body),
+		entry.Status, entry.TransactionLogIds, entry.CreatedAt)
+	if err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to create audit entry for %s %s, error: %s",
+			entry.Method, entry.Route, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// GetAll returns the filtered entries, the latest first.
+func (r AuditPostgres) GetAll(ctx context.Context, filter model.AuditFilter, pageNum int, pageSize int) (
+	[]model.AuditEntry, error) {
+	conditions, args := auditConditions(filter)
+	args = append(args, pageSize, pageNum*pageSize)
+	query := "SELECT " + auditEntryColumns + " FROM audit_log AS al" + whereClause(conditions) +
+		fmt.Sprintf(" ORDER BY al.created_at DESC, al.id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	var entries []model.AuditEntry
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, r.db), &entries, query, args...); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get audit entries, error: %s", err.Error())
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func auditConditions(filter model.AuditFilter) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.CallerId != "" {
+		add("al.caller_id = $%d", filter.CallerId)
+	}
+	if filter.Protocol != "" {
+		add("al.protocol = $%d", filter.Protocol)
+	}
+	if filter.Method != "" {
+		add("al.method = $%d", filter.Method)
+	}
+	if filter.Route != "" {
+		add("al.route = $%d", filter.Route)
+	}
+	if filter.Status != nil {
+		add("al.status = $%d", *filter.Status)
+	}
+	if filter.RequestId != "" {
+		add("al.request_id = $%d", filter.RequestId)
+	}
+	if filter.TransactionLogId != nil {
+		add("al.transaction_log_ids @> $%d::jsonb", fmt.Sprintf("[%d]", *filter.TransactionLogId))
+	}
+	if filter.From != nil {
+		add("al.created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		add("al.created_at < $%d", *filter.To)
+	}
+
+	return conditions, args
+}
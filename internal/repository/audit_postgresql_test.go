@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/stretchr/testify/assert"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestAuditPostgres_GetAll(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx(sqlxmock.QueryMatcherOption(sqlxmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewAuditPostgres(db, log)
+
+	createdAt := time.Date(2021, time.March, 1, 10, 0, 0, 0, time.UTC)
+	status := 200
+	logId := int32(7)
+	rows := sqlxmock.NewRows([]string{"id", "protocol", "method", "route", "path", "caller_id", "admin", "source_ip",
+		"user_agent", "request_id", "body", "status", "transaction_log_ids", "created_at"}).
+		AddRow(1, "http", "POST", "/api/v1/balances/transfer", "/api/v1/balances/transfer", "billing", false,
+			"10.0.0.1", "curl/7.68.0", "req-1", []byte(`{"amount":10}`), 200, []byte("[7,8]"), createdAt)
+	mock.ExpectQuery("SELECT al.id, al.protocol, al.method, al.route, al.path, al.caller_id, al.admin, "+
+		"al.source_ip, al.user_agent, al.request_id, al.body, al.status, al.transaction_log_ids, al.created_at "+
+		"FROM audit_log AS al WHERE al.caller_id = $1 AND al.status = $2 AND "+
+		"al.transaction_log_ids @> $3::jsonb AND al.created_at >= $4 "+
+		"ORDER BY al.created_at DESC, al.id DESC LIMIT $5 OFFSET $6").
+		WithArgs("billing", 200, "[7]", createdAt, 10, 20).WillReturnRows(rows)
+
+	got, err := r.GetAll(context.Background(), model.AuditFilter{CallerId: "billing", Status: &status,
+		TransactionLogId: &logId, From: &createdAt}, 2, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.AuditEntry{{Id: 1, Protocol: "http", Method: "POST", Route: "/api/v1/balances/transfer",
+		Path: "/api/v1/balances/transfer", CallerId: "billing", SourceIp: "10.0.0.1", UserAgent: "curl/7.68.0",
+		RequestId: "req-1", Body: json.RawMessage(`{"amount":10}`), Status: 200,
+		TransactionLogIds: model.TransactionLogIds{7, 8}, CreatedAt: createdAt}}, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const balanceSnapshotColumns = "bs.user_id, bs.day, bs.as_of, bs.balance, bs.created_at"
+
+type BalanceSnapshotPostgres struct {
+	db     *sqlx.DB
+	logger logger.Logger
+}
+
+func NewBalanceSnapshotPostgres(db *sqlx.DB, logger logger.Logger) *BalanceSnapshotPostgres {
+	return &BalanceSnapshotPostgres{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// GetLatestAt returns the latest snapshot of a user taken as of the given time or earlier.
+func (r BalanceSnapshotPostgres) GetLatestAt(ctx context.Context, userId uuid.UUID, at time.Time) (
+	model.BalanceSnapshot, error) {
+	query := "SELECT " + balanceSnapshotColumns + " FROM balance_snapshot AS bs WHERE bs.user_id = $1 " +
+		"AND bs.as_of <= $2 ORDER BY bs.as_of DESC LIMIT 1"
+
+	var snapshot model.BalanceSnapshot
+
+	err := sqlx.GetContext(ctx, executor(ctx, r.db), &snapshot, query, userId, at)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		r.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to get balance snapshot of user, error: %s", err.Error())
+	}
+
+	return snapshot, err
+}
+
+// Create stores a snapshot, a snapshot of the same user and day that already exists is kept.
+func (r BalanceSnapshotPostgres) Create(ctx context.Context, snapshot model.BalanceSnapshot) (bool, error) {
+	query := "INSERT INTO balance_snapshot AS bs (user_id, day, as_of, balance) VALUES ($1, $2, $3, $4) " +
+		"ON CONFLICT (user_id, day) DO NOTHING"
+
+	res, err := executor(ctx, r.db).ExecContext(ctx, query, snapshot.UserId, snapshot.Day, snapshot.AsOf,
+		snapshot.Balance)
+	if err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", snapshot.UserId).
+			Errorf("error in db while trying to create balance snapshot of user, error: %s", err.Error())
+		return false, err
+	}
+
+	created, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return created > 0, nil
+}
+
+// GetByUserIdBetween returns the snapshots of a user for the days from and to, both included, by day.
+func (r BalanceSnapshotPostgres) GetByUserIdBetween(ctx context.Context, userId uuid.UUID, from time.Time,
+	to time.Time) ([]model.BalanceSnapshot, error) {
+	query := "SELECT " + balanceSnapshotColumns + " FROM balance_snapshot AS bs WHERE bs.user_id = $1 " +
+		"AND bs.day >= $2 AND bs.day <= $3 ORDER BY bs.day"
+
+	var snapshots []model.BalanceSnapshot
+
+	err := sqlx.SelectContext(ctx, executor(ctx, r.db), &snapshots, query, userId, from, to)
+	if err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to get balance snapshots of user, error: %s", err.Error())
+		return nil, err
+	}
+
+	return snapshots, nil
+}
+
+// GetLatestDay returns the latest day snapshots were taken for, nil when none were taken yet. A day counts
+// once it is marked taken, even when no account was open yet to snapshot.
+func (r BalanceSnapshotPostgres) GetLatestDay(ctx context.Context) (*time.Time, error) {
+	var day *time.Time
+
+	err := sqlx.GetContext(ctx, executor(ctx, r.db), &day, "SELECT MAX(bsd.day) FROM balance_snapshot_day AS bsd")
+	if err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get latest balance snapshot day, error: %s",
+			err.Error())
+		return nil, err
+	}
+
+	return day, nil
+}
+
+// CreateForDay snapshots the balance of every account opened by asOf for the given day: the previous
+// snapshot of the account plus the transaction log entries after it and up to asOf. Accounts already
+// snapshotted for the day are skipped. It returns how many snapshots were created.
+func (r BalanceSnapshotPostgres) CreateForDay(ctx context.Context, day time.Time, asOf time.Time) (int64, error) {
+	query := "INSERT INTO balance_snapshot (user_id, day, as_of, balance) " +
+		"SELECT ub.user_id, $1, $2, COALESCE(prev.balance, 0) + COALESCE((SELECT SUM(" + signedAmount + ") " +
+		"FROM transaction_log AS tl WHERE tl.user_id = ub.user_id " +
+		"AND tl.date > COALESCE(prev.as_of, '-infinity') AND tl.date <= $2), 0) " +
+		"FROM user_balance AS ub LEFT JOIN LATERAL (SELECT bs.balance, bs.as_of FROM balance_snapshot AS bs " +
+		"WHERE bs.user_id = ub.user_id AND bs.as_of < $2 ORDER BY bs.as_of DESC LIMIT 1) AS prev ON true " +
+		"WHERE ub.created_at <= $2 ON CONFLICT (user_id, day) DO NOTHING"
+
+	res, err := executor(ctx, r.db).ExecContext(ctx, query, day, asOf)
+	if err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to create balance snapshots for %s, error: %s",
+			day.Format("2006-01-02"), err.Error())
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// MarkDayTaken records that the snapshots of a day were taken, with how many there were.
+func (r BalanceSnapshotPostgres) MarkDayTaken(ctx context.Context, day time.Time, snapshots int64) error {
+	query := "INSERT INTO balance_snapshot_day (day, snapshots) VALUES ($1, $2) ON CONFLICT (day) DO NOTHING"
+
+	if _, err := executor(ctx, r.db).ExecContext(ctx, query, day, snapshots); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to mark balance snapshots of %s taken, error: %s",
+			day.Format("2006-01-02"), err.Error())
+		return err
+	}
+
+	return nil
+}
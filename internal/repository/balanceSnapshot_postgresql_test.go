@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestBalanceSnapshotPostgres_GetLatestAt(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx(sqlxmock.QueryMatcherOption(sqlxmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewBalanceSnapshotPostgres(db, log)
+
+	query := "SELECT bs.user_id, bs.day, bs.as_of, bs.balance, bs.created_at FROM balance_snapshot AS bs " +
+		"WHERE bs.user_id = $1 AND bs.as_of <= $2 ORDER BY bs.as_of DESC LIMIT 1"
+	userId := uuid.New()
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	asOf := day.Add(24 * time.Hour)
+	at := asOf.Add(time.Hour)
+
+	rows := sqlxmock.NewRows([]string{"user_id", "day", "as_of", "balance", "created_at"}).
+		AddRow(userId, day, asOf, 150.5, asOf)
+	mock.ExpectQuery(query).WithArgs(userId, at).WillReturnRows(rows)
+
+	got, err := r.GetLatestAt(context.Background(), userId, at)
+	assert.NoError(t, err)
+	assert.Equal(t, model.BalanceSnapshot{UserId: userId, Day: day, AsOf: asOf, Balance: 150.5, CreatedAt: asOf}, got)
+
+	mock.ExpectQuery(query).WithArgs(userId, day).WillReturnError(sql.ErrNoRows)
+
+	_, err = r.GetLatestAt(context.Background(), userId, day)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBalanceSnapshotPostgres_Create(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewBalanceSnapshotPostgres(db, log)
+
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	snapshot := model.BalanceSnapshot{UserId: uuid.New(), Day: day, AsOf: day.Add(24 * time.Hour), Balance: 10}
+
+	tests := []struct {
+		name        string
+		affected    int64
+		expectedOut bool
+	}{
+		{name: "Ok", affected: 1, expectedOut: true},
+		{name: "Already taken", affected: 0, expectedOut: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mock.ExpectExec("INSERT INTO balance_snapshot (.+) ON CONFLICT \\(user_id, day\\) DO NOTHING").
+				WithArgs(snapshot.UserId, snapshot.Day, snapshot.AsOf, snapshot.Balance).
+				WillReturnResult(sqlxmock.NewResult(0, test.affected))
+
+			got, err := r.Create(context.Background(), snapshot)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedOut, got)
+		})
+	}
+}
+
+func TestBalanceSnapshotPostgres_CreateForDay(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewBalanceSnapshotPostgres(db, log)
+
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	asOf := day.Add(24 * time.Hour)
+
+	mock.ExpectExec("INSERT INTO balance_snapshot (.+) SELECT (.+) FROM user_balance AS ub LEFT JOIN LATERAL (.+) "+
+		"WHERE ub.created_at <= \\$2 ON CONFLICT \\(user_id, day\\) DO NOTHING").
+		WithArgs(day, asOf).
+		WillReturnResult(sqlxmock.NewResult(0, 42))
+
+	got, err := r.CreateForDay(context.Background(), day, asOf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), got)
+}
+
+func TestBalanceSnapshotPostgres_MarkDayTaken(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewBalanceSnapshotPostgres(db, log)
+
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec("INSERT INTO balance_snapshot_day \\(day, snapshots\\) VALUES \\(\\$1, \\$2\\) "+
+		"ON CONFLICT \\(day\\) DO NOTHING").
+		WithArgs(day, int64(0)).
+		WillReturnResult(sqlxmock.NewResult(0, 1))
+
+	assert.NoError(t, r.MarkDayTaken(context.Background(), day, 0))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBalanceSnapshotPostgres_GetLatestDay(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx(sqlxmock.QueryMatcherOption(sqlxmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewBalanceSnapshotPostgres(db, log)
+
+	query := "SELECT MAX(bsd.day) FROM balance_snapshot_day AS bsd"
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(query).WillReturnRows(sqlxmock.NewRows([]string{"max"}).AddRow(day))
+	got, err := r.GetLatestDay(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, &day, got)
+
+	mock.ExpectQuery(query).WillReturnRows(sqlxmock.NewRows([]string{"max"}).AddRow(nil))
+	got, err = r.GetLatestDay(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/jmoiron/sqlx"
+)
+
+type BatchPostgres struct {
+	db     *sqlx.DB
+	logger logger.Logger
+}
+
+func NewBatchPostgres(db *sqlx.DB, logger logger.Logger) *BatchPostgres {
+	return &BatchPostgres{
+		db:     db,
+		logger: logger}
+}
+
+// Create stores an applied batch and reports false, without storing it, when a batch with the same
+// idempotency key already exists.
+func (b BatchPostgres) Create(ctx context.Context, batch model.Batch) (bool, error) {
+	query := "INSERT INTO batch (id, idempotency_key, request_hash, result, created_at) " +
+		"VALUES ($1, $2, $3, $4, $5) ON CONFLICT (idempotency_key) DO NOTHING"
+
+	result, err := executor(ctx, b.db).ExecContext(ctx, query, batch.Id, batch.IdempotencyKey, batch.RequestHash,
+		batch.Items, batch.CreatedAt)
+	if err != nil {
+		b.logger.WithContext(ctx).Errorf("error in db while trying to create batch %v, error: %s",
+			batch.Id, err.Error())
+		return false, err
+	}
+
+	created, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return created > 0, nil
+}
+
+func (b BatchPostgres) GetByIdempotencyKey(ctx context.Context, key string) (model.Batch, error) {
+	query := "SELECT b.id, b.idempotency_key, b.request_hash, b.result, b.created_at FROM batch AS b " +
+		"WHERE b.idempotency_key = $1"
+
+	var batch model.Batch
+
+	err := sqlx.GetContext(ctx, executor(ctx, b.db), &batch, query, key)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		b.logger.WithContext(ctx).Errorf("error in db while trying to get batch by idempotency key, error: %s",
+			err.Error())
+	}
+	if err != nil {
+		return model.Batch{}, err
+	}
+
+	return batch, nil
+}
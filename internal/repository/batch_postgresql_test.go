@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestBatchPostgres_Create(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewBatchPostgres(db, log)
+
+	key := "payroll"
+	batch := model.Batch{
+		Id:             uuid.New(),
+		IdempotencyKey: &key,
+		RequestHash:    "hash",
+		Items:          model.BatchItemResults{{Index: 0, Type: model.BatchOperationCredit, Status: "applied"}},
+		CreatedAt:      time.Now(),
+	}
+
+	tests := []struct {
+		name        string
+		affected    int64
+		expectedOut bool
+	}{
+		{name: "Ok", affected: 1, expectedOut: true},
+		{name: "Idempotency key taken", affected: 0, expectedOut: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mock.ExpectExec("INSERT INTO batch (.+) ON CONFLICT \\(idempotency_key\\) DO NOTHING").
+				WithArgs(batch.Id, batch.IdempotencyKey, batch.RequestHash,
+					[]byte(`[{"index":0,"type":"credit","status":"applied"}]`), batch.CreatedAt).
+				WillReturnResult(sqlxmock.NewResult(0, test.affected))
+
+			got, err := r.Create(context.Background(), batch)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedOut, got)
+		})
+	}
+}
+
+func TestBatchPostgres_GetByIdempotencyKey(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewBatchPostgres(db, log)
+
+	id := uuid.New()
+	rows := sqlxmock.NewRows([]string{"id", "idempotency_key", "request_hash", "result", "created_at"}).
+		AddRow(id, "payroll", "hash", []byte(`[{"index":0,"type":"debit","status":"applied","balance":5}]`),
+			time.Now())
+	mock.ExpectQuery("SELECT (.+) FROM batch AS b WHERE b.idempotency_key = \\$1").
+		WithArgs("payroll").WillReturnRows(rows)
+
+	got, err := r.GetByIdempotencyKey(context.Background(), "payroll")
+	assert.NoError(t, err)
+	assert.Equal(t, id, got.Id)
+	assert.Equal(t, "payroll", *got.IdempotencyKey)
+	assert.Len(t, got.Items, 1)
+	assert.Equal(t, 5.0, *got.Items[0].Balance)
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const bonusGrantColumns = "bg.id, bg.user_id, bg.amount, bg.remaining, bg.reason, bg.expires_at, bg.created_at, " +
+	"bg.transaction_log_id, bg.expired_at, bg.expired_amount"
+
+type BonusPostgres struct {
+	db     *sqlx.DB
+	logger logger.Logger
+}
+
+func NewBonusPostgres(db *sqlx.DB, logger logger.Logger) *BonusPostgres {
+	return &BonusPostgres{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r BonusPostgres) Create(ctx context.Context, grant model.BonusGrant) (int64, error) {
+	query := "INSERT INTO bonus_grant (user_id, amount, remaining, reason, expires_at, created_at, " +
+		"transaction_log_id) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id"
+
+	var id int64
+
+	row := executor(ctx, r.db).QueryRowxContext(ctx, query, grant.UserId, grant.Amount, grant.Remaining,
+		grant.Reason, grant.ExpiresAt, grant.CreatedAt, grant.TransactionLogId)
+	if err := row.Scan(&id); err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", grant.UserId).
+			Errorf("error in db while trying to create bonus grant of user, error: %s", err.Error())
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetActiveByUserId returns the grants of a user not expired yet, spent ones included, soonest
+// expiring first.
+func (r BonusPostgres) GetActiveByUserId(ctx context.Context, userId uuid.UUID) ([]model.BonusGrant, error) {
+	query := "SELECT " + bonusGrantColumns + " FROM bonus_grant AS bg WHERE bg.user_id = $1 " +
+		"AND bg.expired_at IS NULL ORDER BY bg.expires_at, bg.id"
+
+	var grants []model.BonusGrant
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, r.db), &grants, query, userId); err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to get bonus grants of user, error: %s", err.Error())
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+func (r BonusPostgres) UpdateRemaining(ctx context.Context, id int64, remaining float64) error {
+	query := "UPDATE bonus_grant SET remaining = $1 WHERE id = $2"
+
+	if _, err := executor(ctx, r.db).ExecContext(ctx, query, remaining, id); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to update bonus grant %v, error: %s", id,
+			err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Expire marks a grant expired, what was left of it becoming its expired amount.
+func (r BonusPostgres) Expire(ctx context.Context, id int64, expiredAt time.Time) error {
+	query := "UPDATE bonus_grant SET expired_at = $1, expired_amount = remaining, remaining = 0 WHERE id = $2"
+
+	if _, err := executor(ctx, r.db).ExecContext(ctx, query, expiredAt, id); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to expire bonus grant %v, error: %s", id,
+			err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// GetDueUserIds returns users having grants expired by now that are not marked expired yet.
+func (r BonusPostgres) GetDueUserIds(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error) {
+	query := "SELECT DISTINCT bg.user_id FROM bonus_grant AS bg WHERE bg.expired_at IS NULL " +
+		"AND bg.expires_at <= $1 LIMIT $2"
+
+	var userIds []uuid.UUID
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, r.db), &userIds, query, now, limit); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get users with due bonus grants, error: %s",
+			err.Error())
+		return nil, err
+	}
+
+	return userIds, nil
+}
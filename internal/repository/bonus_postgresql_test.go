@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestBonusPostgres_GetActiveByUserId(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx(sqlxmock.QueryMatcherOption(sqlxmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewBonusPostgres(db, log)
+
+	userId := uuid.New()
+	createdAt := time.Date(2021, time.March, 1, 10, 0, 0, 0, time.UTC)
+	expiresAt := createdAt.AddDate(0, 1, 0)
+	rows := sqlxmock.NewRows([]string{"id", "user_id", "amount", "remaining", "reason", "expires_at", "created_at",
+		"transaction_log_id", "expired_at", "expired_amount"}).
+		AddRow(3, userId, 50, 20, "spring promo", expiresAt, createdAt, 11, nil, 0)
+	mock.ExpectQuery("SELECT bg.id, bg.user_id, bg.amount, bg.remaining, bg.reason, bg.expires_at, bg.created_at, " +
+		"bg.transaction_log_id, bg.expired_at, bg.expired_amount FROM bonus_grant AS bg WHERE bg.user_id = $1 " +
+		"AND bg.expired_at IS NULL ORDER BY bg.expires_at, bg.id").
+		WithArgs(userId).WillReturnRows(rows)
+
+	got, err := r.GetActiveByUserId(context.Background(), userId)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.BonusGrant{{Id: 3, UserId: userId, Amount: 50, Remaining: 20, Reason: "spring promo",
+		ExpiresAt: expiresAt, CreatedAt: createdAt, TransactionLogId: 11}}, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBonusPostgres_Expire(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx(sqlxmock.QueryMatcherOption(sqlxmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewBonusPostgres(db, log)
+
+	expiredAt := time.Now()
+	mock.ExpectExec("UPDATE bonus_grant SET expired_at = $1, expired_amount = remaining, remaining = 0 "+
+		"WHERE id = $2").
+		WithArgs(expiredAt, int64(3)).WillReturnResult(sqlxmock.NewResult(0, 1))
+
+	assert.NoError(t, r.Expire(context.Background(), 3, expiredAt))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBonusPostgres_GetDueUserIds(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx(sqlxmock.QueryMatcherOption(sqlxmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewBonusPostgres(db, log)
+
+	now := time.Now()
+	userId := uuid.New()
+	mock.ExpectQuery("SELECT DISTINCT bg.user_id FROM bonus_grant AS bg WHERE bg.expired_at IS NULL "+
+		"AND bg.expires_at <= $1 LIMIT $2").
+		WithArgs(now, 50).WillReturnRows(sqlxmock.NewRows([]string{"user_id"}).AddRow(userId))
+
+	got, err := r.GetDueUserIds(context.Background(), now, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{userId}, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
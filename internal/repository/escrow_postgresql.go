@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const escrowColumns = "e.id, e.deal_id, e.buyer_id, e.seller_id, e.account_id, e.amount, e.released_amount, " +
+	"e.refunded_amount, e.status, e.funding_log_id, e.created_at, e.updated_at, e.settled_at"
+
+type EscrowPostgres struct {
+	db     *sqlx.DB
+	logger logger.Logger
+}
+
+func NewEscrowPostgres(db *sqlx.DB, logger logger.Logger) *EscrowPostgres {
+	return &EscrowPostgres{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Create stores an escrow and reports false, without storing it, when the deal is already escrowed.
+func (r EscrowPostgres) Create(ctx context.Context, escrow model.Escrow) (bool, error) {
+	query := "INSERT INTO escrow (id, deal_id, buyer_id, seller_id, account_id, amount, status, funding_log_id, " +
+		"created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) ON CONFLICT (deal_id) DO NOTHING"
+
+	res, err := executor(ctx, r.db).ExecContext(ctx, query, escrow.Id, escrow.DealId, escrow.BuyerId,
+		escrow.SellerId, escrow.AccountId, escrow.Amount, escrow.Status, escrow.FundingLogId, escrow.CreatedAt,
+		escrow.UpdatedAt)
+	if err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to create escrow of deal %q, error: %s",
+			escrow.DealId, err.Error())
+		return false, err
+	}
+
+	created, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return created > 0, nil
+}
+
+func (r EscrowPostgres) Get(ctx context.Context, id uuid.UUID) (model.Escrow, error) {
+	return r.get(ctx, "SELECT "+escrowColumns+" FROM escrow AS e WHERE e.id = $1", id)
+}
+
+func (r EscrowPostgres) GetByDealId(ctx context.Context, dealId string) (model.Escrow, error) {
+	return r.get(ctx, "SELECT "+escrowColumns+" FROM escrow AS e WHERE e.deal_id = $1", dealId)
+}
+
+// GetForUpdate locks the escrow until the end of the transaction, so that it is settled once.
+func (r EscrowPostgres) GetForUpdate(ctx context.Context, id uuid.UUID) (model.Escrow, error) {
+	return r.get(ctx, "SELECT "+escrowColumns+" FROM escrow AS e WHERE e.id = $1 FOR UPDATE", id)
+}
+
+func (r EscrowPostgres) get(ctx context.Context, query string, arg interface{}) (model.Escrow, error) {
+	var escrow model.Escrow
+
+	if err := sqlx.GetContext(ctx, executor(ctx, r.db), &escrow, query, arg); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get escrow %v, error: %s", arg, err.Error())
+		return model.Escrow{}, err
+	}
+
+	return escrow, nil
+}
+
+// Settle records how a funded escrow was settled.
+func (r EscrowPostgres) Settle(ctx context.Context, escrow model.Escrow) error {
+	query := "UPDATE escrow SET released_amount = $1, refunded_amount = $2, status = $3, updated_at = $4, " +
+		"settled_at = $5 WHERE id = $6"
+
+	_, err := executor(ctx, r.db).ExecContext(ctx, query, escrow.ReleasedAmount, escrow.RefundedAmount,
+		escrow.Status, escrow.UpdatedAt, escrow.SettledAt, escrow.Id)
+	if err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to settle escrow %v, error: %s",
+			escrow.Id, err.Error())
+		return err
+	}
+
+	return nil
+}
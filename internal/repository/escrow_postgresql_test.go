@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestEscrowPostgres_GetByDealId(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewEscrowPostgres(db, log)
+
+	now := time.Now()
+	id, buyer, seller, account := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	var fundingLogId int32 = 3
+
+	rows := sqlxmock.NewRows([]string{"id", "deal_id", "buyer_id", "seller_id", "account_id", "amount",
+		"released_amount", "refunded_amount", "status", "funding_log_id", "created_at", "updated_at", "settled_at"}).
+		AddRow(id, "deal-1", buyer, seller, account, 100, 70, 30, model.EscrowSplit, fundingLogId, now, now, now)
+	mock.ExpectQuery("SELECT (.+) FROM escrow AS e WHERE e.deal_id = \\$1").
+		WithArgs("deal-1").WillReturnRows(rows)
+
+	got, err := r.GetByDealId(context.Background(), "deal-1")
+	assert.NoError(t, err)
+	assert.Equal(t, id, got.Id)
+	assert.Equal(t, 70.0, got.ReleasedAmount)
+	assert.Equal(t, 30.0, got.RefundedAmount)
+	assert.Equal(t, fundingLogId, *got.FundingLogId)
+	assert.Equal(t, now, *got.SettledAt)
+}
+
+func TestEscrowPostgres_Settle(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewEscrowPostgres(db, log)
+
+	now := time.Now()
+	escrow := model.Escrow{
+		Id:             uuid.New(),
+		Amount:         100,
+		ReleasedAmount: 100,
+		Status:         model.EscrowReleased,
+		UpdatedAt:      now,
+		SettledAt:      &now,
+	}
+
+	mock.ExpectExec("UPDATE escrow SET released_amount = \\$1, refunded_amount = \\$2, status = \\$3, "+
+		"updated_at = \\$4, settled_at = \\$5 WHERE id = \\$6").
+		WithArgs(100.0, 0.0, model.EscrowReleased, now, &now, escrow.Id).
+		WillReturnResult(sqlxmock.NewResult(0, 1))
+
+	assert.NoError(t, r.Settle(context.Background(), escrow))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEscrowPostgres_Create(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewEscrowPostgres(db, log)
+
+	escrow := model.Escrow{Id: uuid.New(), DealId: "deal-1", Amount: 100, Status: model.EscrowFunded}
+	query := "INSERT INTO escrow (.+) ON CONFLICT \\(deal_id\\) DO NOTHING"
+
+	mock.ExpectExec(query).WillReturnResult(sqlxmock.NewResult(0, 1))
+	created, err := r.Create(context.Background(), escrow)
+	assert.NoError(t, err)
+	assert.True(t, created)
+
+	// the deal was escrowed by another transaction in the meantime
+	mock.ExpectExec(query).WillReturnResult(sqlxmock.NewResult(0, 0))
+	created, err = r.Create(context.Background(), escrow)
+	assert.NoError(t, err)
+	assert.False(t, created)
+}
@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/jmoiron/sqlx"
+)
+
+type HealthPostgres struct {
+	db     *sqlx.DB
+	logger logger.Logger
+}
+
+func NewHealthPostgres(db *sqlx.DB, logger logger.Logger) *HealthPostgres {
+	return &HealthPostgres{
+		db:     db,
+		logger: logger}
+}
+
+func (h HealthPostgres) Ping(ctx context.Context) error {
+	if err := h.db.PingContext(ctx); err != nil {
+		h.logger.WithContext(ctx).Errorf("could not ping database, error: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (h HealthPostgres) MigrationVersion(ctx context.Context) (uint, bool, error) {
+	var (
+		version uint
+		dirty   bool
+	)
+
+	row := h.db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1")
+	if err := row.Scan(&version, &dirty); err != nil {
+		h.logger.WithContext(ctx).Errorf("could not get schema migration version, error: %s", err.Error())
+		return 0, false, err
+	}
+
+	return version, dirty, nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/stretchr/testify/assert"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestHealthPostgres_MigrationVersion(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx(sqlxmock.QueryMatcherOption(sqlxmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewHealthPostgres(db, log)
+
+	type mockBehavior func()
+
+	tests := []struct {
+		name            string
+		mock            mockBehavior
+		expectedVersion uint
+		expectedDirty   bool
+		expectedErr     bool
+	}{
+		{
+			name: "Ok",
+			mock: func() {
+				rows := sqlxmock.NewRows([]string{"version", "dirty"}).AddRow(2, false)
+				mock.ExpectQuery("SELECT version, dirty FROM schema_migrations LIMIT 1").WillReturnRows(rows)
+			},
+			expectedVersion: 2,
+			expectedDirty:   false,
+			expectedErr:     false,
+		},
+		{
+			name: "Dirty",
+			mock: func() {
+				rows := sqlxmock.NewRows([]string{"version", "dirty"}).AddRow(3, true)
+				mock.ExpectQuery("SELECT version, dirty FROM schema_migrations LIMIT 1").WillReturnRows(rows)
+			},
+			expectedVersion: 3,
+			expectedDirty:   true,
+			expectedErr:     false,
+		},
+		{
+			name: "No migrations table",
+			mock: func() {
+				mock.ExpectQuery("SELECT version, dirty FROM schema_migrations LIMIT 1").
+					WillReturnError(errors.New(`relation "schema_migrations" does not exist`))
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.mock()
+
+			version, dirty, err := r.MigrationVersion(context.Background())
+			if test.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedVersion, version)
+				assert.Equal(t, test.expectedDirty, dirty)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type LimitPostgres struct {
+	db     *sqlx.DB
+	logger logger.Logger
+}
+
+func NewLimitPostgres(db *sqlx.DB, logger logger.Logger) *LimitPostgres {
+	return &LimitPostgres{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r LimitPostgres) GetByUserId(ctx context.Context, userId uuid.UUID) (model.UserLimits, error) {
+	query := "SELECT ul.user_id, ul.tier, ul.max_transfer, ul.daily_outgoing, ul.monthly_outgoing, ul.max_balance, " +
+		"ul.transfers_per_hour, ul.updated_by, ul.updated_at FROM user_limit AS ul WHERE ul.user_id = $1"
+
+	var limits model.UserLimits
+
+	err := sqlx.GetContext(ctx, executor(ctx, r.db), &limits, query, userId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		r.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to get limits of user, error: %s", err.Error())
+	}
+
+	return limits, err
+}
+
+// Upsert replaces the limit overrides of a user.
+func (r LimitPostgres) Upsert(ctx context.Context, limits model.UserLimits) error {
+	query := "INSERT INTO user_limit (user_id, tier, max_transfer, daily_outgoing, monthly_outgoing, max_balance, " +
+		"transfers_per_hour, updated_by, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) " +
+		"ON CONFLICT (user_id) DO UPDATE SET tier = EXCLUDED.tier, max_transfer = EXCLUDED.max_transfer, " +
+		"daily_outgoing = EXCLUDED.daily_outgoing, monthly_outgoing = EXCLUDED.monthly_outgoing, " +
+		"max_balance = EXCLUDED.max_balance, transfers_per_hour = EXCLUDED.transfers_per_hour, " +
+		"updated_by = EXCLUDED.updated_by, updated_at = EXCLUDED.updated_at"
+
+	_, err := executor(ctx, r.db).ExecContext(ctx, query, limits.UserId, limits.Tier, limits.MaxTransfer,
+		limits.DailyOutgoing, limits.MonthlyOutgoing, limits.MaxBalance, limits.TransfersPerHour,
+		limits.UpdatedBy, limits.UpdatedAt)
+	if err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", limits.UserId).
+			Errorf("error in db while trying to save limits of user, error: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
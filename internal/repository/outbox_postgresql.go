@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/jmoiron/sqlx"
+)
+
+type OutboxPostgres struct {
+	db     *sqlx.DB
+	logger logger.Logger
+}
+
+func NewOutboxPostgres(db *sqlx.DB, logger logger.Logger) *OutboxPostgres {
+	return &OutboxPostgres{
+		db:     db,
+		logger: logger}
+}
+
+func (o OutboxPostgres) Create(ctx context.Context, event model.OutboxEvent) (int64, error) {
+	query := "INSERT INTO outbox_event AS oe (user_id, event_type, payload, request_id, created_at) " +
+		"VALUES ($1, $2, $3, $4, $5) RETURNING id"
+
+	var id int64
+
+	row := executor(ctx, o.db).QueryRowxContext(ctx, query, event.UserId, event.EventType, []byte(event.Payload),
+		event.RequestId, event.CreatedAt)
+	if err := row.Scan(&id); err != nil {
+		o.logger.WithContext(ctx).WithField("user_id", event.UserId).
+			Errorf("error in db while trying to create outbox event %s, error: %s", event.EventType, err.Error())
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (o OutboxPostgres) GetPending(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	query := "SELECT oe.id, oe.user_id, oe.event_type, oe.payload, oe.request_id, oe.created_at, " +
+		"oe.dispatched_at, oe.attempts, oe.last_error FROM outbox_event AS oe " +
+		"WHERE oe.dispatched_at IS NULL ORDER BY oe.id LIMIT $1"
+
+	var events []model.OutboxEvent
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, o.db), &events, query, limit); err != nil {
+		o.logger.WithContext(ctx).Errorf("error in db while trying to get pending outbox events, error: %s",
+			err.Error())
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (o OutboxPostgres) MarkDispatched(ctx context.Context, id int64) error {
+	query := "UPDATE outbox_event SET dispatched_at = now(), attempts = attempts + 1, last_error = '' WHERE id = $1"
+
+	if _, err := executor(ctx, o.db).ExecContext(ctx, query, id); err != nil {
+		o.logger.WithContext(ctx).Errorf("error in db while trying to mark outbox event %v dispatched, error: %s",
+			id, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (o OutboxPostgres) MarkFailed(ctx context.Context, id int64, reason string) error {
+	query := "UPDATE outbox_event SET attempts = attempts + 1, last_error = $1 WHERE id = $2"
+
+	if _, err := executor(ctx, o.db).ExecContext(ctx, query, reason, id); err != nil {
+		o.logger.WithContext(ctx).Errorf("error in db while trying to mark outbox event %v failed, error: %s",
+			id, err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestOutboxPostgres_Create(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewOutboxPostgres(db, log)
+
+	type args struct {
+		event model.OutboxEvent
+	}
+
+	type mockBehavior func(args args)
+
+	tests := []struct {
+		name        string
+		mock        mockBehavior
+		input       args
+		expectedOut int64
+		expectedErr bool
+	}{
+		{
+			name: "Ok",
+			input: args{event: model.OutboxEvent{
+				UserId:    uuid.New(),
+				EventType: model.EventBalanceCredited,
+				Payload:   json.RawMessage(`{"amount":100}`),
+				RequestId: "request",
+				CreatedAt: time.Now(),
+			}},
+			mock: func(args args) {
+				event := args.event
+				rows := sqlxmock.NewRows([]string{"id"}).AddRow(7)
+				mock.ExpectQuery("INSERT INTO outbox_event").
+					WithArgs(event.UserId, event.EventType, []byte(event.Payload), event.RequestId, event.CreatedAt).
+					WillReturnRows(rows)
+			},
+			expectedOut: 7,
+			expectedErr: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.mock(test.input)
+
+			got, err := r.Create(context.Background(), test.input.event)
+			if test.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedOut, got)
+			}
+		})
+	}
+}
+
+func TestOutboxPostgres_GetPending(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewOutboxPostgres(db, log)
+
+	userId := uuid.New()
+	createdAt := time.Now()
+
+	rows := sqlxmock.NewRows([]string{"id", "user_id", "event_type", "payload", "request_id", "created_at",
+		"dispatched_at", "attempts", "last_error"}).
+		AddRow(1, userId, model.EventBalanceCredited, []byte(`{}`), "", createdAt, nil, 0, "").
+		AddRow(2, userId, model.EventBalanceDebited, []byte(`{}`), "", createdAt, nil, 1, "timeout")
+	mock.ExpectQuery("SELECT (.+) FROM outbox_event AS oe WHERE oe.dispatched_at IS NULL ORDER BY oe.id").
+		WithArgs(10).WillReturnRows(rows)
+
+	got, err := r.GetPending(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Len(t, got, 2)
+	assert.Equal(t, int64(1), got[0].Id)
+	assert.Equal(t, "timeout", got[1].LastError)
+	assert.Nil(t, got[1].DispatchedAt)
+}
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const paymentRequestColumns = "pr.id, pr.requester_id, pr.payer_id, pr.amount, pr.note, pr.status, pr.expires_at, " +
+	"pr.created_at, pr.updated_at"
+
+type PaymentRequestPostgres struct {
+	db     *sqlx.DB
+	logger logger.Logger
+}
+
+func NewPaymentRequestPostgres(db *sqlx.DB, logger logger.Logger) *PaymentRequestPostgres {
+	return &PaymentRequestPostgres{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r PaymentRequestPostgres) Create(ctx context.Context, request model.PaymentRequest) error {
+	query := "INSERT INTO payment_request (id, requester_id, payer_id, amount, note, status, expires_at, " +
+		"created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+
+	_, err := executor(ctx, r.db).ExecContext(ctx, query, request.Id, request.RequesterId, request.PayerId,
+		request.Amount, request.Note, request.Status, request.ExpiresAt, request.CreatedAt, request.UpdatedAt)
+	if err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to create payment request, error: %s",
+			err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (r PaymentRequestPostgres) Get(ctx context.Context, id uuid.UUID) (model.PaymentRequest, error) {
+	return r.get(ctx, "SELECT "+paymentRequestColumns+" FROM payment_request AS pr WHERE pr.id = $1", id)
+}
+
+// GetForUpdate locks the payment request until the end of the transaction, so that it changes status
+// once even when the payer and the requester act on it at the same time.
+func (r PaymentRequestPostgres) GetForUpdate(ctx context.Context, id uuid.UUID) (model.PaymentRequest, error) {
+	return r.get(ctx, "SELECT "+paymentRequestColumns+" FROM payment_request AS pr WHERE pr.id = $1 FOR UPDATE",
+		id)
+}
+
+func (r PaymentRequestPostgres) get(ctx context.Context, query string, id uuid.UUID) (model.PaymentRequest, error) {
+	var request model.PaymentRequest
+
+	if err := sqlx.GetContext(ctx, executor(ctx, r.db), &request, query, id); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get payment request %v, error: %s",
+			id, err.Error())
+		return model.PaymentRequest{}, err
+	}
+
+	return request, nil
+}
+
+// GetAll returns the filtered requests, the latest first.
+func (r PaymentRequestPostgres) GetAll(ctx context.Context, filter model.PaymentRequestFilter, pageNum int,
+	pageSize int) ([]model.PaymentRequest, error) {
+	var conditions []string
+	var args []interface{}
+
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.RequesterId != nil {
+		add("pr.requester_id = $%d", *filter.RequesterId)
+	}
+	if filter.PayerId != nil {
+		add("pr.payer_id = $%d", *filter.PayerId)
+	}
+	if filter.Status != "" {
+		add("pr.status = $%d", filter.Status)
+	}
+
+	args = append(args, pageSize, pageNum*pageSize)
+	query := "SELECT " + paymentRequestColumns + " FROM payment_request AS pr" + whereClause(conditions) +
+		fmt.Sprintf(" ORDER BY pr.created_at DESC, pr.id LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	var requests []model.PaymentRequest
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, r.db), &requests, query, args...); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get payment requests, error: %s", err.Error())
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// GetDue locks pending requests expired by now, skipping the ones locked by other instances or by
+// the users acting on them.
+func (r PaymentRequestPostgres) GetDue(ctx context.Context, now time.Time, limit int) (
+	[]model.PaymentRequest, error) {
+	query := "SELECT " + paymentRequestColumns + " FROM payment_request AS pr " +
+		"WHERE pr.status = $1 AND pr.expires_at <= $2 ORDER BY pr.expires_at LIMIT $3 FOR UPDATE SKIP LOCKED"
+
+	var requests []model.PaymentRequest
+
+	err := sqlx.SelectContext(ctx, executor(ctx, r.db), &requests, query, model.PaymentRequestPending, now, limit)
+	if err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get expired payment requests, error: %s",
+			err.Error())
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+func (r PaymentRequestPostgres) UpdateStatus(ctx context.Context, id uuid.UUID, status string,
+	updatedAt time.Time) error {
+	query := "UPDATE payment_request SET status = $1, updated_at = $2 WHERE id = $3"
+
+	if _, err := executor(ctx, r.db).ExecContext(ctx, query, status, updatedAt, id); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to update status of payment request %v, "+
+			"error: %s", id, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (r PaymentRequestPostgres) CreateEvent(ctx context.Context, event model.PaymentRequestEvent) error {
+	query := "INSERT INTO payment_request_event (payment_request_id, from_status, to_status, actor_id, reason, " +
+		"created_at) VALUES ($1, $2, $3, $4, $5, $6)"
+
+	_, err := executor(ctx, r.db).ExecContext(ctx, query, event.PaymentRequestId, event.FromStatus, event.ToStatus,
+		event.ActorId, event.Reason, event.CreatedAt)
+	if err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to record event of payment request %v, "+
+			"error: %s", event.PaymentRequestId, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (r PaymentRequestPostgres) GetEvents(ctx context.Context, id uuid.UUID) ([]model.PaymentRequestEvent, error) {
+	query := "SELECT pre.id, pre.payment_request_id, pre.from_status, pre.to_status, pre.actor_id, pre.reason, " +
+		"pre.created_at FROM payment_request_event AS pre WHERE pre.payment_request_id = $1 ORDER BY pre.id"
+
+	var events []model.PaymentRequestEvent
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, r.db), &events, query, id); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get events of payment request %v, error: %s",
+			id, err.Error())
+		return nil, err
+	}
+
+	return events, nil
+}
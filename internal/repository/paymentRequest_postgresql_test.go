@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+var paymentRequestRowColumns = []string{"id", "requester_id", "payer_id", "amount", "note", "status", "expires_at",
+	"created_at", "updated_at"}
+
+func TestPaymentRequestPostgres_GetAll(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewPaymentRequestPostgres(db, log)
+
+	now := time.Now()
+	id, requester, payer := uuid.New(), uuid.New(), uuid.New()
+
+	rows := sqlxmock.NewRows(paymentRequestRowColumns).
+		AddRow(id, requester, payer, 25.5, "dinner", model.PaymentRequestPending, now, now, now)
+	mock.ExpectQuery("SELECT (.+) FROM payment_request AS pr WHERE pr.payer_id = \\$1 AND pr.status = \\$2 "+
+		"ORDER BY pr.created_at DESC, pr.id LIMIT \\$3 OFFSET \\$4").
+		WithArgs(payer, model.PaymentRequestPending, 10, 20).WillReturnRows(rows)
+
+	got, err := r.GetAll(context.Background(), model.PaymentRequestFilter{
+		PayerId: &payer,
+		Status:  model.PaymentRequestPending,
+	}, 2, 10)
+	assert.NoError(t, err)
+	assert.Len(t, got, 1)
+	assert.Equal(t, id, got[0].Id)
+	assert.Equal(t, "dinner", got[0].Note)
+}
+
+func TestPaymentRequestPostgres_GetDue(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewPaymentRequestPostgres(db, log)
+
+	now := time.Now()
+	id := uuid.New()
+
+	rows := sqlxmock.NewRows(paymentRequestRowColumns).
+		AddRow(id, uuid.New(), uuid.New(), 10, "", model.PaymentRequestPending, now, now, now)
+	mock.ExpectQuery("SELECT (.+) FROM payment_request AS pr WHERE pr.status = \\$1 AND pr.expires_at <= \\$2 "+
+		"(.+) FOR UPDATE SKIP LOCKED").
+		WithArgs(model.PaymentRequestPending, now, 5).WillReturnRows(rows)
+
+	got, err := r.GetDue(context.Background(), now, 5)
+	assert.NoError(t, err)
+	assert.Len(t, got, 1)
+	assert.Equal(t, id, got[0].Id)
+}
+
+func TestPaymentRequestPostgres_CreateEvent(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewPaymentRequestPostgres(db, log)
+
+	event := model.PaymentRequestEvent{
+		PaymentRequestId: uuid.New(),
+		FromStatus:       model.PaymentRequestPending,
+		ToStatus:         model.PaymentRequestExpired,
+		CreatedAt:        time.Now(),
+	}
+	mock.ExpectExec("INSERT INTO payment_request_event").
+		WithArgs(event.PaymentRequestId, event.FromStatus, event.ToStatus, nil, "", event.CreatedAt).
+		WillReturnResult(sqlxmock.NewResult(1, 1))
+
+	assert.NoError(t, r.CreateEvent(context.Background(), event))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
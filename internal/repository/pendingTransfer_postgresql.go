@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const pendingTransferColumns = "pt.id, pt.sender_id, pt.receiver_id, pt.amount, pt.fee, pt.held_amount, " +
+	"pt.captured_amount, pt.reference, pt.status, pt.void_reason, pt.transaction_log_id, pt.expires_at, " +
+	"pt.created_at, pt.updated_at"
+
+type PendingTransferPostgres struct {
+	db     *sqlx.DB
+	logger logger.Logger
+}
+
+func NewPendingTransferPostgres(db *sqlx.DB, logger logger.Logger) *PendingTransferPostgres {
+	return &PendingTransferPostgres{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r PendingTransferPostgres) Create(ctx context.Context, transfer model.PendingTransfer) error {
+	query := "INSERT INTO pending_transfer (id, sender_id, receiver_id, amount, fee, held_amount, reference, " +
+		"status, expires_at, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)"
+
+	_, err := executor(ctx, r.db).ExecContext(ctx, query, transfer.Id, transfer.SenderId, transfer.ReceiverId,
+		transfer.Amount, transfer.Fee, transfer.HeldAmount, transfer.Reference, transfer.Status, transfer.ExpiresAt, transfer.CreatedAt,
+		transfer.UpdatedAt)
+	if err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to create pending transfer, error: %s",
+			err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (r PendingTransferPostgres) Get(ctx context.Context, id uuid.UUID) (model.PendingTransfer, error) {
+	return r.get(ctx, "SELECT "+pendingTransferColumns+" FROM pending_transfer AS pt WHERE pt.id = $1", id)
+}
+
+// GetForUpdate locks the pending transfer until the end of the transaction, so that it is captured or
+// voided once.
+func (r PendingTransferPostgres) GetForUpdate(ctx context.Context, id uuid.UUID) (model.PendingTransfer, error) {
+	return r.get(ctx, "SELECT "+pendingTransferColumns+" FROM pending_transfer AS pt WHERE pt.id = $1 FOR UPDATE",
+		id)
+}
+
+func (r PendingTransferPostgres) get(ctx context.Context, query string, id uuid.UUID) (model.PendingTransfer, error) {
+	var transfer model.PendingTransfer
+
+	if err := sqlx.GetContext(ctx, executor(ctx, r.db), &transfer, query, id); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get pending transfer %v, error: %s",
+			id, err.Error())
+		return model.PendingTransfer{}, err
+	}
+
+	return transfer, nil
+}
+
+func (r PendingTransferPostgres) Update(ctx context.Context, transfer model.PendingTransfer) error {
+	query := "UPDATE pending_transfer SET captured_amount = $1, status = $2, void_reason = $3, " +
+		"transaction_log_id = $4, updated_at = $5 WHERE id = $6"
+
+	_, err := executor(ctx, r.db).ExecContext(ctx, query, transfer.CapturedAmount, transfer.Status,
+		transfer.VoidReason, transfer.TransactionLogId, transfer.UpdatedAt, transfer.Id)
+	if err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to update pending transfer %v, error: %s",
+			transfer.Id, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// GetDueIds returns the ids of up to limit authorized transfers expired by now, the earliest first.
+func (r PendingTransferPostgres) GetDueIds(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error) {
+	query := "SELECT pt.id FROM pending_transfer AS pt WHERE pt.status = $1 AND pt.expires_at <= $2 " +
+		"ORDER BY pt.expires_at LIMIT $3"
+
+	var ids []uuid.UUID
+
+	err := sqlx.SelectContext(ctx, executor(ctx, r.db), &ids, query, model.PendingTransferAuthorized, now, limit)
+	if err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get expired pending transfers, error: %s",
+			err.Error())
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// SumIncoming returns what the authorized transfers to a user will credit it once captured in full.
+func (r PendingTransferPostgres) SumIncoming(ctx context.Context, receiverId uuid.UUID) (float64, error) {
+	query := "SELECT COALESCE(SUM(pt.amount), 0) FROM pending_transfer AS pt " +
+		"WHERE pt.receiver_id = $1 AND pt.status = $2"
+
+	var sum float64
+
+	err := sqlx.GetContext(ctx, executor(ctx, r.db), &sum, query, receiverId, model.PendingTransferAuthorized)
+	if err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", receiverId).
+			Errorf("error in db while trying to sum incoming pending transfers, error: %s", err.Error())
+		return 0, err
+	}
+
+	return sum, nil
+}
+
+// SumOutgoing returns how many transfers a user authorized that are not captured nor voided yet and what
+// they will take from it once captured in full, fees left out.
+func (r PendingTransferPostgres) SumOutgoing(ctx context.Context, senderId uuid.UUID) (float64, int, error) {
+	query := "SELECT COALESCE(SUM(pt.amount), 0), COUNT(*) FROM pending_transfer AS pt " +
+		"WHERE pt.sender_id = $1 AND pt.status = $2"
+
+	var sum float64
+	var count int
+
+	row := executor(ctx, r.db).QueryRowxContext(ctx, query, senderId, model.PendingTransferAuthorized)
+	if err := row.Scan(&sum, &count); err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", senderId).
+			Errorf("error in db while trying to sum outgoing pending transfers, error: %s", err.Error())
+		return 0, 0, err
+	}
+
+	return sum, count, nil
+}
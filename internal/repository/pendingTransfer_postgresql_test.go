@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestPendingTransferPostgres_GetForUpdate(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewPendingTransferPostgres(db, log)
+
+	now := time.Now()
+	id, sender, receiver := uuid.New(), uuid.New(), uuid.New()
+	var logId int32 = 7
+
+	rows := sqlxmock.NewRows([]string{"id", "sender_id", "receiver_id", "amount", "fee", "held_amount",
+		"captured_amount", "reference", "status", "void_reason", "transaction_log_id", "expires_at", "created_at",
+		"updated_at"}).
+		AddRow(id, sender, receiver, 50, 1, 51, 20, "order-1", model.PendingTransferCaptured, "", logId, now, now,
+			now)
+	mock.ExpectQuery("SELECT (.+) FROM pending_transfer AS pt WHERE pt.id = \\$1 FOR UPDATE").
+		WithArgs(id).WillReturnRows(rows)
+
+	got, err := r.GetForUpdate(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, 50.0, got.Amount)
+	assert.Equal(t, 1.0, got.Fee)
+	assert.Equal(t, 51.0, got.HeldAmount)
+	assert.Equal(t, 20.0, got.CapturedAmount)
+	assert.Equal(t, model.PendingTransferCaptured, got.Status)
+	assert.Equal(t, logId, *got.TransactionLogId)
+}
+
+func TestPendingTransferPostgres_GetDueIds(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewPendingTransferPostgres(db, log)
+
+	now := time.Now()
+	id := uuid.New()
+
+	rows := sqlxmock.NewRows([]string{"id"}).AddRow(id)
+	mock.ExpectQuery("SELECT pt.id FROM pending_transfer AS pt WHERE pt.status = \\$1 AND pt.expires_at <= \\$2 "+
+		"ORDER BY pt.expires_at LIMIT \\$3").
+		WithArgs(model.PendingTransferAuthorized, now, 5).WillReturnRows(rows)
+
+	got, err := r.GetDueIds(context.Background(), now, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{id}, got)
+}
+
+func TestPendingTransferPostgres_SumIncoming(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewPendingTransferPostgres(db, log)
+
+	receiver := uuid.New()
+
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(pt.amount\\), 0\\) FROM pending_transfer AS pt "+
+		"WHERE pt.receiver_id = \\$1 AND pt.status = \\$2").
+		WithArgs(receiver, model.PendingTransferAuthorized).
+		WillReturnRows(sqlxmock.NewRows([]string{"sum"}).AddRow(42.5))
+
+	got, err := r.SumIncoming(context.Background(), receiver)
+	assert.NoError(t, err)
+	assert.Equal(t, 42.5, got)
+}
+
+func TestPendingTransferPostgres_SumOutgoing(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewPendingTransferPostgres(db, log)
+
+	sender := uuid.New()
+
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(pt.amount\\), 0\\), COUNT\\(\\*\\) FROM pending_transfer AS pt "+
+		"WHERE pt.sender_id = \\$1 AND pt.status = \\$2").
+		WithArgs(sender, model.PendingTransferAuthorized).
+		WillReturnRows(sqlxmock.NewRows([]string{"sum", "count"}).AddRow(42.5, 2))
+
+	sum, count, err := r.SumOutgoing(context.Background(), sender)
+	assert.NoError(t, err)
+	assert.Equal(t, 42.5, sum)
+	assert.Equal(t, 2, count)
+}
@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const reconciliationRunColumns = "rr.id, rr.trigger, rr.sample_size, rr.status, rr.checked, rr.mismatched, " +
+	"rr.total_difference, rr.error, rr.started_at, rr.finished_at"
+
+const reconciliationMismatchColumns = "rm.id, rm.run_id, rm.user_id, rm.balance, rm.logged_balance, rm.difference, " +
+	"rm.status, rm.resolved_by, rm.resolved_at, rm.correction_log_id"
+
+// balanceComparison selects the balance of accounts next to the net of their logged movements.
+const balanceComparison = "SELECT ub.user_id, ub.balance, COALESCE((SELECT SUM(" + signedAmount + ") " +
+	"FROM transaction_log AS tl WHERE tl.user_id = ub.user_id), 0) AS logged_balance FROM user_balance AS ub "
+
+type ReconciliationPostgres struct {
+	db     *sqlx.DB
+	logger logger.Logger
+}
+
+func NewReconciliationPostgres(db *sqlx.DB, logger logger.Logger) *ReconciliationPostgres {
+	return &ReconciliationPostgres{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r ReconciliationPostgres) CreateRun(ctx context.Context, run model.ReconciliationRun) error {
+	query := "INSERT INTO reconciliation_run (id, trigger, sample_size, status, started_at) VALUES ($1, $2, $3, $4, $5)"
+
+	_, err := executor(ctx, r.db).ExecContext(ctx, query, run.Id, run.Trigger, run.SampleSize, run.Status,
+		run.StartedAt)
+	if err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to create reconciliation run, error: %s",
+			err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (r ReconciliationPostgres) UpdateRun(ctx context.Context, run model.ReconciliationRun) error {
+	query := "UPDATE reconciliation_run SET status = $1, checked = $2, mismatched = $3, total_difference = $4, " +
+		"error = $5, finished_at = $6 WHERE id = $7"
+
+	_, err := executor(ctx, r.db).ExecContext(ctx, query, run.Status, run.Checked, run.Mismatched,
+		run.TotalDifference, run.Error, run.FinishedAt, run.Id)
+	if err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to update reconciliation run %v, error: %s",
+			run.Id, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (r ReconciliationPostgres) GetRun(ctx context.Context, id uuid.UUID) (model.ReconciliationRun, error) {
+	query := "SELECT " + reconciliationRunColumns + " FROM reconciliation_run AS rr WHERE rr.id = $1"
+
+	var run model.ReconciliationRun
+
+	err := sqlx.GetContext(ctx, executor(ctx, r.db), &run, query, id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get reconciliation run %v, error: %s",
+			id, err.Error())
+	}
+
+	return run, err
+}
+
+// GetLatestRun returns the latest run started by the given trigger.
+func (r ReconciliationPostgres) GetLatestRun(ctx context.Context, trigger string) (model.ReconciliationRun, error) {
+	query := "SELECT " + reconciliationRunColumns + " FROM reconciliation_run AS rr WHERE rr.trigger = $1 " +
+		"ORDER BY rr.started_at DESC LIMIT 1"
+
+	var run model.ReconciliationRun
+
+	err := sqlx.GetContext(ctx, executor(ctx, r.db), &run, query, trigger)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get latest %s reconciliation run, error: %s",
+			trigger, err.Error())
+	}
+
+	return run, err
+}
+
+// GetRuns returns runs, the latest first.
+func (r ReconciliationPostgres) GetRuns(ctx context.Context, pageNum int, pageSize int) (
+	[]model.ReconciliationRun, error) {
+	query := "SELECT " + reconciliationRunColumns + " FROM reconciliation_run AS rr " +
+		"ORDER BY rr.started_at DESC LIMIT $1 OFFSET $2"
+
+	var runs []model.ReconciliationRun
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, r.db), &runs, query, pageSize, pageNum*pageSize); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get reconciliation runs, error: %s",
+			err.Error())
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// CompareBalances compares up to limit accounts following the given user id in user id order.
+func (r ReconciliationPostgres) CompareBalances(ctx context.Context, afterUserId uuid.UUID, limit int) (
+	[]model.BalanceComparison, error) {
+	query := balanceComparison + "WHERE ub.user_id > $1 ORDER BY ub.user_id LIMIT $2"
+
+	var comparisons []model.BalanceComparison
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, r.db), &comparisons, query, afterUserId, limit); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to compare balances with logs, error: %s",
+			err.Error())
+		return nil, err
+	}
+
+	return comparisons, nil
+}
+
+// SampleBalances compares size accounts picked at random.
+func (r ReconciliationPostgres) SampleBalances(ctx context.Context, size int) ([]model.BalanceComparison, error) {
+	query := balanceComparison + "ORDER BY random() LIMIT $1"
+
+	var comparisons []model.BalanceComparison
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, r.db), &comparisons, query, size); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to compare sampled balances with logs, error: %s",
+			err.Error())
+		return nil, err
+	}
+
+	return comparisons, nil
+}
+
+func (r ReconciliationPostgres) CreateMismatch(ctx context.Context, mismatch model.ReconciliationMismatch) (
+	int64, error) {
+	query := "INSERT INTO reconciliation_mismatch (run_id, user_id, balance, logged_balance, difference, status) " +
+		"VALUES ($1, $2, $3, $4, $5, $6) RETURNING id"
+
+	var id int64
+
+	row := executor(ctx, r.db).QueryRowxContext(ctx, query, mismatch.RunId, mismatch.UserId, mismatch.Balance,
+		mismatch.LoggedBalance, mismatch.Difference, mismatch.Status)
+	if err := row.Scan(&id); err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", mismatch.UserId).
+			Errorf("error in db while trying to create reconciliation mismatch, error: %s", err.Error())
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r ReconciliationPostgres) GetMismatches(ctx context.Context, runId uuid.UUID) (
+	[]model.ReconciliationMismatch, error) {
+	query := "SELECT " + reconciliationMismatchColumns + " FROM reconciliation_mismatch AS rm " +
+		"WHERE rm.run_id = $1 ORDER BY rm.id"
+
+	var mismatches []model.ReconciliationMismatch
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, r.db), &mismatches, query, runId); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get mismatches of reconciliation run %v, "+
+			"error: %s", runId, err.Error())
+		return nil, err
+	}
+
+	return mismatches, nil
+}
+
+// GetMismatchForUpdate returns the mismatch locked until the end of the current transaction.
+func (r ReconciliationPostgres) GetMismatchForUpdate(ctx context.Context, id int64) (
+	model.ReconciliationMismatch, error) {
+	query := "SELECT " + reconciliationMismatchColumns + " FROM reconciliation_mismatch AS rm WHERE rm.id = $1 " +
+		"FOR UPDATE"
+
+	var mismatch model.ReconciliationMismatch
+
+	err := sqlx.GetContext(ctx, executor(ctx, r.db), &mismatch, query, id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to get reconciliation mismatch %v, error: %s",
+			id, err.Error())
+	}
+
+	return mismatch, err
+}
+
+func (r ReconciliationPostgres) UpdateMismatch(ctx context.Context, mismatch model.ReconciliationMismatch) error {
+	query := "UPDATE reconciliation_mismatch SET status = $1, resolved_by = $2, resolved_at = $3, " +
+		"correction_log_id = $4 WHERE id = $5"
+
+	_, err := executor(ctx, r.db).ExecContext(ctx, query, mismatch.Status, mismatch.ResolvedBy, mismatch.ResolvedAt,
+		mismatch.CorrectionLogId, mismatch.Id)
+	if err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to update reconciliation mismatch %v, error: %s",
+			mismatch.Id, err.Error())
+		return err
+	}
+
+	return nil
+}
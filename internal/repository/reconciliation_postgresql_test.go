@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestReconciliationPostgres_CompareBalances(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx(sqlxmock.QueryMatcherOption(sqlxmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewReconciliationPostgres(db, log)
+
+	userId := uuid.New()
+	rows := sqlxmock.NewRows([]string{"user_id", "balance", "logged_balance"}).AddRow(userId, 50, 40)
+	mock.ExpectQuery("SELECT ub.user_id, ub.balance, COALESCE((SELECT SUM(CASE WHEN tl.operation_type IN "+
+		"('credit', 'transfer_in', 'reversal_credit', 'correction_credit', 'fee_revenue', 'bonus_credit', 'split_in', 'escrow_hold', 'escrow_release', 'escrow_refund') "+
+		"THEN tl.amount ELSE -tl.amount END) "+
+		"FROM transaction_log AS tl WHERE tl.user_id = ub.user_id), 0) AS logged_balance FROM user_balance AS ub "+
+		"WHERE ub.user_id > $1 ORDER BY ub.user_id LIMIT $2").
+		WithArgs(uuid.Nil, 1000).WillReturnRows(rows)
+
+	got, err := r.CompareBalances(context.Background(), uuid.Nil, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.BalanceComparison{{UserId: userId, Balance: 50, LoggedBalance: 40}}, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReconciliationPostgres_CreateMismatch(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewReconciliationPostgres(db, log)
+
+	mismatch := model.ReconciliationMismatch{RunId: uuid.New(), UserId: uuid.New(), Balance: 50, LoggedBalance: 40,
+		Difference: 10, Status: model.MismatchOpen}
+	mock.ExpectQuery("INSERT INTO reconciliation_mismatch (.+) RETURNING id").
+		WithArgs(mismatch.RunId, mismatch.UserId, mismatch.Balance, mismatch.LoggedBalance, mismatch.Difference,
+			mismatch.Status).
+		WillReturnRows(sqlxmock.NewRows([]string{"id"}).AddRow(7))
+
+	id, err := r.CreateMismatch(context.Background(), mismatch)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), id)
+}
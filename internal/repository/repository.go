@@ -1,34 +1,205 @@
 package repository
 
 import (
-	"log"
+	"context"
+	"time"
 
+	"github.com/Feokrat/user-balance-api/internal/logger"
 	"github.com/Feokrat/user-balance-api/internal/model"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
 type UserBalance interface {
-	GetByUserId(userId uuid.UUID) (model.UserBalance, error)
-	UpdateByUserId(userId uuid.UUID, changeAmount float64) error
-	CheckIfExistsByUserId(userId uuid.UUID) (bool, error)
-	Create(userBalance model.UserBalance) error
+	GetByUserId(ctx context.Context, userId uuid.UUID) (model.UserBalance, error)
+	GetByUserIdForUpdate(ctx context.Context, userId uuid.UUID) (model.UserBalance, error)
+	UpdateByUserId(ctx context.Context, userId uuid.UUID, changeAmount float64) (float64, error)
+	CheckIfExistsByUserId(ctx context.Context, userId uuid.UUID) (bool, error)
+	Create(ctx context.Context, userBalance model.UserBalance) error
+	UpdateStatusByUserId(ctx context.Context, userId uuid.UUID, status string) error
+	UpdateOverdraftLimitByUserId(ctx context.Context, userId uuid.UUID, overdraftLimit float64) error
+	UpdateHeldByUserId(ctx context.Context, userId uuid.UUID, changeAmount float64) (float64, error)
+	CreateStatusChange(ctx context.Context, change model.AccountStatusChange) error
+	GetStatusChanges(ctx context.Context, userId uuid.UUID, pageNum int, pageSize int) (
+		[]model.AccountStatusChange, error)
+	Search(ctx context.Context, query model.AccountQuery) ([]model.UserBalance, error)
+	Totals(ctx context.Context, filter model.AccountFilter) ([]model.AccountTotals, error)
 }
 
 type TransactionLog interface {
-	GetAllByUserId(userId uuid.UUID, sortField string, pageNum int, pageSize int) ([]model.TransactionLog, error)
-	CountByUserId(userId uuid.UUID) (int, error)
-	Create(transactionLog model.TransactionLog) (int32, error)
+	GetAllByUserId(ctx context.Context, userId uuid.UUID, sortField string, pageNum int, pageSize int) (
+		[]model.TransactionLog, error)
+	CountByUserId(ctx context.Context, userId uuid.UUID) (int, error)
+	Create(ctx context.Context, transactionLog model.TransactionLog) (int32, error)
+	GetById(ctx context.Context, id int32) (model.TransactionLog, error)
+	GetByIdForUpdate(ctx context.Context, id int32) (model.TransactionLog, error)
+	GetByRelatedLogId(ctx context.Context, relatedLogId int32) (model.TransactionLog, error)
+	AddReversedAmount(ctx context.Context, id int32, amount float64) (model.TransactionLog, error)
+	SumOutgoingSince(ctx context.Context, userId uuid.UUID, since time.Time) (float64, error)
+	CountTransfersSince(ctx context.Context, userId uuid.UUID, since time.Time) (int, *time.Time, error)
+	SumChangesBetween(ctx context.Context, userId uuid.UUID, from time.Time, to time.Time) (float64, error)
+}
+
+type BalanceSnapshot interface {
+	GetLatestAt(ctx context.Context, userId uuid.UUID, at time.Time) (model.BalanceSnapshot, error)
+	GetByUserIdBetween(ctx context.Context, userId uuid.UUID, from time.Time, to time.Time) (
+		[]model.BalanceSnapshot, error)
+	GetLatestDay(ctx context.Context) (*time.Time, error)
+	Create(ctx context.Context, snapshot model.BalanceSnapshot) (bool, error)
+	CreateForDay(ctx context.Context, day time.Time, asOf time.Time) (int64, error)
+	MarkDayTaken(ctx context.Context, day time.Time, snapshots int64) error
+}
+
+type Limit interface {
+	GetByUserId(ctx context.Context, userId uuid.UUID) (model.UserLimits, error)
+	Upsert(ctx context.Context, limits model.UserLimits) error
+}
+
+type Outbox interface {
+	Create(ctx context.Context, event model.OutboxEvent) (int64, error)
+	GetPending(ctx context.Context, limit int) ([]model.OutboxEvent, error)
+	MarkDispatched(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, reason string) error
+}
+
+type Webhook interface {
+	CreateSubscription(ctx context.Context, subscription model.WebhookSubscription) error
+	GetSubscription(ctx context.Context, id uuid.UUID) (model.WebhookSubscription, error)
+	GetAllSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error)
+	GetActiveSubscriptionsByEventType(ctx context.Context, eventType string) ([]model.WebhookSubscription, error)
+	UpdateSubscription(ctx context.Context, subscription model.WebhookSubscription) error
+	DeleteSubscription(ctx context.Context, id uuid.UUID) (bool, error)
+	CreateDelivery(ctx context.Context, delivery model.WebhookDelivery) error
+	ClaimDueDeliveries(ctx context.Context, limit int, leaseUntil time.Time) ([]model.WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery model.WebhookDelivery) error
+	CreateAttempt(ctx context.Context, attempt model.WebhookDeliveryAttempt) error
+	GetDeliveriesBySubscription(ctx context.Context, subscriptionId uuid.UUID, pageNum int, pageSize int) (
+		[]model.WebhookDelivery, error)
+	GetAttemptsByDeliveryIds(ctx context.Context, deliveryIds []int64) ([]model.WebhookDeliveryAttempt, error)
+}
+
+type Batch interface {
+	Create(ctx context.Context, batch model.Batch) (bool, error)
+	GetByIdempotencyKey(ctx context.Context, key string) (model.Batch, error)
+}
+
+type ScheduledTransfer interface {
+	Create(ctx context.Context, transfer model.ScheduledTransfer) error
+	Get(ctx context.Context, id uuid.UUID) (model.ScheduledTransfer, error)
+	GetForUpdate(ctx context.Context, id uuid.UUID) (model.ScheduledTransfer, error)
+	GetAll(ctx context.Context, pageNum int, pageSize int) ([]model.ScheduledTransfer, error)
+	GetDue(ctx context.Context, now time.Time, limit int) ([]model.ScheduledTransfer, error)
+	Update(ctx context.Context, transfer model.ScheduledTransfer) error
+	Delete(ctx context.Context, id uuid.UUID) (bool, error)
+	CreateExecution(ctx context.Context, execution model.ScheduledTransferExecution) error
+	GetExecutions(ctx context.Context, id uuid.UUID, pageNum int, pageSize int) (
+		[]model.ScheduledTransferExecution, error)
+}
+
+type Reconciliation interface {
+	CreateRun(ctx context.Context, run model.ReconciliationRun) error
+	UpdateRun(ctx context.Context, run model.ReconciliationRun) error
+	GetRun(ctx context.Context, id uuid.UUID) (model.ReconciliationRun, error)
+	GetLatestRun(ctx context.Context, trigger string) (model.ReconciliationRun, error)
+	GetRuns(ctx context.Context, pageNum int, pageSize int) ([]model.ReconciliationRun, error)
+	CompareBalances(ctx context.Context, afterUserId uuid.UUID, limit int) ([]model.BalanceComparison, error)
+	SampleBalances(ctx context.Context, size int) ([]model.BalanceComparison, error)
+	CreateMismatch(ctx context.Context, mismatch model.ReconciliationMismatch) (int64, error)
+	GetMismatches(ctx context.Context, runId uuid.UUID) ([]model.ReconciliationMismatch, error)
+	GetMismatchForUpdate(ctx context.Context, id int64) (model.ReconciliationMismatch, error)
+	UpdateMismatch(ctx context.Context, mismatch model.ReconciliationMismatch) error
+}
+
+type Bonus interface {
+	Create(ctx context.Context, grant model.BonusGrant) (int64, error)
+	GetActiveByUserId(ctx context.Context, userId uuid.UUID) ([]model.BonusGrant, error)
+	UpdateRemaining(ctx context.Context, id int64, remaining float64) error
+	Expire(ctx context.Context, id int64, expiredAt time.Time) error
+	GetDueUserIds(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error)
+}
+
+type PaymentRequest interface {
+	Create(ctx context.Context, request model.PaymentRequest) error
+	Get(ctx context.Context, id uuid.UUID) (model.PaymentRequest, error)
+	GetForUpdate(ctx context.Context, id uuid.UUID) (model.PaymentRequest, error)
+	GetAll(ctx context.Context, filter model.PaymentRequestFilter, pageNum int, pageSize int) (
+		[]model.PaymentRequest, error)
+	GetDue(ctx context.Context, now time.Time, limit int) ([]model.PaymentRequest, error)
+	UpdateStatus(ctx context.Context, id uuid.UUID, status string, updatedAt time.Time) error
+	CreateEvent(ctx context.Context, event model.PaymentRequestEvent) error
+	GetEvents(ctx context.Context, id uuid.UUID) ([]model.PaymentRequestEvent, error)
+}
+
+type PendingTransfer interface {
+	Create(ctx context.Context, transfer model.PendingTransfer) error
+	Get(ctx context.Context, id uuid.UUID) (model.PendingTransfer, error)
+	GetForUpdate(ctx context.Context, id uuid.UUID) (model.PendingTransfer, error)
+	Update(ctx context.Context, transfer model.PendingTransfer) error
+	GetDueIds(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error)
+	SumIncoming(ctx context.Context, receiverId uuid.UUID) (float64, error)
+	SumOutgoing(ctx context.Context, senderId uuid.UUID) (float64, int, error)
+}
+
+type Escrow interface {
+	Create(ctx context.Context, escrow model.Escrow) (bool, error)
+	Get(ctx context.Context, id uuid.UUID) (model.Escrow, error)
+	GetByDealId(ctx context.Context, dealId string) (model.Escrow, error)
+	GetForUpdate(ctx context.Context, id uuid.UUID) (model.Escrow, error)
+	Settle(ctx context.Context, escrow model.Escrow) error
+}
+
+type Audit interface {
+	Create(ctx context.Context, entry model.AuditEntry) error
+	GetAll(ctx context.Context, filter model.AuditFilter, pageNum int, pageSize int) ([]model.AuditEntry, error)
+}
+
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	WithinSavepoint(ctx context.Context, fn func(ctx context.Context) error) error
+	TryAdvisoryLock(ctx context.Context, key int64) (bool, error)
+}
+
+type Health interface {
+	Ping(ctx context.Context) error
+	MigrationVersion(ctx context.Context) (version uint, dirty bool, err error)
 }
 
 type Repository struct {
 	UserBalance
 	TransactionLog
+	Outbox
+	Webhook
+	Batch
+	ScheduledTransfer
+	Limit
+	BalanceSnapshot
+	Reconciliation
+	Bonus
+	PaymentRequest
+	PendingTransfer
+	Escrow
+	Audit
+	Transactor
+	Health
 }
 
-func NewRepositories(db *sqlx.DB, logger *log.Logger) *Repository {
+func NewRepositories(db *sqlx.DB, logger logger.Logger) *Repository {
 	return &Repository{
-		UserBalance:    NewUserBalancePostgres(db, logger),
-		TransactionLog: NewTransactionLogPostgres(db, logger),
+		UserBalance:       NewUserBalancePostgres(db, logger),
+		TransactionLog:    NewTransactionLogPostgres(db, logger),
+		Outbox:            NewOutboxPostgres(db, logger),
+		Webhook:           NewWebhookPostgres(db, logger),
+		Batch:             NewBatchPostgres(db, logger),
+		ScheduledTransfer: NewScheduledTransferPostgres(db, logger),
+		Limit:             NewLimitPostgres(db, logger),
+		BalanceSnapshot:   NewBalanceSnapshotPostgres(db, logger),
+		Reconciliation:    NewReconciliationPostgres(db, logger),
+		Bonus:             NewBonusPostgres(db, logger),
+		PaymentRequest:    NewPaymentRequestPostgres(db, logger),
+		PendingTransfer:   NewPendingTransferPostgres(db, logger),
+		Escrow:            NewEscrowPostgres(db, logger),
+		Audit:             NewAuditPostgres(db, logger),
+		Transactor:        NewTransactorPostgres(db, logger),
+		Health:            NewHealthPostgres(db, logger),
 	}
 }
@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const scheduledTransferColumns = "st.id, st.sender_id, st.receiver_id, st.amount, st.recurrence, st.day_of_month, " +
+	"st.next_run_at, st.retry_at, st.retries, st.status, st.last_error, st.created_at, st.updated_at"
+
+type ScheduledTransferPostgres struct {
+	db     *sqlx.DB
+	logger logger.Logger
+}
+
+func NewScheduledTransferPostgres(db *sqlx.DB, logger logger.Logger) *ScheduledTransferPostgres {
+	return &ScheduledTransferPostgres{
+		db:     db,
+		logger: logger}
+}
+
+func (s ScheduledTransferPostgres) Create(ctx context.Context, transfer model.ScheduledTransfer) error {
+	query := "INSERT INTO scheduled_transfer (id, sender_id, receiver_id, amount, recurrence, day_of_month, " +
+		"next_run_at, status, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"
+
+	_, err := executor(ctx, s.db).ExecContext(ctx, query, transfer.Id, transfer.SenderId, transfer.ReceiverId,
+		transfer.Amount, transfer.Recurrence, transfer.DayOfMonth, transfer.NextRunAt, transfer.Status,
+		transfer.CreatedAt, transfer.UpdatedAt)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("error in db while trying to create scheduled transfer, error: %s",
+			err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (s ScheduledTransferPostgres) Get(ctx context.Context, id uuid.UUID) (model.ScheduledTransfer, error) {
+	return s.get(ctx, "SELECT "+scheduledTransferColumns+" FROM scheduled_transfer AS st WHERE st.id = $1", id)
+}
+
+// GetForUpdate locks the scheduled transfer until the end of the transaction, so that it is not
+// changed while the worker executes it.
+func (s ScheduledTransferPostgres) GetForUpdate(ctx context.Context, id uuid.UUID) (model.ScheduledTransfer, error) {
+	return s.get(ctx, "SELECT "+scheduledTransferColumns+" FROM scheduled_transfer AS st WHERE st.id = $1 "+
+		"FOR UPDATE", id)
+}
+
+func (s ScheduledTransferPostgres) get(ctx context.Context, query string, id uuid.UUID) (
+	model.ScheduledTransfer, error) {
+	var transfer model.ScheduledTransfer
+
+	if err := sqlx.GetContext(ctx, executor(ctx, s.db), &transfer, query, id); err != nil {
+		s.logger.WithContext(ctx).Errorf("error in db while trying to get scheduled transfer %v, error: %s",
+			id, err.Error())
+		return model.ScheduledTransfer{}, err
+	}
+
+	return transfer, nil
+}
+
+func (s ScheduledTransferPostgres) GetAll(ctx context.Context, pageNum int, pageSize int) (
+	[]model.ScheduledTransfer, error) {
+	query := "SELECT " + scheduledTransferColumns + " FROM scheduled_transfer AS st " +
+		"ORDER BY st.created_at LIMIT $1 OFFSET $2"
+
+	var transfers []model.ScheduledTransfer
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, s.db), &transfers, query, pageSize, pageNum*pageSize); err != nil {
+		s.logger.WithContext(ctx).Errorf("error in db while trying to get scheduled transfers, error: %s",
+			err.Error())
+		return nil, err
+	}
+
+	return transfers, nil
+}
+
+// GetDue locks active transfers whose occurrence or retry is due, skipping the ones locked by
+// other instances.
+func (s ScheduledTransferPostgres) GetDue(ctx context.Context, now time.Time, limit int) (
+	[]model.ScheduledTransfer, error) {
+	query := "SELECT " + scheduledTransferColumns + " FROM scheduled_transfer AS st " +
+		"WHERE st.status = $1 AND COALESCE(st.retry_at, st.next_run_at) <= $2 " +
+		"ORDER BY COALESCE(st.retry_at, st.next_run_at) LIMIT $3 FOR UPDATE SKIP LOCKED"
+
+	var transfers []model.ScheduledTransfer
+
+	err := sqlx.SelectContext(ctx, executor(ctx, s.db), &transfers, query, model.ScheduledTransferActive, now,
+		limit)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("error in db while trying to get due scheduled transfers, error: %s",
+			err.Error())
+		return nil, err
+	}
+
+	return transfers, nil
+}
+
+func (s ScheduledTransferPostgres) Update(ctx context.Context, transfer model.ScheduledTransfer) error {
+	query := "UPDATE scheduled_transfer SET amount = $1, recurrence = $2, day_of_month = $3, next_run_at = $4, " +
+		"retry_at = $5, retries = $6, status = $7, last_error = $8, updated_at = $9 WHERE id = $10"
+
+	_, err := executor(ctx, s.db).ExecContext(ctx, query, transfer.Amount, transfer.Recurrence, transfer.DayOfMonth,
+		transfer.NextRunAt, transfer.RetryAt, transfer.Retries, transfer.Status, transfer.LastError,
+		transfer.UpdatedAt, transfer.Id)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("error in db while trying to update scheduled transfer %v, error: %s",
+			transfer.Id, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (s ScheduledTransferPostgres) Delete(ctx context.Context, id uuid.UUID) (bool, error) {
+	result, err := executor(ctx, s.db).ExecContext(ctx, "DELETE FROM scheduled_transfer WHERE id = $1", id)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("error in db while trying to delete scheduled transfer %v, error: %s",
+			id, err.Error())
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return deleted > 0, nil
+}
+
+func (s ScheduledTransferPostgres) CreateExecution(ctx context.Context,
+	execution model.ScheduledTransferExecution) error {
+	query := "INSERT INTO scheduled_transfer_execution (scheduled_transfer_id, scheduled_for, executed_at, " +
+		"status, error) VALUES ($1, $2, $3, $4, $5)"
+
+	_, err := executor(ctx, s.db).ExecContext(ctx, query, execution.ScheduledTransferId, execution.ScheduledFor,
+		execution.ExecutedAt, execution.Status, execution.Error)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("error in db while trying to record execution of scheduled transfer %v, "+
+			"error: %s", execution.ScheduledTransferId, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (s ScheduledTransferPostgres) GetExecutions(ctx context.Context, id uuid.UUID, pageNum int, pageSize int) (
+	[]model.ScheduledTransferExecution, error) {
+	query := "SELECT ste.id, ste.scheduled_transfer_id, ste.scheduled_for, ste.executed_at, ste.status, ste.error " +
+		"FROM scheduled_transfer_execution AS ste WHERE ste.scheduled_transfer_id = $1 " +
+		"ORDER BY ste.id DESC LIMIT $2 OFFSET $3"
+
+	var executions []model.ScheduledTransferExecution
+
+	err := sqlx.SelectContext(ctx, executor(ctx, s.db), &executions, query, id, pageSize, pageNum*pageSize)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("error in db while trying to get executions of scheduled transfer %v, "+
+			"error: %s", id, err.Error())
+		return nil, err
+	}
+
+	return executions, nil
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestScheduledTransferPostgres_GetDue(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewScheduledTransferPostgres(db, log)
+
+	now := time.Now()
+	id := uuid.New()
+
+	rows := sqlxmock.NewRows([]string{"id", "sender_id", "receiver_id", "amount", "recurrence", "day_of_month",
+		"next_run_at", "retry_at", "retries", "status", "last_error", "created_at", "updated_at"}).
+		AddRow(id, uuid.New(), uuid.New(), 30, model.RecurrenceMonthly, 31, now, nil, 0,
+			model.ScheduledTransferActive, "", now, now)
+	mock.ExpectQuery("SELECT (.+) FROM scheduled_transfer AS st WHERE st.status = \\$1 "+
+		"AND COALESCE\\(st.retry_at, st.next_run_at\\) <= \\$2 (.+) FOR UPDATE SKIP LOCKED").
+		WithArgs(model.ScheduledTransferActive, now, 1).WillReturnRows(rows)
+
+	got, err := r.GetDue(context.Background(), now, 1)
+	assert.NoError(t, err)
+	assert.Len(t, got, 1)
+	assert.Equal(t, id, got[0].Id)
+	assert.Equal(t, 31, got[0].DayOfMonth)
+	assert.Nil(t, got[0].RetryAt)
+}
+
+func TestScheduledTransferPostgres_Delete(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewScheduledTransferPostgres(db, log)
+
+	id := uuid.New()
+	mock.ExpectExec("DELETE FROM scheduled_transfer WHERE id = \\$1").WithArgs(id).
+		WillReturnResult(sqlxmock.NewResult(0, 0))
+
+	deleted, err := r.Delete(context.Background(), id)
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+}
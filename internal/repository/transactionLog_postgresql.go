@@ -1,67 +1,201 @@
 package repository
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"time"
 
+	"github.com/Feokrat/user-balance-api/internal/audit"
+	"github.com/Feokrat/user-balance-api/internal/logger"
 	"github.com/Feokrat/user-balance-api/internal/model"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
+// signedAmount is what an entry changed the balance of its user by, credits adding their amount and every
+// other operation taking it.
+const signedAmount = "CASE WHEN tl.operation_type IN ('" + model.OperationCredit + "', '" + model.OperationTransferIn +
+	"', '" + model.OperationReversalCredit + "', '" + model.OperationCorrectionCredit + "', '" +
+	model.OperationFeeRevenue + "', '" + model.OperationBonusCredit + "', '" + model.OperationSplitIn + "', '" +
+	model.OperationEscrowHold + "', '" + model.OperationEscrowRelease + "', '" + model.OperationEscrowRefund +
+	"') THEN tl.amount " +
+	"ELSE -tl.amount END"
+
+const transactionLogColumns = "tl.id, tl.user_id, tl.date, tl.amount, tl.commentary, tl.request_id, " +
+	"tl.operation_type, tl.counterparty_id, tl.related_log_id, tl.reversal_of, tl.reversed_amount, tl.reversal_status, " +
+	"tl.grace_ends_at, tl.fee_of, tl.correlation_id"
+
 type TransactionLogPostgres struct {
 	db     *sqlx.DB
-	logger *log.Logger
+	logger logger.Logger
 }
 
-func NewTransactionLogPostgres(db *sqlx.DB, logger *log.Logger) *TransactionLogPostgres {
+func NewTransactionLogPostgres(db *sqlx.DB, logger logger.Logger) *TransactionLogPostgres {
 	return &TransactionLogPostgres{
 		db:     db,
 		logger: logger}
 }
 
-func (t TransactionLogPostgres) GetAllByUserId(userId uuid.UUID, sortField string, pageNum int, pageSize int) (
-	[]model.TransactionLog, error) {
-	query := fmt.Sprintf("SELECT tl.id, tl.user_id, tl.date, tl.amount, tl.commentary FROM transaction_log AS tl "+
-		"WHERE tl.user_id = $1 ORDER BY %s LIMIT $2 OFFSET $3", sortField)
+func (t TransactionLogPostgres) GetAllByUserId(ctx context.Context, userId uuid.UUID, sortField string, pageNum int,
+	pageSize int) ([]model.TransactionLog, error) {
+	query := fmt.Sprintf("SELECT %s FROM transaction_log AS tl WHERE tl.user_id = $1 ORDER BY %s LIMIT $2 OFFSET $3",
+		transactionLogColumns, sortField)
 
 	var transactionLogs []model.TransactionLog
 
-	err := t.db.Select(&transactionLogs, query, userId, pageSize, pageNum*pageSize)
+	err := sqlx.SelectContext(ctx, executor(ctx, t.db), &transactionLogs, query, userId, pageSize,
+		pageNum*pageSize)
 	if err != nil {
-		t.logger.Printf("error in db while trying to get transaction log of user %v, error: %s",
-			userId, err)
+		t.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to get transaction log of user, error: %s", err)
 		return nil, err
 	}
 
 	return transactionLogs, nil
 }
 
-func (t TransactionLogPostgres) CountByUserId(userId uuid.UUID) (int, error) {
+func (t TransactionLogPostgres) CountByUserId(ctx context.Context, userId uuid.UUID) (int, error) {
 	var count int
-	row := t.db.QueryRow("SELECT COUNT(*) FROM transaction_log AS tl WHERE tl.user_id=$1", userId)
+	row := executor(ctx, t.db).QueryRowxContext(ctx,
+		"SELECT COUNT(*) FROM transaction_log AS tl WHERE tl.user_id=$1", userId)
 	err := row.Scan(&count)
 	if err != nil {
-		t.logger.Printf("could not perform count for transaction logs of user %v in db, error: %s",
-			userId, err.Error())
+		t.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not perform count for transaction logs of user in db, error: %s", err.Error())
 		return 0, err
 	}
 
 	return count, nil
 }
 
-func (t TransactionLogPostgres) Create(transactionLog model.TransactionLog) (int32, error) {
-	query := "INSERT INTO transaction_log AS tl (user_id, date, amount, commentary) VALUES ($1, $2, $3, $4) RETURNING id"
+func (t TransactionLogPostgres) Create(ctx context.Context, transactionLog model.TransactionLog) (int32, error) {
+	query := "INSERT INTO transaction_log AS tl (user_id, date, amount, commentary, request_id, operation_type, " +
+		"counterparty_id, related_log_id, reversal_of, grace_ends_at, fee_of, correlation_id) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id"
 
 	var id int32
 
-	row := t.db.QueryRow(query, transactionLog.UserId, transactionLog.Date, transactionLog.Amount, transactionLog.Commentary)
+	row := executor(ctx, t.db).QueryRowxContext(ctx, query, transactionLog.UserId, transactionLog.Date,
+		transactionLog.Amount, transactionLog.Commentary, transactionLog.RequestId, transactionLog.OperationType,
+		transactionLog.CounterpartyId, transactionLog.RelatedLogId, transactionLog.ReversalOf,
+		transactionLog.GraceEndsAt, transactionLog.FeeOf, transactionLog.CorrelationId)
 
 	if err := row.Scan(&id); err != nil {
-		t.logger.Printf("error in db while trying to create transaction log info for user %v, error: %s",
-			transactionLog.UserId, err.Error())
+		t.logger.WithContext(ctx).WithField("user_id", transactionLog.UserId).
+			Errorf("error in db while trying to create transaction log info for user, error: %s", err.Error())
 		return 0, err
 	}
+	audit.FromContext(ctx).AddTransactionLog(id)
 
 	return id, nil
 }
+
+func (t TransactionLogPostgres) GetById(ctx context.Context, id int32) (model.TransactionLog, error) {
+	return t.get(ctx, "SELECT "+transactionLogColumns+" FROM transaction_log AS tl WHERE tl.id = $1", id)
+}
+
+// GetByIdForUpdate returns the entry locked until the end of the current transaction.
+func (t TransactionLogPostgres) GetByIdForUpdate(ctx context.Context, id int32) (model.TransactionLog, error) {
+	return t.get(ctx, "SELECT "+transactionLogColumns+" FROM transaction_log AS tl WHERE tl.id = $1 FOR UPDATE", id)
+}
+
+// GetByRelatedLogId returns the entry linked to the entry with the given id, that is the incoming
+// entry of a transfer given its outgoing one.
+func (t TransactionLogPostgres) GetByRelatedLogId(ctx context.Context, relatedLogId int32) (
+	model.TransactionLog, error) {
+	return t.get(ctx, "SELECT "+transactionLogColumns+" FROM transaction_log AS tl WHERE tl.related_log_id = $1",
+		relatedLogId)
+}
+
+func (t TransactionLogPostgres) get(ctx context.Context, query string, id int32) (model.TransactionLog, error) {
+	var transactionLog model.TransactionLog
+
+	err := sqlx.GetContext(ctx, executor(ctx, t.db), &transactionLog, query, id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		t.logger.WithContext(ctx).WithField("transaction_log_id", id).
+			Errorf("error in db while trying to get transaction log, error: %s", err.Error())
+	}
+
+	return transactionLog, err
+}
+
+// AddReversedAmount adds amount to the reversed amount of an entry and updates its reversal status.
+func (t TransactionLogPostgres) AddReversedAmount(ctx context.Context, id int32, amount float64) (
+	model.TransactionLog, error) {
+	query := "UPDATE transaction_log AS tl SET reversed_amount = tl.reversed_amount + $1, " +
+		"reversal_status = CASE WHEN tl.reversed_amount + $1 >= tl.amount THEN '" + model.ReversalStatusReversed +
+		"' ELSE '" + model.ReversalStatusPartial + "' END WHERE tl.id = $2 RETURNING " + transactionLogColumns
+
+	var transactionLog model.TransactionLog
+
+	err := sqlx.GetContext(ctx, executor(ctx, t.db), &transactionLog, query, amount, id)
+	if err != nil {
+		t.logger.WithContext(ctx).WithField("transaction_log_id", id).
+			Errorf("error in db while trying to update reversed amount of transaction log, error: %s", err.Error())
+		return model.TransactionLog{}, err
+	}
+
+	return transactionLog, nil
+}
+
+// SumOutgoingSince sums the debits and outgoing transfers, split ones and escrow fundings included, of a user
+// logged since the given time.
+func (t TransactionLogPostgres) SumOutgoingSince(ctx context.Context, userId uuid.UUID, since time.Time) (
+	float64, error) {
+	query := "SELECT COALESCE(SUM(tl.amount), 0) FROM transaction_log AS tl WHERE tl.user_id = $1 " +
+		"AND tl.operation_type IN ($2, $3, $4, $5) AND tl.date >= $6"
+
+	var sum float64
+
+	err := sqlx.GetContext(ctx, executor(ctx, t.db), &sum, query, userId, model.OperationDebit,
+		model.OperationTransferOut, model.OperationSplitOut, model.OperationEscrowFund, since)
+	if err != nil {
+		t.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to sum outgoing amounts of user, error: %s", err.Error())
+		return 0, err
+	}
+
+	return sum, nil
+}
+
+// CountTransfersSince counts the outgoing transfers of a user logged after the given time, a split transfer
+// counting once and an escrow funding as a transfer, and returns the time of the first of them.
+func (t TransactionLogPostgres) CountTransfersSince(ctx context.Context, userId uuid.UUID, since time.Time) (
+	int, *time.Time, error) {
+	query := "SELECT COUNT(*), MIN(tl.date) FROM transaction_log AS tl WHERE tl.user_id = $1 " +
+		"AND tl.operation_type IN ($2, $3, $4) AND tl.date > $5"
+
+	var count int
+	var first *time.Time
+
+	row := executor(ctx, t.db).QueryRowxContext(ctx, query, userId, model.OperationTransferOut,
+		model.OperationSplitOut, model.OperationEscrowFund, since)
+	if err := row.Scan(&count, &first); err != nil {
+		t.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to count transfers of user, error: %s", err.Error())
+		return 0, nil, err
+	}
+
+	return count, first, nil
+}
+
+// SumChangesBetween sums the balance changes of a user logged after from and up to to, credits count as
+// positive amounts and every other operation as a negative one. A zero from sums from the first entry.
+func (t TransactionLogPostgres) SumChangesBetween(ctx context.Context, userId uuid.UUID, from time.Time,
+	to time.Time) (float64, error) {
+	query := "SELECT COALESCE(SUM(" + signedAmount + "), 0) FROM transaction_log AS tl " +
+		"WHERE tl.user_id = $1 AND tl.date > $2 AND tl.date <= $3"
+
+	var sum float64
+
+	err := sqlx.GetContext(ctx, executor(ctx, t.db), &sum, query, userId, from, to)
+	if err != nil {
+		t.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to sum balance changes of user, error: %s", err.Error())
+		return 0, err
+	}
+
+	return sum, nil
+}
@@ -1,8 +1,7 @@
 package repository
 
 import (
-	"log"
-	"os"
+	"context"
 	"testing"
 	"time"
 
@@ -10,13 +9,18 @@ import (
 
 	"github.com/google/uuid"
 
+	"github.com/Feokrat/user-balance-api/internal/logger"
 	"github.com/Feokrat/user-balance-api/internal/model"
 
 	sqlxmock "github.com/zhashkevych/go-sqlxmock"
 )
 
+var transactionLogTestColumns = []string{"id", "user_id", "date", "amount", "commentary", "request_id",
+	"operation_type", "counterparty_id", "related_log_id", "reversal_of", "reversed_amount", "reversal_status",
+	"grace_ends_at", "fee_of", "correlation_id"}
+
 func TestTransactionLogPostgres_Create(t *testing.T) {
-	logger := log.New(os.Stdout, "logger: ", log.Lshortfile)
+	log := logger.NewDefault()
 
 	db, mock, err := sqlxmock.Newx()
 	if err != nil {
@@ -24,7 +28,7 @@ func TestTransactionLogPostgres_Create(t *testing.T) {
 	}
 	defer db.Close()
 
-	r := NewTransactionLogPostgres(db, logger)
+	r := NewTransactionLogPostgres(db, log)
 
 	type args struct {
 		transactionLog model.TransactionLog
@@ -44,16 +48,20 @@ func TestTransactionLogPostgres_Create(t *testing.T) {
 		{
 			name: "Ok",
 			input: args{transactionLog: model.TransactionLog{
-				UserId:     testUserId,
-				Date:       time.Now(),
-				Amount:     100,
-				Commentary: "Test 100",
+				UserId:        testUserId,
+				Date:          time.Now(),
+				Amount:        100,
+				Commentary:    "Test 100",
+				OperationType: model.OperationCredit,
 			}},
 			mock: func(args args) {
 				transactionLog := args.transactionLog
 				rows := sqlxmock.NewRows([]string{"id"}).AddRow(1)
 				mock.ExpectQuery("INSERT INTO transaction_log").
-					WithArgs(transactionLog.UserId, transactionLog.Date, transactionLog.Amount, transactionLog.Commentary).
+					WithArgs(transactionLog.UserId, transactionLog.Date, transactionLog.Amount, transactionLog.Commentary,
+						transactionLog.RequestId, transactionLog.OperationType, transactionLog.CounterpartyId,
+						transactionLog.RelatedLogId, transactionLog.ReversalOf, transactionLog.GraceEndsAt,
+						transactionLog.FeeOf, transactionLog.CorrelationId).
 					WillReturnRows(rows)
 			},
 			expectedOut: 1,
@@ -65,7 +73,7 @@ func TestTransactionLogPostgres_Create(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			test.mock(test.input)
 
-			got, err := r.Create(test.input.transactionLog)
+			got, err := r.Create(context.Background(), test.input.transactionLog)
 			if test.expectedErr {
 				assert.Error(t, err)
 			} else {
@@ -77,7 +85,7 @@ func TestTransactionLogPostgres_Create(t *testing.T) {
 }
 
 func TestTransactionLogPostgres_GetAllByUserId(t *testing.T) {
-	logger := log.New(os.Stdout, "logger: ", log.Lshortfile)
+	log := logger.NewDefault()
 
 	db, mock, err := sqlxmock.Newx(sqlxmock.QueryMatcherOption(sqlxmock.QueryMatcherEqual))
 	if err != nil {
@@ -85,11 +93,11 @@ func TestTransactionLogPostgres_GetAllByUserId(t *testing.T) {
 	}
 	defer db.Close()
 
-	r := NewTransactionLogPostgres(db, logger)
+	r := NewTransactionLogPostgres(db, log)
 
 	type args struct {
-		userId uuid.UUID
-		pageNum int
+		userId   uuid.UUID
+		pageNum  int
 		pageSize int
 	}
 
@@ -106,49 +114,54 @@ func TestTransactionLogPostgres_GetAllByUserId(t *testing.T) {
 		expectedErr bool
 	}{
 		{
-			name:  "Ok",
+			name: "Ok",
 			input: args{
 				userId:   userId,
 				pageNum:  1,
 				pageSize: 100,
 			},
 			mock: func(args args) {
-				rows := sqlxmock.NewRows([]string{"id", "user_id", "date", "amount", "commentary"}).
-					AddRow(1, userId, time, 100, "TEST1").
-					AddRow(2, userId, time, 200, "TEST2")
+				rows := sqlxmock.NewRows(transactionLogTestColumns).
+					AddRow(1, userId, time, 100, "TEST1", "", model.OperationCredit, nil, nil, nil, 0, "", nil, nil, nil).
+					AddRow(2, userId, time, 200, "TEST2", "", model.OperationDebit, nil, nil, nil, 50,
+						model.ReversalStatusPartial, nil, nil, nil)
 
-				mock.ExpectQuery("SELECT tl.id, tl.user_id, tl.date, tl.amount, tl.commentary FROM transaction_log AS tl WHERE tl.user_id = $1 ORDER BY date LIMIT $2 OFFSET $3").
+				mock.ExpectQuery("SELECT tl.id, tl.user_id, tl.date, tl.amount, tl.commentary, tl.request_id, tl.operation_type, tl.counterparty_id, tl.related_log_id, tl.reversal_of, tl.reversed_amount, tl.reversal_status, tl.grace_ends_at, tl.fee_of, tl.correlation_id FROM transaction_log AS tl WHERE tl.user_id = $1 ORDER BY date LIMIT $2 OFFSET $3").
 					WithArgs(args.userId, args.pageSize, args.pageNum*args.pageSize).WillReturnRows(rows)
 			},
 			expectedOut: []model.TransactionLog{
 				{
-					Id:         1,
-					UserId:     userId,
-					Date:       time,
-					Amount:     100,
-					Commentary: "TEST1",
+					Id:            1,
+					UserId:        userId,
+					Date:          time,
+					Amount:        100,
+					Commentary:    "TEST1",
+					OperationType: model.OperationCredit,
 				},
 				{
-					Id:         2,
-					UserId:     userId,
-					Date:       time,
-					Amount:     200,
-					Commentary: "TEST2",
+					Id:             2,
+					UserId:         userId,
+					Date:           time,
+					Amount:         200,
+					Commentary:     "TEST2",
+					OperationType:  model.OperationDebit,
+					ReversedAmount: 50,
+					ReversalStatus: model.ReversalStatusPartial,
 				},
 			},
 			expectedErr: false,
 		},
 		{
-			name:  "Empty List",
+			name: "Empty List",
 			input: args{
 				userId:   userId,
 				pageNum:  1,
 				pageSize: 100,
 			},
 			mock: func(args args) {
-				rows := sqlxmock.NewRows([]string{"id", "user_id", "date", "amount", "commentary"})
+				rows := sqlxmock.NewRows(transactionLogTestColumns)
 
-				mock.ExpectQuery("SELECT tl.id, tl.user_id, tl.date, tl.amount, tl.commentary FROM transaction_log AS tl WHERE tl.user_id = $1 ORDER BY date LIMIT $2 OFFSET $3").
+				mock.ExpectQuery("SELECT tl.id, tl.user_id, tl.date, tl.amount, tl.commentary, tl.request_id, tl.operation_type, tl.counterparty_id, tl.related_log_id, tl.reversal_of, tl.reversed_amount, tl.reversal_status, tl.grace_ends_at, tl.fee_of, tl.correlation_id FROM transaction_log AS tl WHERE tl.user_id = $1 ORDER BY date LIMIT $2 OFFSET $3").
 					WithArgs(args.userId, args.pageSize, args.pageNum*args.pageSize).WillReturnRows(rows)
 			},
 			expectedOut: nil,
@@ -160,7 +173,7 @@ func TestTransactionLogPostgres_GetAllByUserId(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			test.mock(test.input)
 
-			got, err := r.GetAllByUserId(test.input.userId, "date", 1, 100)
+			got, err := r.GetAllByUserId(context.Background(), test.input.userId, "date", 1, 100)
 			if test.expectedErr {
 				assert.Error(t, err)
 			} else {
@@ -169,4 +182,82 @@ func TestTransactionLogPostgres_GetAllByUserId(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestTransactionLogPostgres_GetByIdForUpdate(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewTransactionLogPostgres(db, log)
+
+	userId, counterpartyId := uuid.New(), uuid.New()
+	rows := sqlxmock.NewRows(transactionLogTestColumns).
+		AddRow(3, userId, time.Now(), 100, "Sended 100 rubles", "", model.OperationTransferOut, counterpartyId,
+			nil, nil, 0, "", nil, nil, nil)
+	mock.ExpectQuery("SELECT (.+) FROM transaction_log AS tl WHERE tl.id = \\$1 FOR UPDATE").
+		WithArgs(int32(3)).WillReturnRows(rows)
+
+	got, err := r.GetByIdForUpdate(context.Background(), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), got.Id)
+	assert.Equal(t, model.OperationTransferOut, got.OperationType)
+	assert.Equal(t, counterpartyId, *got.CounterpartyId)
+	assert.Nil(t, got.RelatedLogId)
+}
+
+func TestTransactionLogPostgres_AddReversedAmount(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewTransactionLogPostgres(db, log)
+
+	rows := sqlxmock.NewRows(transactionLogTestColumns).
+		AddRow(3, uuid.New(), time.Now(), 100, "Added 100 rubles", "", model.OperationCredit, nil, nil, nil, 100,
+			model.ReversalStatusReversed, nil, nil, nil)
+	mock.ExpectQuery("UPDATE transaction_log AS tl SET reversed_amount = tl.reversed_amount \\+ \\$1, (.+) "+
+		"WHERE tl.id = \\$2 RETURNING").
+		WithArgs(40.0, int32(3)).WillReturnRows(rows)
+
+	got, err := r.AddReversedAmount(context.Background(), 3, 40)
+	assert.NoError(t, err)
+	assert.Equal(t, 100.0, got.ReversedAmount)
+	assert.Equal(t, model.ReversalStatusReversed, got.ReversalStatus)
+}
+
+func TestTransactionLogPostgres_SumChangesBetween(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx(sqlxmock.QueryMatcherOption(sqlxmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewTransactionLogPostgres(db, log)
+
+	userId := uuid.New()
+	from := time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC)
+	to := from.Add(5 * time.Hour)
+
+	mock.ExpectQuery("SELECT COALESCE(SUM(CASE WHEN tl.operation_type IN ('credit', 'transfer_in', 'reversal_credit', "+
+		"'correction_credit', 'fee_revenue', 'bonus_credit', 'split_in', 'escrow_hold', 'escrow_release', 'escrow_refund') THEN tl.amount ELSE -tl.amount END), 0) "+
+		"FROM transaction_log AS tl "+
+		"WHERE tl.user_id = $1 AND tl.date > $2 AND tl.date <= $3").
+		WithArgs(userId, from, to).
+		WillReturnRows(sqlxmock.NewRows([]string{"coalesce"}).AddRow(-42.5))
+
+	got, err := r.SumChangesBetween(context.Background(), userId, from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, -42.5, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
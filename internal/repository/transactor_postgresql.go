@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/Feokrat/user-balance-api/internal/audit"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/jmoiron/sqlx"
+)
+
+type txKey struct{}
+
+// savepointName is reused by nested savepoints, each of them is released or rolled back before the
+// savepoint around it and names the latest one.
+const savepointName = "nested"
+
+type TransactorPostgres struct {
+	db     *sqlx.DB
+	logger logger.Logger
+}
+
+func NewTransactorPostgres(db *sqlx.DB, logger logger.Logger) *TransactorPostgres {
+	return &TransactorPostgres{
+		db:     db,
+		logger: logger}
+}
+
+// WithinTransaction runs fn in a database transaction carried by the context passed to fn, so every
+// repository called with that context takes part in it. Nested calls join the outer transaction.
+// Transaction log entries are added to the audit trail of ctx only once the transaction commits.
+func (t TransactorPostgres) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := t.db.BeginTxx(ctx, nil)
+	if err != nil {
+		t.logger.WithContext(ctx).Errorf("could not begin transaction, error: %s", err.Error())
+		return err
+	}
+
+	txCtx := context.WithValue(ctx, txKey{}, tx)
+	trail := audit.FromContext(ctx).Pending()
+	if trail != nil {
+		txCtx = audit.ContextWithTrail(txCtx, trail)
+	}
+
+	if err := fn(txCtx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			t.logger.WithContext(ctx).Errorf("could not rollback transaction, error: %s", rbErr.Error())
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.logger.WithContext(ctx).Errorf("could not commit transaction, error: %s", err.Error())
+		return err
+	}
+	trail.Commit()
+
+	return nil
+}
+
+// WithinSavepoint runs fn like WithinTransaction, but within the transaction of ctx, when there is one, fn runs
+// in a savepoint: whatever fn wrote is rolled back when it fails and the outer transaction goes on.
+func (t TransactorPostgres) WithinSavepoint(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx, ok := ctx.Value(txKey{}).(*sqlx.Tx)
+	if !ok {
+		return t.WithinTransaction(ctx, fn)
+	}
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+savepointName); err != nil {
+		t.logger.WithContext(ctx).Errorf("could not create savepoint, error: %s", err.Error())
+		return err
+	}
+
+	spCtx := ctx
+	trail := audit.FromContext(ctx).Pending()
+	if trail != nil {
+		spCtx = audit.ContextWithTrail(ctx, trail)
+	}
+
+	if err := fn(spCtx); err != nil {
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepointName); rbErr != nil {
+			t.logger.WithContext(ctx).Errorf("could not rollback to savepoint, error: %s", rbErr.Error())
+			return rbErr
+		}
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+savepointName); err != nil {
+		t.logger.WithContext(ctx).Errorf("could not release savepoint, error: %s", err.Error())
+		return err
+	}
+	trail.Commit()
+
+	return nil
+}
+
+// TryAdvisoryLock takes a transaction scoped advisory lock, it must be called within a transaction.
+// It reports false when another transaction holds the lock.
+func (t TransactorPostgres) TryAdvisoryLock(ctx context.Context, key int64) (bool, error) {
+	var locked bool
+
+	err := sqlx.GetContext(ctx, executor(ctx, t.db), &locked, "SELECT pg_try_advisory_xact_lock($1)", key)
+	if err != nil {
+		t.logger.WithContext(ctx).Errorf("could not take advisory lock %v, error: %s", key, err.Error())
+		return false, err
+	}
+
+	return locked, nil
+}
+
+// executor returns the transaction bound to ctx or db when there is none.
+func executor(ctx context.Context, db *sqlx.DB) sqlx.ExtContext {
+	if tx, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return tx
+	}
+
+	return db
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Feokrat/user-balance-api/internal/audit"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestTransactorPostgres_WithinTransaction(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	transactor := NewTransactorPostgres(db, log)
+	balances := NewUserBalancePostgres(db, log)
+	userId := uuid.New()
+
+	t.Run("Commit", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("UPDATE user_balance").WithArgs(10.0, userId).
+			WillReturnRows(sqlxmock.NewRows([]string{"balance"}).AddRow(10))
+		mock.ExpectCommit()
+
+		err := transactor.WithinTransaction(context.Background(), func(ctx context.Context) error {
+			return transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+				_, err := balances.UpdateByUserId(ctx, userId, 10)
+				return err
+			})
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		fnErr := errors.New("not enough funds")
+		err := transactor.WithinTransaction(context.Background(), func(ctx context.Context) error {
+			return fnErr
+		})
+		assert.Equal(t, fnErr, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("Savepoint", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT nested").WillReturnResult(sqlxmock.NewResult(0, 0))
+		mock.ExpectQuery("UPDATE user_balance").WithArgs(-10.0, userId).
+			WillReturnRows(sqlxmock.NewRows([]string{"balance"}).AddRow(-10))
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT nested").WillReturnResult(sqlxmock.NewResult(0, 0))
+		mock.ExpectExec("SAVEPOINT nested").WillReturnResult(sqlxmock.NewResult(0, 0))
+		mock.ExpectQuery("UPDATE user_balance").WithArgs(10.0, userId).
+			WillReturnRows(sqlxmock.NewRows([]string{"balance"}).AddRow(10))
+		mock.ExpectExec("RELEASE SAVEPOINT nested").WillReturnResult(sqlxmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		fnErr := errors.New("not enough funds")
+		err := transactor.WithinTransaction(context.Background(), func(ctx context.Context) error {
+			err := transactor.WithinSavepoint(ctx, func(ctx context.Context) error {
+				if _, err := balances.UpdateByUserId(ctx, userId, -10); err != nil {
+					return err
+				}
+				return fnErr
+			})
+			assert.Equal(t, fnErr, err)
+
+			return transactor.WithinSavepoint(ctx, func(ctx context.Context) error {
+				_, err := balances.UpdateByUserId(ctx, userId, 10)
+				return err
+			})
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("AuditTrail", func(t *testing.T) {
+		logs := NewTransactionLogPostgres(db, log)
+		trail := audit.NewTrail()
+		ctx := audit.ContextWithTrail(context.Background(), trail)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO transaction_log").
+			WillReturnRows(sqlxmock.NewRows([]string{"id"}).AddRow(7))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO transaction_log").
+			WillReturnRows(sqlxmock.NewRows([]string{"id"}).AddRow(8))
+		mock.ExpectRollback()
+
+		err := transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			_, err := logs.Create(ctx, model.TransactionLog{UserId: userId, Amount: 10})
+			return err
+		})
+		assert.NoError(t, err)
+
+		fnErr := errors.New("limit exceeded")
+		err = transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			if _, err := logs.Create(ctx, model.TransactionLog{UserId: userId, Amount: 10}); err != nil {
+				return err
+			}
+			return fnErr
+		})
+		assert.Equal(t, fnErr, err)
+		assert.Equal(t, []int32{7}, trail.TransactionLogIds())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
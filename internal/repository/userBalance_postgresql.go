@@ -1,78 +1,290 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"fmt"
+	"strings"
 
+	"github.com/Feokrat/user-balance-api/internal/logger"
 	"github.com/Feokrat/user-balance-api/internal/model"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
+const userBalanceColumns = "ub.user_id, ub.balance, ub.status, ub.currency, ub.metadata, ub.created_at, " +
+	"ub.overdraft_limit, ub.overdrawn_since, ub.held"
+
 type UserBalancePostgres struct {
 	db     *sqlx.DB
-	logger *log.Logger
+	logger logger.Logger
 }
 
-func NewUserBalancePostgres(db *sqlx.DB, logger *log.Logger) *UserBalancePostgres {
+func NewUserBalancePostgres(db *sqlx.DB, logger logger.Logger) *UserBalancePostgres {
 	return &UserBalancePostgres{
 		db:     db,
 		logger: logger}
 }
 
-func (r UserBalancePostgres) GetByUserId(userId uuid.UUID) (model.UserBalance, error) {
-	query := "SELECT ub.user_id, ub.balance FROM user_balance AS ub WHERE ub.user_id = $1"
+func (r UserBalancePostgres) GetByUserId(ctx context.Context, userId uuid.UUID) (model.UserBalance, error) {
+	query := "SELECT " + userBalanceColumns + " FROM user_balance AS ub WHERE ub.user_id = $1"
 
 	var userBalance model.UserBalance
 
-	err := r.db.Get(&userBalance, query, userId)
+	err := sqlx.GetContext(ctx, executor(ctx, r.db), &userBalance, query, userId)
+
+	if err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to get user balance of user, error: %s", err)
+		return model.UserBalance{}, err
+	}
+
+	return userBalance, nil
+}
+
+// GetByUserIdForUpdate locks the user balance row until the end of the transaction bound to ctx.
+func (r UserBalancePostgres) GetByUserIdForUpdate(ctx context.Context, userId uuid.UUID) (model.UserBalance, error) {
+	query := "SELECT " + userBalanceColumns + " FROM user_balance AS ub WHERE ub.user_id = $1 FOR UPDATE"
+
+	var userBalance model.UserBalance
 
+	err := sqlx.GetContext(ctx, executor(ctx, r.db), &userBalance, query, userId)
 	if err != nil {
-		r.logger.Printf("error in db while trying to get user balance of user %v, error: %s",
-			userId, userId)
+		r.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to lock user balance of user, error: %s", err)
 		return model.UserBalance{}, err
 	}
 
 	return userBalance, nil
 }
 
-func (r UserBalancePostgres) UpdateByUserId(userId uuid.UUID, changeAmount float64) error {
-	query := "UPDATE user_balance ub SET balance = balance + $1 WHERE user_id = $2"
-	_, err := r.db.Exec(query, changeAmount, userId)
-	return err
+// UpdateByUserId changes the balance of a user and keeps track of when it went below zero.
+func (r UserBalancePostgres) UpdateByUserId(ctx context.Context, userId uuid.UUID, changeAmount float64) (
+	float64, error) {
+	query := "UPDATE user_balance ub SET balance = balance + $1, overdrawn_since = CASE WHEN balance + $1 >= 0 " +
+		"THEN NULL ELSE COALESCE(overdrawn_since, now()) END WHERE user_id = $2 RETURNING balance"
+
+	var balance float64
+
+	row := executor(ctx, r.db).QueryRowxContext(ctx, query, changeAmount, userId)
+	if err := row.Scan(&balance); err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to update user balance of user, error: %s", err)
+		return 0, err
+	}
+
+	return balance, nil
 }
 
-func (r UserBalancePostgres) CheckIfExistsByUserId(userId uuid.UUID) (bool, error) {
+func (r UserBalancePostgres) CheckIfExistsByUserId(ctx context.Context, userId uuid.UUID) (bool, error) {
 	query := "SELECT ub.user_id, ub.balance FROM user_balance AS ub WHERE ub.user_id = $1"
 
 	var UserBalance model.UserBalance
 
-	err := r.db.Get(&UserBalance, query, userId)
+	err := sqlx.GetContext(ctx, executor(ctx, r.db), &UserBalance, query, userId)
 	if err == sql.ErrNoRows {
-		r.logger.Printf("could not find user balance of user %v in db",
-			userId)
+		r.logger.WithContext(ctx).WithField("user_id", userId).
+			Debugf("could not find user balance of user in db")
 		return false, nil
 	} else if err != nil {
-		r.logger.Printf("error in db while trying to check if user %v exists, error: %s",
-			userId, err.Error())
+		r.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to check if user exists, error: %s", err.Error())
 		return false, err
 	}
 
 	return true, nil
 }
 
-func (r UserBalancePostgres) Create(UserBalance model.UserBalance) error {
-	query := "INSERT INTO user_balance AS ub (user_id, balance) VALUES ($1, $2) RETURNING user_id"
+func (r UserBalancePostgres) Create(ctx context.Context, UserBalance model.UserBalance) error {
+	query := "INSERT INTO user_balance AS ub (user_id, balance, currency, metadata, created_at) " +
+		"VALUES ($1, $2, $3, $4, $5) RETURNING user_id"
 
 	var userId uuid.UUID
 
-	row := r.db.QueryRow(query, UserBalance.UserId, UserBalance.Balance)
+	row := executor(ctx, r.db).QueryRowxContext(ctx, query, UserBalance.UserId, UserBalance.Balance,
+		UserBalance.Currency, UserBalance.Metadata, UserBalance.CreatedAt)
 
 	if err := row.Scan(&userId); err != nil {
-		r.logger.Printf("error in db while trying to create user balance info of user %v, error: %s",
-			userId, err.Error())
+		r.logger.WithContext(ctx).WithField("user_id", UserBalance.UserId).
+			Errorf("error in db while trying to create user balance info of user, error: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// UpdateHeldByUserId changes the funds of a user held by pending transfers by changeAmount and returns
+// what it holds now.
+func (r UserBalancePostgres) UpdateHeldByUserId(ctx context.Context, userId uuid.UUID, changeAmount float64) (
+	float64, error) {
+	query := "UPDATE user_balance ub SET held = held + $1 WHERE user_id = $2 RETURNING held"
+
+	var held float64
+
+	row := executor(ctx, r.db).QueryRowxContext(ctx, query, changeAmount, userId)
+	if err := row.Scan(&held); err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to update held funds of user balance, error: %s", err.Error())
+		return 0, err
+	}
+
+	return held, nil
+}
+
+func (r UserBalancePostgres) UpdateStatusByUserId(ctx context.Context, userId uuid.UUID, status string) error {
+	query := "UPDATE user_balance ub SET status = $1 WHERE user_id = $2"
+
+	if _, err := executor(ctx, r.db).ExecContext(ctx, query, status, userId); err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to update status of user balance, error: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (r UserBalancePostgres) UpdateOverdraftLimitByUserId(ctx context.Context, userId uuid.UUID,
+	overdraftLimit float64) error {
+	query := "UPDATE user_balance ub SET overdraft_limit = $1 WHERE user_id = $2"
+
+	if _, err := executor(ctx, r.db).ExecContext(ctx, query, overdraftLimit, userId); err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to update overdraft limit of user balance, error: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (r UserBalancePostgres) CreateStatusChange(ctx context.Context, change model.AccountStatusChange) error {
+	query := "INSERT INTO account_status_change (user_id, from_status, to_status, reason, operator_id, changed_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6)"
+
+	_, err := executor(ctx, r.db).ExecContext(ctx, query, change.UserId, change.FromStatus, change.ToStatus,
+		change.Reason, change.OperatorId, change.ChangedAt)
+	if err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", change.UserId).
+			Errorf("error in db while trying to create account status change, error: %s", err.Error())
 		return err
 	}
 
 	return nil
 }
+
+// GetStatusChanges returns the status history of an account, newest first.
+func (r UserBalancePostgres) GetStatusChanges(ctx context.Context, userId uuid.UUID, pageNum int, pageSize int) (
+	[]model.AccountStatusChange, error) {
+	query := "SELECT sc.id, sc.user_id, sc.from_status, sc.to_status, sc.reason, sc.operator_id, sc.changed_at " +
+		"FROM account_status_change AS sc WHERE sc.user_id = $1 ORDER BY sc.changed_at DESC, sc.id DESC " +
+		"LIMIT $2 OFFSET $3"
+
+	var changes []model.AccountStatusChange
+
+	err := sqlx.SelectContext(ctx, executor(ctx, r.db), &changes, query, userId, pageSize, pageNum*pageSize)
+	if err != nil {
+		r.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("error in db while trying to get status history of user balance, error: %s", err.Error())
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// accountSortColumns maps the sort fields of account searches to their columns.
+var accountSortColumns = map[string]string{
+	model.AccountSortBalance:   "ub.balance",
+	model.AccountSortCreatedAt: "ub.created_at",
+}
+
+// Search returns a page of the accounts matching the query, starting after its cursor.
+func (r UserBalancePostgres) Search(ctx context.Context, query model.AccountQuery) ([]model.UserBalance, error) {
+	column, ok := accountSortColumns[query.SortField]
+	if !ok {
+		return nil, fmt.Errorf("unknown account sort field %q", query.SortField)
+	}
+	direction, comparison := "ASC", ">"
+	if query.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	conditions, args := accountConditions(query.Filter)
+	if query.After != nil {
+		var value interface{} = query.After.CreatedAt
+		if query.SortField == model.AccountSortBalance {
+			value = query.After.Balance
+		}
+		args = append(args, value, query.After.UserId)
+		conditions = append(conditions, fmt.Sprintf("(%s, ub.user_id) %s ($%d, $%d)", column, comparison,
+			len(args)-1, len(args)))
+	}
+	args = append(args, query.Limit)
+
+	sqlQuery := fmt.Sprintf("SELECT %s FROM user_balance AS ub%s ORDER BY %s %s, ub.user_id %s LIMIT $%d",
+		userBalanceColumns, whereClause(conditions), column, direction, direction, len(args))
+
+	var accounts []model.UserBalance
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, r.db), &accounts, sqlQuery, args...); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to search accounts, error: %s", err.Error())
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// Totals aggregates the accounts matching filter by currency.
+func (r UserBalancePostgres) Totals(ctx context.Context, filter model.AccountFilter) (
+	[]model.AccountTotals, error) {
+	conditions, args := accountConditions(filter)
+	query := "SELECT ub.currency, COUNT(*) AS count, SUM(ub.balance) AS total_balance, " +
+		"MIN(ub.balance) AS min_balance, MAX(ub.balance) AS max_balance FROM user_balance AS ub" +
+		whereClause(conditions) + " GROUP BY ub.currency ORDER BY ub.currency"
+
+	var totals []model.AccountTotals
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, r.db), &totals, query, args...); err != nil {
+		r.logger.WithContext(ctx).Errorf("error in db while trying to aggregate accounts, error: %s", err.Error())
+		return nil, err
+	}
+
+	return totals, nil
+}
+
+func accountConditions(filter model.AccountFilter) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.MinBalance != nil {
+		add("ub.balance >= $%d", *filter.MinBalance)
+	}
+	if filter.MaxBalance != nil {
+		add("ub.balance <= $%d", *filter.MaxBalance)
+	}
+	if filter.Status != "" {
+		add("ub.status = $%d", filter.Status)
+	}
+	if filter.Currency != "" {
+		add("ub.currency = $%d", filter.Currency)
+	}
+	if filter.CreatedFrom != nil {
+		add("ub.created_at >= $%d", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		add("ub.created_at < $%d", *filter.CreatedTo)
+	}
+
+	return conditions, args
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
@@ -1,18 +1,19 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"github.com/Feokrat/user-balance-api/internal/logger"
 	"github.com/Feokrat/user-balance-api/internal/model"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	sqlxmock "github.com/zhashkevych/go-sqlxmock"
-	"log"
-	"os"
 	"testing"
+	"time"
 )
 
 func TestUserBalancePostgres_Create(t *testing.T) {
-	logger := log.New(os.Stdout, "logger: ", log.Lshortfile)
+	log := logger.NewDefault()
 
 	db, mock, err := sqlxmock.Newx()
 	if err != nil {
@@ -20,7 +21,7 @@ func TestUserBalancePostgres_Create(t *testing.T) {
 	}
 	defer db.Close()
 
-	r := NewUserBalancePostgres(db, logger)
+	r := NewUserBalancePostgres(db, log)
 
 	type args struct {
 		userBalance model.UserBalance
@@ -42,12 +43,16 @@ func TestUserBalancePostgres_Create(t *testing.T) {
 				userBalance := args.userBalance
 				rows := sqlxmock.NewRows([]string{"user_id"}).AddRow(testUserId)
 				mock.ExpectQuery("INSERT INTO user_balance").
-					WithArgs(userBalance.UserId, userBalance.Balance).
+					WithArgs(userBalance.UserId, userBalance.Balance, userBalance.Currency, userBalance.Metadata,
+						userBalance.CreatedAt).
 					WillReturnRows(rows)
 			},
 			input: args{userBalance: model.UserBalance{
-				UserId:  testUserId,
-				Balance: 100,
+				UserId:    testUserId,
+				Balance:   100,
+				Currency:  "RUB",
+				Metadata:  model.AccountMetadata{"segment": "b2b"},
+				CreatedAt: time.Now(),
 			}},
 			expectedErr: false,
 		},
@@ -57,7 +62,7 @@ func TestUserBalancePostgres_Create(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			test.mock(test.input)
 
-			err := r.Create(test.input.userBalance)
+			err := r.Create(context.Background(), test.input.userBalance)
 			if test.expectedErr {
 				assert.Error(t, err)
 			} else {
@@ -68,7 +73,7 @@ func TestUserBalancePostgres_Create(t *testing.T) {
 }
 
 func TestUserBalancePostgres_GetByUserId(t *testing.T) {
-	logger := log.New(os.Stdout, "logger: ", log.Lshortfile)
+	log := logger.NewDefault()
 
 	db, mock, err := sqlxmock.Newx(sqlxmock.QueryMatcherOption(sqlxmock.QueryMatcherEqual))
 	if err != nil {
@@ -76,13 +81,15 @@ func TestUserBalancePostgres_GetByUserId(t *testing.T) {
 	}
 	defer db.Close()
 
-	r := NewUserBalancePostgres(db, logger)
+	r := NewUserBalancePostgres(db, log)
 
 	type args struct {
 		userId uuid.UUID
 	}
 
 	testUserId := uuid.New()
+	createdAt := time.Date(2021, 10, 1, 9, 0, 0, 0, time.UTC)
+	overdrawnSince := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
 
 	type mockBehavior func(args args)
 
@@ -92,37 +99,47 @@ func TestUserBalancePostgres_GetByUserId(t *testing.T) {
 		input       args
 		expectedOut model.UserBalance
 		expectedErr bool
-		err error
+		err         error
 	}{
 		{
 			name: "Ok",
 			mock: func(args args) {
-				rows := sqlxmock.NewRows([]string{"user_id", "balance"}).
-					AddRow(testUserId, 20)
+				rows := sqlxmock.NewRows([]string{"user_id", "balance", "status", "currency", "metadata", "created_at",
+					"overdraft_limit", "overdrawn_since", "held"}).
+					AddRow(testUserId, -20, model.AccountFrozen, "RUB", []byte(`{"segment":"b2b"}`), createdAt, 100,
+						overdrawnSince, 15)
 
-				mock.ExpectQuery("SELECT ub.user_id, ub.balance FROM user_balance AS ub WHERE ub.user_id = $1").
+				mock.ExpectQuery("SELECT ub.user_id, ub.balance, ub.status, ub.currency, ub.metadata, ub.created_at, ub.overdraft_limit, ub.overdrawn_since, ub.held FROM user_balance AS ub WHERE ub.user_id = $1").
 					WithArgs(args.userId).WillReturnRows(rows)
 			},
 			input: args{userId: testUserId},
 			expectedOut: model.UserBalance{
-				UserId:  testUserId,
-				Balance: 20,
+				UserId:         testUserId,
+				Balance:        -20,
+				Status:         model.AccountFrozen,
+				Currency:       "RUB",
+				Metadata:       model.AccountMetadata{"segment": "b2b"},
+				CreatedAt:      createdAt,
+				OverdraftLimit: 100,
+				OverdrawnSince: &overdrawnSince,
+				Held:           15,
 			},
 			expectedErr: false,
-			err: nil,
+			err:         nil,
 		},
 		{
 			name: "Not found",
 			mock: func(args args) {
-				rows := sqlxmock.NewRows([]string{"user_id", "balance"})
+				rows := sqlxmock.NewRows([]string{"user_id", "balance", "status", "currency", "metadata", "created_at",
+					"overdraft_limit", "overdrawn_since", "held"})
 
-				mock.ExpectQuery("SELECT ub.user_id, ub.balance FROM user_balance AS ub WHERE ub.user_id = $1").
+				mock.ExpectQuery("SELECT ub.user_id, ub.balance, ub.status, ub.currency, ub.metadata, ub.created_at, ub.overdraft_limit, ub.overdrawn_since, ub.held FROM user_balance AS ub WHERE ub.user_id = $1").
 					WithArgs(args.userId).WillReturnRows(rows)
 			},
-			input: args{userId: testUserId},
+			input:       args{userId: testUserId},
 			expectedOut: model.UserBalance{},
 			expectedErr: true,
-			err: sql.ErrNoRows,
+			err:         sql.ErrNoRows,
 		},
 	}
 
@@ -130,12 +147,12 @@ func TestUserBalancePostgres_GetByUserId(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			test.mock(test.input)
 
-			got, err := r.GetByUserId(test.input.userId)
+			got, err := r.GetByUserId(context.Background(), test.input.userId)
 			if test.expectedErr {
 				assert.Error(t, err)
 				assert.Equal(t, err, test.err)
 			} else {
-				assert.Equal(t, got.UserId, test.expectedOut.UserId)
+				assert.Equal(t, got, test.expectedOut)
 				assert.NoError(t, err)
 			}
 		})
@@ -143,5 +160,177 @@ func TestUserBalancePostgres_GetByUserId(t *testing.T) {
 }
 
 func TestUserBalancePostgres_UpdateByUserId(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx(sqlxmock.QueryMatcherOption(sqlxmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewUserBalancePostgres(db, log)
+
+	type args struct {
+		userId       uuid.UUID
+		changeAmount float64
+	}
 
-}
\ No newline at end of file
+	testUserId := uuid.New()
+
+	type mockBehavior func(args args)
+
+	tests := []struct {
+		name        string
+		mock        mockBehavior
+		input       args
+		expectedOut float64
+		expectedErr bool
+	}{
+		{
+			name: "Ok",
+			mock: func(args args) {
+				rows := sqlxmock.NewRows([]string{"balance"}).AddRow(120)
+
+				mock.ExpectQuery("UPDATE user_balance ub SET balance = balance + $1, overdrawn_since = CASE WHEN balance + $1 >= 0 THEN NULL ELSE COALESCE(overdrawn_since, now()) END WHERE user_id = $2 RETURNING balance").
+					WithArgs(args.changeAmount, args.userId).WillReturnRows(rows)
+			},
+			input:       args{userId: testUserId, changeAmount: 20},
+			expectedOut: 120,
+			expectedErr: false,
+		},
+		{
+			name: "Not found",
+			mock: func(args args) {
+				rows := sqlxmock.NewRows([]string{"balance"})
+
+				mock.ExpectQuery("UPDATE user_balance ub SET balance = balance + $1, overdrawn_since = CASE WHEN balance + $1 >= 0 THEN NULL ELSE COALESCE(overdrawn_since, now()) END WHERE user_id = $2 RETURNING balance").
+					WithArgs(args.changeAmount, args.userId).WillReturnRows(rows)
+			},
+			input:       args{userId: testUserId, changeAmount: -20},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.mock(test.input)
+
+			got, err := r.UpdateByUserId(context.Background(), test.input.userId, test.input.changeAmount)
+			if test.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedOut, got)
+			}
+		})
+	}
+}
+
+func TestUserBalancePostgres_GetStatusChanges(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewUserBalancePostgres(db, log)
+
+	userId := uuid.New()
+	changedAt := time.Now()
+	rows := sqlxmock.NewRows([]string{"id", "user_id", "from_status", "to_status", "reason", "operator_id",
+		"changed_at"}).
+		AddRow(2, userId, model.AccountFrozen, model.AccountActive, "cleared", "operator-1", changedAt).
+		AddRow(1, userId, model.AccountActive, model.AccountFrozen, "aml check", "operator-1", changedAt)
+	mock.ExpectQuery("SELECT (.+) FROM account_status_change AS sc WHERE sc.user_id = \\$1 "+
+		"ORDER BY sc.changed_at DESC, sc.id DESC LIMIT \\$2 OFFSET \\$3").
+		WithArgs(userId, 10, 10).WillReturnRows(rows)
+
+	got, err := r.GetStatusChanges(context.Background(), userId, 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, got, 2)
+	assert.Equal(t, model.AccountStatusChange{
+		Id:         2,
+		UserId:     userId,
+		FromStatus: model.AccountFrozen,
+		ToStatus:   model.AccountActive,
+		Reason:     "cleared",
+		OperatorId: "operator-1",
+		ChangedAt:  changedAt,
+	}, got[0])
+}
+
+func TestUserBalancePostgres_Search(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx(sqlxmock.QueryMatcherOption(sqlxmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewUserBalancePostgres(db, log)
+
+	columns := []string{"user_id", "balance", "status", "currency", "metadata", "created_at", "overdraft_limit",
+		"overdrawn_since", "held"}
+	minBalance := 100.0
+	createdFrom := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	after := model.AccountCursor{Balance: 500, UserId: uuid.New()}
+	userId := uuid.New()
+
+	rows := sqlxmock.NewRows(columns).AddRow(userId, 400, model.AccountActive, "RUB", []byte(`{}`), createdFrom, 0, nil,
+		0)
+	mock.ExpectQuery("SELECT ub.user_id, ub.balance, ub.status, ub.currency, ub.metadata, ub.created_at, "+
+		"ub.overdraft_limit, ub.overdrawn_since, ub.held FROM user_balance AS ub WHERE ub.balance >= $1 AND ub.status = $2 "+
+		"AND ub.created_at >= $3 AND (ub.balance, ub.user_id) < ($4, $5) "+
+		"ORDER BY ub.balance DESC, ub.user_id DESC LIMIT $6").
+		WithArgs(minBalance, model.AccountActive, createdFrom, after.Balance, after.UserId, 11).
+		WillReturnRows(rows)
+
+	got, err := r.Search(context.Background(), model.AccountQuery{
+		Filter: model.AccountFilter{
+			MinBalance:  &minBalance,
+			Status:      model.AccountActive,
+			CreatedFrom: &createdFrom,
+		},
+		SortField:  model.AccountSortBalance,
+		Descending: true,
+		After:      &after,
+		Limit:      11,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, got, 1)
+	assert.Equal(t, userId, got[0].UserId)
+	assert.Equal(t, model.AccountMetadata{}, got[0].Metadata)
+
+	_, err = r.Search(context.Background(), model.AccountQuery{SortField: "user_id; DROP TABLE user_balance"})
+	assert.Error(t, err)
+}
+
+func TestUserBalancePostgres_Totals(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx(sqlxmock.QueryMatcherOption(sqlxmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewUserBalancePostgres(db, log)
+
+	rows := sqlxmock.NewRows([]string{"currency", "count", "total_balance", "min_balance", "max_balance"}).
+		AddRow("RUB", 2, 150, 50, 100).
+		AddRow("USD", 1, 10, 10, 10)
+	mock.ExpectQuery("SELECT ub.currency, COUNT(*) AS count, SUM(ub.balance) AS total_balance, " +
+		"MIN(ub.balance) AS min_balance, MAX(ub.balance) AS max_balance FROM user_balance AS ub " +
+		"GROUP BY ub.currency ORDER BY ub.currency").
+		WillReturnRows(rows)
+
+	got, err := r.Totals(context.Background(), model.AccountFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []model.AccountTotals{
+		{Currency: "RUB", Count: 2, TotalBalance: 150, MinBalance: 50, MaxBalance: 100},
+		{Currency: "USD", Count: 1, TotalBalance: 10, MinBalance: 10, MaxBalance: 10},
+	}, got)
+}
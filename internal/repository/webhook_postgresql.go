@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	webhookSubscriptionColumns = "ws.id, ws.url, ws.event_types, ws.secret, ws.active, ws.created_at, ws.updated_at"
+	webhookDeliveryColumns     = "wd.id, wd.subscription_id, wd.event_id, wd.event_type, wd.payload, wd.status, " +
+		"wd.attempts, wd.next_attempt_at, wd.last_error, wd.created_at, wd.delivered_at"
+)
+
+type WebhookPostgres struct {
+	db     *sqlx.DB
+	logger logger.Logger
+}
+
+func NewWebhookPostgres(db *sqlx.DB, logger logger.Logger) *WebhookPostgres {
+	return &WebhookPostgres{
+		db:     db,
+		logger: logger}
+}
+
+func (w WebhookPostgres) CreateSubscription(ctx context.Context, subscription model.WebhookSubscription) error {
+	query := "INSERT INTO webhook_subscription (id, url, event_types, secret, active, created_at, updated_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7)"
+
+	_, err := executor(ctx, w.db).ExecContext(ctx, query, subscription.Id, subscription.URL,
+		subscription.EventTypes, subscription.Secret, subscription.Active, subscription.CreatedAt,
+		subscription.UpdatedAt)
+	if err != nil {
+		w.logger.WithContext(ctx).Errorf("error in db while trying to create webhook subscription, error: %s",
+			err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (w WebhookPostgres) GetSubscription(ctx context.Context, id uuid.UUID) (model.WebhookSubscription, error) {
+	query := "SELECT " + webhookSubscriptionColumns + " FROM webhook_subscription AS ws WHERE ws.id = $1"
+
+	var subscription model.WebhookSubscription
+
+	if err := sqlx.GetContext(ctx, executor(ctx, w.db), &subscription, query, id); err != nil {
+		w.logger.WithContext(ctx).Errorf("error in db while trying to get webhook subscription %v, error: %s",
+			id, err.Error())
+		return model.WebhookSubscription{}, err
+	}
+
+	return subscription, nil
+}
+
+func (w WebhookPostgres) GetAllSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error) {
+	query := "SELECT " + webhookSubscriptionColumns + " FROM webhook_subscription AS ws ORDER BY ws.created_at"
+
+	var subscriptions []model.WebhookSubscription
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, w.db), &subscriptions, query); err != nil {
+		w.logger.WithContext(ctx).Errorf("error in db while trying to get webhook subscriptions, error: %s",
+			err.Error())
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+func (w WebhookPostgres) GetActiveSubscriptionsByEventType(ctx context.Context, eventType string) (
+	[]model.WebhookSubscription, error) {
+	query := "SELECT " + webhookSubscriptionColumns + " FROM webhook_subscription AS ws " +
+		"WHERE ws.active AND (cardinality(ws.event_types) = 0 OR $1 = ANY(ws.event_types))"
+
+	var subscriptions []model.WebhookSubscription
+
+	if err := sqlx.SelectContext(ctx, executor(ctx, w.db), &subscriptions, query, eventType); err != nil {
+		w.logger.WithContext(ctx).Errorf("error in db while trying to get webhook subscriptions for %s, error: %s",
+			eventType, err.Error())
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+func (w WebhookPostgres) UpdateSubscription(ctx context.Context, subscription model.WebhookSubscription) error {
+	query := "UPDATE webhook_subscription SET url = $1, event_types = $2, active = $3, updated_at = $4 WHERE id = $5"
+
+	_, err := executor(ctx, w.db).ExecContext(ctx, query, subscription.URL, subscription.EventTypes,
+		subscription.Active, subscription.UpdatedAt, subscription.Id)
+	if err != nil {
+		w.logger.WithContext(ctx).Errorf("error in db while trying to update webhook subscription %v, error: %s",
+			subscription.Id, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (w WebhookPostgres) DeleteSubscription(ctx context.Context, id uuid.UUID) (bool, error) {
+	result, err := executor(ctx, w.db).ExecContext(ctx, "DELETE FROM webhook_subscription WHERE id = $1", id)
+	if err != nil {
+		w.logger.WithContext(ctx).Errorf("error in db while trying to delete webhook subscription %v, error: %s",
+			id, err.Error())
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return deleted > 0, nil
+}
+
+// CreateDelivery queues a delivery, an event already queued for the subscription is skipped.
+func (w WebhookPostgres) CreateDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	query := "INSERT INTO webhook_delivery (subscription_id, event_id, event_type, payload, status, " +
+		"next_attempt_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7) " +
+		"ON CONFLICT (subscription_id, event_id) DO NOTHING"
+
+	_, err := executor(ctx, w.db).ExecContext(ctx, query, delivery.SubscriptionId, delivery.EventId,
+		delivery.EventType, []byte(delivery.Payload), delivery.Status, delivery.NextAttemptAt, delivery.CreatedAt)
+	if err != nil {
+		w.logger.WithContext(ctx).Errorf("error in db while trying to create webhook delivery of event %v, error: %s",
+			delivery.EventId, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// ClaimDueDeliveries claims pending deliveries whose next attempt is due by moving their next attempt
+// to leaseUntil, skipping the ones being claimed by other instances. A claimed delivery is due again
+// once the lease is over, unless its attempt was recorded in the meantime.
+func (w WebhookPostgres) ClaimDueDeliveries(ctx context.Context, limit int, leaseUntil time.Time) (
+	[]model.WebhookDelivery, error) {
+	query := "UPDATE webhook_delivery AS wd SET next_attempt_at = $1 WHERE wd.id IN (" +
+		"SELECT d.id FROM webhook_delivery AS d WHERE d.status = $2 AND d.next_attempt_at <= now() " +
+		"ORDER BY d.next_attempt_at LIMIT $3 FOR UPDATE SKIP LOCKED) RETURNING " + webhookDeliveryColumns
+
+	var deliveries []model.WebhookDelivery
+
+	err := sqlx.SelectContext(ctx, executor(ctx, w.db), &deliveries, query, leaseUntil,
+		model.WebhookDeliveryPending, limit)
+	if err != nil {
+		w.logger.WithContext(ctx).Errorf("error in db while trying to claim due webhook deliveries, error: %s",
+			err.Error())
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (w WebhookPostgres) UpdateDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	query := "UPDATE webhook_delivery SET status = $1, attempts = $2, next_attempt_at = $3, last_error = $4, " +
+		"delivered_at = $5 WHERE id = $6"
+
+	_, err := executor(ctx, w.db).ExecContext(ctx, query, delivery.Status, delivery.Attempts,
+		delivery.NextAttemptAt, delivery.LastError, delivery.DeliveredAt, delivery.Id)
+	if err != nil {
+		w.logger.WithContext(ctx).Errorf("error in db while trying to update webhook delivery %v, error: %s",
+			delivery.Id, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (w WebhookPostgres) CreateAttempt(ctx context.Context, attempt model.WebhookDeliveryAttempt) error {
+	query := "INSERT INTO webhook_delivery_attempt (delivery_id, attempted_at, status_code, error, duration_ms) " +
+		"VALUES ($1, $2, $3, $4, $5)"
+
+	_, err := executor(ctx, w.db).ExecContext(ctx, query, attempt.DeliveryId, attempt.AttemptedAt,
+		attempt.StatusCode, attempt.Error, attempt.DurationMs)
+	if err != nil {
+		w.logger.WithContext(ctx).Errorf("error in db while trying to record attempt of webhook delivery %v, "+
+			"error: %s", attempt.DeliveryId, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (w WebhookPostgres) GetDeliveriesBySubscription(ctx context.Context, subscriptionId uuid.UUID, pageNum int,
+	pageSize int) ([]model.WebhookDelivery, error) {
+	query := "SELECT " + webhookDeliveryColumns + " FROM webhook_delivery AS wd WHERE wd.subscription_id = $1 " +
+		"ORDER BY wd.id DESC LIMIT $2 OFFSET $3"
+
+	var deliveries []model.WebhookDelivery
+
+	err := sqlx.SelectContext(ctx, executor(ctx, w.db), &deliveries, query, subscriptionId, pageSize,
+		pageNum*pageSize)
+	if err != nil {
+		w.logger.WithContext(ctx).Errorf("error in db while trying to get deliveries of webhook subscription %v, "+
+			"error: %s", subscriptionId, err.Error())
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (w WebhookPostgres) GetAttemptsByDeliveryIds(ctx context.Context, deliveryIds []int64) (
+	[]model.WebhookDeliveryAttempt, error) {
+	query := "SELECT wda.id, wda.delivery_id, wda.attempted_at, wda.status_code, wda.error, wda.duration_ms " +
+		"FROM webhook_delivery_attempt AS wda WHERE wda.delivery_id = ANY($1) ORDER BY wda.id"
+
+	var attempts []model.WebhookDeliveryAttempt
+
+	err := sqlx.SelectContext(ctx, executor(ctx, w.db), &attempts, query, pq.Int64Array(deliveryIds))
+	if err != nil {
+		w.logger.WithContext(ctx).Errorf("error in db while trying to get webhook delivery attempts, error: %s",
+			err.Error())
+		return nil, err
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	sqlxmock "github.com/zhashkevych/go-sqlxmock"
+)
+
+func TestWebhookPostgres_ClaimDueDeliveries(t *testing.T) {
+	log := logger.NewDefault()
+
+	db, mock, err := sqlxmock.Newx()
+	if err != nil {
+		t.Fatalf("error while trying to mock db, error: %s", err.Error())
+	}
+	defer db.Close()
+
+	r := NewWebhookPostgres(db, log)
+
+	now := time.Now()
+	leaseUntil := now.Add(time.Minute)
+	subscriptionId := uuid.New()
+
+	rows := sqlxmock.NewRows([]string{"id", "subscription_id", "event_id", "event_type", "payload", "status",
+		"attempts", "next_attempt_at", "last_error", "created_at", "delivered_at"}).
+		AddRow(3, subscriptionId, 11, model.EventBalanceCredited, []byte(`{}`), model.WebhookDeliveryPending, 1,
+			leaseUntil, "", now, nil)
+	mock.ExpectQuery("UPDATE webhook_delivery AS wd SET next_attempt_at = \\$1 WHERE wd.id IN \\("+
+		"SELECT d.id FROM webhook_delivery AS d WHERE d.status = \\$2 AND d.next_attempt_at <= now\\(\\) "+
+		"ORDER BY d.next_attempt_at LIMIT \\$3 FOR UPDATE SKIP LOCKED\\) RETURNING (.+)").
+		WithArgs(leaseUntil, model.WebhookDeliveryPending, 10).WillReturnRows(rows)
+
+	got, err := r.ClaimDueDeliveries(context.Background(), 10, leaseUntil)
+	assert.NoError(t, err)
+	assert.Len(t, got, 1)
+	assert.Equal(t, int64(3), got[0].Id)
+	assert.Equal(t, subscriptionId, got[0].SubscriptionId)
+	assert.Equal(t, leaseUntil, got[0].NextAttemptAt)
+}
@@ -1,10 +1,19 @@
 package schemas
 
 import (
+	"time"
+
 	"github.com/Feokrat/user-balance-api/internal/model"
 	"github.com/google/uuid"
 )
 
+// BusinessError is implemented by the errors of operations refused by the rules of the service, as opposed
+// to failures of its infrastructure. Retrying such an operation unchanged fails the same way.
+type BusinessError interface {
+	error
+	businessError()
+}
+
 type ErrorResponse struct {
 	Message string `json:"message"`
 }
@@ -21,6 +30,8 @@ func (e ErrorAmountToSendNegative) Error() string {
 	return e.Message
 }
 
+func (ErrorAmountToSendNegative) businessError() {}
+
 type ErrorNotEnoughFunds struct {
 	Message string `json:"message"`
 }
@@ -29,6 +40,8 @@ func (e ErrorNotEnoughFunds) Error() string {
 	return e.Message
 }
 
+func (ErrorNotEnoughFunds) businessError() {}
+
 type ErrorUserBalanceNotFound struct {
 	Message string `json:"message"`
 }
@@ -37,13 +50,354 @@ func (e ErrorUserBalanceNotFound) Error() string {
 	return e.Message
 }
 
+func (ErrorUserBalanceNotFound) businessError() {}
+
+type ErrorWebhookSubscriptionNotFound struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorWebhookSubscriptionNotFound) Error() string {
+	return e.Message
+}
+
+func (ErrorWebhookSubscriptionNotFound) businessError() {}
+
+type ErrorInvalidWebhookSubscription struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidWebhookSubscription) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidWebhookSubscription) businessError() {}
+
+type ErrorInvalidBatch struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidBatch) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidBatch) businessError() {}
+
+type ErrorBatchFailed struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorBatchFailed) Error() string {
+	return e.Message
+}
+
+func (ErrorBatchFailed) businessError() {}
+
+type ErrorIdempotencyKeyReused struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorIdempotencyKeyReused) Error() string {
+	return e.Message
+}
+
+func (ErrorIdempotencyKeyReused) businessError() {}
+
+type ErrorScheduledTransferNotFound struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorScheduledTransferNotFound) Error() string {
+	return e.Message
+}
+
+func (ErrorScheduledTransferNotFound) businessError() {}
+
+type ErrorInvalidScheduledTransfer struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidScheduledTransfer) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidScheduledTransfer) businessError() {}
+
+type ErrorPaymentRequestNotFound struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorPaymentRequestNotFound) Error() string {
+	return e.Message
+}
+
+func (ErrorPaymentRequestNotFound) businessError() {}
+
+type ErrorInvalidPaymentRequest struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidPaymentRequest) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidPaymentRequest) businessError() {}
+
+type ErrorPendingTransferNotFound struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorPendingTransferNotFound) Error() string {
+	return e.Message
+}
+
+func (ErrorPendingTransferNotFound) businessError() {}
+
+type ErrorInvalidPendingTransfer struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidPendingTransfer) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidPendingTransfer) businessError() {}
+
+type ErrorEscrowNotFound struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorEscrowNotFound) Error() string {
+	return e.Message
+}
+
+func (ErrorEscrowNotFound) businessError() {}
+
+type ErrorInvalidEscrow struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidEscrow) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidEscrow) businessError() {}
+
+type ErrorTransactionLogNotFound struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorTransactionLogNotFound) Error() string {
+	return e.Message
+}
+
+func (ErrorTransactionLogNotFound) businessError() {}
+
+type ErrorInvalidReversal struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidReversal) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidReversal) businessError() {}
+
+type ErrorAccountFrozen struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorAccountFrozen) Error() string {
+	return e.Message
+}
+
+func (ErrorAccountFrozen) businessError() {}
+
+type ErrorAccountClosed struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorAccountClosed) Error() string {
+	return e.Message
+}
+
+func (ErrorAccountClosed) businessError() {}
+
+type ErrorInvalidAccountStatus struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidAccountStatus) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidAccountStatus) businessError() {}
+
+// ErrorLimitExceeded tells which limit an operation would exceed and, for limits on totals over
+// a period, when the period resets.
+type ErrorLimitExceeded struct {
+	Message    string     `json:"message"`
+	Limit      string     `json:"limit"`
+	LimitValue float64    `json:"limitValue"`
+	ResetsAt   *time.Time `json:"resetsAt,omitempty"`
+}
+
+func (e ErrorLimitExceeded) Error() string {
+	return e.Message
+}
+
+func (ErrorLimitExceeded) businessError() {}
+
+type ErrorInvalidLimits struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidLimits) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidLimits) businessError() {}
+
+type ErrorInvalidOverdraft struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidOverdraft) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidOverdraft) businessError() {}
+
+type ErrorAccountExists struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorAccountExists) Error() string {
+	return e.Message
+}
+
+func (ErrorAccountExists) businessError() {}
+
+type ErrorInvalidAccount struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidAccount) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidAccount) businessError() {}
+
+type ErrorCurrencyMismatch struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorCurrencyMismatch) Error() string {
+	return e.Message
+}
+
+func (ErrorCurrencyMismatch) businessError() {}
+
+type ErrorReconciliationNotFound struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorReconciliationNotFound) Error() string {
+	return e.Message
+}
+
+func (ErrorReconciliationNotFound) businessError() {}
+
+type ErrorInvalidReconciliation struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidReconciliation) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidReconciliation) businessError() {}
+
+type ErrorInvalidCorrection struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidCorrection) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidCorrection) businessError() {}
+
+type ErrorInvalidFeeQuote struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidFeeQuote) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidFeeQuote) businessError() {}
+
+type ErrorInvalidBonus struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidBonus) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidBonus) businessError() {}
+
+type ErrorInvalidSplitTransfer struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidSplitTransfer) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidSplitTransfer) businessError() {}
+
+type ErrorInvalidDateRange struct {
+	Message string `json:"message"`
+}
+
+func (e ErrorInvalidDateRange) Error() string {
+	return e.Message
+}
+
+func (ErrorInvalidDateRange) businessError() {}
+
 type ValidationErrorResponse struct {
 	Message string `json:"message"`
 	Errors  string `json:"errors"`
 }
 
 type UserBalanceResponse struct {
-	Balance float64 `json:"balance"`
+	Balance        float64 `json:"balance"`
+	OverdraftLimit float64 `json:"overdraftLimit"`
+	// Available is the balance plus the overdraft credit left.
+	Available float64 `json:"available"`
+	// Real and Bonus split the balance into the funds of the user and the bonus granted to it, spent
+	// first from BonusGrants in their order.
+	Real        float64            `json:"real"`
+	Bonus       float64            `json:"bonus"`
+	BonusGrants []model.BonusGrant `json:"bonusGrants,omitempty"`
+	// Pending is held for the pending transfers of the user and left out of Available, PendingIncoming
+	// is what its pending transfers from others will credit it once captured.
+	Pending         float64 `json:"pending"`
+	PendingIncoming float64 `json:"pendingIncoming"`
+}
+
+type DailyBalancesResponse struct {
+	Items    []model.DailyBalance `json:"items"`
+	Len      int                  `json:"len"`
+	Currency string               `json:"currency"`
+}
+
+type HistoricalBalanceResponse struct {
+	Balance  float64   `json:"balance"`
+	Currency string    `json:"currency"`
+	At       time.Time `json:"at"`
 }
 
 type ChangeBalanceRequest struct {
@@ -55,10 +409,267 @@ type TransactionRequest struct {
 	SenderId   uuid.UUID `json:"senderId"`
 	ReceiverId uuid.UUID `json:"receiverId"`
 	Amount     float64   `json:"amount"`
+	// ExcludeBonus sends real funds only, leaving the bonus of the sender untouched.
+	ExcludeBonus bool `json:"excludeBonus"`
+}
+
+type SplitTransferRequest struct {
+	SenderId uuid.UUID `json:"senderId"`
+	// Amount is the total to split, required when a share is a percentage of it.
+	Amount    float64            `json:"amount"`
+	Receivers []model.SplitShare `json:"receivers"`
+	// ExcludeBonus sends real funds only, leaving the bonus of the sender untouched.
+	ExcludeBonus bool `json:"excludeBonus"`
 }
 
 type TransactionLogResponse struct {
 	Items []model.TransactionLog `json:"items"`
-	Len int `json:"len"`
-	All int `json:"all"`
+	Len   int                    `json:"len"`
+	All   int                    `json:"all"`
+}
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+type ReadinessResponse struct {
+	Status     string                           `json:"status"`
+	Components map[string]model.ComponentHealth `json:"components"`
+}
+
+type CreateWebhookRequest struct {
+	URL        string   `json:"url" binding:"required"`
+	EventTypes []string `json:"eventTypes"`
+	// Secret signs the payloads, a random one is generated when it is empty.
+	Secret string `json:"secret"`
+}
+
+type UpdateWebhookRequest struct {
+	URL        string   `json:"url" binding:"required"`
+	EventTypes []string `json:"eventTypes"`
+	Active     *bool    `json:"active"`
+}
+
+type WebhookSubscriptionsResponse struct {
+	Items []model.WebhookSubscription `json:"items"`
+}
+
+type WebhookDeliveriesResponse struct {
+	Items []model.WebhookDelivery `json:"items"`
+	Len   int                     `json:"len"`
+}
+
+type BatchRequest struct {
+	Operations []model.BatchOperation `json:"operations" binding:"required"`
+}
+
+// BatchErrorResponse describes a rejected or failed batch along with its per-item results.
+type BatchErrorResponse struct {
+	Message string      `json:"message"`
+	Batch   model.Batch `json:"batch"`
+}
+
+type CreateScheduledTransferRequest struct {
+	SenderId   uuid.UUID `json:"senderId" binding:"required"`
+	ReceiverId uuid.UUID `json:"receiverId" binding:"required"`
+	Amount     float64   `json:"amount" binding:"required"`
+	// StartAt is the time of the first run.
+	StartAt time.Time `json:"startAt" binding:"required"`
+	// Recurrence is once, daily, weekly or monthly, once when empty.
+	Recurrence string `json:"recurrence"`
+	// DayOfMonth is the day monthly transfers run on, the day of StartAt when zero.
+	DayOfMonth int `json:"dayOfMonth"`
+}
+
+type UpdateScheduledTransferRequest struct {
+	Amount     float64 `json:"amount" binding:"required"`
+	Recurrence string  `json:"recurrence"`
+	DayOfMonth int     `json:"dayOfMonth"`
+	// NextRunAt moves the next occurrence, it is kept when empty.
+	NextRunAt *time.Time `json:"nextRunAt"`
+	// Active pauses or resumes the transfer.
+	Active *bool `json:"active"`
+}
+
+type ScheduledTransfersResponse struct {
+	Items []model.ScheduledTransfer `json:"items"`
+	Len   int                       `json:"len"`
+}
+
+type ScheduledTransferExecutionsResponse struct {
+	Items []model.ScheduledTransferExecution `json:"items"`
+	Len   int                                `json:"len"`
+}
+
+type CreatePaymentRequestRequest struct {
+	RequesterId uuid.UUID `json:"requesterId" binding:"required"`
+	PayerId     uuid.UUID `json:"payerId" binding:"required"`
+	Amount      float64   `json:"amount" binding:"required"`
+	Note        string    `json:"note"`
+	// ExpiresAt is when the request expires if still pending, the default expiry from now when empty.
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// PaymentRequestActionRequest names the user accepting, declining or cancelling a payment request.
+type PaymentRequestActionRequest struct {
+	UserId uuid.UUID `json:"userId" binding:"required"`
+	Reason string    `json:"reason"`
+}
+
+// PaymentRequestsQuery lists the requests of a payer or of a requester, one of them is required.
+type PaymentRequestsQuery struct {
+	PayerId     string `form:"payerId"`
+	RequesterId string `form:"requesterId"`
+	Status      string `form:"status"`
+}
+
+type PaymentRequestsResponse struct {
+	Items []model.PaymentRequest `json:"items"`
+	Len   int                    `json:"len"`
+}
+
+type AuthorizeTransferRequest struct {
+	SenderId   uuid.UUID `json:"senderId" binding:"required"`
+	ReceiverId uuid.UUID `json:"receiverId" binding:"required"`
+	Amount     float64   `json:"amount" binding:"required"`
+	Reference  string    `json:"reference"`
+}
+
+// CaptureTransferRequest captures amount of a pending transfer, all of it when amount is empty.
+type CaptureTransferRequest struct {
+	Amount float64 `json:"amount"`
+}
+
+type VoidTransferRequest struct {
+	Reason string `json:"reason"`
+}
+
+type FundEscrowRequest struct {
+	DealId   string    `json:"dealId" binding:"required"`
+	BuyerId  uuid.UUID `json:"buyerId" binding:"required"`
+	SellerId uuid.UUID `json:"sellerId" binding:"required"`
+	Amount   float64   `json:"amount" binding:"required"`
+}
+
+// SplitEscrowRequest pays SellerAmount of an escrow to the seller and the rest back to the buyer.
+type SplitEscrowRequest struct {
+	SellerAmount float64 `json:"sellerAmount" binding:"required"`
+}
+
+type ReverseOperationRequest struct {
+	// Amount is the part of the operation to reverse, all that is left of it when empty.
+	Amount float64 `json:"amount"`
+	Reason string  `json:"reason"`
+}
+
+type ChangeAccountStatusRequest struct {
+	// Status is active, frozen or closed.
+	Status     string `json:"status" binding:"required"`
+	Reason     string `json:"reason" binding:"required"`
+	OperatorId string `json:"operatorId" binding:"required"`
+}
+
+type AccountStatusChangesResponse struct {
+	Items []model.AccountStatusChange `json:"items"`
+	Len   int                         `json:"len"`
+}
+
+// UpdateLimitsRequest replaces the limit overrides of a user, every omitted limit is taken from the tier.
+type UpdateLimitsRequest struct {
+	Tier             *string  `json:"tier"`
+	MaxTransfer      *float64 `json:"maxTransfer"`
+	DailyOutgoing    *float64 `json:"dailyOutgoing"`
+	MonthlyOutgoing  *float64 `json:"monthlyOutgoing"`
+	MaxBalance       *float64 `json:"maxBalance"`
+	TransfersPerHour *int     `json:"transfersPerHour"`
+	OperatorId       string   `json:"operatorId" binding:"required"`
+}
+
+type GrantBonusRequest struct {
+	UserId    uuid.UUID `json:"userId" binding:"required"`
+	Amount    float64   `json:"amount" binding:"required"`
+	ExpiresAt time.Time `json:"expiresAt" binding:"required"`
+	Reason    string    `json:"reason"`
+}
+
+type SetOverdraftLimitRequest struct {
+	OverdraftLimit *float64 `json:"overdraftLimit" binding:"required"`
+	OperatorId     string   `json:"operatorId" binding:"required"`
+}
+
+type OpenAccountRequest struct {
+	// UserId is the id of the account, a new one is generated when empty.
+	UserId *uuid.UUID `json:"userId"`
+	// Currency is the ISO 4217 code of the account currency, the default currency when empty.
+	Currency string                `json:"currency"`
+	Metadata model.AccountMetadata `json:"metadata"`
+}
+
+type CloseAccountRequest struct {
+	// PayoutTo is the account receiving the remaining balance, which is paid out of the system when empty.
+	PayoutTo   *uuid.UUID `json:"payoutTo"`
+	Reason     string     `json:"reason" binding:"required"`
+	OperatorId string     `json:"operatorId" binding:"required"`
+}
+
+// SearchAccountsRequest holds the query params of the admin account search, times are RFC 3339.
+type SearchAccountsRequest struct {
+	MinBalance  *float64   `form:"minBalance"`
+	MaxBalance  *float64   `form:"maxBalance"`
+	Status      string     `form:"status"`
+	Currency    string     `form:"currency"`
+	CreatedFrom *time.Time `form:"createdFrom"`
+	CreatedTo   *time.Time `form:"createdTo"`
+	// SortField is balance or createdAt, createdAt by default.
+	SortField string `form:"sortField"`
+	// Order is asc or desc, desc by default.
+	Order    string `form:"order" binding:"omitempty,oneof=asc desc"`
+	Cursor   string `form:"cursor"`
+	PageSize int    `form:"pageSize"`
+}
+
+type AccountsResponse struct {
+	Items []model.UserBalance `json:"items"`
+	Len   int                 `json:"len"`
+	// NextCursor is passed as cursor to get the next page, it is empty on the last page.
+	NextCursor string                `json:"nextCursor,omitempty"`
+	Totals     []model.AccountTotals `json:"totals"`
+}
+
+type ReconcileRequest struct {
+	// SampleSize is how many random accounts are compared, every account when 0.
+	SampleSize int `json:"sampleSize" binding:"min=0"`
+}
+
+type ApproveCorrectionRequest struct {
+	OperatorId string `json:"operatorId" binding:"required"`
+}
+
+type ReconciliationsResponse struct {
+	Items []model.ReconciliationRun `json:"items"`
+	Len   int                       `json:"len"`
+}
+
+type FeeQuoteRequest struct {
+	Operation string  `form:"operation" binding:"required"`
+	UserId    string  `form:"userId" binding:"required"`
+	Amount    float64 `form:"amount" binding:"required"`
+}
+
+type AuditEntriesRequest struct {
+	CallerId string `form:"callerId"`
+	Protocol string `form:"protocol" binding:"omitempty,oneof=http grpc"`
+	Method   string `form:"method"`
+	// Route is the route template of REST calls, like /api/v1/balances/:id, or the full gRPC method.
+	Route            string     `form:"route"`
+	Status           *int       `form:"status"`
+	RequestId        string     `form:"requestId"`
+	TransactionLogId *int32     `form:"transactionLogId"`
+	From             *time.Time `form:"from"`
+	To               *time.Time `form:"to"`
+}
+
+type AuditEntriesResponse struct {
+	Items []model.AuditEntry `json:"items"`
+	Len   int                `json:"len"`
 }
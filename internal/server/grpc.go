@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"google.golang.org/grpc"
+)
+
+type GRPCserver struct {
+	grpcServer *grpc.Server
+	addr       string
+}
+
+func NewGRPCserver(cfg *config.Config, grpcServer *grpc.Server) *GRPCserver {
+	return &GRPCserver{
+		grpcServer: grpcServer,
+		addr:       cfg.GRPC.Host + ":" + cfg.GRPC.Port,
+	}
+}
+
+func (s *GRPCserver) Run() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	return s.grpcServer.Serve(listener)
+}
+
+// Stop waits for in-flight calls to finish, calls still running when ctx is done are cancelled.
+func (s *GRPCserver) Stop(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.grpcServer.Stop()
+		return ctx.Err()
+	}
+}
@@ -14,8 +14,11 @@ type HTTPserver struct {
 func NewHTTPserver(cfg *config.Config, handler http.Handler) *HTTPserver {
 	return &HTTPserver{
 		httpServer: &http.Server{
-			Addr:    cfg.HTTP.Host + ":" + cfg.HTTP.Port,
-			Handler: handler,
+			Addr:         cfg.HTTP.Host + ":" + cfg.HTTP.Port,
+			Handler:      handler,
+			ReadTimeout:  cfg.HTTP.ReadTimeout,
+			WriteTimeout: cfg.HTTP.WriteTimeout,
+			IdleTimeout:  cfg.HTTP.IdleTimeout,
 		},
 	}
 }
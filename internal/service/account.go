@@ -0,0 +1,404 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+// OpenAccount opens an empty account for userId, a generated id when it is empty, in currency or the
+// default currency.
+func (s UserBalanceService) OpenAccount(ctx context.Context, userId uuid.UUID, currency string,
+	metadata model.AccountMetadata) (model.UserBalance, error) {
+	if userId == uuid.Nil {
+		userId = uuid.New()
+	}
+	if currency == "" {
+		currency = s.accounts.DefaultCurrency
+	}
+	currency = strings.ToUpper(currency)
+	if !validCurrency(currency) {
+		return model.UserBalance{}, schemas.ErrorInvalidAccount{
+			Message: fmt.Sprintf("currency %q is not an ISO 4217 currency code", currency),
+		}
+	}
+	if metadata == nil {
+		metadata = model.AccountMetadata{}
+	}
+
+	log := s.logger.WithContext(ctx).WithField("user_id", userId)
+
+	account := model.UserBalance{
+		UserId:    userId,
+		Status:    model.AccountActive,
+		Currency:  currency,
+		Metadata:  metadata,
+		CreatedAt: time.Now(),
+	}
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		exists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, userId)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return schemas.ErrorAccountExists{
+				Message: fmt.Sprintf("account of user %v already exists", userId),
+			}
+		}
+
+		if err = s.userBalanceRepo.Create(ctx, account); err != nil {
+			return err
+		}
+
+		return s.publishEvent(ctx, userId, model.EventAccountCreated, account)
+	})
+	if err != nil {
+		log.Warnf("could not open account, error: %s", err.Error())
+		return model.UserBalance{}, err
+	}
+
+	log.Infof("opened account in %s", currency)
+	return account, nil
+}
+
+// GetAccount returns the account of a user.
+func (s UserBalanceService) GetAccount(ctx context.Context, userId uuid.UUID) (model.UserBalance, error) {
+	exists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, userId)
+	if err != nil {
+		return model.UserBalance{}, err
+	}
+	if !exists {
+		return model.UserBalance{}, accountNotFound(userId)
+	}
+
+	ub, err := s.userBalanceRepo.GetByUserId(ctx, userId)
+	if err != nil {
+		s.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not get account of user, error: %s", err.Error())
+		return model.UserBalance{}, err
+	}
+
+	return ub, nil
+}
+
+// accountPageCursor is the opaque cursor of account searches, it remembers the order it was made for.
+type accountPageCursor struct {
+	SortField  string `json:"sortField"`
+	Descending bool   `json:"descending"`
+	model.AccountCursor
+}
+
+// SearchAccounts returns a page of at most pageSize accounts matching filter, sorted by sortField and
+// starting after cursor, together with the totals of all matching accounts.
+func (s UserBalanceService) SearchAccounts(ctx context.Context, filter model.AccountFilter, sortField string,
+	descending bool, cursor string, pageSize int) (model.AccountPage, error) {
+	if sortField == "" {
+		sortField = model.AccountSortCreatedAt
+	}
+	filter.Currency = strings.ToUpper(filter.Currency)
+	if err := validateAccountSearch(filter, sortField); err != nil {
+		return model.AccountPage{}, err
+	}
+
+	query := model.AccountQuery{
+		Filter:     filter,
+		SortField:  sortField,
+		Descending: descending,
+		Limit:      pageSize + 1,
+	}
+	if cursor != "" {
+		after, err := decodeAccountCursor(cursor, sortField, descending)
+		if err != nil {
+			return model.AccountPage{}, err
+		}
+		query.After = &after
+	}
+
+	log := s.logger.WithContext(ctx)
+
+	accounts, err := s.userBalanceRepo.Search(ctx, query)
+	if err != nil {
+		log.Errorf("could not search accounts, error: %s", err.Error())
+		return model.AccountPage{}, err
+	}
+	totals, err := s.userBalanceRepo.Totals(ctx, filter)
+	if err != nil {
+		log.Errorf("could not aggregate accounts, error: %s", err.Error())
+		return model.AccountPage{}, err
+	}
+
+	page := model.AccountPage{Items: accounts, Totals: totals}
+	// one account more than the page is asked for, to know whether a next page exists
+	if len(accounts) > pageSize {
+		page.Items = accounts[:pageSize]
+		last := page.Items[pageSize-1]
+		page.NextCursor, err = encodeAccountCursor(accountPageCursor{
+			SortField:  sortField,
+			Descending: descending,
+			AccountCursor: model.AccountCursor{
+				Balance:   last.Balance,
+				CreatedAt: last.CreatedAt,
+				UserId:    last.UserId,
+			},
+		})
+		if err != nil {
+			return model.AccountPage{}, err
+		}
+	}
+
+	return page, nil
+}
+
+// CloseAccount pays out the remaining balance of an account and closes it. The balance goes to the
+// account payoutTo, or out of the system when it is empty; an account owing money can not be closed.
+// The payout is not subject to the limits of the closed account.
+func (s UserBalanceService) CloseAccount(ctx context.Context, userId uuid.UUID, payoutTo *uuid.UUID,
+	reason string, operatorId string) (model.AccountClosure, error) {
+	log := s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"user_id":     userId,
+		"operator_id": operatorId,
+	})
+
+	if strings.TrimSpace(reason) == "" || strings.TrimSpace(operatorId) == "" {
+		return model.AccountClosure{}, schemas.ErrorInvalidAccountStatus{
+			Message: "reason and operatorId are required",
+		}
+	}
+	if payoutTo != nil && *payoutTo == userId {
+		return model.AccountClosure{}, schemas.ErrorInvalidAccount{
+			Message: "an account can not be paid out to itself",
+		}
+	}
+
+	var closure model.AccountClosure
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		ub, receiver, err := s.lockClosedAccounts(ctx, userId, payoutTo)
+		if err != nil {
+			return err
+		}
+
+		if err = checkCanDebit(ub); err != nil {
+			return err
+		}
+		if ub.Held > 0 {
+			return schemas.ErrorInvalidAccountStatus{
+				Message: fmt.Sprintf("account of user %v holds %v for pending transfers and can not be closed",
+					userId, ub.Held),
+			}
+		}
+		if ub, err = s.forfeitBonus(ctx, ub); err != nil {
+			return err
+		}
+		if ub.Balance < 0 {
+			return schemas.ErrorInvalidAccountStatus{
+				Message: fmt.Sprintf("account of user %v owes %v and can not be closed", userId, -ub.Balance),
+			}
+		}
+
+		if ub.Balance > 0 {
+			payout, err := s.payOut(ctx, ub, receiver)
+			if err != nil {
+				return err
+			}
+			closure.Payout = &payout
+			ub.Balance = 0
+		}
+
+		closure.Account, err = s.setAccountStatus(ctx, ub, model.AccountClosed, reason, operatorId)
+		return err
+	})
+	if err != nil {
+		log.Warnf("could not close account, error: %s", err.Error())
+		return model.AccountClosure{}, err
+	}
+
+	log.Infof("closed account, reason: %s", reason)
+	return closure, nil
+}
+
+// lockClosedAccounts locks the account to close and the account receiving its payout, if any.
+func (s UserBalanceService) lockClosedAccounts(ctx context.Context, userId uuid.UUID, payoutTo *uuid.UUID) (
+	model.UserBalance, *model.UserBalance, error) {
+	if payoutTo == nil {
+		ub, err := s.lockAccount(ctx, userId)
+		return ub, nil, err
+	}
+
+	for _, id := range []uuid.UUID{userId, *payoutTo} {
+		exists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, id)
+		if err != nil {
+			return model.UserBalance{}, nil, err
+		}
+		if !exists {
+			return model.UserBalance{}, nil, accountNotFound(id)
+		}
+	}
+
+	balances, err := s.lockBalances(ctx, userId, *payoutTo)
+	if err != nil {
+		return model.UserBalance{}, nil, err
+	}
+	receiver := balances[*payoutTo]
+
+	return balances[userId], &receiver, nil
+}
+
+// payOut moves the whole balance of an account to receiver, or out of the system when receiver is nil,
+// and returns the entry taking it from the account.
+func (s UserBalanceService) payOut(ctx context.Context, ub model.UserBalance, receiver *model.UserBalance) (
+	model.TransactionLog, error) {
+	amount := ub.Balance
+
+	if receiver == nil {
+		balance, err := s.subBalance(ctx, ub.UserId, -amount)
+		if err != nil {
+			return model.TransactionLog{}, err
+		}
+
+		return s.recordBalanceChange(ctx, model.TransactionLog{
+			UserId:        ub.UserId,
+			Amount:        amount,
+			Commentary:    fmt.Sprintf("Paid out %v rubles on closing the account", amount),
+			OperationType: model.OperationPayout,
+		}, model.EventBalanceDebited, balance)
+	}
+
+	if err := checkCanCredit(*receiver); err != nil {
+		return model.TransactionLog{}, err
+	}
+	if err := checkSameCurrency(ub, *receiver); err != nil {
+		return model.TransactionLog{}, err
+	}
+	if err := s.limits.CheckCredit(ctx, receiver.UserId, receiver.Balance+amount); err != nil {
+		return model.TransactionLog{}, err
+	}
+
+	senderBalance, err := s.subBalance(ctx, ub.UserId, -amount)
+	if err != nil {
+		return model.TransactionLog{}, err
+	}
+
+	sent, err := s.recordBalanceChange(ctx, model.TransactionLog{
+		UserId:         ub.UserId,
+		Amount:         amount,
+		Commentary:     fmt.Sprintf("Paid out %v rubles to user %v on closing the account", amount, receiver.UserId),
+		OperationType:  model.OperationTransferOut,
+		CounterpartyId: &receiver.UserId,
+	}, model.EventBalanceDebited, senderBalance)
+	if err != nil {
+		return model.TransactionLog{}, err
+	}
+
+	receiverBalance, err := s.addBalance(ctx, receiver.UserId, amount)
+	if err != nil {
+		return model.TransactionLog{}, err
+	}
+
+	_, err = s.recordBalanceChange(ctx, model.TransactionLog{
+		UserId:         receiver.UserId,
+		Amount:         amount,
+		Commentary:     fmt.Sprintf("Received %v rubles from closed account of user %v", amount, ub.UserId),
+		OperationType:  model.OperationTransferIn,
+		CounterpartyId: &sent.UserId,
+		RelatedLogId:   &sent.Id,
+	}, model.EventBalanceCredited, receiverBalance)
+	if err != nil {
+		return model.TransactionLog{}, err
+	}
+
+	return sent, s.publishEvent(ctx, ub.UserId, model.EventTransferCompleted, model.TransferCompletedEvent{
+		SenderId:   ub.UserId,
+		ReceiverId: receiver.UserId,
+		Amount:     amount,
+	})
+}
+
+func validateAccountSearch(filter model.AccountFilter, sortField string) error {
+	switch {
+	case sortField != model.AccountSortBalance && sortField != model.AccountSortCreatedAt:
+		return schemas.ErrorInvalidAccount{
+			Message: fmt.Sprintf("unknown sort field %q, must be balance or createdAt", sortField),
+		}
+	case filter.Status != "" && filter.Status != model.AccountActive && filter.Status != model.AccountFrozen &&
+		filter.Status != model.AccountClosed:
+		return schemas.ErrorInvalidAccount{
+			Message: fmt.Sprintf("unknown status %q, must be active, frozen or closed", filter.Status),
+		}
+	case filter.MinBalance != nil && filter.MaxBalance != nil && *filter.MinBalance > *filter.MaxBalance:
+		return schemas.ErrorInvalidAccount{
+			Message: "minBalance must not be greater than maxBalance",
+		}
+	case filter.CreatedFrom != nil && filter.CreatedTo != nil && filter.CreatedFrom.After(*filter.CreatedTo):
+		return schemas.ErrorInvalidAccount{
+			Message: "createdFrom must not be after createdTo",
+		}
+	}
+
+	return nil
+}
+
+func encodeAccountCursor(cursor accountPageCursor) (string, error) {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeAccountCursor(cursor string, sortField string, descending bool) (model.AccountCursor, error) {
+	invalid := schemas.ErrorInvalidAccount{
+		Message: "cursor is malformed",
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return model.AccountCursor{}, invalid
+	}
+	var decoded accountPageCursor
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		return model.AccountCursor{}, invalid
+	}
+	if decoded.SortField != sortField || decoded.Descending != descending {
+		return model.AccountCursor{}, schemas.ErrorInvalidAccount{
+			Message: "cursor was made for another sort order",
+		}
+	}
+
+	return decoded.AccountCursor, nil
+}
+
+func checkSameCurrency(sender model.UserBalance, receiver model.UserBalance) error {
+	if sender.Currency != receiver.Currency {
+		return schemas.ErrorCurrencyMismatch{
+			Message: fmt.Sprintf("account of user %v is in %s and account of user %v is in %s",
+				sender.UserId, sender.Currency, receiver.UserId, receiver.Currency),
+		}
+	}
+
+	return nil
+}
+
+// validCurrency reports whether code looks like an ISO 4217 currency code.
+func validCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+// ChangeAccountStatus freezes, unfreezes or closes an account and records the change in its status
+// history. Closed accounts can not change status anymore and only an empty account can be closed.
+func (s UserBalanceService) ChangeAccountStatus(ctx context.Context, userId uuid.UUID, status string,
+	reason string, operatorId string) (model.UserBalance, error) {
+	log := s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"user_id":     userId,
+		"operator_id": operatorId,
+	})
+
+	if status != model.AccountActive && status != model.AccountFrozen && status != model.AccountClosed {
+		return model.UserBalance{}, schemas.ErrorInvalidAccountStatus{
+			Message: fmt.Sprintf("unknown status %q, must be active, frozen or closed", status),
+		}
+	}
+	if strings.TrimSpace(reason) == "" || strings.TrimSpace(operatorId) == "" {
+		return model.UserBalance{}, schemas.ErrorInvalidAccountStatus{
+			Message: "reason and operatorId are required",
+		}
+	}
+
+	var ub model.UserBalance
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		ub, err = s.lockAccount(ctx, userId)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case ub.Status == model.AccountClosed:
+			return schemas.ErrorAccountClosed{
+				Message: fmt.Sprintf("account of user %v is closed permanently", userId),
+			}
+		case ub.Status == status:
+			return schemas.ErrorInvalidAccountStatus{
+				Message: fmt.Sprintf("account of user %v is already %s", userId, status),
+			}
+		case status == model.AccountClosed && ub.Balance != 0:
+			return schemas.ErrorInvalidAccountStatus{
+				Message: fmt.Sprintf("account of user %v can not be closed with balance %v", userId, ub.Balance),
+			}
+		}
+
+		ub, err = s.setAccountStatus(ctx, ub, status, reason, operatorId)
+		return err
+	})
+	if err != nil {
+		log.Warnf("could not change account status to %s, error: %s", status, err.Error())
+		return model.UserBalance{}, err
+	}
+
+	log.Infof("changed account status to %s, reason: %s", status, reason)
+	return ub, nil
+}
+
+// GetAccountStatusHistory returns the status changes of an account, newest first.
+func (s UserBalanceService) GetAccountStatusHistory(ctx context.Context, userId uuid.UUID, pageNum int,
+	pageSize int) ([]model.AccountStatusChange, error) {
+	exists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, accountNotFound(userId)
+	}
+
+	changes, err := s.userBalanceRepo.GetStatusChanges(ctx, userId, pageNum, pageSize)
+	if err != nil {
+		s.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not get account status history, error: %s", err.Error())
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// setAccountStatus records a status change of a locked account and publishes it.
+func (s UserBalanceService) setAccountStatus(ctx context.Context, ub model.UserBalance, status string,
+	reason string, operatorId string) (model.UserBalance, error) {
+	if err := s.userBalanceRepo.UpdateStatusByUserId(ctx, ub.UserId, status); err != nil {
+		return model.UserBalance{}, err
+	}
+
+	change := model.AccountStatusChange{
+		UserId:     ub.UserId,
+		FromStatus: ub.Status,
+		ToStatus:   status,
+		Reason:     reason,
+		OperatorId: operatorId,
+		ChangedAt:  time.Now(),
+	}
+	if err := s.userBalanceRepo.CreateStatusChange(ctx, change); err != nil {
+		return model.UserBalance{}, err
+	}
+
+	ub.Status = status
+	err := s.publishEvent(ctx, ub.UserId, model.EventAccountStatusChanged, model.AccountStatusChangedEvent{
+		UserId:     ub.UserId,
+		FromStatus: change.FromStatus,
+		ToStatus:   change.ToStatus,
+		Reason:     reason,
+		OperatorId: operatorId,
+	})
+	if err != nil {
+		return model.UserBalance{}, err
+	}
+
+	return ub, nil
+}
+
+func (s UserBalanceService) lockAccount(ctx context.Context, userId uuid.UUID) (model.UserBalance, error) {
+	exists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, userId)
+	if err != nil {
+		return model.UserBalance{}, err
+	}
+	if !exists {
+		return model.UserBalance{}, accountNotFound(userId)
+	}
+
+	return s.userBalanceRepo.GetByUserIdForUpdate(ctx, userId)
+}
+
+func accountNotFound(userId uuid.UUID) error {
+	return schemas.ErrorUserBalanceNotFound{
+		Message: fmt.Sprintf("user balance of user with id %v not found", userId),
+	}
+}
+
+// checkCanCredit reports whether money can be added to the account, which only closed accounts refuse.
+func checkCanCredit(ub model.UserBalance) error {
+	if ub.Status == model.AccountClosed {
+		return schemas.ErrorAccountClosed{
+			Message: fmt.Sprintf("account of user %v is closed", ub.UserId),
+		}
+	}
+
+	return nil
+}
+
+// checkCanDebit reports whether money can be taken from the account, which frozen and closed accounts refuse.
+func checkCanDebit(ub model.UserBalance) error {
+	switch ub.Status {
+	case model.AccountFrozen:
+		return schemas.ErrorAccountFrozen{
+			Message: fmt.Sprintf("account of user %v is frozen", ub.UserId),
+		}
+	case model.AccountClosed:
+		return checkCanCredit(ub)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserBalanceService_AccountStatusEnforcement(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name        string
+		status      string
+		operation   func(s *UserBalanceService) error
+		expectedErr error
+	}{
+		{
+			name:   "Frozen account is credited",
+			status: model.AccountFrozen,
+			operation: func(s *UserBalanceService) error {
+				_, err := s.ChangeUserBalanceByUserId(context.Background(), alice, 10)
+				return err
+			},
+		},
+		{
+			name:   "Frozen account receives a transfer",
+			status: model.AccountFrozen,
+			operation: func(s *UserBalanceService) error {
+				return s.ApplyTransaction(context.Background(), bob, alice, 10)
+			},
+		},
+		{
+			name:   "Frozen account is not debited",
+			status: model.AccountFrozen,
+			operation: func(s *UserBalanceService) error {
+				_, err := s.ChangeUserBalanceByUserId(context.Background(), alice, -10)
+				return err
+			},
+			expectedErr: schemas.ErrorAccountFrozen{},
+		},
+		{
+			name:   "Frozen account does not send a transfer",
+			status: model.AccountFrozen,
+			operation: func(s *UserBalanceService) error {
+				return s.ApplyTransaction(context.Background(), alice, bob, 10)
+			},
+			expectedErr: schemas.ErrorAccountFrozen{},
+		},
+		{
+			name:   "Closed account is not credited",
+			status: model.AccountClosed,
+			operation: func(s *UserBalanceService) error {
+				_, err := s.ChangeUserBalanceByUserId(context.Background(), alice, 10)
+				return err
+			},
+			expectedErr: schemas.ErrorAccountClosed{},
+		},
+		{
+			name:   "Closed account does not receive a transfer",
+			status: model.AccountClosed,
+			operation: func(s *UserBalanceService) error {
+				return s.ApplyTransaction(context.Background(), bob, alice, 10)
+			},
+			expectedErr: schemas.ErrorAccountClosed{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, balanceRepo, logRepo, _ := newTestUserBalanceService(map[uuid.UUID]float64{alice: 50, bob: 50})
+			balanceRepo.statuses[alice] = tt.status
+
+			err := tt.operation(s)
+			if tt.expectedErr != nil {
+				assert.IsType(t, tt.expectedErr, err)
+				assert.Equal(t, map[uuid.UUID]float64{alice: 50, bob: 50}, balanceRepo.balances)
+				assert.Empty(t, logRepo.logs)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestUserBalanceService_ChangeAccountStatus(t *testing.T) {
+	alice, empty := uuid.New(), uuid.New()
+	s, balanceRepo, _, outboxRepo := newTestUserBalanceService(map[uuid.UUID]float64{alice: 50, empty: 0})
+	ctx := context.Background()
+
+	account, err := s.ChangeAccountStatus(ctx, alice, model.AccountFrozen, "aml check", "operator-1")
+	assert.NoError(t, err)
+	assert.Equal(t, model.AccountFrozen, account.Status)
+	assert.Equal(t, model.AccountFrozen, balanceRepo.statuses[alice])
+	assert.Equal(t, model.EventAccountStatusChanged, outboxRepo.events[len(outboxRepo.events)-1].EventType)
+
+	_, err = s.ChangeAccountStatus(ctx, alice, model.AccountFrozen, "again", "operator-1")
+	assert.IsType(t, schemas.ErrorInvalidAccountStatus{}, err, "an account is not frozen twice")
+	_, err = s.ChangeAccountStatus(ctx, alice, model.AccountClosed, "leaving", "operator-1")
+	assert.IsType(t, schemas.ErrorInvalidAccountStatus{}, err, "an account with money is not closed")
+	_, err = s.ChangeAccountStatus(ctx, alice, "suspended", "typo", "operator-1")
+	assert.IsType(t, schemas.ErrorInvalidAccountStatus{}, err)
+	_, err = s.ChangeAccountStatus(ctx, alice, model.AccountActive, "", "operator-1")
+	assert.IsType(t, schemas.ErrorInvalidAccountStatus{}, err, "a reason is required")
+	_, err = s.ChangeAccountStatus(ctx, uuid.New(), model.AccountFrozen, "aml check", "operator-1")
+	assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+
+	_, err = s.ChangeAccountStatus(ctx, alice, model.AccountActive, "cleared", "operator-2")
+	assert.NoError(t, err)
+
+	_, err = s.ChangeAccountStatus(ctx, empty, model.AccountClosed, "leaving", "operator-1")
+	assert.NoError(t, err)
+	_, err = s.ChangeAccountStatus(ctx, empty, model.AccountActive, "came back", "operator-1")
+	assert.IsType(t, schemas.ErrorAccountClosed{}, err, "a closed account stays closed")
+
+	history, err := s.GetAccountStatusHistory(ctx, alice, 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, model.AccountFrozen, history[0].FromStatus)
+	assert.Equal(t, model.AccountActive, history[0].ToStatus)
+	assert.Equal(t, "operator-2", history[0].OperatorId)
+	assert.Equal(t, "aml check", history[1].Reason)
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserBalanceService_OpenAccount(t *testing.T) {
+	existing := uuid.New()
+	s, balanceRepo, _, outboxRepo := newTestUserBalanceService(map[uuid.UUID]float64{existing: 10})
+	ctx := context.Background()
+
+	account, err := s.OpenAccount(ctx, uuid.Nil, "", nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, uuid.Nil, account.UserId)
+	assert.Equal(t, "RUB", account.Currency)
+	assert.Equal(t, model.AccountActive, account.Status)
+	assert.Contains(t, balanceRepo.balances, account.UserId)
+	assert.Equal(t, model.EventAccountCreated, outboxRepo.events[0].EventType)
+
+	userId := uuid.New()
+	account, err = s.OpenAccount(ctx, userId, "usd", model.AccountMetadata{"segment": "b2b"})
+	assert.NoError(t, err)
+	assert.Equal(t, userId, account.UserId)
+	assert.Equal(t, "USD", account.Currency)
+	assert.Equal(t, model.AccountMetadata{"segment": "b2b"}, account.Metadata)
+
+	_, err = s.OpenAccount(ctx, existing, "", nil)
+	assert.IsType(t, schemas.ErrorAccountExists{}, err)
+	_, err = s.OpenAccount(ctx, uuid.Nil, "rubles", nil)
+	assert.IsType(t, schemas.ErrorInvalidAccount{}, err)
+}
+
+func TestUserBalanceService_ImplicitCreate(t *testing.T) {
+	unknown := uuid.New()
+	s, balanceRepo, _, _ := newTestUserBalanceService(map[uuid.UUID]float64{})
+	ctx := context.Background()
+
+	_, err := s.GetAccount(ctx, unknown)
+	assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+	_, err = s.GetAccountBalance(ctx, unknown)
+	assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+	_, err = s.ChangeUserBalanceByUserId(ctx, unknown, 100)
+	assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+	assert.NotContains(t, balanceRepo.balances, unknown)
+
+	s.accounts.ImplicitCreate = true
+
+	ub, err := s.GetAccountBalance(ctx, unknown)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, ub.Balance)
+	created, err := s.ChangeUserBalanceByUserId(ctx, unknown, 100)
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, 100.0, balanceRepo.balances[unknown])
+}
+
+func TestUserBalanceService_CloseAccount(t *testing.T) {
+	tests := []struct {
+		name             string
+		balance          float64
+		status           string
+		payout           bool
+		receiverCurrency string
+		expectedErr      error
+		expectedPayout   string
+		expectedBalances []float64
+	}{
+		{name: "Empty", expectedBalances: []float64{0, 10}},
+		{
+			name:             "Paid out of the system",
+			balance:          40,
+			expectedPayout:   model.OperationPayout,
+			expectedBalances: []float64{0, 10},
+		},
+		{
+			name:             "Paid out to another account",
+			balance:          40,
+			payout:           true,
+			expectedPayout:   model.OperationTransferOut,
+			expectedBalances: []float64{0, 50},
+		},
+		{
+			name:             "Owing",
+			balance:          -5,
+			expectedErr:      schemas.ErrorInvalidAccountStatus{},
+			expectedBalances: []float64{-5, 10},
+		},
+		{
+			name:             "Frozen",
+			balance:          40,
+			status:           model.AccountFrozen,
+			expectedErr:      schemas.ErrorAccountFrozen{},
+			expectedBalances: []float64{40, 10},
+		},
+		{
+			name:             "Payout in another currency",
+			balance:          40,
+			payout:           true,
+			receiverCurrency: "USD",
+			expectedErr:      schemas.ErrorCurrencyMismatch{},
+			expectedBalances: []float64{40, 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userId, receiverId := uuid.New(), uuid.New()
+			s, balanceRepo, logRepo, _ := newTestUserBalanceService(map[uuid.UUID]float64{
+				userId:     tt.balance,
+				receiverId: 10,
+			})
+			balanceRepo.overdrafts[userId] = 100
+			if tt.status != "" {
+				balanceRepo.statuses[userId] = tt.status
+			}
+			if tt.receiverCurrency != "" {
+				balanceRepo.currencies[receiverId] = tt.receiverCurrency
+			}
+			var payoutTo *uuid.UUID
+			if tt.payout {
+				payoutTo = &receiverId
+			}
+
+			closure, err := s.CloseAccount(context.Background(), userId, payoutTo, "customer request", "operator-1")
+			assert.Equal(t, tt.expectedBalances, []float64{balanceRepo.balances[userId], balanceRepo.balances[receiverId]})
+			if tt.expectedErr != nil {
+				assert.IsType(t, tt.expectedErr, err)
+				assert.Empty(t, logRepo.logs)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, model.AccountClosed, closure.Account.Status)
+			assert.Equal(t, 0.0, closure.Account.Balance)
+			assert.Equal(t, model.AccountClosed, balanceRepo.statuses[userId])
+			if tt.expectedPayout == "" {
+				assert.Nil(t, closure.Payout)
+				return
+			}
+			assert.Equal(t, tt.expectedPayout, closure.Payout.OperationType)
+			assert.Equal(t, tt.balance, closure.Payout.Amount)
+		})
+	}
+}
+
+func TestUserBalanceService_ApplyTransaction_CurrencyMismatch(t *testing.T) {
+	sender, receiver := uuid.New(), uuid.New()
+	s, balanceRepo, _, _ := newTestUserBalanceService(map[uuid.UUID]float64{sender: 100, receiver: 0})
+	balanceRepo.currencies[receiver] = "USD"
+
+	err := s.ApplyTransaction(context.Background(), sender, receiver, 10)
+	assert.IsType(t, schemas.ErrorCurrencyMismatch{}, err)
+	assert.Equal(t, map[uuid.UUID]float64{sender: 100, receiver: 0}, balanceRepo.balances)
+}
+
+func TestUserBalanceService_SearchAccounts(t *testing.T) {
+	balances := map[uuid.UUID]float64{}
+	for _, balance := range []float64{5, 50, 70, 70, 300, 1000} {
+		balances[uuid.New()] = balance
+	}
+	s, balanceRepo, _, _ := newTestUserBalanceService(balances)
+	for userId, balance := range balances {
+		if balance == 1000 {
+			balanceRepo.statuses[userId] = model.AccountFrozen
+		}
+	}
+	ctx := context.Background()
+	minBalance := 10.0
+
+	var got []float64
+	cursor := ""
+	for pages := 0; pages < 10; pages++ {
+		page, err := s.SearchAccounts(ctx, model.AccountFilter{MinBalance: &minBalance, Status: model.AccountActive},
+			model.AccountSortBalance, true, cursor, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, []model.AccountTotals{
+			{Currency: "RUB", Count: 4, TotalBalance: 490, MinBalance: 50, MaxBalance: 300},
+		}, page.Totals)
+		for _, ub := range page.Items {
+			got = append(got, ub.Balance)
+		}
+
+		cursor = page.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+	assert.Equal(t, []float64{300, 70, 70, 50}, got)
+
+	page, err := s.SearchAccounts(ctx, model.AccountFilter{}, model.AccountSortBalance, true, "", 2)
+	assert.NoError(t, err)
+	_, err = s.SearchAccounts(ctx, model.AccountFilter{}, model.AccountSortBalance, false, page.NextCursor, 2)
+	assert.IsType(t, schemas.ErrorInvalidAccount{}, err, "a cursor only works for its sort order")
+	_, err = s.SearchAccounts(ctx, model.AccountFilter{}, model.AccountSortBalance, true, "not a cursor", 2)
+	assert.IsType(t, schemas.ErrorInvalidAccount{}, err)
+	_, err = s.SearchAccounts(ctx, model.AccountFilter{}, "userId", true, "", 2)
+	assert.IsType(t, schemas.ErrorInvalidAccount{}, err)
+	_, err = s.SearchAccounts(ctx, model.AccountFilter{Status: "deleted"}, "", true, "", 2)
+	assert.IsType(t, schemas.ErrorInvalidAccount{}, err)
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+)
+
+type AuditService struct {
+	auditRepo repository.Audit
+	logger    logger.Logger
+}
+
+func NewAuditService(auditRepo repository.Audit, logger logger.Logger) *AuditService {
+	return &AuditService{auditRepo: auditRepo, logger: logger}
+}
+
+func (s AuditService) RecordAuditEntry(ctx context.Context, entry model.AuditEntry) error {
+	if err := s.auditRepo.Create(ctx, entry); err != nil {
+		s.logger.WithContext(ctx).WithFields(logger.Fields{
+			"method": entry.Method,
+			"route":  entry.Route,
+			"status": entry.Status,
+		}).Errorf("could not record audit entry, error: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (s AuditService) GetAuditEntries(ctx context.Context, filter model.AuditFilter, pageNum int, pageSize int) (
+	[]model.AuditEntry, error) {
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return nil, schemas.ErrorInvalidDateRange{
+			Message: fmt.Sprintf("from %s is not before to %s", filter.From.Format(time.RFC3339),
+				filter.To.Format(time.RFC3339)),
+		}
+	}
+
+	entries, err := s.auditRepo.GetAll(ctx, filter, pageNum, pageSize)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("could not get audit entries, error: %s", err.Error())
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+// maxSeriesDays bounds the days of a daily balance series.
+const maxSeriesDays = 366
+
+// BalanceHistoryService reconstructs past balances from the transaction log, starting from the latest
+// daily balance snapshot so that only the entries logged after it have to be summed.
+type BalanceHistoryService struct {
+	userBalanceRepo     repository.UserBalance
+	transactionLogRepo  repository.TransactionLog
+	balanceSnapshotRepo repository.BalanceSnapshot
+	accounts            config.AccountsConfig
+	days                businessDays
+	logger              logger.Logger
+	now                 func() time.Time
+}
+
+func NewBalanceHistoryService(userBalanceRepo repository.UserBalance, transactionLogRepo repository.TransactionLog,
+	balanceSnapshotRepo repository.BalanceSnapshot, accounts config.AccountsConfig, snapshots config.SnapshotsConfig,
+	logger logger.Logger) *BalanceHistoryService {
+	return &BalanceHistoryService{
+		userBalanceRepo:     userBalanceRepo,
+		transactionLogRepo:  transactionLogRepo,
+		balanceSnapshotRepo: balanceSnapshotRepo,
+		accounts:            accounts,
+		days:                newBusinessDays(snapshots),
+		logger:              logger,
+		now:                 time.Now,
+	}
+}
+
+// GetBalanceAt returns the balance a user had at the given time, that is every transaction log entry of
+// the user dated up to that time.
+func (s BalanceHistoryService) GetBalanceAt(ctx context.Context, userId uuid.UUID, at time.Time) (
+	model.HistoricalBalance, error) {
+	ub, exists, err := s.getAccount(ctx, userId)
+	if err != nil {
+		return model.HistoricalBalance{}, err
+	}
+	if !exists {
+		return model.HistoricalBalance{UserId: userId, At: at, Currency: ub.Currency}, nil
+	}
+
+	balance, err := s.balanceAt(ctx, userId, at)
+	if err != nil {
+		return model.HistoricalBalance{}, err
+	}
+
+	return model.HistoricalBalance{UserId: userId, At: at, Balance: balance, Currency: ub.Currency}, nil
+}
+
+// GetDailyBalances returns the closing balances of a user for the days from and to, both included, up to
+// the last closed day. Days without a snapshot, closed before snapshots were taken, are reconstructed.
+func (s BalanceHistoryService) GetDailyBalances(ctx context.Context, userId uuid.UUID, from time.Time,
+	to time.Time) (model.BalanceSeries, error) {
+	from, to = dateOf(from), dateOf(to)
+	if to.Before(from) {
+		return model.BalanceSeries{}, schemas.ErrorInvalidDateRange{
+			Message: fmt.Sprintf("from %s is after to %s", from.Format(dayFormat), to.Format(dayFormat)),
+		}
+	}
+	if to.Sub(from) >= maxSeriesDays*24*time.Hour {
+		return model.BalanceSeries{}, schemas.ErrorInvalidDateRange{
+			Message: fmt.Sprintf("a series covers at most %d days", maxSeriesDays),
+		}
+	}
+
+	ub, exists, err := s.getAccount(ctx, userId)
+	if err != nil {
+		return model.BalanceSeries{}, err
+	}
+	series := model.BalanceSeries{UserId: userId, Currency: ub.Currency, Items: []model.DailyBalance{}}
+	if !exists {
+		return series, nil
+	}
+
+	if last := s.days.lastClosed(s.now()); last.Before(to) {
+		to = last
+	}
+	if to.Before(from) {
+		return series, nil
+	}
+
+	snapshots, err := s.balanceSnapshotRepo.GetByUserIdBetween(ctx, userId, from, to)
+	if err != nil {
+		return model.BalanceSeries{}, err
+	}
+	byDay := make(map[string]model.BalanceSnapshot, len(snapshots))
+	for _, snapshot := range snapshots {
+		byDay[snapshot.Day.Format(dayFormat)] = snapshot
+	}
+
+	var previous *model.DailyBalance
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		item := model.DailyBalance{Day: day.Format(dayFormat), AsOf: s.days.closesAt(day)}
+
+		switch snapshot, ok := byDay[item.Day]; {
+		case ok:
+			item.AsOf, item.Balance = snapshot.AsOf, snapshot.Balance
+		case previous != nil:
+			changes, err := s.transactionLogRepo.SumChangesBetween(ctx, userId, previous.AsOf, item.AsOf)
+			if err != nil {
+				return model.BalanceSeries{}, err
+			}
+			item.Balance = roundCents(previous.Balance + changes)
+		default:
+			if item.Balance, err = s.balanceAt(ctx, userId, item.AsOf); err != nil {
+				return model.BalanceSeries{}, err
+			}
+		}
+
+		series.Items = append(series.Items, item)
+		previous = &series.Items[len(series.Items)-1]
+	}
+
+	return series, nil
+}
+
+// getAccount returns the account of a user, or an empty one in the default currency and false for
+// users without an account when accounts are created implicitly.
+func (s BalanceHistoryService) getAccount(ctx context.Context, userId uuid.UUID) (model.UserBalance, bool, error) {
+	ub, err := s.userBalanceRepo.GetByUserId(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		if !s.accounts.ImplicitCreate {
+			return model.UserBalance{}, false, accountNotFound(userId)
+		}
+		return model.UserBalance{UserId: userId, Currency: s.accounts.DefaultCurrency}, false, nil
+	}
+	if err != nil {
+		return model.UserBalance{}, false, err
+	}
+
+	return ub, true, nil
+}
+
+// balanceAt sums the latest snapshot of a user taken by the given time and the entries logged after it.
+func (s BalanceHistoryService) balanceAt(ctx context.Context, userId uuid.UUID, at time.Time) (float64, error) {
+	var from time.Time
+	var balance float64
+
+	snapshot, err := s.balanceSnapshotRepo.GetLatestAt(ctx, userId, at)
+	switch {
+	case err == nil:
+		from, balance = snapshot.AsOf, snapshot.Balance
+	case !errors.Is(err, sql.ErrNoRows):
+		return 0, err
+	}
+
+	changes, err := s.transactionLogRepo.SumChangesBetween(ctx, userId, from, at)
+	if err != nil {
+		s.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not sum balance changes of user, error: %s", err.Error())
+		return 0, err
+	}
+
+	return roundCents(balance + changes), nil
+}
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBalanceSnapshotRepo takes snapshots of the accounts opened by then from the entries of logs.
+type fakeBalanceSnapshotRepo struct {
+	snapshots []model.BalanceSnapshot
+	days      []time.Time
+	accounts  []model.UserBalance
+	logs      *fakeTransactionLogRepo
+}
+
+func (r *fakeBalanceSnapshotRepo) GetLatestAt(_ context.Context, userId uuid.UUID, at time.Time) (
+	model.BalanceSnapshot, error) {
+	var latest *model.BalanceSnapshot
+	for i, snapshot := range r.snapshots {
+		if snapshot.UserId != userId || snapshot.AsOf.After(at) {
+			continue
+		}
+		if latest == nil || snapshot.AsOf.After(latest.AsOf) {
+			latest = &r.snapshots[i]
+		}
+	}
+	if latest == nil {
+		return model.BalanceSnapshot{}, sql.ErrNoRows
+	}
+	return *latest, nil
+}
+
+func (r *fakeBalanceSnapshotRepo) Create(_ context.Context, snapshot model.BalanceSnapshot) (bool, error) {
+	for _, existing := range r.snapshots {
+		if existing.UserId == snapshot.UserId && existing.Day.Equal(snapshot.Day) {
+			return false, nil
+		}
+	}
+	r.snapshots = append(r.snapshots, snapshot)
+	return true, nil
+}
+
+func (r *fakeBalanceSnapshotRepo) GetByUserIdBetween(_ context.Context, userId uuid.UUID, from time.Time,
+	to time.Time) ([]model.BalanceSnapshot, error) {
+	var snapshots []model.BalanceSnapshot
+	for _, snapshot := range r.snapshots {
+		if snapshot.UserId == userId && !snapshot.Day.Before(from) && !snapshot.Day.After(to) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+func (r *fakeBalanceSnapshotRepo) GetLatestDay(context.Context) (*time.Time, error) {
+	var latest *time.Time
+	for i, day := range r.days {
+		if latest == nil || day.After(*latest) {
+			latest = &r.days[i]
+		}
+	}
+	return latest, nil
+}
+
+func (r *fakeBalanceSnapshotRepo) MarkDayTaken(_ context.Context, day time.Time, _ int64) error {
+	r.days = append(r.days, day)
+	return nil
+}
+
+func (r *fakeBalanceSnapshotRepo) CreateForDay(ctx context.Context, day time.Time, asOf time.Time) (int64, error) {
+	var created int64
+	for _, account := range r.accounts {
+		if account.CreatedAt.After(asOf) {
+			continue
+		}
+		userId := account.UserId
+
+		var from time.Time
+		var balance float64
+		if previous, err := r.GetLatestAt(ctx, userId, asOf.Add(-time.Nanosecond)); err == nil {
+			from, balance = previous.AsOf, previous.Balance
+		}
+		changes, _ := r.logs.SumChangesBetween(ctx, userId, from, asOf)
+
+		ok, _ := r.Create(ctx, model.BalanceSnapshot{UserId: userId, Day: day, AsOf: asOf, Balance: balance + changes})
+		if ok {
+			created++
+		}
+	}
+	return created, nil
+}
+
+type lockedTransactor struct {
+	fakeTransactor
+}
+
+func (lockedTransactor) TryAdvisoryLock(context.Context, int64) (bool, error) {
+	return false, nil
+}
+
+func TestBalanceHistoryService_GetBalanceAt(t *testing.T) {
+	user := uuid.New()
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	balanceRepo := newFakeUserBalanceRepo(map[uuid.UUID]float64{user: 65})
+	balanceRepo.currencies[user] = "USD"
+	logRepo := &fakeTransactionLogRepo{logs: []model.TransactionLog{
+		{UserId: user, Date: day.Add(1 * time.Hour), Amount: 100, OperationType: model.OperationCredit},
+		{UserId: user, Date: day.Add(2 * time.Hour), Amount: 30, OperationType: model.OperationTransferOut},
+		{UserId: uuid.New(), Date: day.Add(2 * time.Hour), Amount: 30, OperationType: model.OperationTransferIn},
+		{UserId: user, Date: day.Add(26 * time.Hour), Amount: 10.5, OperationType: model.OperationDebit},
+		{UserId: user, Date: day.Add(27 * time.Hour), Amount: 5.5, OperationType: model.OperationReversalCredit},
+	}}
+	snapshotRepo := &fakeBalanceSnapshotRepo{}
+	s := NewBalanceHistoryService(balanceRepo, logRepo, snapshotRepo, config.AccountsConfig{DefaultCurrency: "RUB"},
+		config.SnapshotsConfig{Cutoff: 24 * time.Hour, Timezone: "UTC"}, logger.NewDefault())
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		expected float64
+	}{
+		{name: "Before first entry", at: day, expected: 0},
+		{name: "At entry", at: day.Add(1 * time.Hour), expected: 100},
+		{name: "Between entries", at: day.Add(3 * time.Hour), expected: 70},
+		{name: "Next day", at: day.Add(26*time.Hour + time.Minute), expected: 59.5},
+		{name: "Now", at: time.Now(), expected: 65},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			balance, err := s.GetBalanceAt(ctx, user, tc.at)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, balance.Balance)
+			assert.Equal(t, "USD", balance.Currency)
+			assert.Equal(t, tc.at, balance.At)
+		})
+	}
+
+	// a snapshot replaces the entries logged up to its time, tamper with it to see it being used
+	snapshotRepo.snapshots = append(snapshotRepo.snapshots, model.BalanceSnapshot{
+		UserId: user, Day: day, AsOf: day.Add(24 * time.Hour), Balance: 1000,
+	})
+	balance, err := s.GetBalanceAt(ctx, user, day.Add(3*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 70.0, balance.Balance, "snapshots taken after the requested time are ignored")
+	balance, err = s.GetBalanceAt(ctx, user, day.Add(26*time.Hour+time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 989.5, balance.Balance)
+
+	_, err = s.GetBalanceAt(ctx, uuid.New(), day)
+	assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+
+	s.accounts.ImplicitCreate = true
+	balance, err = s.GetBalanceAt(ctx, uuid.New(), day)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, balance.Balance)
+	assert.Equal(t, "RUB", balance.Currency)
+}
+
+func TestBalanceSnapshotJob_TakeDue(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	assert.NoError(t, err)
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	// days close at 18:00 in Moscow, 15:00 UTC
+	closes := time.Date(2021, 3, 1, 15, 0, 0, 0, time.UTC)
+
+	logRepo := &fakeTransactionLogRepo{logs: []model.TransactionLog{
+		{UserId: alice, Date: closes.Add(-time.Hour), Amount: 100, OperationType: model.OperationCredit},
+		{UserId: alice, Date: closes, Amount: 20, OperationType: model.OperationTransferOut},
+		{UserId: bob, Date: closes, Amount: 20, OperationType: model.OperationTransferIn},
+		{UserId: alice, Date: closes.Add(time.Second), Amount: 50, OperationType: model.OperationDebit},
+		{UserId: bob, Date: closes.Add(48 * time.Hour), Amount: 5, OperationType: model.OperationDebit},
+	}}
+	snapshotRepo := &fakeBalanceSnapshotRepo{accounts: []model.UserBalance{{UserId: alice}, {UserId: bob}},
+		logs: logRepo}
+	cfg := config.SnapshotsConfig{Cutoff: 18 * time.Hour, Timezone: "Europe/Moscow", Delay: time.Minute,
+		BackfillDays: 1}
+	job := NewBalanceSnapshotJob(snapshotRepo, fakeTransactor{}, cfg, logger.NewDefault())
+	ctx := context.Background()
+
+	assert.Equal(t, closes.In(moscow), job.days.closesAt(day))
+
+	// the day after is over but not settled yet, the day before is backfilled
+	job.now = func() time.Time { return closes.Add(24*time.Hour + 30*time.Second) }
+	taken, err := job.TakeDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, taken)
+	assert.Equal(t, []model.BalanceSnapshot{
+		{UserId: alice, Day: day.AddDate(0, 0, -1), AsOf: closes.Add(-24 * time.Hour).In(moscow), Balance: 0},
+		{UserId: bob, Day: day.AddDate(0, 0, -1), AsOf: closes.Add(-24 * time.Hour).In(moscow), Balance: 0},
+		{UserId: alice, Day: day, AsOf: closes.In(moscow), Balance: 80},
+		{UserId: bob, Day: day, AsOf: closes.In(moscow), Balance: 20},
+	}, snapshotRepo.snapshots)
+
+	// another instance holds the lock
+	job.now = func() time.Time { return closes.Add(72 * time.Hour) }
+	job.transactor = lockedTransactor{}
+	taken, err = job.TakeDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, taken)
+
+	// missed days are backfilled up to the last closed one
+	job.transactor = fakeTransactor{}
+	taken, err = job.TakeDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, taken)
+	assert.Len(t, snapshotRepo.snapshots, 8)
+	latest, _ := snapshotRepo.GetLatestAt(ctx, alice, closes.Add(72*time.Hour))
+	assert.Equal(t, day.AddDate(0, 0, 2), latest.Day)
+	assert.Equal(t, 30.0, latest.Balance)
+	latest, _ = snapshotRepo.GetLatestAt(ctx, bob, closes.Add(72*time.Hour))
+	assert.Equal(t, 15.0, latest.Balance)
+
+	taken, err = job.TakeDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, taken)
+}
+
+func TestBalanceSnapshotJob_TakeDue_BeforeAccountsOpened(t *testing.T) {
+	user := uuid.New()
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	logRepo := &fakeTransactionLogRepo{logs: []model.TransactionLog{
+		{UserId: user, Date: day.Add(-12 * time.Hour), Amount: 100, OperationType: model.OperationCredit},
+	}}
+	// the account is opened within the last day of the backfill, the days before it have nothing to snapshot
+	snapshotRepo := &fakeBalanceSnapshotRepo{
+		accounts: []model.UserBalance{{UserId: user, CreatedAt: day.Add(-13 * time.Hour)}},
+		logs:     logRepo,
+	}
+	job := NewBalanceSnapshotJob(snapshotRepo, fakeTransactor{}, config.SnapshotsConfig{Cutoff: 24 * time.Hour,
+		Timezone: "UTC", BackfillDays: 31}, logger.NewDefault())
+	job.now = func() time.Time { return day.Add(time.Hour) }
+	ctx := context.Background()
+
+	taken, err := job.TakeDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 32, taken)
+	assert.Len(t, snapshotRepo.days, 32)
+	assert.Equal(t, []model.BalanceSnapshot{
+		{UserId: user, Day: day.AddDate(0, 0, -1), AsOf: day, Balance: 100},
+	}, snapshotRepo.snapshots)
+
+	taken, err = job.TakeDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, taken)
+}
+
+func TestBalanceHistoryService_GetDailyBalances(t *testing.T) {
+	user := uuid.New()
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	balanceRepo := newFakeUserBalanceRepo(map[uuid.UUID]float64{user: 0})
+	logRepo := &fakeTransactionLogRepo{logs: []model.TransactionLog{
+		{UserId: user, Date: day.Add(time.Hour), Amount: 100, OperationType: model.OperationCredit},
+		{UserId: user, Date: day.Add(49 * time.Hour), Amount: 40, OperationType: model.OperationDebit},
+		{UserId: user, Date: day.Add(73 * time.Hour), Amount: 10, OperationType: model.OperationCredit},
+	}}
+	// the snapshot of the second day differs from the log to tell it apart
+	snapshotRepo := &fakeBalanceSnapshotRepo{snapshots: []model.BalanceSnapshot{
+		{UserId: user, Day: day.AddDate(0, 0, 1), AsOf: day.Add(48 * time.Hour), Balance: 90},
+	}}
+	s := NewBalanceHistoryService(balanceRepo, logRepo, snapshotRepo, config.AccountsConfig{DefaultCurrency: "RUB"},
+		config.SnapshotsConfig{Cutoff: 24 * time.Hour, Timezone: "UTC"}, logger.NewDefault())
+	s.now = func() time.Time { return day.Add(80 * time.Hour) }
+	ctx := context.Background()
+
+	series, err := s.GetDailyBalances(ctx, user, day.AddDate(0, 0, -1), day.AddDate(0, 0, 10))
+	assert.NoError(t, err)
+	assert.Equal(t, "RUB", series.Currency)
+	assert.Equal(t, []model.DailyBalance{
+		{Day: "2021-02-28", AsOf: day, Balance: 0},
+		{Day: "2021-03-01", AsOf: day.Add(24 * time.Hour), Balance: 100},
+		{Day: "2021-03-02", AsOf: day.Add(48 * time.Hour), Balance: 90},
+		{Day: "2021-03-03", AsOf: day.Add(72 * time.Hour), Balance: 50},
+	}, series.Items, "the days after the last closed one are left out")
+
+	series, err = s.GetDailyBalances(ctx, user, day.AddDate(0, 0, 10), day.AddDate(0, 0, 20))
+	assert.NoError(t, err)
+	assert.Empty(t, series.Items)
+
+	_, err = s.GetDailyBalances(ctx, user, day, day.AddDate(0, 0, -1))
+	assert.IsType(t, schemas.ErrorInvalidDateRange{}, err)
+	_, err = s.GetDailyBalances(ctx, user, day, day.AddDate(0, 0, maxSeriesDays))
+	assert.IsType(t, schemas.ErrorInvalidDateRange{}, err)
+	_, err = s.GetDailyBalances(ctx, uuid.New(), day, day)
+	assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+)
+
+// balanceSnapshotLockKey is the advisory lock letting a single instance take snapshots at a time.
+const balanceSnapshotLockKey int64 = 7301002
+
+const dayFormat = "2006-01-02"
+
+// businessDays tells when days close. Days are dates at midnight UTC whatever the timezone they
+// close in, the way they are stored in the database.
+type businessDays struct {
+	cutoff   time.Duration
+	location *time.Location
+}
+
+func newBusinessDays(cfg config.SnapshotsConfig) businessDays {
+	location, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	return businessDays{cutoff: cfg.Cutoff, location: location}
+}
+
+// closesAt returns when the day closes, cutoff after its midnight in wall clock time so days with a
+// daylight saving change close at the same time of day as the others.
+func (d businessDays) closesAt(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), int(d.cutoff/time.Hour), 0, 0, 0, d.location).
+		Add(d.cutoff % time.Hour)
+}
+
+// lastClosed returns the latest day closed at the given time.
+func (d businessDays) lastClosed(now time.Time) time.Time {
+	day := dateOf(now.In(d.location))
+	for d.closesAt(day).After(now) {
+		day = day.AddDate(0, 0, -1)
+	}
+
+	return day
+}
+
+// dateOf returns the date of t as a day.
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// BalanceSnapshotJob records the closing balance of every account once its day closed. Days are
+// snapshotted oldest first from the one after the latest day taken, so days missed while no instance
+// ran are backfilled, and an advisory lock keeps other instances waiting meanwhile.
+type BalanceSnapshotJob struct {
+	balanceSnapshotRepo repository.BalanceSnapshot
+	transactor          repository.Transactor
+	days                businessDays
+	cfg                 config.SnapshotsConfig
+	logger              logger.Logger
+	now                 func() time.Time
+}
+
+func NewBalanceSnapshotJob(balanceSnapshotRepo repository.BalanceSnapshot, transactor repository.Transactor,
+	cfg config.SnapshotsConfig, logger logger.Logger) *BalanceSnapshotJob {
+	return &BalanceSnapshotJob{
+		balanceSnapshotRepo: balanceSnapshotRepo,
+		transactor:          transactor,
+		days:                newBusinessDays(cfg),
+		cfg:                 cfg,
+		logger:              logger,
+		now:                 time.Now,
+	}
+}
+
+// Run takes due snapshots every poll interval until ctx is cancelled.
+func (j *BalanceSnapshotJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.TakeDue(ctx); err != nil && ctx.Err() == nil {
+			j.logger.Errorf("could not take balance snapshots, error: %s", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// TakeDue snapshots every closed day not snapshotted yet, each day in its own transaction, and
+// returns how many days were snapshotted.
+func (j *BalanceSnapshotJob) TakeDue(ctx context.Context) (int, error) {
+	taken := 0
+
+	for ctx.Err() == nil {
+		found := false
+
+		err := j.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			locked, err := j.transactor.TryAdvisoryLock(ctx, balanceSnapshotLockKey)
+			if err != nil || !locked {
+				return err
+			}
+
+			day, ok, err := j.nextDay(ctx)
+			if err != nil || !ok {
+				return err
+			}
+
+			found = true
+			created, err := j.balanceSnapshotRepo.CreateForDay(ctx, day, j.days.closesAt(day))
+			if err != nil {
+				return err
+			}
+			// days before any account was opened have no snapshot, they are taken all the same
+			if err = j.balanceSnapshotRepo.MarkDayTaken(ctx, day, created); err != nil {
+				return err
+			}
+
+			j.logger.WithField("day", day.Format(dayFormat)).Infof("took %d balance snapshots", created)
+			return nil
+		})
+		if err != nil {
+			return taken, err
+		}
+		if !found {
+			break
+		}
+
+		taken++
+	}
+
+	return taken, nil
+}
+
+// nextDay returns the day to snapshot next, false when every closed day is snapshotted. Days close
+// for snapshots delay after their cut-off.
+func (j *BalanceSnapshotJob) nextDay(ctx context.Context) (time.Time, bool, error) {
+	last := j.days.lastClosed(j.now().Add(-j.cfg.Delay))
+
+	latest, err := j.balanceSnapshotRepo.GetLatestDay(ctx)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	next := last.AddDate(0, 0, -j.cfg.BackfillDays)
+	if latest != nil {
+		next = dateOf(*latest).AddDate(0, 0, 1)
+	}
+
+	return next, !next.After(last), nil
+}
@@ -0,0 +1,359 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+// errBatchKeyTaken rolls back a batch whose idempotency key was stored by a concurrent request.
+var errBatchKeyTaken = errors.New("idempotency key taken by a concurrent batch")
+
+// BatchService applies ordered lists of balance operations in a single transaction.
+type BatchService struct {
+	userBalance     UserBalance
+	userBalanceRepo repository.UserBalance
+	batchRepo       repository.Batch
+	transactor      repository.Transactor
+	fees            Fees
+	cfg             config.BatchConfig
+	accounts        config.AccountsConfig
+	logger          logger.Logger
+}
+
+func NewBatchService(userBalance UserBalance, userBalanceRepo repository.UserBalance, batchRepo repository.Batch,
+	transactor repository.Transactor, fees Fees, cfg config.BatchConfig, accounts config.AccountsConfig,
+	logger logger.Logger) *BatchService {
+	return &BatchService{
+		userBalance:     userBalance,
+		userBalanceRepo: userBalanceRepo,
+		batchRepo:       batchRepo,
+		transactor:      transactor,
+		fees:            fees,
+		cfg:             cfg,
+		accounts:        accounts,
+		logger:          logger,
+	}
+}
+
+// ExecuteBatch validates all operations and applies them in order, either all of them or none.
+// A batch with an idempotency key is applied once, repeating it returns the stored result. On a
+// rejected or failed batch both the per-item results and the error are returned.
+func (s BatchService) ExecuteBatch(ctx context.Context, idempotencyKey string,
+	operations []model.BatchOperation) (model.Batch, error) {
+	log := s.logger.WithContext(ctx)
+
+	batch := model.Batch{
+		Id:        uuid.New(),
+		CreatedAt: time.Now(),
+	}
+	if idempotencyKey != "" {
+		batch.IdempotencyKey = &idempotencyKey
+	}
+
+	if err := s.validate(&batch, operations); err != nil {
+		log.Warnf("batch rejected, error: %s", err.Error())
+		return batch, err
+	}
+	if err := s.checkAccounts(ctx, &batch, operations); err != nil {
+		log.Warnf("batch rejected, error: %s", err.Error())
+		return batch, err
+	}
+
+	requestHash, err := hashOperations(operations)
+	if err != nil {
+		return model.Batch{}, err
+	}
+	batch.RequestHash = requestHash
+
+	var stored *model.Batch
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if idempotencyKey != "" {
+			existing, err := s.batchRepo.GetByIdempotencyKey(ctx, idempotencyKey)
+			if err == nil {
+				stored = &existing
+				return nil
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+
+		if err := s.lockAccounts(ctx, operations); err != nil {
+			return err
+		}
+		if err := s.apply(ctx, &batch, operations); err != nil {
+			return err
+		}
+
+		created, err := s.batchRepo.Create(ctx, batch)
+		if err != nil {
+			return err
+		}
+		if !created {
+			return errBatchKeyTaken
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, errBatchKeyTaken) {
+		existing, getErr := s.batchRepo.GetByIdempotencyKey(ctx, idempotencyKey)
+		if getErr != nil {
+			return model.Batch{}, getErr
+		}
+		stored, err = &existing, nil
+	}
+
+	if err != nil {
+		log.WithField("batch_id", batch.Id).Errorf("could not apply batch, error: %s", err.Error())
+		return batch, err
+	}
+
+	if stored != nil {
+		if stored.RequestHash != requestHash {
+			return model.Batch{}, schemas.ErrorIdempotencyKeyReused{
+				Message: fmt.Sprintf("idempotency key %q was already used for a different batch", idempotencyKey),
+			}
+		}
+
+		log.WithField("batch_id", stored.Id).Infof("replaying batch for idempotency key")
+		stored.Status = model.BatchApplied
+		stored.Replayed = true
+		return *stored, nil
+	}
+
+	log.WithField("batch_id", batch.Id).Infof("applied batch of %v operations", len(operations))
+	return batch, nil
+}
+
+// validate checks every operation up front, so that a batch with an invalid operation does not
+// touch any balance.
+func (s BatchService) validate(batch *model.Batch, operations []model.BatchOperation) error {
+	if len(operations) == 0 {
+		batch.Status = model.BatchRejected
+		return schemas.ErrorInvalidBatch{Message: "batch has no operations"}
+	}
+
+	if len(operations) > s.cfg.MaxSize {
+		batch.Status = model.BatchRejected
+		return schemas.ErrorInvalidBatch{
+			Message: fmt.Sprintf("batch has %v operations, at most %v are allowed", len(operations), s.cfg.MaxSize),
+		}
+	}
+
+	batch.Items = make(model.BatchItemResults, len(operations))
+	invalid := 0
+	for i, operation := range operations {
+		batch.Items[i] = model.BatchItemResult{Index: i, Type: operation.Type, Status: model.BatchItemSkipped}
+
+		if problem := validateOperation(operation); problem != "" {
+			batch.Items[i].Status = model.BatchItemInvalid
+			batch.Items[i].Error = problem
+			invalid++
+		}
+	}
+
+	if invalid > 0 {
+		batch.Status = model.BatchRejected
+		return schemas.ErrorInvalidBatch{
+			Message: fmt.Sprintf("batch has %v invalid operations", invalid),
+		}
+	}
+
+	return nil
+}
+
+// checkAccounts rejects a batch referencing accounts that do not exist. When accounts are opened on their first
+// credit, the account credited by an operation exists for the operations after it.
+func (s BatchService) checkAccounts(ctx context.Context, batch *model.Batch,
+	operations []model.BatchOperation) error {
+	exists := map[uuid.UUID]bool{}
+	missing := 0
+	for i, operation := range operations {
+		userIds := []uuid.UUID{operation.UserId}
+		if operation.Type == model.BatchOperationTransfer {
+			userIds = []uuid.UUID{operation.SenderId, operation.ReceiverId}
+		}
+
+		for _, userId := range userIds {
+			found, ok := exists[userId]
+			if !ok {
+				var err error
+				found, err = s.userBalanceRepo.CheckIfExistsByUserId(ctx, userId)
+				if err != nil {
+					return err
+				}
+				exists[userId] = found
+			}
+
+			if !found && operation.Type == model.BatchOperationCredit && s.accounts.ImplicitCreate {
+				exists[userId] = true
+				continue
+			}
+			if !found {
+				batch.Items[i].Status = model.BatchItemInvalid
+				batch.Items[i].Error = fmt.Sprintf("user balance of %v not found", userId)
+				missing++
+				break
+			}
+		}
+	}
+
+	if missing > 0 {
+		batch.Status = model.BatchRejected
+		return schemas.ErrorInvalidBatch{
+			Message: fmt.Sprintf("batch has %v operations on accounts that do not exist", missing),
+		}
+	}
+
+	return nil
+}
+
+// lockAccounts locks every existing account of a batch, with the fee revenue accounts of their currencies, in a
+// stable order before the operations run. The operations lock their own accounts one after another, which
+// would deadlock with a concurrent batch locking some of the same accounts in another order.
+func (s BatchService) lockAccounts(ctx context.Context, operations []model.BatchOperation) error {
+	seen := map[uuid.UUID]bool{}
+	var userIds []uuid.UUID
+	lock := func(userId uuid.UUID) {
+		if !seen[userId] {
+			seen[userId] = true
+			userIds = append(userIds, userId)
+		}
+	}
+
+	for _, operation := range operations {
+		for _, userId := range []uuid.UUID{operation.UserId, operation.SenderId, operation.ReceiverId} {
+			if userId == uuid.Nil || seen[userId] {
+				continue
+			}
+
+			ub, err := s.userBalanceRepo.GetByUserId(ctx, userId)
+			if errors.Is(err, sql.ErrNoRows) {
+				// opened by a credit of the batch, nobody else can lock it yet
+				seen[userId] = true
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			lock(userId)
+			if revenueAccountId, err := s.fees.RevenueAccount(ub.Currency); err == nil {
+				lock(revenueAccountId)
+			}
+		}
+	}
+
+	_, err := lockBalances(ctx, s.userBalanceRepo, userIds...)
+	return err
+}
+
+func validateOperation(operation model.BatchOperation) string {
+	if operation.Amount <= 0 || math.IsNaN(operation.Amount) || math.IsInf(operation.Amount, 0) {
+		return fmt.Sprintf("amount must be positive, got %v", operation.Amount)
+	}
+
+	switch operation.Type {
+	case model.BatchOperationCredit, model.BatchOperationDebit:
+		if operation.UserId == uuid.Nil {
+			return "userId is required"
+		}
+	case model.BatchOperationTransfer:
+		if operation.SenderId == uuid.Nil || operation.ReceiverId == uuid.Nil {
+			return "senderId and receiverId are required"
+		}
+		if operation.SenderId == operation.ReceiverId {
+			return "senderId and receiverId must differ"
+		}
+	default:
+		return fmt.Sprintf("unknown operation type %q, must be credit, debit or transfer", operation.Type)
+	}
+
+	return ""
+}
+
+// apply runs the operations in order within the batch transaction. When one fails, the operations
+// before it are reported as rolled back and the ones after it as skipped.
+func (s BatchService) apply(ctx context.Context, batch *model.Batch, operations []model.BatchOperation) error {
+	for i, operation := range operations {
+		item := &batch.Items[i]
+
+		if err := s.applyOperation(ctx, operation, item); err != nil {
+			batch.Status = model.BatchFailed
+			for j := 0; j < i; j++ {
+				batch.Items[j].Status = model.BatchItemRolledBack
+			}
+			item.Status = model.BatchItemFailed
+			item.Error = err.Error()
+
+			if isBusinessError(err) {
+				return schemas.ErrorBatchFailed{
+					Message: fmt.Sprintf("operation %v failed, the batch was rolled back: %s", i, err.Error()),
+				}
+			}
+			return err
+		}
+
+		item.Status = model.BatchItemApplied
+	}
+
+	batch.Status = model.BatchApplied
+	return nil
+}
+
+func (s BatchService) applyOperation(ctx context.Context, operation model.BatchOperation,
+	item *model.BatchItemResult) error {
+	switch operation.Type {
+	case model.BatchOperationCredit, model.BatchOperationDebit:
+		amount := operation.Amount
+		if operation.Type == model.BatchOperationDebit {
+			amount = -amount
+		}
+
+		created, err := s.userBalance.ChangeUserBalanceByUserId(ctx, operation.UserId, amount)
+		if err != nil {
+			return err
+		}
+
+		balance, err := s.userBalance.GetBalanceByUserId(ctx, operation.UserId)
+		if err != nil {
+			return err
+		}
+
+		item.Created = created
+		item.Balance = &balance
+		return nil
+	default:
+		return s.userBalance.ApplyTransaction(ctx, operation.SenderId, operation.ReceiverId, operation.Amount)
+	}
+}
+
+// isBusinessError reports whether err is a rejected operation rather than an infrastructure failure.
+func isBusinessError(err error) bool {
+	var businessErr schemas.BusinessError
+	return errors.As(err, &businessErr)
+}
+
+func hashOperations(operations []model.BatchOperation) (string, error) {
+	data, err := json.Marshal(operations)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
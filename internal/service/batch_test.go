@@ -0,0 +1,323 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLedger is an in-memory UserBalance whose transactor restores the balances when the
+// transaction function fails, like a rolled back database transaction. Transfers are charged fee
+// once they are written, like the fee following a transfer in the same transaction, into the
+// revenue account when one is set.
+type fakeLedger struct {
+	balances map[uuid.UUID]float64
+	batches  map[string]model.Batch
+	fee      float64
+	revenue  uuid.UUID
+}
+
+func newFakeLedger(balances map[uuid.UUID]float64) *fakeLedger {
+	return &fakeLedger{balances: balances, batches: map[string]model.Batch{}}
+}
+
+func (l *fakeLedger) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	balances := make(map[uuid.UUID]float64, len(l.balances))
+	for id, balance := range l.balances {
+		balances[id] = balance
+	}
+	batches := make(map[string]model.Batch, len(l.batches))
+	for key, batch := range l.batches {
+		batches[key] = batch
+	}
+
+	if err := fn(ctx); err != nil {
+		l.balances, l.batches = balances, batches
+		return err
+	}
+
+	return nil
+}
+
+func (l *fakeLedger) WithinSavepoint(ctx context.Context, fn func(ctx context.Context) error) error {
+	return l.WithinTransaction(ctx, fn)
+}
+
+func (l *fakeLedger) TryAdvisoryLock(context.Context, int64) (bool, error) {
+	return true, nil
+}
+
+func (l *fakeLedger) GetBalanceByUserId(_ context.Context, userId uuid.UUID) (float64, error) {
+	return l.balances[userId], nil
+}
+
+func (l *fakeLedger) ChangeUserBalanceByUserId(_ context.Context, userId uuid.UUID, changeAmount float64) (
+	bool, error) {
+	balance, ok := l.balances[userId]
+	if changeAmount < 0 {
+		if !ok {
+			return false, schemas.ErrorUserBalanceNotFound{Message: "user balance not found"}
+		}
+		if balance+changeAmount < 0 {
+			return false, schemas.ErrorNotEnoughFunds{Message: "not enough funds"}
+		}
+	}
+
+	l.balances[userId] = balance + changeAmount
+	return !ok, nil
+}
+
+func (l *fakeLedger) ApplyTransaction(_ context.Context, senderId uuid.UUID, receiverId uuid.UUID,
+	amount float64) error {
+	if l.balances[senderId] < amount {
+		return schemas.ErrorNotEnoughFunds{Message: "not enough funds"}
+	}
+
+	l.balances[senderId] -= amount
+	l.balances[receiverId] += amount
+
+	if l.balances[senderId] < l.fee {
+		return schemas.ErrorNotEnoughFunds{Message: "not enough funds for the fee"}
+	}
+	l.balances[senderId] -= l.fee
+	return nil
+}
+
+func (l *fakeLedger) QuoteFee(_ context.Context, operation string, userId uuid.UUID, amount float64) (
+	model.FeeQuote, error) {
+	return model.FeeQuote{Operation: operation, UserId: userId, Amount: amount, Fee: l.fee, Total: amount + l.fee}, nil
+}
+
+func (l *fakeLedger) RevenueAccount(currency string) (uuid.UUID, error) {
+	if l.revenue == uuid.Nil {
+		return uuid.UUID{}, fmt.Errorf("no revenue account is configured for fees in %s", currency)
+	}
+	return l.revenue, nil
+}
+
+func (l *fakeLedger) Create(_ context.Context, batch model.Batch) (bool, error) {
+	if batch.IdempotencyKey == nil {
+		return true, nil
+	}
+	if _, ok := l.batches[*batch.IdempotencyKey]; ok {
+		return false, nil
+	}
+
+	l.batches[*batch.IdempotencyKey] = batch
+	return true, nil
+}
+
+func (l *fakeLedger) GetByIdempotencyKey(_ context.Context, key string) (model.Batch, error) {
+	batch, ok := l.batches[key]
+	if !ok {
+		return model.Batch{}, sql.ErrNoRows
+	}
+
+	return batch, nil
+}
+
+// batchService builds a batch service over the ledger opening accounts on their first credit, the accounts of the
+// ledger exist.
+func (ts *testServices) batchService(ledger *fakeLedger) *BatchService {
+	for id, balance := range ledger.balances {
+		ts.balanceRepo.balances[id] = balance
+	}
+
+	return NewBatchService(ledger, ts.balanceRepo, ledger, ledger, ledger, config.BatchConfig{MaxSize: 3},
+		config.AccountsConfig{ImplicitCreate: true}, logger.NewDefault())
+}
+
+func TestBatchService_ExecuteBatch(t *testing.T) {
+	alice, bob, carol, dave := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	tests := []struct {
+		name             string
+		operations       []model.BatchOperation
+		expectedStatus   string
+		expectedItems    []string
+		expectedErr      error
+		expectedBalances map[uuid.UUID]float64
+	}{
+		{
+			name: "Ok",
+			operations: []model.BatchOperation{
+				{Type: model.BatchOperationCredit, UserId: carol, Amount: 10},
+				{Type: model.BatchOperationDebit, UserId: alice, Amount: 20},
+				{Type: model.BatchOperationTransfer, SenderId: alice, ReceiverId: bob, Amount: 30},
+			},
+			expectedStatus:   model.BatchApplied,
+			expectedItems:    []string{model.BatchItemApplied, model.BatchItemApplied, model.BatchItemApplied},
+			expectedBalances: map[uuid.UUID]float64{alice: 50, bob: 30, carol: 10},
+		},
+		{
+			name: "Credited account exists for the next operations",
+			operations: []model.BatchOperation{
+				{Type: model.BatchOperationCredit, UserId: carol, Amount: 10},
+				{Type: model.BatchOperationTransfer, SenderId: carol, ReceiverId: bob, Amount: 5},
+			},
+			expectedStatus:   model.BatchApplied,
+			expectedItems:    []string{model.BatchItemApplied, model.BatchItemApplied},
+			expectedBalances: map[uuid.UUID]float64{alice: 100, bob: 5, carol: 5},
+		},
+		{
+			name: "Unknown account rejects the batch",
+			operations: []model.BatchOperation{
+				{Type: model.BatchOperationDebit, UserId: alice, Amount: 10},
+				{Type: model.BatchOperationTransfer, SenderId: alice, ReceiverId: dave, Amount: 1},
+				{Type: model.BatchOperationDebit, UserId: dave, Amount: 1},
+			},
+			expectedStatus:   model.BatchRejected,
+			expectedItems:    []string{model.BatchItemSkipped, model.BatchItemInvalid, model.BatchItemInvalid},
+			expectedErr:      schemas.ErrorInvalidBatch{},
+			expectedBalances: map[uuid.UUID]float64{alice: 100, bob: 0},
+		},
+		{
+			name: "Failed operation rolls back the batch",
+			operations: []model.BatchOperation{
+				{Type: model.BatchOperationCredit, UserId: bob, Amount: 10},
+				{Type: model.BatchOperationTransfer, SenderId: bob, ReceiverId: alice, Amount: 50},
+				{Type: model.BatchOperationDebit, UserId: alice, Amount: 1},
+			},
+			expectedStatus:   model.BatchFailed,
+			expectedItems:    []string{model.BatchItemRolledBack, model.BatchItemFailed, model.BatchItemSkipped},
+			expectedErr:      schemas.ErrorBatchFailed{},
+			expectedBalances: map[uuid.UUID]float64{alice: 100, bob: 0},
+		},
+		{
+			name: "Invalid operation rejects the batch",
+			operations: []model.BatchOperation{
+				{Type: model.BatchOperationCredit, UserId: bob, Amount: 10},
+				{Type: model.BatchOperationTransfer, SenderId: alice, ReceiverId: alice, Amount: 1},
+				{Type: "refund", UserId: alice, Amount: 1},
+			},
+			expectedStatus:   model.BatchRejected,
+			expectedItems:    []string{model.BatchItemSkipped, model.BatchItemInvalid, model.BatchItemInvalid},
+			expectedErr:      schemas.ErrorInvalidBatch{},
+			expectedBalances: map[uuid.UUID]float64{alice: 100, bob: 0},
+		},
+		{
+			name: "Amounts that are not numbers reject the batch",
+			operations: []model.BatchOperation{
+				{Type: model.BatchOperationDebit, UserId: alice, Amount: math.NaN()},
+				{Type: model.BatchOperationTransfer, SenderId: alice, ReceiverId: bob, Amount: math.Inf(1)},
+			},
+			expectedStatus:   model.BatchRejected,
+			expectedItems:    []string{model.BatchItemInvalid, model.BatchItemInvalid},
+			expectedErr:      schemas.ErrorInvalidBatch{},
+			expectedBalances: map[uuid.UUID]float64{alice: 100, bob: 0},
+		},
+		{
+			name: "Too many operations",
+			operations: []model.BatchOperation{
+				{Type: model.BatchOperationCredit, UserId: bob, Amount: 1},
+				{Type: model.BatchOperationCredit, UserId: bob, Amount: 1},
+				{Type: model.BatchOperationCredit, UserId: bob, Amount: 1},
+				{Type: model.BatchOperationCredit, UserId: bob, Amount: 1},
+			},
+			expectedStatus:   model.BatchRejected,
+			expectedErr:      schemas.ErrorInvalidBatch{},
+			expectedBalances: map[uuid.UUID]float64{alice: 100, bob: 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := newFakeLedger(map[uuid.UUID]float64{alice: 100, bob: 0})
+
+			batch, err := newTestServices(map[uuid.UUID]float64{}).batchService(ledger).ExecuteBatch(context.Background(), "", tt.operations)
+			if tt.expectedErr != nil {
+				assert.IsType(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.expectedStatus, batch.Status)
+			var items []string
+			for _, item := range batch.Items {
+				items = append(items, item.Status)
+			}
+			assert.Equal(t, tt.expectedItems, items)
+			assert.Equal(t, tt.expectedBalances, ledger.balances)
+		})
+	}
+}
+
+func TestBatchService_ExecuteBatch_LocksAccountsUpFront(t *testing.T) {
+	alice, bob, carol, revenue := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	ledger := newFakeLedger(map[uuid.UUID]float64{alice: 100, bob: 0, revenue: 0})
+	ledger.revenue = revenue
+	ts := newTestServices(map[uuid.UUID]float64{})
+	s := ts.batchService(ledger)
+
+	_, err := s.ExecuteBatch(context.Background(), "", []model.BatchOperation{
+		{Type: model.BatchOperationTransfer, SenderId: alice, ReceiverId: bob, Amount: 10},
+		{Type: model.BatchOperationTransfer, SenderId: bob, ReceiverId: alice, Amount: 5},
+		{Type: model.BatchOperationCredit, UserId: carol, Amount: 1},
+	})
+	assert.NoError(t, err)
+
+	// every account of the batch and the revenue account, in the same order whatever the operations,
+	// the account the batch opens left out
+	expected := []uuid.UUID{alice, bob, revenue}
+	sort.Slice(expected, func(i, j int) bool {
+		return bytes.Compare(expected[i][:], expected[j][:]) < 0
+	})
+	assert.Equal(t, expected, ts.balanceRepo.locked)
+}
+
+func TestBatchService_ExecuteBatch_IdempotencyKey(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	ledger := newFakeLedger(map[uuid.UUID]float64{alice: 100, bob: 0})
+	s := newTestServices(map[uuid.UUID]float64{}).batchService(ledger)
+	ctx := context.Background()
+
+	operations := []model.BatchOperation{
+		{Type: model.BatchOperationTransfer, SenderId: alice, ReceiverId: bob, Amount: 40},
+	}
+
+	first, err := s.ExecuteBatch(ctx, "payroll-2021-10", operations)
+	assert.NoError(t, err)
+	assert.False(t, first.Replayed)
+
+	second, err := s.ExecuteBatch(ctx, "payroll-2021-10", operations)
+	assert.NoError(t, err)
+	assert.True(t, second.Replayed)
+	assert.Equal(t, first.Id, second.Id)
+	assert.Equal(t, model.BatchApplied, second.Status)
+	assert.Equal(t, map[uuid.UUID]float64{alice: 60, bob: 40}, ledger.balances)
+
+	operations[0].Amount = 50
+	_, err = s.ExecuteBatch(ctx, "payroll-2021-10", operations)
+	assert.IsType(t, schemas.ErrorIdempotencyKeyReused{}, err)
+	assert.Equal(t, map[uuid.UUID]float64{alice: 60, bob: 40}, ledger.balances)
+
+	operations[0].Amount = 1000
+	_, err = s.ExecuteBatch(ctx, "payroll-2021-11", operations)
+	assert.IsType(t, schemas.ErrorBatchFailed{}, err)
+
+	_, err = s.ExecuteBatch(ctx, "payroll-2021-11", []model.BatchOperation{
+		{Type: model.BatchOperationTransfer, SenderId: alice, ReceiverId: bob, Amount: 10},
+	})
+	assert.NoError(t, err, "a failed batch does not consume its idempotency key")
+	assert.Equal(t, map[uuid.UUID]float64{alice: 50, bob: 50}, ledger.balances)
+}
+
+func TestIsBusinessError(t *testing.T) {
+	assert.True(t, isBusinessError(schemas.ErrorNotEnoughFunds{}))
+	assert.True(t, isBusinessError(schemas.ErrorInvalidEscrow{}))
+	assert.True(t, isBusinessError(schemas.ErrorInvalidFeeQuote{}))
+	assert.True(t, isBusinessError(fmt.Errorf("transfer 3: %w", schemas.ErrorLimitExceeded{})))
+	assert.False(t, isBusinessError(sql.ErrConnDone))
+	assert.False(t, isBusinessError(schemas.ErrorResponse{}))
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+// GrantBonus credits amount of bonus money to a user, spent before the real funds until expiresAt.
+func (s UserBalanceService) GrantBonus(ctx context.Context, userId uuid.UUID, amount float64, expiresAt time.Time,
+	reason string) (model.BonusGrant, error) {
+	log := s.logger.WithContext(ctx).WithField("user_id", userId)
+
+	if amount <= 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return model.BonusGrant{}, schemas.ErrorInvalidBonus{
+			Message: fmt.Sprintf("amount of a bonus must be positive, got %v", amount),
+		}
+	}
+	now := time.Now()
+	if !expiresAt.After(now) {
+		return model.BonusGrant{}, schemas.ErrorInvalidBonus{
+			Message: fmt.Sprintf("a bonus must expire in the future, got %v", expiresAt.Format(time.RFC3339)),
+		}
+	}
+
+	grant := model.BonusGrant{
+		UserId:    userId,
+		Amount:    roundCents(amount),
+		Remaining: roundCents(amount),
+		Reason:    strings.TrimSpace(reason),
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		ub, err := s.lockAccount(ctx, userId)
+		if err != nil {
+			return err
+		}
+		if err = s.limits.CheckCredit(ctx, userId, ub.Balance+grant.Amount); err != nil {
+			return err
+		}
+
+		balance, err := s.addBalance(ctx, userId, grant.Amount)
+		if err != nil {
+			return err
+		}
+
+		commentary := fmt.Sprintf("Granted %v rubles of bonus until %s", grant.Amount,
+			expiresAt.Format(time.RFC3339))
+		if grant.Reason != "" {
+			commentary += ": " + grant.Reason
+		}
+		entry, err := s.recordBalanceChange(ctx, model.TransactionLog{
+			UserId:        userId,
+			Amount:        grant.Amount,
+			Commentary:    commentary,
+			OperationType: model.OperationBonusCredit,
+		}, model.EventBalanceCredited, balance)
+		if err != nil {
+			return err
+		}
+
+		grant.TransactionLogId = entry.Id
+		grant.Id, err = s.bonusRepo.Create(ctx, grant)
+		return err
+	})
+	if err != nil {
+		log.Warnf("could not grant bonus to user, error: %s", err.Error())
+		return model.BonusGrant{}, err
+	}
+
+	log.Infof("granted %v of bonus to user until %v", grant.Amount, expiresAt)
+	return grant, nil
+}
+
+// GetBalanceBreakdown splits the balance of a user into real and bonus funds.
+func (s UserBalanceService) GetBalanceBreakdown(ctx context.Context, userId uuid.UUID) (
+	model.BalanceBreakdown, error) {
+	ub, err := s.GetAccountBalance(ctx, userId)
+	if err != nil {
+		return model.BalanceBreakdown{}, err
+	}
+
+	grants, err := s.bonusGrants(ctx, userId)
+	if err != nil {
+		s.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not get bonus grants of user, error: %s", err.Error())
+		return model.BalanceBreakdown{}, err
+	}
+
+	incoming, err := s.pendingRepo.SumIncoming(ctx, userId)
+	if err != nil {
+		s.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not get incoming pending transfers of user, error: %s", err.Error())
+		return model.BalanceBreakdown{}, err
+	}
+
+	bonus := remainingOf(grants)
+
+	return model.BalanceBreakdown{
+		UserId:          userId,
+		Real:            roundCents(ub.Balance - bonus),
+		Bonus:           bonus,
+		Grants:          grants,
+		Pending:         ub.Held,
+		PendingIncoming: roundCents(incoming),
+	}, nil
+}
+
+// ApplyTransactionExcludingBonus transfers amount from the real funds of the sender, leaving its bonus
+// untouched. The receiver gets real funds either way.
+func (s UserBalanceService) ApplyTransactionExcludingBonus(ctx context.Context, senderId uuid.UUID,
+	receiverId uuid.UUID, amount float64) error {
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.applyTransaction(ctx, senderId, receiverId, amount, false)
+		return err
+	})
+}
+
+// ExpireBonuses takes back what is left of the grants of a user expired by at and returns how much
+// that was. Each expired grant left unspent is logged with its own entry.
+func (s UserBalanceService) ExpireBonuses(ctx context.Context, userId uuid.UUID, at time.Time) (float64, error) {
+	var expired float64
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if _, err := s.lockBalances(ctx, userId); err != nil {
+			return err
+		}
+
+		grants, err := s.bonusRepo.GetActiveByUserId(ctx, userId)
+		if err != nil {
+			return err
+		}
+
+		due := make([]model.BonusGrant, 0, len(grants))
+		for _, grant := range grants {
+			if grant.Due(at) {
+				due = append(due, grant)
+			}
+		}
+
+		expired, err = s.expireGrants(ctx, userId, due, at, "expired")
+		return err
+	})
+	if err != nil {
+		s.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not expire bonus of user, error: %s", err.Error())
+		return 0, err
+	}
+
+	return expired, nil
+}
+
+// forfeitBonus takes back every grant of an account being closed, so only its real funds are paid out.
+func (s UserBalanceService) forfeitBonus(ctx context.Context, ub model.UserBalance) (model.UserBalance, error) {
+	grants, err := s.bonusRepo.GetActiveByUserId(ctx, ub.UserId)
+	if err != nil {
+		return model.UserBalance{}, err
+	}
+
+	forfeited, err := s.expireGrants(ctx, ub.UserId, grants, time.Now(), "forfeited on closing the account")
+	if err != nil {
+		return model.UserBalance{}, err
+	}
+	ub.Balance = roundCents(ub.Balance - forfeited)
+
+	return ub, nil
+}
+
+// expireGrants marks grants of a locked balance expired and takes what is left of them from the balance,
+// whatever the status of the account, as that money was never the user's own.
+func (s UserBalanceService) expireGrants(ctx context.Context, userId uuid.UUID, grants []model.BonusGrant,
+	at time.Time, why string) (float64, error) {
+	var expired float64
+
+	for _, grant := range grants {
+		if grant.Remaining > 0 {
+			balance, err := s.userBalanceRepo.UpdateByUserId(ctx, userId, -grant.Remaining)
+			if err != nil {
+				return 0, err
+			}
+
+			_, err = s.recordBalanceChange(ctx, model.TransactionLog{
+				UserId: userId,
+				Amount: grant.Remaining,
+				Commentary: fmt.Sprintf("Bonus grant %v %s, %v of %v rubles unspent", grant.Id, why,
+					grant.Remaining, grant.Amount),
+				OperationType: model.OperationBonusExpiry,
+			}, model.EventBalanceDebited, balance)
+			if err != nil {
+				return 0, err
+			}
+			expired += grant.Remaining
+		}
+
+		if err := s.bonusRepo.Expire(ctx, grant.Id, at); err != nil {
+			return 0, err
+		}
+	}
+
+	return roundCents(expired), nil
+}
+
+// bonusGrants returns the unexpired grants of a user with money left, in the order they are spent.
+func (s UserBalanceService) bonusGrants(ctx context.Context, userId uuid.UUID) ([]model.BonusGrant, error) {
+	active, err := s.bonusRepo.GetActiveByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	grants := make([]model.BonusGrant, 0, len(active))
+	for _, grant := range active {
+		if grant.Remaining > 0 {
+			grants = append(grants, grant)
+		}
+	}
+
+	if s.bonus.SpendOrder == model.BonusSpendGrantedFirst {
+		sort.SliceStable(grants, func(i, j int) bool {
+			return grants[i].Id < grants[j].Id
+		})
+	}
+
+	return grants, nil
+}
+
+// spendBonus takes amount, or as much of it as there is, from the grants not due at the given time in
+// the order they are given.
+func (s UserBalanceService) spendBonus(ctx context.Context, grants []model.BonusGrant, amount float64,
+	at time.Time) error {
+	for _, grant := range grants {
+		if amount <= 0 {
+			break
+		}
+		if grant.Due(at) {
+			continue
+		}
+
+		spent := math.Min(grant.Remaining, amount)
+		if err := s.bonusRepo.UpdateRemaining(ctx, grant.Id, roundCents(grant.Remaining-spent)); err != nil {
+			return err
+		}
+		amount = roundCents(amount - spent)
+	}
+
+	return nil
+}
+
+// splitBonus returns the money left of the grants that can still be spent at the given time and of the
+// ones due, which await being taken back and can not be spent anymore.
+func splitBonus(grants []model.BonusGrant, at time.Time) (spendable float64, due float64) {
+	for _, grant := range grants {
+		if grant.Due(at) {
+			due += grant.Remaining
+		} else {
+			spendable += grant.Remaining
+		}
+	}
+
+	return roundCents(spendable), roundCents(due)
+}
+
+func remainingOf(grants []model.BonusGrant) float64 {
+	var remaining float64
+	for _, grant := range grants {
+		remaining += grant.Remaining
+	}
+
+	return roundCents(remaining)
+}
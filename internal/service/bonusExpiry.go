@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+)
+
+// BonusExpiryJob takes back what is left of expired bonus grants. The grants of each user are expired
+// in their own transaction with the balance locked, so instances running the job concurrently expire
+// every grant once.
+type BonusExpiryJob struct {
+	bonusRepo repository.Bonus
+	bonus     Bonus
+	cfg       config.BonusConfig
+	logger    logger.Logger
+	now       func() time.Time
+}
+
+func NewBonusExpiryJob(bonusRepo repository.Bonus, bonus Bonus, cfg config.BonusConfig,
+	logger logger.Logger) *BonusExpiryJob {
+	return &BonusExpiryJob{
+		bonusRepo: bonusRepo,
+		bonus:     bonus,
+		cfg:       cfg,
+		logger:    logger,
+		now:       time.Now,
+	}
+}
+
+// Run expires due grants every poll interval until ctx is cancelled.
+func (j *BonusExpiryJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.ExpireDue(ctx); err != nil && ctx.Err() == nil {
+			j.logger.Errorf("could not expire bonus grants, error: %s", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExpireDue expires the due grants of up to a batch of users and returns how many users had some.
+func (j *BonusExpiryJob) ExpireDue(ctx context.Context) (int, error) {
+	now := j.now()
+
+	userIds, err := j.bonusRepo.GetDueUserIds(ctx, now, j.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, userId := range userIds {
+		if ctx.Err() != nil {
+			return i, ctx.Err()
+		}
+
+		expired, err := j.bonus.ExpireBonuses(ctx, userId, now)
+		if err != nil {
+			return i, err
+		}
+		if expired > 0 {
+			j.logger.WithField("user_id", userId).Infof("took back %v of expired bonus", expired)
+		}
+	}
+
+	return len(userIds), nil
+}
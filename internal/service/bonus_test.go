@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeBonusRepo struct {
+	grants []model.BonusGrant
+}
+
+func (r *fakeBonusRepo) Create(_ context.Context, grant model.BonusGrant) (int64, error) {
+	grant.Id = int64(len(r.grants) + 1)
+	r.grants = append(r.grants, grant)
+	return grant.Id, nil
+}
+
+func (r *fakeBonusRepo) GetActiveByUserId(_ context.Context, userId uuid.UUID) ([]model.BonusGrant, error) {
+	var grants []model.BonusGrant
+	for _, grant := range r.grants {
+		if grant.UserId == userId && grant.ExpiredAt == nil {
+			grants = append(grants, grant)
+		}
+	}
+	sort.SliceStable(grants, func(i, j int) bool {
+		return grants[i].ExpiresAt.Before(grants[j].ExpiresAt)
+	})
+	return grants, nil
+}
+
+func (r *fakeBonusRepo) UpdateRemaining(_ context.Context, id int64, remaining float64) error {
+	r.grants[id-1].Remaining = remaining
+	return nil
+}
+
+func (r *fakeBonusRepo) Expire(_ context.Context, id int64, expiredAt time.Time) error {
+	grant := &r.grants[id-1]
+	grant.ExpiredAt = &expiredAt
+	grant.ExpiredAmount = grant.Remaining
+	grant.Remaining = 0
+	return nil
+}
+
+func (r *fakeBonusRepo) GetDueUserIds(_ context.Context, now time.Time, limit int) ([]uuid.UUID, error) {
+	var userIds []uuid.UUID
+	seen := map[uuid.UUID]bool{}
+	for _, grant := range r.grants {
+		if grant.ExpiredAt == nil && grant.Due(now) && !seen[grant.UserId] && len(userIds) < limit {
+			seen[grant.UserId] = true
+			userIds = append(userIds, grant.UserId)
+		}
+	}
+	return userIds, nil
+}
+
+func TestUserBalanceService_GrantBonus(t *testing.T) {
+	user := uuid.New()
+	ctx := context.Background()
+	ts := newTestServices(map[uuid.UUID]float64{user: 10})
+	s := ts.build()
+
+	expiresAt := time.Now().Add(24 * time.Hour)
+	grant, err := s.GrantBonus(ctx, user, 50, expiresAt, " spring promo ")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), grant.Id)
+	assert.Equal(t, 50.0, grant.Remaining)
+	assert.Equal(t, "spring promo", grant.Reason)
+	assert.Equal(t, 60.0, ts.balanceRepo.balances[user])
+
+	assert.Len(t, ts.logRepo.logs, 1)
+	assert.Equal(t, model.OperationBonusCredit, ts.logRepo.logs[0].OperationType)
+	assert.Equal(t, ts.logRepo.logs[0].Id, ts.bonusRepo.grants[0].TransactionLogId)
+
+	breakdown, err := s.GetBalanceBreakdown(ctx, user)
+	assert.NoError(t, err)
+	assert.Equal(t, 10.0, breakdown.Real)
+	assert.Equal(t, 50.0, breakdown.Bonus)
+	assert.Len(t, breakdown.Grants, 1)
+
+	_, err = s.GrantBonus(ctx, user, 0, expiresAt, "")
+	assert.IsType(t, schemas.ErrorInvalidBonus{}, err)
+	_, err = s.GrantBonus(ctx, user, 10, time.Now().Add(-time.Hour), "")
+	assert.IsType(t, schemas.ErrorInvalidBonus{}, err)
+	_, err = s.GrantBonus(ctx, uuid.New(), 10, expiresAt, "")
+	assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+
+	// grants can not be reversed, they expire
+	_, err = s.ReverseOperation(ctx, ts.logRepo.logs[0].Id, 0, "")
+	assert.IsType(t, schemas.ErrorInvalidReversal{}, err)
+}
+
+func TestUserBalanceService_SpendBonus(t *testing.T) {
+	tests := []struct {
+		name       string
+		spendOrder string
+		remaining  []float64
+	}{
+		{
+			name:       "Expiring first",
+			spendOrder: model.BonusSpendExpiringFirst,
+			remaining:  []float64{25, 0},
+		},
+		{
+			name:       "Granted first",
+			spendOrder: model.BonusSpendGrantedFirst,
+			remaining:  []float64{5, 20},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := uuid.New()
+			ctx := context.Background()
+			ts := newTestServices(map[uuid.UUID]float64{user: 10})
+			ts.bonusConfig.SpendOrder = tt.spendOrder
+			s := ts.build()
+
+			_, err := s.GrantBonus(ctx, user, 30, time.Now().Add(10*24*time.Hour), "")
+			assert.NoError(t, err)
+			_, err = s.GrantBonus(ctx, user, 20, time.Now().Add(5*24*time.Hour), "")
+			assert.NoError(t, err)
+
+			_, err = s.ChangeUserBalanceByUserId(ctx, user, -25)
+			assert.NoError(t, err)
+			assert.Equal(t, 35.0, ts.balanceRepo.balances[user])
+			assert.Equal(t, tt.remaining, []float64{ts.bonusRepo.grants[0].Remaining, ts.bonusRepo.grants[1].Remaining})
+
+			// the real funds are spent once the bonus is gone
+			_, err = s.ChangeUserBalanceByUserId(ctx, user, -30)
+			assert.NoError(t, err)
+			breakdown, err := s.GetBalanceBreakdown(ctx, user)
+			assert.NoError(t, err)
+			assert.Equal(t, 5.0, breakdown.Real)
+			assert.Equal(t, 0.0, breakdown.Bonus)
+		})
+	}
+}
+
+func TestUserBalanceService_ApplyTransactionExcludingBonus(t *testing.T) {
+	sender, receiver := uuid.New(), uuid.New()
+	ctx := context.Background()
+	ts := newTestServices(map[uuid.UUID]float64{sender: 10, receiver: 0})
+	s := ts.build()
+
+	_, err := s.GrantBonus(ctx, sender, 50, time.Now().Add(24*time.Hour), "")
+	assert.NoError(t, err)
+
+	err = s.ApplyTransactionExcludingBonus(ctx, sender, receiver, 20)
+	assert.IsType(t, schemas.ErrorNotEnoughFunds{}, err)
+	assert.Equal(t, 60.0, ts.balanceRepo.balances[sender])
+
+	assert.NoError(t, s.ApplyTransactionExcludingBonus(ctx, sender, receiver, 10))
+	assert.Equal(t, map[uuid.UUID]float64{sender: 50, receiver: 10}, ts.balanceRepo.balances)
+	assert.Equal(t, 50.0, ts.bonusRepo.grants[0].Remaining)
+
+	// a plain transfer spends the bonus, the receiver gets real funds
+	assert.NoError(t, s.ApplyTransaction(ctx, sender, receiver, 20))
+	assert.Equal(t, 30.0, ts.bonusRepo.grants[0].Remaining)
+	breakdown, err := s.GetBalanceBreakdown(ctx, receiver)
+	assert.NoError(t, err)
+	assert.Equal(t, 30.0, breakdown.Real)
+}
+
+func TestUserBalanceService_AuthorizeTransferHoldsRealFunds(t *testing.T) {
+	sender, receiver := uuid.New(), uuid.New()
+	ctx := context.Background()
+	ts := newTestServices(map[uuid.UUID]float64{sender: 10, receiver: 0})
+	s := ts.build()
+
+	_, err := s.GrantBonus(ctx, sender, 50, time.Now().Add(time.Hour), "")
+	assert.NoError(t, err)
+
+	// bonus could expire before the capture, it is not held
+	_, err = s.AuthorizeTransfer(ctx, sender, receiver, 20, "")
+	assert.IsType(t, schemas.ErrorNotEnoughFunds{}, err)
+
+	transfer, err := s.AuthorizeTransfer(ctx, sender, receiver, 10, "")
+	assert.NoError(t, err)
+
+	// the capture goes through once the bonus expired, out of the real funds held
+	ts.bonusRepo.grants[0].ExpiresAt = time.Now().Add(-time.Minute)
+	_, err = s.CaptureTransfer(ctx, transfer.Id, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]float64{sender: 50, receiver: 10}, ts.balanceRepo.balances)
+	assert.Equal(t, 50.0, ts.bonusRepo.grants[0].Remaining)
+}
+
+func TestBonusExpiryJob_ExpireDue(t *testing.T) {
+	user, other := uuid.New(), uuid.New()
+	ctx := context.Background()
+	ts := newTestServices(map[uuid.UUID]float64{user: 10, other: 0})
+	s := ts.build()
+
+	_, err := s.GrantBonus(ctx, user, 30, time.Now().Add(time.Hour), "")
+	assert.NoError(t, err)
+	_, err = s.GrantBonus(ctx, user, 20, time.Now().Add(48*time.Hour), "")
+	assert.NoError(t, err)
+	_, err = s.ChangeUserBalanceByUserId(ctx, user, -10)
+	assert.NoError(t, err)
+
+	// an expired grant can not be spent while it waits to be taken back
+	ts.bonusRepo.grants[0].ExpiresAt = time.Now().Add(-time.Minute)
+	_, err = s.ChangeUserBalanceByUserId(ctx, user, -31)
+	assert.IsType(t, schemas.ErrorNotEnoughFunds{}, err)
+
+	job := NewBonusExpiryJob(ts.bonusRepo, s, config.BonusConfig{BatchSize: 10}, logger.NewDefault())
+	expired, err := job.ExpireDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	assert.Equal(t, 30.0, ts.balanceRepo.balances[user])
+	assert.Equal(t, 20.0, ts.bonusRepo.grants[0].ExpiredAmount)
+	assert.NotNil(t, ts.bonusRepo.grants[0].ExpiredAt)
+	assert.Nil(t, ts.bonusRepo.grants[1].ExpiredAt)
+
+	last := ts.logRepo.logs[len(ts.logRepo.logs)-1]
+	assert.Equal(t, model.OperationBonusExpiry, last.OperationType)
+	assert.Equal(t, 20.0, last.Amount)
+
+	expired, err = job.ExpireDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, expired)
+}
+
+func TestUserBalanceService_CloseAccountForfeitsBonus(t *testing.T) {
+	user, payee := uuid.New(), uuid.New()
+	ctx := context.Background()
+	ts := newTestServices(map[uuid.UUID]float64{user: 10, payee: 0})
+	s := ts.build()
+
+	_, err := s.GrantBonus(ctx, user, 50, time.Now().Add(24*time.Hour), "")
+	assert.NoError(t, err)
+
+	closure, err := s.CloseAccount(ctx, user, &payee, "user request", "operator")
+	assert.NoError(t, err)
+	assert.Equal(t, 10.0, closure.Payout.Amount)
+	assert.Equal(t, map[uuid.UUID]float64{user: 0, payee: 10}, ts.balanceRepo.balances)
+	assert.Equal(t, 50.0, ts.bonusRepo.grants[0].ExpiredAmount)
+}
+
+func TestUserBalanceService_BonusNotTurnedIntoRealFunds(t *testing.T) {
+	user, seller := uuid.New(), uuid.New()
+	ctx := context.Background()
+
+	t.Run("Reversed credit", func(t *testing.T) {
+		ts := newTestServices(map[uuid.UUID]float64{user: 0})
+		s := ts.build()
+
+		_, err := s.ChangeUserBalanceByUserId(ctx, user, 30)
+		assert.NoError(t, err)
+		_, err = s.GrantBonus(ctx, user, 50, time.Now().Add(24*time.Hour), "")
+		assert.NoError(t, err)
+
+		_, err = s.ReverseOperation(ctx, ts.logRepo.logs[0].Id, 0, "")
+		assert.NoError(t, err)
+		assert.Equal(t, 50.0, ts.balanceRepo.balances[user])
+		assert.Equal(t, 50.0, ts.bonusRepo.grants[0].Remaining, "the reversal takes real funds back")
+
+		breakdown, err := s.GetBalanceBreakdown(ctx, user)
+		assert.NoError(t, err)
+		assert.Equal(t, 0.0, breakdown.Real)
+		assert.Equal(t, 50.0, breakdown.Bonus)
+	})
+
+	t.Run("Escrow funded with bonus", func(t *testing.T) {
+		ts := newTestServices(map[uuid.UUID]float64{user: 10, seller: 0, testEscrowAccount: 0})
+		s := ts.build()
+
+		_, err := s.GrantBonus(ctx, user, 50, time.Now().Add(24*time.Hour), "")
+		assert.NoError(t, err)
+
+		_, err = s.FundEscrow(ctx, "deal-1", user, seller, 30)
+		assert.IsType(t, schemas.ErrorNotEnoughFunds{}, err)
+
+		escrow, err := s.FundEscrow(ctx, "deal-1", user, seller, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 50.0, ts.bonusRepo.grants[0].Remaining)
+
+		_, err = s.RefundEscrow(ctx, escrow.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, 60.0, ts.balanceRepo.balances[user])
+		assert.Equal(t, 50.0, ts.bonusRepo.grants[0].Remaining)
+	})
+}
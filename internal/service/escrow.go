@@ -0,0 +1,402 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+// maxDealIdLength bounds the id of the deal an escrow is linked to.
+const maxDealIdLength = 255
+
+// FundEscrow takes amount from the real funds of the buyer of a deal into the escrow account of its currency,
+// where it stays until the escrow is settled. A deal is escrowed once.
+func (s UserBalanceService) FundEscrow(ctx context.Context, dealId string, buyerId uuid.UUID, sellerId uuid.UUID,
+	amount float64) (model.Escrow, error) {
+	dealId = strings.TrimSpace(dealId)
+	log := s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"deal_id":   dealId,
+		"buyer_id":  buyerId,
+		"seller_id": sellerId,
+	})
+
+	if dealId == "" || len(dealId) > maxDealIdLength {
+		return model.Escrow{}, schemas.ErrorInvalidEscrow{
+			Message: fmt.Sprintf("deal id must be set and at most %d characters long", maxDealIdLength),
+		}
+	}
+	if buyerId == sellerId {
+		return model.Escrow{}, schemas.ErrorInvalidEscrow{
+			Message: "buyer and seller must differ",
+		}
+	}
+	if amount <= 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return model.Escrow{}, schemas.ErrorInvalidEscrow{
+			Message: fmt.Sprintf("amount must be positive, got %v", amount),
+		}
+	}
+	amount = roundCents(amount)
+
+	for i, userId := range []uuid.UUID{buyerId, sellerId} {
+		role := "buyer"
+		if i == 1 {
+			role = "seller"
+		}
+		exists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, userId)
+		if err != nil {
+			log.Errorf("could not check if %s exists, error: %s", role, err.Error())
+			return model.Escrow{}, err
+		}
+		if !exists {
+			return model.Escrow{}, schemas.ErrorUserBalanceNotFound{
+				Message: fmt.Sprintf("user balance of %s %v not found", role, userId),
+			}
+		}
+	}
+
+	var escrow model.Escrow
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.escrowRepo.GetByDealId(ctx, dealId)
+		if err == nil {
+			return schemas.ErrorInvalidEscrow{
+				Message: fmt.Sprintf("deal %q is already escrowed", dealId),
+			}
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		// the escrow account is locked together with the parties, in the same stable order
+		buyer, err := s.userBalanceRepo.GetByUserId(ctx, buyerId)
+		if err != nil {
+			return err
+		}
+		accountId, err := s.escrowAccount(buyer.Currency)
+		if err != nil {
+			return err
+		}
+		if accountId == buyerId || accountId == sellerId {
+			return schemas.ErrorInvalidEscrow{
+				Message: fmt.Sprintf("escrow account %v can not be a party of the deal", accountId),
+			}
+		}
+		balances, err := s.lockBalances(ctx, buyerId, sellerId, accountId)
+		if err != nil {
+			log.Errorf("could not lock balances of the deal, error: %s", err.Error())
+			return err
+		}
+
+		if err = checkCanDebit(balances[buyerId]); err != nil {
+			log.Warnf("buyer can not send money, error: %s", err.Error())
+			return err
+		}
+		if err = checkCanCredit(balances[sellerId]); err != nil {
+			log.Warnf("seller can not receive money, error: %s", err.Error())
+			return err
+		}
+		if err = checkCanCredit(balances[accountId]); err != nil {
+			log.Warnf("escrow account can not receive money, error: %s", err.Error())
+			return err
+		}
+		if err = checkSameCurrency(balances[buyerId], balances[sellerId]); err != nil {
+			log.Warnf("escrow between currencies refused, error: %s", err.Error())
+			return err
+		}
+		if err = s.limits.CheckDebit(ctx, buyerId, amount, true); err != nil {
+			log.Warnf("escrow exceeds a limit of buyer, error: %s", err.Error())
+			return err
+		}
+
+		now := time.Now()
+		escrow = model.Escrow{
+			Id:        uuid.New(),
+			DealId:    dealId,
+			BuyerId:   buyerId,
+			SellerId:  sellerId,
+			AccountId: accountId,
+			Amount:    amount,
+			Status:    model.EscrowFunded,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		// settling pays real funds out, so the escrow is funded with real funds only
+		buyerBalance, err := s.debitBalance(ctx, buyerId, -amount, false)
+		if err != nil {
+			log.Warnf("could not take money of buyer, error: %s", err.Error())
+			return err
+		}
+
+		funded, err := s.recordBalanceChange(ctx, model.TransactionLog{
+			UserId:         buyerId,
+			Amount:         amount,
+			Commentary:     fmt.Sprintf("Escrowed %v rubles for deal %s with user %v", amount, dealId, sellerId),
+			OperationType:  model.OperationEscrowFund,
+			CounterpartyId: &sellerId,
+			CorrelationId:  &escrow.Id,
+		}, model.EventBalanceDebited, buyerBalance)
+		if err != nil {
+			log.Errorf("could not log info about buyer, error: %s", err.Error())
+			return err
+		}
+
+		accountBalance, err := s.addBalance(ctx, accountId, amount)
+		if err != nil {
+			log.Errorf("could not add money to escrow account %v, error: %s", accountId, err.Error())
+			return err
+		}
+
+		_, err = s.recordBalanceChange(ctx, model.TransactionLog{
+			UserId:         accountId,
+			Amount:         amount,
+			Commentary:     fmt.Sprintf("Holding %v rubles of user %v for deal %s", amount, buyerId, dealId),
+			OperationType:  model.OperationEscrowHold,
+			CounterpartyId: &buyerId,
+			RelatedLogId:   &funded.Id,
+			CorrelationId:  &escrow.Id,
+		}, model.EventBalanceCredited, accountBalance)
+		if err != nil {
+			log.Errorf("could not log info about escrow account, error: %s", err.Error())
+			return err
+		}
+
+		escrow.FundingLogId = &funded.Id
+		created, err := s.escrowRepo.Create(ctx, escrow)
+		if err != nil {
+			return err
+		}
+		// a concurrent funding of the deal got in first
+		if !created {
+			return schemas.ErrorInvalidEscrow{
+				Message: fmt.Sprintf("deal %q is already escrowed", dealId),
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return model.Escrow{}, err
+	}
+
+	log.WithField("escrow_id", escrow.Id).Infof("escrowed %v rubles", amount)
+	return escrow, nil
+}
+
+// GetEscrow returns an escrow whatever its status.
+func (s UserBalanceService) GetEscrow(ctx context.Context, id uuid.UUID) (model.Escrow, error) {
+	escrow, err := s.escrowRepo.Get(ctx, id)
+	if err != nil {
+		return model.Escrow{}, escrowError(id.String(), err)
+	}
+
+	return escrow, nil
+}
+
+// GetEscrowByDealId returns the escrow of a deal.
+func (s UserBalanceService) GetEscrowByDealId(ctx context.Context, dealId string) (model.Escrow, error) {
+	escrow, err := s.escrowRepo.GetByDealId(ctx, strings.TrimSpace(dealId))
+	if err != nil {
+		return model.Escrow{}, escrowError("of deal "+dealId, err)
+	}
+
+	return escrow, nil
+}
+
+// ReleaseEscrow pays the whole escrow out to the seller.
+func (s UserBalanceService) ReleaseEscrow(ctx context.Context, id uuid.UUID) (model.Escrow, error) {
+	return s.settleEscrow(ctx, id, nil)
+}
+
+// RefundEscrow pays the whole escrow back to the buyer.
+func (s UserBalanceService) RefundEscrow(ctx context.Context, id uuid.UUID) (model.Escrow, error) {
+	zero := 0.0
+	return s.settleEscrow(ctx, id, &zero)
+}
+
+// SplitEscrow pays sellerAmount out to the seller and the rest of the escrow back to the buyer, each of
+// them getting part of it.
+func (s UserBalanceService) SplitEscrow(ctx context.Context, id uuid.UUID, sellerAmount float64) (
+	model.Escrow, error) {
+	if sellerAmount <= 0 || math.IsNaN(sellerAmount) || math.IsInf(sellerAmount, 0) {
+		return model.Escrow{}, schemas.ErrorInvalidEscrow{
+			Message: fmt.Sprintf("seller amount must be positive, got %v", sellerAmount),
+		}
+	}
+	sellerAmount = roundCents(sellerAmount)
+
+	return s.settleEscrow(ctx, id, &sellerAmount)
+}
+
+// settleEscrow pays sellerAmount of a funded escrow out to the seller, all of it when sellerAmount is nil,
+// and the rest back to the buyer, in one transaction. A split must leave something to each party.
+func (s UserBalanceService) settleEscrow(ctx context.Context, id uuid.UUID, sellerAmount *float64) (
+	model.Escrow, error) {
+	log := s.logger.WithContext(ctx).WithField("escrow_id", id)
+
+	var escrow model.Escrow
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		escrow, err = s.escrowRepo.GetForUpdate(ctx, id)
+		if err != nil {
+			return escrowError(id.String(), err)
+		}
+		if escrow.Status != model.EscrowFunded {
+			return schemas.ErrorInvalidEscrow{
+				Message: fmt.Sprintf("escrow %v is already %s", id, escrow.Status),
+			}
+		}
+
+		released, status := escrow.Amount, model.EscrowReleased
+		if sellerAmount != nil {
+			released = *sellerAmount
+			switch {
+			case released == 0:
+				status = model.EscrowRefunded
+			case released < escrow.Amount:
+				status = model.EscrowSplit
+			default:
+				return schemas.ErrorInvalidEscrow{
+					Message: fmt.Sprintf("a split of escrow %v must leave part of its %v to the buyer, got %v "+
+						"for the seller", id, escrow.Amount, released),
+				}
+			}
+		}
+		refunded := roundCents(escrow.Amount - released)
+
+		balances, err := s.lockBalances(ctx, escrow.BuyerId, escrow.SellerId, escrow.AccountId)
+		if err != nil {
+			log.Errorf("could not lock balances of the deal, error: %s", err.Error())
+			return err
+		}
+		if released > 0 {
+			seller := balances[escrow.SellerId]
+			if err = checkCanCredit(seller); err != nil {
+				log.Warnf("seller can not receive money, error: %s", err.Error())
+				return err
+			}
+			if err = s.limits.CheckCredit(ctx, escrow.SellerId, seller.Balance+released); err != nil {
+				log.Warnf("escrow exceeds a limit of seller, error: %s", err.Error())
+				return err
+			}
+		}
+		if refunded > 0 {
+			if err = checkCanCredit(balances[escrow.BuyerId]); err != nil {
+				log.Warnf("buyer can not receive money, error: %s", err.Error())
+				return err
+			}
+		}
+
+		if err = s.payOutEscrow(ctx, escrow, escrow.SellerId, escrow.BuyerId, released,
+			model.OperationEscrowRelease); err != nil {
+			return err
+		}
+		if err = s.payOutEscrow(ctx, escrow, escrow.BuyerId, escrow.SellerId, refunded,
+			model.OperationEscrowRefund); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		escrow.ReleasedAmount = released
+		escrow.RefundedAmount = refunded
+		escrow.Status = status
+		escrow.UpdatedAt = now
+		escrow.SettledAt = &now
+		return s.escrowRepo.Settle(ctx, escrow)
+	})
+	if err != nil {
+		log.Warnf("could not settle escrow, error: %s", err.Error())
+		return model.Escrow{}, err
+	}
+
+	log.Infof("escrow %s, %v rubles released and %v refunded", escrow.Status, escrow.ReleasedAmount,
+		escrow.RefundedAmount)
+	return escrow, nil
+}
+
+// payOutEscrow moves amount of an escrow from its escrow account to a party of the deal, logging the
+// entry of the party against the other party.
+func (s UserBalanceService) payOutEscrow(ctx context.Context, escrow model.Escrow, partyId uuid.UUID,
+	otherPartyId uuid.UUID, amount float64, operationType string) error {
+	if amount <= 0 {
+		return nil
+	}
+	log := s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"escrow_id": escrow.Id,
+		"user_id":   partyId,
+	})
+
+	accountBalance, err := s.debitBalance(ctx, escrow.AccountId, -amount, false)
+	if err != nil {
+		log.Errorf("could not take money from escrow account %v, error: %s", escrow.AccountId, err.Error())
+		return err
+	}
+
+	payout, err := s.recordBalanceChange(ctx, model.TransactionLog{
+		UserId:         escrow.AccountId,
+		Amount:         amount,
+		Commentary:     fmt.Sprintf("Paid %v rubles to user %v for deal %s", amount, partyId, escrow.DealId),
+		OperationType:  model.OperationEscrowPayout,
+		CounterpartyId: &partyId,
+		CorrelationId:  &escrow.Id,
+	}, model.EventBalanceDebited, accountBalance)
+	if err != nil {
+		log.Errorf("could not log info about escrow account, error: %s", err.Error())
+		return err
+	}
+
+	balance, err := s.addBalance(ctx, partyId, amount)
+	if err != nil {
+		log.Errorf("could not send escrowed money to user, error: %s", err.Error())
+		return err
+	}
+
+	verb := "Released"
+	if operationType == model.OperationEscrowRefund {
+		verb = "Refunded"
+	}
+	_, err = s.recordBalanceChange(ctx, model.TransactionLog{
+		UserId:         partyId,
+		Amount:         amount,
+		Commentary:     fmt.Sprintf("%s %v rubles of deal %s with user %v", verb, amount, escrow.DealId, otherPartyId),
+		OperationType:  operationType,
+		CounterpartyId: &otherPartyId,
+		RelatedLogId:   &payout.Id,
+		CorrelationId:  &escrow.Id,
+	}, model.EventBalanceCredited, balance)
+	if err != nil {
+		log.Errorf("could not log info about user, error: %s", err.Error())
+	}
+
+	return err
+}
+
+// escrowAccount returns the account holding the money escrowed in currency.
+func (s UserBalanceService) escrowAccount(currency string) (uuid.UUID, error) {
+	accountId, ok := s.escrowAccounts[currency]
+	if !ok {
+		return uuid.UUID{}, schemas.ErrorInvalidEscrow{
+			Message: fmt.Sprintf("no escrow account is configured for %s", currency),
+		}
+	}
+
+	return accountId, nil
+}
+
+func escrowError(id string, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return schemas.ErrorEscrowNotFound{
+			Message: fmt.Sprintf("escrow %s not found", id),
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeEscrowRepo struct {
+	escrows map[uuid.UUID]model.Escrow
+	// uncommitted hides escrows from GetByDealId, as when they are funded by a transaction not committed yet
+	uncommitted bool
+}
+
+func newFakeEscrowRepo() *fakeEscrowRepo {
+	return &fakeEscrowRepo{escrows: map[uuid.UUID]model.Escrow{}}
+}
+
+func (r *fakeEscrowRepo) Create(_ context.Context, escrow model.Escrow) (bool, error) {
+	for _, existing := range r.escrows {
+		if existing.DealId == escrow.DealId {
+			return false, nil
+		}
+	}
+	r.escrows[escrow.Id] = escrow
+	return true, nil
+}
+
+func (r *fakeEscrowRepo) Get(_ context.Context, id uuid.UUID) (model.Escrow, error) {
+	escrow, ok := r.escrows[id]
+	if !ok {
+		return model.Escrow{}, sql.ErrNoRows
+	}
+	return escrow, nil
+}
+
+func (r *fakeEscrowRepo) GetByDealId(_ context.Context, dealId string) (model.Escrow, error) {
+	if r.uncommitted {
+		return model.Escrow{}, sql.ErrNoRows
+	}
+	for _, escrow := range r.escrows {
+		if escrow.DealId == dealId {
+			return escrow, nil
+		}
+	}
+	return model.Escrow{}, sql.ErrNoRows
+}
+
+func (r *fakeEscrowRepo) GetForUpdate(ctx context.Context, id uuid.UUID) (model.Escrow, error) {
+	return r.Get(ctx, id)
+}
+
+func (r *fakeEscrowRepo) Settle(_ context.Context, escrow model.Escrow) error {
+	r.escrows[escrow.Id] = escrow
+	return nil
+}
+
+var testEscrowAccount = uuid.New()
+
+var testEscrowConfig = config.EscrowConfig{
+	// keys come lowercased from the configuration
+	Accounts: map[string]string{"rub": testEscrowAccount.String()},
+}
+
+func TestUserBalanceService_FundEscrow(t *testing.T) {
+	buyer, seller := uuid.New(), uuid.New()
+	ctx := context.Background()
+	s, balanceRepo, logRepo, _ := newTestUserBalanceService(map[uuid.UUID]float64{
+		buyer: 100, seller: 0, testEscrowAccount: 0,
+	})
+
+	escrow, err := s.FundEscrow(ctx, " deal-1 ", buyer, seller, 60)
+	assert.NoError(t, err)
+	assert.Equal(t, "deal-1", escrow.DealId)
+	assert.Equal(t, model.EscrowFunded, escrow.Status)
+	assert.Equal(t, testEscrowAccount, escrow.AccountId)
+	assert.Equal(t, map[uuid.UUID]float64{buyer: 40, seller: 0, testEscrowAccount: 60}, balanceRepo.balances)
+
+	// the funding is logged against the buyer and the seller, the escrow account entry is linked to it
+	assert.Len(t, logRepo.logs, 2)
+	assert.Equal(t, model.OperationEscrowFund, logRepo.logs[0].OperationType)
+	assert.Equal(t, seller, *logRepo.logs[0].CounterpartyId)
+	assert.Equal(t, escrow.Id, *logRepo.logs[0].CorrelationId)
+	assert.Equal(t, *escrow.FundingLogId, logRepo.logs[0].Id)
+	assert.Equal(t, model.OperationEscrowHold, logRepo.logs[1].OperationType)
+	assert.Equal(t, logRepo.logs[0].Id, *logRepo.logs[1].RelatedLogId)
+
+	got, err := s.GetEscrowByDealId(ctx, "deal-1")
+	assert.NoError(t, err)
+	assert.Equal(t, escrow.Id, got.Id)
+
+	_, err = s.FundEscrow(ctx, "deal-1", buyer, seller, 10)
+	assert.IsType(t, schemas.ErrorInvalidEscrow{}, err)
+	_, err = s.FundEscrow(ctx, "deal-2", buyer, seller, 50)
+	assert.IsType(t, schemas.ErrorNotEnoughFunds{}, err)
+	for _, tt := range []struct {
+		dealId   string
+		sellerId uuid.UUID
+		amount   float64
+	}{
+		{dealId: " ", sellerId: seller, amount: 10},
+		{dealId: "deal-3", sellerId: buyer, amount: 10},
+		{dealId: "deal-3", sellerId: seller, amount: 0},
+	} {
+		_, err = s.FundEscrow(ctx, tt.dealId, buyer, tt.sellerId, tt.amount)
+		assert.IsType(t, schemas.ErrorInvalidEscrow{}, err)
+	}
+	_, err = s.FundEscrow(ctx, "deal-3", buyer, uuid.New(), 10)
+	assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+
+	// deals can only be escrowed in currencies with an escrow account
+	balanceRepo.currencies[buyer], balanceRepo.currencies[seller] = "USD", "USD"
+	_, err = s.FundEscrow(ctx, "deal-3", buyer, seller, 10)
+	assert.IsType(t, schemas.ErrorInvalidEscrow{}, err)
+	assert.Len(t, logRepo.logs, 2)
+}
+
+func TestUserBalanceService_FundEscrowRefused(t *testing.T) {
+	buyer, seller := uuid.New(), uuid.New()
+	ctx := context.Background()
+
+	t.Run("Deal escrowed concurrently", func(t *testing.T) {
+		s, _, _, _ := newTestUserBalanceService(map[uuid.UUID]float64{
+			buyer: 100, seller: 0, testEscrowAccount: 0,
+		})
+		escrowRepo := s.escrowRepo.(*fakeEscrowRepo)
+
+		_, err := s.FundEscrow(ctx, "deal-1", buyer, seller, 10)
+		assert.NoError(t, err)
+
+		// both fundings miss each other, the second one is refused when it is stored
+		escrowRepo.uncommitted = true
+		_, err = s.FundEscrow(ctx, "deal-1", buyer, seller, 10)
+		assert.IsType(t, schemas.ErrorInvalidEscrow{}, err)
+		assert.Len(t, escrowRepo.escrows, 1)
+	})
+
+	t.Run("Escrow account is a party", func(t *testing.T) {
+		s, _, logRepo, _ := newTestUserBalanceService(map[uuid.UUID]float64{buyer: 100, testEscrowAccount: 0})
+
+		_, err := s.FundEscrow(ctx, "deal-1", buyer, testEscrowAccount, 10)
+		assert.IsType(t, schemas.ErrorInvalidEscrow{}, err)
+		assert.Empty(t, logRepo.logs)
+	})
+
+	t.Run("Escrow account closed", func(t *testing.T) {
+		s, balanceRepo, logRepo, _ := newTestUserBalanceService(map[uuid.UUID]float64{
+			buyer: 100, seller: 0, testEscrowAccount: 0,
+		})
+		balanceRepo.statuses[testEscrowAccount] = model.AccountClosed
+
+		_, err := s.FundEscrow(ctx, "deal-1", buyer, seller, 10)
+		assert.IsType(t, schemas.ErrorAccountClosed{}, err)
+		assert.Empty(t, logRepo.logs)
+	})
+}
+
+func TestUserBalanceService_SettleEscrow(t *testing.T) {
+	buyer, seller := uuid.New(), uuid.New()
+	ctx := context.Background()
+	s, balanceRepo, logRepo, _ := newTestUserBalanceService(map[uuid.UUID]float64{
+		buyer: 300, seller: 0, testEscrowAccount: 0,
+	})
+
+	released, err := s.FundEscrow(ctx, "released", buyer, seller, 100)
+	assert.NoError(t, err)
+	refunded, err := s.FundEscrow(ctx, "refunded", buyer, seller, 100)
+	assert.NoError(t, err)
+	split, err := s.FundEscrow(ctx, "split", buyer, seller, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 300.0, balanceRepo.balances[testEscrowAccount])
+
+	escrow, err := s.ReleaseEscrow(ctx, released.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, model.EscrowReleased, escrow.Status)
+	assert.Equal(t, 100.0, escrow.ReleasedAmount)
+	assert.NotNil(t, escrow.SettledAt)
+
+	// the payout of the escrow account is linked to the entry of the seller, logged against the buyer
+	payout, release := logRepo.logs[len(logRepo.logs)-2], logRepo.logs[len(logRepo.logs)-1]
+	assert.Equal(t, model.OperationEscrowPayout, payout.OperationType)
+	assert.Equal(t, testEscrowAccount, payout.UserId)
+	assert.Equal(t, model.OperationEscrowRelease, release.OperationType)
+	assert.Equal(t, seller, release.UserId)
+	assert.Equal(t, buyer, *release.CounterpartyId)
+	assert.Equal(t, payout.Id, *release.RelatedLogId)
+	assert.Equal(t, released.Id, *release.CorrelationId)
+
+	escrow, err = s.RefundEscrow(ctx, refunded.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, model.EscrowRefunded, escrow.Status)
+	assert.Equal(t, 100.0, escrow.RefundedAmount)
+	assert.Equal(t, model.OperationEscrowRefund, logRepo.logs[len(logRepo.logs)-1].OperationType)
+
+	for _, sellerAmount := range []float64{0, 100, 150} {
+		_, err = s.SplitEscrow(ctx, split.Id, sellerAmount)
+		assert.IsType(t, schemas.ErrorInvalidEscrow{}, err)
+	}
+	escrow, err = s.SplitEscrow(ctx, split.Id, 70)
+	assert.NoError(t, err)
+	assert.Equal(t, model.EscrowSplit, escrow.Status)
+	assert.Equal(t, 70.0, escrow.ReleasedAmount)
+	assert.Equal(t, 30.0, escrow.RefundedAmount)
+
+	assert.Equal(t, map[uuid.UUID]float64{buyer: 130, seller: 170, testEscrowAccount: 0}, balanceRepo.balances)
+
+	// an escrow is settled once, and its entries can not be reversed on their own
+	_, err = s.RefundEscrow(ctx, released.Id)
+	assert.IsType(t, schemas.ErrorInvalidEscrow{}, err)
+	_, err = s.ReverseOperation(ctx, release.Id, 0, "")
+	assert.IsType(t, schemas.ErrorInvalidReversal{}, err)
+	_, err = s.ReleaseEscrow(ctx, uuid.New())
+	assert.IsType(t, schemas.ErrorEscrowNotFound{}, err)
+}
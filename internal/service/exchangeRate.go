@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+)
+
+// ExchangeRateService asks the exchange rates provider for conversion rates and remembers
+// the outcome of the last request so the provider state can be reported by readiness checks.
+type ExchangeRateService struct {
+	cfg    config.ExchangeRateConfig
+	client *http.Client
+	logger logger.Logger
+
+	mu          sync.RWMutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+}
+
+func NewExchangeRateService(cfg config.ExchangeRateConfig, logger logger.Logger) *ExchangeRateService {
+	return &ExchangeRateService{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		logger: logger,
+	}
+}
+
+func (s *ExchangeRateService) GetExchangeRate(ctx context.Context, fromCurrency string,
+	toCurrency string) (float64, error) {
+	rate, err := s.getExchangeRate(ctx, fromCurrency, toCurrency)
+	s.recordResult(err)
+
+	return rate, err
+}
+
+func (s *ExchangeRateService) ProviderStatus() model.ComponentHealth {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	details := map[string]interface{}{}
+	if !s.lastSuccess.IsZero() {
+		details["lastSuccess"] = s.lastSuccess
+	}
+	if !s.lastFailure.IsZero() {
+		details["lastFailure"] = s.lastFailure
+	}
+
+	switch {
+	case s.lastSuccess.IsZero() && s.lastFailure.IsZero():
+		return model.ComponentHealth{Status: model.HealthStatusUnknown, Message: "no requests made yet"}
+	case s.lastFailure.After(s.lastSuccess):
+		return model.ComponentHealth{Status: model.HealthStatusDegraded, Message: s.lastError, Details: details}
+	default:
+		return model.ComponentHealth{Status: model.HealthStatusUp, Details: details}
+	}
+}
+
+func (s *ExchangeRateService) recordResult(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.lastFailure = time.Now()
+		s.lastError = err.Error()
+		return
+	}
+
+	s.lastSuccess = time.Now()
+}
+
+func (s *ExchangeRateService) getExchangeRate(ctx context.Context, fromCurrency string,
+	toCurrency string) (float64, error) {
+	log := s.logger.WithContext(ctx)
+
+	if fromCurrency == "" {
+		fromCurrency = s.cfg.BaseCurrency
+	}
+
+	currencies := fmt.Sprintf("%s_%s", fromCurrency, toCurrency)
+	query := url.Values{}
+	query.Set("q", currencies)
+	query.Set("compact", "ultra")
+	query.Set("apiKey", s.cfg.APIKey)
+	exchangerURL := s.cfg.URL + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, exchangerURL, nil)
+	if err != nil {
+		log.Errorf("could not build exchange rates request, error: %s", err.Error())
+		return 0, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		log.Errorf("could not get exchange rates, error: %s", err.Error())
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("could not get exchange rates, status code: %v", resp.StatusCode)
+		return 0, fmt.Errorf("exchange rates provider responded with status %v", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Errorf("could not read response body, error: %s", err.Error())
+		return 0, err
+	}
+
+	respBody := map[string]float64{}
+	err = json.Unmarshal(body, &respBody)
+	if err != nil {
+		log.Errorf("could not unmarshal response body, error: %s", err.Error())
+		return 0, err
+	}
+
+	return respBody[currencies], nil
+}
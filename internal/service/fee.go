@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+// feeRule is a fee rule of the configuration with its effective times parsed.
+type feeRule struct {
+	config.FeeRuleConfig
+	effectiveFrom time.Time
+	effectiveTo   *time.Time
+	freeTiers     map[string]bool
+}
+
+// FeeService evaluates the configured fee rules. The fee of an operation is charged by the operation
+// itself, in its transaction, into the revenue account of its currency.
+type FeeService struct {
+	userBalanceRepo repository.UserBalance
+	limits          Limits
+	// rules are sorted by operation and then by effectiveFrom, the latest first.
+	rules           []feeRule
+	revenueAccounts map[string]uuid.UUID
+	logger          logger.Logger
+	now             func() time.Time
+}
+
+func NewFeeService(userBalanceRepo repository.UserBalance, limits Limits, cfg config.FeesConfig,
+	logger logger.Logger) *FeeService {
+	// the configuration is validated on load, so rules and accounts that do not parse are skipped
+	rules := make([]feeRule, 0, len(cfg.Rules))
+	for _, ruleConfig := range cfg.Rules {
+		rule := feeRule{FeeRuleConfig: ruleConfig, freeTiers: map[string]bool{}}
+		var err error
+		if rule.effectiveFrom, err = time.Parse(time.RFC3339, ruleConfig.EffectiveFrom); err != nil {
+			continue
+		}
+		if ruleConfig.EffectiveTo != "" {
+			effectiveTo, err := time.Parse(time.RFC3339, ruleConfig.EffectiveTo)
+			if err != nil {
+				continue
+			}
+			rule.effectiveTo = &effectiveTo
+		}
+		for _, tier := range ruleConfig.FreeTiers {
+			rule.freeTiers[strings.ToLower(tier)] = true
+		}
+		rules = append(rules, rule)
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Operation != rules[j].Operation {
+			return rules[i].Operation < rules[j].Operation
+		}
+		return rules[i].effectiveFrom.After(rules[j].effectiveFrom)
+	})
+
+	// currencies are keys of the configuration, which lowercases them
+	revenueAccounts := make(map[string]uuid.UUID, len(cfg.RevenueAccounts))
+	for currency, accountId := range cfg.RevenueAccounts {
+		if id, err := uuid.Parse(accountId); err == nil {
+			revenueAccounts[strings.ToUpper(currency)] = id
+		}
+	}
+
+	return &FeeService{
+		userBalanceRepo: userBalanceRepo,
+		limits:          limits,
+		rules:           rules,
+		revenueAccounts: revenueAccounts,
+		logger:          logger,
+		now:             time.Now,
+	}
+}
+
+// QuoteFee returns the fee the user would be charged now for an operation of amount. Revenue accounts
+// are never charged.
+func (s FeeService) QuoteFee(ctx context.Context, operation string, userId uuid.UUID, amount float64) (
+	model.FeeQuote, error) {
+	if operation != model.FeeOperationTransfer && operation != model.FeeOperationWithdrawal {
+		return model.FeeQuote{}, schemas.ErrorInvalidFeeQuote{
+			Message: fmt.Sprintf("operation must be %s or %s, got %q", model.FeeOperationTransfer,
+				model.FeeOperationWithdrawal, operation),
+		}
+	}
+	if amount <= 0 {
+		return model.FeeQuote{}, schemas.ErrorInvalidFeeQuote{
+			Message: fmt.Sprintf("amount must be positive, got %v", amount),
+		}
+	}
+
+	ub, err := s.userBalanceRepo.GetByUserId(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.FeeQuote{}, accountNotFound(userId)
+	}
+	if err != nil {
+		s.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not get account to quote fee, error: %s", err.Error())
+		return model.FeeQuote{}, err
+	}
+
+	quote := model.FeeQuote{
+		Operation: operation,
+		UserId:    userId,
+		Amount:    amount,
+		Total:     amount,
+		Currency:  ub.Currency,
+	}
+
+	rule, ok := s.rule(operation, s.now())
+	if !ok || userId == s.revenueAccounts[ub.Currency] {
+		return quote, nil
+	}
+	quote.Rule = rule.Name
+
+	if len(rule.freeTiers) > 0 {
+		limits, err := s.limits.GetAccountLimits(ctx, userId)
+		if err != nil {
+			return model.FeeQuote{}, err
+		}
+		if rule.freeTiers[limits.Tier] {
+			quote.FreeTier = true
+			return quote, nil
+		}
+	}
+
+	fee := rule.Flat + amount*rule.Percent/100
+	if fee < rule.Min {
+		fee = rule.Min
+	}
+	if rule.Max > 0 && fee > rule.Max {
+		fee = rule.Max
+	}
+	quote.Fee = roundCents(fee)
+	quote.Total = roundCents(amount + quote.Fee)
+
+	return quote, nil
+}
+
+// RevenueAccount returns the account collecting the fees charged in currency.
+func (s FeeService) RevenueAccount(currency string) (uuid.UUID, error) {
+	accountId, ok := s.revenueAccounts[currency]
+	if !ok {
+		return uuid.UUID{}, fmt.Errorf("no revenue account is configured for fees in %s", currency)
+	}
+
+	return accountId, nil
+}
+
+// rule returns the rule of the operation in effect at the given time.
+func (s FeeService) rule(operation string, at time.Time) (feeRule, bool) {
+	for _, rule := range s.rules {
+		if rule.Operation != operation || rule.effectiveFrom.After(at) {
+			continue
+		}
+		// the latest rule that started and did not end yet decides, an older one applies again once it ends
+		if rule.effectiveTo != nil && !at.Before(*rule.effectiveTo) {
+			continue
+		}
+		return rule, true
+	}
+
+	return feeRule{}, false
+}
@@ -0,0 +1,318 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var testFeeRules = []config.FeeRuleConfig{
+	{
+		Name:          "transfer-2020",
+		Operation:     model.FeeOperationTransfer,
+		Percent:       5,
+		EffectiveFrom: "2020-01-01T00:00:00Z",
+	},
+	{
+		Name:          "transfer-2021",
+		Operation:     model.FeeOperationTransfer,
+		Percent:       1.5,
+		Min:           1,
+		Max:           5,
+		FreeTiers:     []string{"Premium"},
+		EffectiveFrom: "2021-01-01T00:00:00Z",
+	},
+	{
+		Name:          "transfer-promo-2022",
+		Operation:     model.FeeOperationTransfer,
+		Percent:       0.5,
+		EffectiveFrom: "2022-01-01T00:00:00Z",
+		EffectiveTo:   "2022-02-01T00:00:00Z",
+	},
+	{
+		Name:          "withdrawal-2021",
+		Operation:     model.FeeOperationWithdrawal,
+		Flat:          2,
+		EffectiveFrom: "2021-01-01T00:00:00Z",
+		EffectiveTo:   "2022-01-01T00:00:00Z",
+	},
+}
+
+// chargeTestFees charges the test fee rules into the revenue account, at the time given.
+func (ts *testServices) chargeTestFees(revenue uuid.UUID, now time.Time) *testServices {
+	ts.limitsConfig.Tiers["premium"] = config.LimitTierConfig{}
+	ts.feesConfig = config.FeesConfig{
+		// keys come lowercased from the configuration
+		RevenueAccounts: map[string]string{"rub": revenue.String()},
+		Rules:           testFeeRules,
+	}
+	ts.now = func() time.Time { return now }
+	return ts
+}
+
+func TestFeeService_QuoteFee(t *testing.T) {
+	user, premium, revenue := uuid.New(), uuid.New(), uuid.New()
+	balances := map[uuid.UUID]float64{user: 1000, premium: 1000, revenue: 0}
+	tier := "premium"
+
+	tests := []struct {
+		name      string
+		at        time.Time
+		operation string
+		userId    uuid.UUID
+		amount    float64
+		rule      string
+		fee       float64
+		freeTier  bool
+	}{
+		{
+			name:      "Percent of an earlier rule",
+			at:        time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+			operation: model.FeeOperationTransfer,
+			userId:    user,
+			amount:    100,
+			rule:      "transfer-2020",
+			fee:       5,
+		},
+		{
+			name:      "Percent replaced by a later rule",
+			at:        time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+			operation: model.FeeOperationTransfer,
+			userId:    user,
+			amount:    100,
+			rule:      "transfer-2021",
+			fee:       1.5,
+		},
+		{
+			name:      "Min",
+			at:        time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+			operation: model.FeeOperationTransfer,
+			userId:    user,
+			amount:    10,
+			rule:      "transfer-2021",
+			fee:       1,
+		},
+		{
+			name:      "Max",
+			at:        time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+			operation: model.FeeOperationTransfer,
+			userId:    user,
+			amount:    900,
+			rule:      "transfer-2021",
+			fee:       5,
+		},
+		{
+			name:      "Free tier",
+			at:        time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+			operation: model.FeeOperationTransfer,
+			userId:    premium,
+			amount:    100,
+			rule:      "transfer-2021",
+			freeTier:  true,
+		},
+		{
+			name:      "Revenue account",
+			at:        time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+			operation: model.FeeOperationTransfer,
+			userId:    revenue,
+			amount:    100,
+		},
+		{
+			name:      "Flat",
+			at:        time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+			operation: model.FeeOperationWithdrawal,
+			userId:    user,
+			amount:    100,
+			rule:      "withdrawal-2021",
+			fee:       2,
+		},
+		{
+			name:      "Ended rule",
+			at:        time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+			operation: model.FeeOperationWithdrawal,
+			userId:    user,
+			amount:    100,
+		},
+		{
+			name:      "Rule over another one",
+			at:        time.Date(2022, 1, 15, 0, 0, 0, 0, time.UTC),
+			operation: model.FeeOperationTransfer,
+			userId:    user,
+			amount:    100,
+			rule:      "transfer-promo-2022",
+			fee:       0.5,
+		},
+		{
+			name:      "Rule replaced again once the later one ended",
+			at:        time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC),
+			operation: model.FeeOperationTransfer,
+			userId:    user,
+			amount:    100,
+			rule:      "transfer-2021",
+			fee:       1.5,
+		},
+		{
+			name:      "Before every rule",
+			at:        time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+			operation: model.FeeOperationTransfer,
+			userId:    user,
+			amount:    100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServices(balances).chargeTestFees(revenue, tt.at)
+			ts.build()
+			ts.limitRepo.limits[premium] = model.UserLimits{UserId: premium, Tier: &tier}
+
+			quote, err := ts.fees.QuoteFee(context.Background(), tt.operation, tt.userId, tt.amount)
+			assert.NoError(t, err)
+			assert.Equal(t, model.FeeQuote{
+				Operation: tt.operation,
+				UserId:    tt.userId,
+				Amount:    tt.amount,
+				Fee:       tt.fee,
+				Total:     tt.amount + tt.fee,
+				Currency:  "RUB",
+				Rule:      tt.rule,
+				FreeTier:  tt.freeTier,
+			}, quote)
+		})
+	}
+
+	ts := newTestServices(balances).chargeTestFees(revenue, time.Now())
+	ts.build()
+	_, err := ts.fees.QuoteFee(context.Background(), "deposit", user, 100)
+	assert.IsType(t, schemas.ErrorInvalidFeeQuote{}, err)
+	_, err = ts.fees.QuoteFee(context.Background(), model.FeeOperationTransfer, user, 0)
+	assert.IsType(t, schemas.ErrorInvalidFeeQuote{}, err)
+	_, err = ts.fees.QuoteFee(context.Background(), model.FeeOperationTransfer, uuid.New(), 100)
+	assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+}
+
+func TestUserBalanceService_Fees(t *testing.T) {
+	sender, receiver, revenue := uuid.New(), uuid.New(), uuid.New()
+	at := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	t.Run("Transfer", func(t *testing.T) {
+		ts := newTestServices(map[uuid.UUID]float64{sender: 300, receiver: 0, revenue: 0}).chargeTestFees(revenue, at)
+		s := ts.build()
+
+		assert.NoError(t, s.ApplyTransaction(ctx, sender, receiver, 200))
+		assert.Equal(t, map[uuid.UUID]float64{sender: 97, receiver: 200, revenue: 3}, ts.balanceRepo.balances)
+
+		assert.Len(t, ts.logRepo.logs, 4)
+		sent, fee, feeRevenue := ts.logRepo.logs[0], ts.logRepo.logs[2], ts.logRepo.logs[3]
+		assert.Equal(t, 200.0, sent.Amount)
+		assert.Equal(t, model.OperationFee, fee.OperationType)
+		assert.Equal(t, sender, fee.UserId)
+		assert.Equal(t, 3.0, fee.Amount)
+		assert.Equal(t, sent.Id, *fee.FeeOf)
+		assert.Equal(t, model.OperationFeeRevenue, feeRevenue.OperationType)
+		assert.Equal(t, revenue, feeRevenue.UserId)
+		assert.Equal(t, fee.Id, *feeRevenue.RelatedLogId)
+
+		// reversing the fee refunds it
+		reversal, err := s.ReverseOperation(ctx, fee.Id, 0, "goodwill")
+		assert.NoError(t, err)
+		assert.Len(t, reversal.Entries, 2)
+		assert.Equal(t, map[uuid.UUID]float64{sender: 100, receiver: 200, revenue: 0}, ts.balanceRepo.balances)
+	})
+
+	t.Run("Transfer the fee can not be paid for", func(t *testing.T) {
+		ts := newTestServices(map[uuid.UUID]float64{sender: 100, receiver: 0, revenue: 0}).chargeTestFees(revenue, at)
+		s := ts.build()
+
+		err := s.ApplyTransaction(ctx, sender, receiver, 100)
+		assert.IsType(t, schemas.ErrorNotEnoughFunds{}, err)
+		assert.Equal(t, map[uuid.UUID]float64{sender: 100, receiver: 0, revenue: 0}, ts.balanceRepo.balances)
+		assert.Empty(t, ts.logRepo.logs)
+	})
+
+	t.Run("Transfer the fee can not be paid for out of expired bonus", func(t *testing.T) {
+		ts := newTestServices(map[uuid.UUID]float64{sender: 103, receiver: 0, revenue: 0}).chargeTestFees(revenue, at)
+		s := ts.build()
+		_, err := s.bonusRepo.Create(ctx, model.BonusGrant{
+			UserId:    sender,
+			Amount:    3,
+			Remaining: 3,
+			ExpiresAt: time.Now().Add(-time.Hour),
+		})
+		assert.NoError(t, err)
+
+		// the transfer alone fits what is left once the expired bonus is taken back, its fee does not
+		err = s.ApplyTransaction(ctx, sender, receiver, 100)
+		assert.IsType(t, schemas.ErrorNotEnoughFunds{}, err)
+		assert.Equal(t, map[uuid.UUID]float64{sender: 103, receiver: 0, revenue: 0}, ts.balanceRepo.balances)
+		assert.Empty(t, ts.logRepo.logs)
+	})
+
+	t.Run("Pending transfer holds its fee", func(t *testing.T) {
+		ts := newTestServices(map[uuid.UUID]float64{sender: 203, receiver: 0, revenue: 0}).chargeTestFees(revenue, at)
+		s := ts.build()
+
+		_, err := s.AuthorizeTransfer(ctx, sender, receiver, 201, "")
+		assert.IsType(t, schemas.ErrorNotEnoughFunds{}, err)
+
+		full, err := s.AuthorizeTransfer(ctx, sender, receiver, 200, "")
+		assert.NoError(t, err)
+		assert.Equal(t, 3.0, full.Fee)
+		assert.Equal(t, 203.0, full.HeldAmount)
+		assert.Equal(t, 203.0, ts.balanceRepo.held[sender])
+		assert.IsType(t, schemas.ErrorNotEnoughFunds{}, s.ApplyTransaction(ctx, sender, receiver, 1))
+
+		// capturing the whole amount is paid for by the hold, at the fee quoted whatever the rule now
+		ts.fees.now = func() time.Time { return time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC) }
+		_, err = s.CaptureTransfer(ctx, full.Id, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, map[uuid.UUID]float64{sender: 0, receiver: 200, revenue: 3}, ts.balanceRepo.balances)
+		assert.Equal(t, 0.0, ts.balanceRepo.held[sender])
+		assert.Len(t, ts.logRepo.logs, 4)
+	})
+
+	t.Run("Pending transfer releases its fee", func(t *testing.T) {
+		ts := newTestServices(map[uuid.UUID]float64{sender: 406, receiver: 0, revenue: 0}).chargeTestFees(revenue, at)
+		s := ts.build()
+
+		partial, err := s.AuthorizeTransfer(ctx, sender, receiver, 200, "")
+		assert.NoError(t, err)
+		voided, err := s.AuthorizeTransfer(ctx, sender, receiver, 200, "")
+		assert.NoError(t, err)
+		assert.Equal(t, 406.0, ts.balanceRepo.held[sender])
+
+		// a partial capture is charged its share of the fee quoted
+		ts.fees.now = func() time.Time { return time.Date(2022, 1, 15, 0, 0, 0, 0, time.UTC) }
+		_, err = s.CaptureTransfer(ctx, partial.Id, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, 203.0, ts.balanceRepo.held[sender])
+		_, err = s.VoidTransfer(ctx, voided.Id, "")
+		assert.NoError(t, err)
+		assert.Equal(t, 0.0, ts.balanceRepo.held[sender])
+		assert.Equal(t, map[uuid.UUID]float64{sender: 304.5, receiver: 100, revenue: 1.5}, ts.balanceRepo.balances)
+	})
+
+	t.Run("Withdrawal", func(t *testing.T) {
+		ts := newTestServices(map[uuid.UUID]float64{sender: 100, revenue: 0}).chargeTestFees(revenue, at)
+		s := ts.build()
+
+		_, err := s.ChangeUserBalanceByUserId(ctx, sender, -50)
+		assert.NoError(t, err)
+		assert.Equal(t, map[uuid.UUID]float64{sender: 48, revenue: 2}, ts.balanceRepo.balances)
+		assert.Len(t, ts.logRepo.logs, 3)
+		assert.Equal(t, model.OperationDebit, ts.logRepo.logs[0].OperationType)
+		assert.Equal(t, model.OperationFee, ts.logRepo.logs[1].OperationType)
+		assert.Equal(t, ts.logRepo.logs[0].Id, *ts.logRepo.logs[1].FeeOf)
+
+		// credits are free
+		_, err = s.ChangeUserBalanceByUserId(ctx, sender, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, map[uuid.UUID]float64{sender: 58, revenue: 2}, ts.balanceRepo.balances)
+	})
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+	"github.com/Feokrat/user-balance-api/schema"
+)
+
+const (
+	ComponentDatabase      = "database"
+	ComponentMigrations    = "migrations"
+	ComponentExchangeRates = "exchangeRates"
+	ComponentServer        = "server"
+)
+
+type exchangeRateProvider interface {
+	ProviderStatus() model.ComponentHealth
+}
+
+type HealthService struct {
+	healthRepo   repository.Health
+	exchangeRate exchangeRateProvider
+	checkTimeout time.Duration
+	logger       logger.Logger
+
+	shuttingDown int32
+}
+
+func NewHealthService(healthRepo repository.Health, exchangeRate exchangeRateProvider, checkTimeout time.Duration,
+	logger logger.Logger) *HealthService {
+	return &HealthService{
+		healthRepo:   healthRepo,
+		exchangeRate: exchangeRate,
+		checkTimeout: checkTimeout,
+		logger:       logger,
+	}
+}
+
+// SetShuttingDown makes every following readiness check fail, so that load balancers stop
+// routing traffic to the instance before the server stops accepting connections.
+func (s *HealthService) SetShuttingDown() {
+	atomic.StoreInt32(&s.shuttingDown, 1)
+}
+
+func (s *HealthService) Readiness(ctx context.Context) (bool, map[string]model.ComponentHealth) {
+	components := map[string]model.ComponentHealth{
+		ComponentExchangeRates: s.exchangeRate.ProviderStatus(),
+	}
+	ready := true
+
+	if atomic.LoadInt32(&s.shuttingDown) == 1 {
+		components[ComponentServer] = model.ComponentHealth{
+			Status:  model.HealthStatusDown,
+			Message: "server is shutting down",
+		}
+		ready = false
+	} else {
+		components[ComponentServer] = model.ComponentHealth{Status: model.HealthStatusUp}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.checkTimeout)
+	defer cancel()
+
+	components[ComponentDatabase] = s.checkDatabase(ctx)
+	if components[ComponentDatabase].Status != model.HealthStatusUp {
+		components[ComponentMigrations] = model.ComponentHealth{
+			Status:  model.HealthStatusUnknown,
+			Message: "database is unavailable",
+		}
+		return false, components
+	}
+
+	components[ComponentMigrations] = s.checkMigrations(ctx)
+	if components[ComponentMigrations].Status != model.HealthStatusUp {
+		ready = false
+	}
+
+	return ready, components
+}
+
+func (s *HealthService) checkDatabase(ctx context.Context) model.ComponentHealth {
+	start := time.Now()
+	if err := s.healthRepo.Ping(ctx); err != nil {
+		s.logger.WithContext(ctx).Warnf("readiness check of database failed, error: %s", err.Error())
+		return model.ComponentHealth{Status: model.HealthStatusDown, Message: err.Error()}
+	}
+
+	return model.ComponentHealth{
+		Status:  model.HealthStatusUp,
+		Details: map[string]interface{}{"latency": time.Since(start).String()},
+	}
+}
+
+func (s *HealthService) checkMigrations(ctx context.Context) model.ComponentHealth {
+	expected, err := schema.ExpectedVersion()
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("could not determine expected migration version, error: %s", err.Error())
+		return model.ComponentHealth{Status: model.HealthStatusUnknown, Message: err.Error()}
+	}
+
+	version, dirty, err := s.healthRepo.MigrationVersion(ctx)
+	if err != nil {
+		s.logger.WithContext(ctx).Warnf("readiness check of migrations failed, error: %s", err.Error())
+		return model.ComponentHealth{Status: model.HealthStatusDown, Message: err.Error()}
+	}
+
+	details := map[string]interface{}{
+		"version":  version,
+		"expected": expected,
+		"dirty":    dirty,
+	}
+
+	switch {
+	case dirty:
+		return model.ComponentHealth{
+			Status:  model.HealthStatusDown,
+			Message: fmt.Sprintf("migration %v is dirty", version),
+			Details: details,
+		}
+	case version != expected:
+		return model.ComponentHealth{
+			Status:  model.HealthStatusDown,
+			Message: fmt.Sprintf("database is at version %v, expected %v", version, expected),
+			Details: details,
+		}
+	default:
+		return model.ComponentHealth{Status: model.HealthStatusUp, Details: details}
+	}
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+// LimitService enforces the limits of users: those of their tier, overridden per user. The checks are
+// meant to run in the transaction of the operation, after the balance of the user has been locked, so
+// concurrent operations of a user are counted one after another.
+type LimitService struct {
+	limitRepo          repository.Limit
+	userBalanceRepo    repository.UserBalance
+	transactionLogRepo repository.TransactionLog
+	cfg                config.LimitsConfig
+	location           *time.Location
+	logger             logger.Logger
+	now                func() time.Time
+}
+
+func NewLimitService(limitRepo repository.Limit, userBalanceRepo repository.UserBalance,
+	transactionLogRepo repository.TransactionLog, cfg config.LimitsConfig, logger logger.Logger) *LimitService {
+	location, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	return &LimitService{
+		limitRepo:          limitRepo,
+		userBalanceRepo:    userBalanceRepo,
+		transactionLogRepo: transactionLogRepo,
+		cfg:                cfg,
+		location:           location,
+		logger:             logger,
+		now:                time.Now,
+	}
+}
+
+// CheckDebit checks that amount can be taken from the balance of a user, by a transfer when transfer is set.
+func (s LimitService) CheckDebit(ctx context.Context, userId uuid.UUID, amount float64, transfer bool) error {
+	return s.checkDebit(ctx, userId, amount, transfer, 0, 0)
+}
+
+// CheckAuthorization checks that a transfer of amount can be authorized now and captured later. The count
+// transfers the user authorized and did not capture yet, pending in all, are counted as if they were made.
+func (s LimitService) CheckAuthorization(ctx context.Context, userId uuid.UUID, amount float64, pending float64,
+	count int) error {
+	return s.checkDebit(ctx, userId, amount, true, pending, count)
+}
+
+func (s LimitService) checkDebit(ctx context.Context, userId uuid.UUID, amount float64, transfer bool,
+	pending float64, pendingCount int) error {
+	limits, err := s.GetAccountLimits(ctx, userId)
+	if err != nil {
+		return err
+	}
+	effective := limits.Effective
+	now := s.now().In(s.location)
+
+	if transfer && effective.MaxTransfer > 0 && amount > effective.MaxTransfer {
+		return limitExceeded(model.LimitMaxTransfer, effective.MaxTransfer, nil,
+			fmt.Sprintf("transfer of %v exceeds the maximum transfer of %v", amount, effective.MaxTransfer))
+	}
+
+	if transfer && effective.TransfersPerHour > 0 {
+		count, first, err := s.transactionLogRepo.CountTransfersSince(ctx, userId, now.Add(-time.Hour))
+		if err != nil {
+			return err
+		}
+		count += pendingCount
+		if count >= effective.TransfersPerHour {
+			var resetsAt *time.Time
+			if first != nil {
+				at := first.Add(time.Hour)
+				resetsAt = &at
+			}
+			return limitExceeded(model.LimitTransfersPerHour, float64(effective.TransfersPerHour), resetsAt,
+				fmt.Sprintf("user already made %v transfers within an hour", count))
+		}
+	}
+
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, s.location)
+	periods := []struct {
+		limit    string
+		value    float64
+		start    time.Time
+		resetsAt time.Time
+	}{
+		{model.LimitDailyOutgoing, effective.DailyOutgoing, dayStart, dayStart.AddDate(0, 0, 1)},
+		{model.LimitMonthlyOutgoing, effective.MonthlyOutgoing, dayStart.AddDate(0, 0, 1-now.Day()),
+			dayStart.AddDate(0, 1, 1-now.Day())},
+	}
+
+	for _, period := range periods {
+		if period.value <= 0 {
+			continue
+		}
+
+		spent, err := s.transactionLogRepo.SumOutgoingSince(ctx, userId, period.start)
+		if err != nil {
+			return err
+		}
+		spent += pending
+		if roundCents(spent+amount) > period.value {
+			resetsAt := period.resetsAt
+			return limitExceeded(period.limit, period.value, &resetsAt,
+				fmt.Sprintf("%v would bring outgoing total to %v, above the %s limit of %v",
+					amount, roundCents(spent+amount), period.limit, period.value))
+		}
+	}
+
+	return nil
+}
+
+// CheckCredit checks that a user may hold balance, which is the balance after a credit.
+func (s LimitService) CheckCredit(ctx context.Context, userId uuid.UUID, balance float64) error {
+	limits, err := s.GetAccountLimits(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	if maxBalance := limits.Effective.MaxBalance; maxBalance > 0 && roundCents(balance) > maxBalance {
+		return limitExceeded(model.LimitMaxBalance, maxBalance, nil,
+			fmt.Sprintf("balance of %v would exceed the maximum balance of %v", roundCents(balance), maxBalance))
+	}
+
+	return nil
+}
+
+// GetAccountLimits returns the tier, the overrides and the resulting limits of a user.
+func (s LimitService) GetAccountLimits(ctx context.Context, userId uuid.UUID) (model.AccountLimits, error) {
+	overrides, err := s.limitRepo.GetByUserId(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		overrides, err = model.UserLimits{UserId: userId}, nil
+	}
+	if err != nil {
+		s.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not get limits of user, error: %s", err.Error())
+		return model.AccountLimits{}, err
+	}
+
+	tier := s.cfg.DefaultTier
+	if overrides.Tier != nil {
+		tier = *overrides.Tier
+	}
+	tierLimits := s.cfg.Tiers[tier]
+	transfersPerHour := tierLimits.TransfersPerHour
+	if overrides.TransfersPerHour != nil {
+		transfersPerHour = *overrides.TransfersPerHour
+	}
+
+	return model.AccountLimits{
+		UserId:    userId,
+		Tier:      tier,
+		Overrides: overrides,
+		Effective: model.Limits{
+			MaxTransfer:      overrideOr(overrides.MaxTransfer, tierLimits.MaxTransfer),
+			DailyOutgoing:    overrideOr(overrides.DailyOutgoing, tierLimits.DailyOutgoing),
+			MonthlyOutgoing:  overrideOr(overrides.MonthlyOutgoing, tierLimits.MonthlyOutgoing),
+			MaxBalance:       overrideOr(overrides.MaxBalance, tierLimits.MaxBalance),
+			TransfersPerHour: transfersPerHour,
+		},
+	}, nil
+}
+
+// UpdateAccountLimits replaces the overrides of a user.
+func (s LimitService) UpdateAccountLimits(ctx context.Context, userId uuid.UUID, overrides model.UserLimits) (
+	model.AccountLimits, error) {
+	if overrides.Tier != nil {
+		tier := strings.ToLower(*overrides.Tier)
+		if _, ok := s.cfg.Tiers[tier]; !ok {
+			return model.AccountLimits{}, schemas.ErrorInvalidLimits{
+				Message: fmt.Sprintf("unknown tier %q", *overrides.Tier),
+			}
+		}
+		overrides.Tier = &tier
+	}
+	for _, value := range []*float64{overrides.MaxTransfer, overrides.DailyOutgoing, overrides.MonthlyOutgoing,
+		overrides.MaxBalance, intToFloat(overrides.TransfersPerHour)} {
+		if value != nil && *value < 0 {
+			return model.AccountLimits{}, schemas.ErrorInvalidLimits{
+				Message: fmt.Sprintf("limits must not be negative, got %v", *value),
+			}
+		}
+	}
+
+	exists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, userId)
+	if err != nil {
+		return model.AccountLimits{}, err
+	}
+	if !exists {
+		return model.AccountLimits{}, accountNotFound(userId)
+	}
+
+	overrides.UserId = userId
+	overrides.UpdatedAt = time.Now()
+	if err = s.limitRepo.Upsert(ctx, overrides); err != nil {
+		s.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not update limits of user, error: %s", err.Error())
+		return model.AccountLimits{}, err
+	}
+
+	s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"user_id":     userId,
+		"operator_id": overrides.UpdatedBy,
+	}).Infof("updated limits of user")
+
+	return s.GetAccountLimits(ctx, userId)
+}
+
+func limitExceeded(limit string, value float64, resetsAt *time.Time, message string) error {
+	return schemas.ErrorLimitExceeded{
+		Message:    fmt.Sprintf("%s limit exceeded: %s", limit, message),
+		Limit:      limit,
+		LimitValue: value,
+		ResetsAt:   resetsAt,
+	}
+}
+
+func overrideOr(override *float64, value float64) float64 {
+	if override != nil {
+		return *override
+	}
+
+	return value
+}
+
+func intToFloat(value *int) *float64 {
+	if value == nil {
+		return nil
+	}
+
+	f := float64(*value)
+	return &f
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserBalanceService_Limits(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	now := time.Now().UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name             string
+		tier             config.LimitTierConfig
+		operations       func(s *UserBalanceService) error
+		expectedLimit    string
+		expectedResetsAt func(logs []model.TransactionLog) *time.Time
+	}{
+		{
+			name: "Max transfer",
+			tier: config.LimitTierConfig{MaxTransfer: 50},
+			operations: func(s *UserBalanceService) error {
+				if err := s.ApplyTransaction(context.Background(), alice, bob, 50); err != nil {
+					return err
+				}
+				return s.ApplyTransaction(context.Background(), alice, bob, 50.01)
+			},
+			expectedLimit: model.LimitMaxTransfer,
+		},
+		{
+			name: "Max transfer does not apply to debits",
+			tier: config.LimitTierConfig{MaxTransfer: 50},
+			operations: func(s *UserBalanceService) error {
+				_, err := s.ChangeUserBalanceByUserId(context.Background(), alice, -80)
+				return err
+			},
+		},
+		{
+			name: "Transfers per hour",
+			tier: config.LimitTierConfig{TransfersPerHour: 2},
+			operations: func(s *UserBalanceService) error {
+				for i := 0; i < 3; i++ {
+					if err := s.ApplyTransaction(context.Background(), alice, bob, 1); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			expectedLimit: model.LimitTransfersPerHour,
+			expectedResetsAt: func(logs []model.TransactionLog) *time.Time {
+				resetsAt := logs[0].Date.Add(time.Hour)
+				return &resetsAt
+			},
+		},
+		{
+			name: "Daily outgoing counts debits and transfers",
+			tier: config.LimitTierConfig{DailyOutgoing: 100},
+			operations: func(s *UserBalanceService) error {
+				if _, err := s.ChangeUserBalanceByUserId(context.Background(), alice, -60); err != nil {
+					return err
+				}
+				if err := s.ApplyTransaction(context.Background(), alice, bob, 40); err != nil {
+					return err
+				}
+				_, err := s.ChangeUserBalanceByUserId(context.Background(), alice, -1)
+				return err
+			},
+			expectedLimit: model.LimitDailyOutgoing,
+			expectedResetsAt: func([]model.TransactionLog) *time.Time {
+				resetsAt := dayStart.AddDate(0, 0, 1)
+				return &resetsAt
+			},
+		},
+		{
+			name: "Transfers per hour counts escrow fundings",
+			tier: config.LimitTierConfig{TransfersPerHour: 2},
+			operations: func(s *UserBalanceService) error {
+				if _, err := s.FundEscrow(context.Background(), "deal-1", alice, bob, 1); err != nil {
+					return err
+				}
+				if err := s.ApplyTransaction(context.Background(), alice, bob, 1); err != nil {
+					return err
+				}
+				_, err := s.FundEscrow(context.Background(), "deal-2", alice, bob, 1)
+				return err
+			},
+			expectedLimit: model.LimitTransfersPerHour,
+			expectedResetsAt: func(logs []model.TransactionLog) *time.Time {
+				resetsAt := logs[0].Date.Add(time.Hour)
+				return &resetsAt
+			},
+		},
+		{
+			name: "Daily outgoing counts escrow fundings",
+			tier: config.LimitTierConfig{DailyOutgoing: 100},
+			operations: func(s *UserBalanceService) error {
+				if _, err := s.FundEscrow(context.Background(), "deal-1", alice, bob, 60); err != nil {
+					return err
+				}
+				if err := s.ApplyTransaction(context.Background(), alice, bob, 40); err != nil {
+					return err
+				}
+				_, err := s.FundEscrow(context.Background(), "deal-2", alice, bob, 1)
+				return err
+			},
+			expectedLimit: model.LimitDailyOutgoing,
+			expectedResetsAt: func([]model.TransactionLog) *time.Time {
+				resetsAt := dayStart.AddDate(0, 0, 1)
+				return &resetsAt
+			},
+		},
+		{
+			name: "Daily outgoing counts authorized transfers",
+			tier: config.LimitTierConfig{DailyOutgoing: 100},
+			operations: func(s *UserBalanceService) error {
+				if _, err := s.AuthorizeTransfer(context.Background(), alice, bob, 60, ""); err != nil {
+					return err
+				}
+				_, err := s.AuthorizeTransfer(context.Background(), alice, bob, 41, "")
+				return err
+			},
+			expectedLimit: model.LimitDailyOutgoing,
+			expectedResetsAt: func([]model.TransactionLog) *time.Time {
+				resetsAt := dayStart.AddDate(0, 0, 1)
+				return &resetsAt
+			},
+		},
+		{
+			name: "Transfers per hour counts authorized transfers",
+			tier: config.LimitTierConfig{TransfersPerHour: 2},
+			operations: func(s *UserBalanceService) error {
+				for i := 0; i < 3; i++ {
+					if _, err := s.AuthorizeTransfer(context.Background(), alice, bob, 1, ""); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			expectedLimit: model.LimitTransfersPerHour,
+		},
+		{
+			name: "Monthly outgoing",
+			tier: config.LimitTierConfig{MonthlyOutgoing: 100},
+			operations: func(s *UserBalanceService) error {
+				return s.ApplyTransaction(context.Background(), alice, bob, 101)
+			},
+			expectedLimit: model.LimitMonthlyOutgoing,
+			expectedResetsAt: func([]model.TransactionLog) *time.Time {
+				resetsAt := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+				return &resetsAt
+			},
+		},
+		{
+			name: "Max balance of a credited user",
+			tier: config.LimitTierConfig{MaxBalance: 250},
+			operations: func(s *UserBalanceService) error {
+				_, err := s.ChangeUserBalanceByUserId(context.Background(), alice, 51)
+				return err
+			},
+			expectedLimit: model.LimitMaxBalance,
+		},
+		{
+			name: "Max balance of a receiver",
+			tier: config.LimitTierConfig{MaxBalance: 250},
+			operations: func(s *UserBalanceService) error {
+				return s.ApplyTransaction(context.Background(), bob, alice, 51)
+			},
+			expectedLimit: model.LimitMaxBalance,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServices(map[uuid.UUID]float64{alice: 200, bob: 200, testEscrowAccount: 0})
+			ts.limitsConfig.Tiers["standard"] = tt.tier
+			s := ts.build()
+			ts.limits.now = func() time.Time { return now }
+
+			err := tt.operations(s)
+			if tt.expectedLimit == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var limitErr schemas.ErrorLimitExceeded
+			assert.ErrorAs(t, err, &limitErr)
+			assert.Equal(t, tt.expectedLimit, limitErr.Limit)
+			if tt.expectedResetsAt != nil {
+				assert.Equal(t, tt.expectedResetsAt(ts.logRepo.logs).UTC(), limitErr.ResetsAt.UTC())
+			} else {
+				assert.Nil(t, limitErr.ResetsAt)
+			}
+		})
+	}
+}
+
+func TestLimitService_UpdateAccountLimits(t *testing.T) {
+	alice := uuid.New()
+	ts := newTestServices(map[uuid.UUID]float64{alice: 0})
+	ts.limitsConfig.Tiers = map[string]config.LimitTierConfig{
+		"standard": {MaxTransfer: 100, DailyOutgoing: 500},
+		"business": {MaxTransfer: 10000, DailyOutgoing: 50000, TransfersPerHour: 100},
+	}
+	ts.build()
+	limits := ts.limits
+	ctx := context.Background()
+
+	got, err := limits.GetAccountLimits(ctx, alice)
+	assert.NoError(t, err)
+	assert.Equal(t, "standard", got.Tier)
+	assert.Equal(t, model.Limits{MaxTransfer: 100, DailyOutgoing: 500}, got.Effective)
+
+	tier, dailyOutgoing := "Business", 0.0
+	got, err = limits.UpdateAccountLimits(ctx, alice, model.UserLimits{
+		Tier:          &tier,
+		DailyOutgoing: &dailyOutgoing,
+		UpdatedBy:     "operator-1",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "business", got.Tier)
+	assert.Equal(t, model.Limits{MaxTransfer: 10000, TransfersPerHour: 100}, got.Effective,
+		"a zero override lifts the limit of the tier")
+	assert.Equal(t, "operator-1", got.Overrides.UpdatedBy)
+
+	unknown := "vip"
+	_, err = limits.UpdateAccountLimits(ctx, alice, model.UserLimits{Tier: &unknown})
+	assert.IsType(t, schemas.ErrorInvalidLimits{}, err)
+	negative := -1.0
+	_, err = limits.UpdateAccountLimits(ctx, alice, model.UserLimits{MaxBalance: &negative})
+	assert.IsType(t, schemas.ErrorInvalidLimits{}, err)
+	_, err = limits.UpdateAccountLimits(ctx, uuid.New(), model.UserLimits{})
+	assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+}
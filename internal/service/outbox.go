@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+	"github.com/Feokrat/user-balance-api/internal/sink"
+	"github.com/google/uuid"
+)
+
+// outboxDispatchLockKey is the advisory lock serializing dispatch between instances,
+// which keeps events of every user in order.
+const outboxDispatchLockKey int64 = 7301001
+
+// OutboxDispatcher delivers outbox events to a sink at least once and in order per user:
+// once an event of a user fails, the following events of that user wait for the next round.
+type OutboxDispatcher struct {
+	outboxRepo repository.Outbox
+	transactor repository.Transactor
+	sink       sink.Sink
+	cfg        config.OutboxConfig
+	logger     logger.Logger
+}
+
+func NewOutboxDispatcher(outboxRepo repository.Outbox, transactor repository.Transactor, sink sink.Sink,
+	cfg config.OutboxConfig, logger logger.Logger) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		outboxRepo: outboxRepo,
+		transactor: transactor,
+		sink:       sink,
+		cfg:        cfg,
+		logger:     logger,
+	}
+}
+
+// Run dispatches pending events every poll interval until ctx is cancelled.
+func (d *OutboxDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.DispatchPending(ctx); err != nil && ctx.Err() == nil {
+			d.logger.Errorf("could not dispatch outbox events, error: %s", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchPending delivers one batch of pending events and returns how many were delivered.
+func (d *OutboxDispatcher) DispatchPending(ctx context.Context) (int, error) {
+	dispatched := 0
+
+	err := d.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		locked, err := d.transactor.TryAdvisoryLock(ctx, outboxDispatchLockKey)
+		if err != nil || !locked {
+			return err
+		}
+
+		events, err := d.outboxRepo.GetPending(ctx, d.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		blocked := map[uuid.UUID]bool{}
+		for _, event := range events {
+			if blocked[event.UserId] {
+				continue
+			}
+
+			if err := d.sink.Publish(ctx, event); err != nil {
+				d.logger.WithFields(logger.Fields{
+					"user_id":    event.UserId,
+					"event_id":   event.Id,
+					"request_id": event.RequestId,
+				}).Warnf("could not publish %s event, error: %s", event.EventType, err.Error())
+
+				blocked[event.UserId] = true
+				if err := d.outboxRepo.MarkFailed(ctx, event.Id, err.Error()); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := d.outboxRepo.MarkDispatched(ctx, event.Id); err != nil {
+				return err
+			}
+			dispatched++
+		}
+
+		return nil
+	})
+
+	return dispatched, err
+}
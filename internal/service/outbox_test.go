@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTransactor struct{}
+
+func (fakeTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (fakeTransactor) WithinSavepoint(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (fakeTransactor) TryAdvisoryLock(context.Context, int64) (bool, error) {
+	return true, nil
+}
+
+type fakeOutboxRepo struct {
+	events []model.OutboxEvent
+}
+
+func (r *fakeOutboxRepo) Create(_ context.Context, event model.OutboxEvent) (int64, error) {
+	event.Id = int64(len(r.events) + 1)
+	r.events = append(r.events, event)
+	return event.Id, nil
+}
+
+func (r *fakeOutboxRepo) GetPending(_ context.Context, limit int) ([]model.OutboxEvent, error) {
+	var pending []model.OutboxEvent
+	for _, event := range r.events {
+		if event.DispatchedAt == nil && len(pending) < limit {
+			pending = append(pending, event)
+		}
+	}
+	return pending, nil
+}
+
+func (r *fakeOutboxRepo) MarkDispatched(_ context.Context, id int64) error {
+	now := time.Now()
+	r.events[id-1].DispatchedAt = &now
+	r.events[id-1].Attempts++
+	return nil
+}
+
+func (r *fakeOutboxRepo) MarkFailed(_ context.Context, id int64, reason string) error {
+	r.events[id-1].Attempts++
+	r.events[id-1].LastError = reason
+	return nil
+}
+
+type fakeSink struct {
+	failOnce  map[int64]bool
+	published []int64
+}
+
+func (s *fakeSink) Publish(_ context.Context, event model.OutboxEvent) error {
+	if s.failOnce[event.Id] {
+		delete(s.failOnce, event.Id)
+		return errors.New("sink unavailable")
+	}
+	s.published = append(s.published, event.Id)
+	return nil
+}
+
+func TestOutboxDispatcher_DispatchPending(t *testing.T) {
+	first, second := uuid.New(), uuid.New()
+
+	repo := &fakeOutboxRepo{}
+	for _, userId := range []uuid.UUID{first, second, first, second} {
+		_, _ = repo.Create(context.Background(), model.OutboxEvent{UserId: userId})
+	}
+
+	// the first event of the first user fails once, so the third one has to wait for it
+	eventSink := &fakeSink{failOnce: map[int64]bool{1: true}}
+	dispatcher := NewOutboxDispatcher(repo, fakeTransactor{}, eventSink,
+		config.OutboxConfig{BatchSize: 10}, logger.NewDefault())
+
+	dispatched, err := dispatcher.DispatchPending(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, dispatched)
+	assert.Equal(t, []int64{2, 4}, eventSink.published)
+	assert.Equal(t, "sink unavailable", repo.events[0].LastError)
+
+	dispatched, err = dispatcher.DispatchPending(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, dispatched)
+	assert.Equal(t, []int64{2, 4, 1, 3}, eventSink.published)
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+// GetAccountBalance returns the balance of a user with its overdraft limit, an empty balance for users
+// without an account when accounts are created implicitly.
+func (s UserBalanceService) GetAccountBalance(ctx context.Context, userId uuid.UUID) (model.UserBalance, error) {
+	exists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, userId)
+	if err != nil {
+		return model.UserBalance{}, err
+	}
+	if !exists && !s.accounts.ImplicitCreate {
+		return model.UserBalance{}, accountNotFound(userId)
+	}
+	if !exists {
+		s.logger.WithContext(ctx).WithField("user_id", userId).Infof("user does not exist")
+		return model.UserBalance{UserId: userId, Status: model.AccountActive, Currency: s.accounts.DefaultCurrency}, nil
+	}
+
+	ub, err := s.userBalanceRepo.GetByUserId(ctx, userId)
+	if err != nil {
+		s.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not get user balance info of user, error: %s", err.Error())
+		return model.UserBalance{}, err
+	}
+
+	return ub, nil
+}
+
+// SetOverdraftLimit sets how far the balance of a user may go below zero. A limit can not be lowered
+// below what the user already owes.
+func (s UserBalanceService) SetOverdraftLimit(ctx context.Context, userId uuid.UUID, overdraftLimit float64,
+	operatorId string) (model.UserBalance, error) {
+	log := s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"user_id":     userId,
+		"operator_id": operatorId,
+	})
+
+	if overdraftLimit < 0 || math.IsNaN(overdraftLimit) || math.IsInf(overdraftLimit, 0) {
+		return model.UserBalance{}, schemas.ErrorInvalidOverdraft{
+			Message: fmt.Sprintf("overdraft limit must not be negative, got %v", overdraftLimit),
+		}
+	}
+	if strings.TrimSpace(operatorId) == "" {
+		return model.UserBalance{}, schemas.ErrorInvalidOverdraft{
+			Message: "operatorId is required",
+		}
+	}
+	overdraftLimit = roundCents(overdraftLimit)
+
+	var ub model.UserBalance
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		ub, err = s.lockAccount(ctx, userId)
+		if err != nil {
+			return err
+		}
+
+		if ub.Status == model.AccountClosed {
+			return schemas.ErrorAccountClosed{
+				Message: fmt.Sprintf("account of user %v is closed", userId),
+			}
+		}
+		// the bonus is taken back when it expires, the real funds alone must stay within the limit
+		grants, err := s.bonusGrants(ctx, userId)
+		if err != nil {
+			return err
+		}
+		if real := roundCents(ub.Balance - remainingOf(grants)); real < -overdraftLimit {
+			return schemas.ErrorInvalidOverdraft{
+				Message: fmt.Sprintf("overdraft limit of user %v can not be lowered to %v, the real balance is %v",
+					userId, overdraftLimit, real),
+			}
+		}
+
+		if err = s.userBalanceRepo.UpdateOverdraftLimitByUserId(ctx, userId, overdraftLimit); err != nil {
+			return err
+		}
+		ub.OverdraftLimit = overdraftLimit
+
+		return nil
+	})
+	if err != nil {
+		log.Warnf("could not set overdraft limit of user, error: %s", err.Error())
+		return model.UserBalance{}, err
+	}
+
+	log.Infof("set overdraft limit of user to %v", overdraftLimit)
+
+	return ub, nil
+}
+
+// graceEndsAt returns when the interest-free grace period of an overdrawn balance ends.
+func (s UserBalanceService) graceEndsAt(ctx context.Context, userId uuid.UUID) (*time.Time, error) {
+	ub, err := s.userBalanceRepo.GetByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if ub.OverdrawnSince == nil {
+		return nil, nil
+	}
+
+	endsAt := ub.OverdrawnSince.Add(s.overdraft.GracePeriod)
+	if time.Now().After(endsAt) {
+		s.logger.WithContext(ctx).WithField("user_id", userId).
+			Warnf("balance of user is overdrawn past its grace period, which ended at %v", endsAt)
+	}
+
+	return &endsAt, nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserBalanceService_Overdraft(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	s, balanceRepo, logRepo, _ := newTestUserBalanceService(map[uuid.UUID]float64{alice: 20, bob: 0})
+	balanceRepo.overdrafts[alice] = 100
+	ctx := context.Background()
+
+	_, err := s.ChangeUserBalanceByUserId(ctx, alice, -70)
+	assert.NoError(t, err)
+	assert.Equal(t, -50.0, balanceRepo.balances[alice])
+
+	debit := logRepo.logs[len(logRepo.logs)-1]
+	overdrawnSince := balanceRepo.overdrawnSince[alice]
+	assert.NotNil(t, debit.GraceEndsAt)
+	assert.Equal(t, overdrawnSince.Add(30*24*time.Hour), *debit.GraceEndsAt)
+
+	ub, err := s.GetAccountBalance(ctx, alice)
+	assert.NoError(t, err)
+	assert.Equal(t, 50.0, ub.Available())
+
+	err = s.ApplyTransaction(ctx, alice, bob, 50.01)
+	assert.IsType(t, schemas.ErrorNotEnoughFunds{}, err)
+	err = s.ApplyTransaction(ctx, alice, bob, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]float64{alice: -100, bob: 50}, balanceRepo.balances)
+	assert.Equal(t, overdrawnSince, balanceRepo.overdrawnSince[alice], "grace period counts from the first overdraft")
+
+	_, err = s.ChangeUserBalanceByUserId(ctx, alice, 120)
+	assert.NoError(t, err)
+	credit := logRepo.logs[len(logRepo.logs)-1]
+	assert.Nil(t, credit.GraceEndsAt)
+	assert.NotContains(t, balanceRepo.overdrawnSince, alice)
+}
+
+func TestUserBalanceService_SetOverdraftLimit(t *testing.T) {
+	alice, closed := uuid.New(), uuid.New()
+	s, balanceRepo, _, _ := newTestUserBalanceService(map[uuid.UUID]float64{alice: -30, closed: 0})
+	balanceRepo.overdrafts[alice] = 50
+	balanceRepo.statuses[closed] = model.AccountClosed
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		userId         uuid.UUID
+		overdraftLimit float64
+		operatorId     string
+		expectedErr    error
+	}{
+		{name: "Ok", userId: alice, overdraftLimit: 30, operatorId: "operator-1"},
+		{
+			name:           "Below the owed balance",
+			userId:         alice,
+			overdraftLimit: 29.99,
+			operatorId:     "operator-1",
+			expectedErr:    schemas.ErrorInvalidOverdraft{},
+		},
+		{
+			name:           "Negative",
+			userId:         alice,
+			overdraftLimit: -1,
+			operatorId:     "operator-1",
+			expectedErr:    schemas.ErrorInvalidOverdraft{},
+		},
+		{name: "Without operator", userId: alice, overdraftLimit: 30, expectedErr: schemas.ErrorInvalidOverdraft{}},
+		{
+			name:           "Closed account",
+			userId:         closed,
+			overdraftLimit: 30,
+			operatorId:     "operator-1",
+			expectedErr:    schemas.ErrorAccountClosed{},
+		},
+		{
+			name:           "Unknown account",
+			userId:         uuid.New(),
+			overdraftLimit: 30,
+			operatorId:     "operator-1",
+			expectedErr:    schemas.ErrorUserBalanceNotFound{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.SetOverdraftLimit(ctx, tt.userId, tt.overdraftLimit, tt.operatorId)
+			if tt.expectedErr != nil {
+				assert.IsType(t, tt.expectedErr, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.overdraftLimit, got.OverdraftLimit)
+			assert.Equal(t, tt.overdraftLimit, balanceRepo.overdrafts[tt.userId])
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+// maxPaymentRequestNote bounds the note a requester attaches to a payment request.
+const maxPaymentRequestNote = 500
+
+// PaymentRequestService lets users ask each other for money. A request is paid through ApplyTransaction
+// when the payer accepts it, in the same transaction that marks it accepted, and every change of its
+// status is recorded in its history.
+type PaymentRequestService struct {
+	paymentRequestRepo repository.PaymentRequest
+	userBalanceRepo    repository.UserBalance
+	userBalance        UserBalance
+	transactor         repository.Transactor
+	cfg                config.PaymentRequestsConfig
+	logger             logger.Logger
+	now                func() time.Time
+}
+
+func NewPaymentRequestService(paymentRequestRepo repository.PaymentRequest, userBalanceRepo repository.UserBalance,
+	userBalance UserBalance, transactor repository.Transactor, cfg config.PaymentRequestsConfig,
+	logger logger.Logger) *PaymentRequestService {
+	return &PaymentRequestService{
+		paymentRequestRepo: paymentRequestRepo,
+		userBalanceRepo:    userBalanceRepo,
+		userBalance:        userBalance,
+		transactor:         transactor,
+		cfg:                cfg,
+		logger:             logger,
+		now:                time.Now,
+	}
+}
+
+// CreatePaymentRequest asks the payer for amount on behalf of the requester. A nil expiresAt gives the
+// request the default expiry.
+func (s PaymentRequestService) CreatePaymentRequest(ctx context.Context, requesterId uuid.UUID, payerId uuid.UUID,
+	amount float64, note string, expiresAt *time.Time) (model.PaymentRequest, error) {
+	log := s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"requester_id": requesterId,
+		"payer_id":     payerId,
+	})
+
+	now := s.now()
+	note = strings.TrimSpace(note)
+
+	if requesterId == payerId {
+		return model.PaymentRequest{}, schemas.ErrorInvalidPaymentRequest{
+			Message: "requester and payer must differ",
+		}
+	}
+	if amount <= 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return model.PaymentRequest{}, schemas.ErrorInvalidPaymentRequest{
+			Message: fmt.Sprintf("amount must be positive, got %v", amount),
+		}
+	}
+	if len(note) > maxPaymentRequestNote {
+		return model.PaymentRequest{}, schemas.ErrorInvalidPaymentRequest{
+			Message: fmt.Sprintf("note can be at most %d characters long", maxPaymentRequestNote),
+		}
+	}
+	expiry := now.Add(s.cfg.DefaultExpiry)
+	if expiresAt != nil {
+		expiry = *expiresAt
+	}
+	if !expiry.After(now) || expiry.After(now.Add(s.cfg.MaxExpiry)) {
+		return model.PaymentRequest{}, schemas.ErrorInvalidPaymentRequest{
+			Message: fmt.Sprintf("a payment request must expire in the future and within %v, got %v",
+				s.cfg.MaxExpiry, expiry.Format(time.RFC3339)),
+		}
+	}
+
+	for i, userId := range []uuid.UUID{requesterId, payerId} {
+		role := "requester"
+		if i == 1 {
+			role = "payer"
+		}
+		exists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, userId)
+		if err != nil {
+			log.Errorf("could not check if %s exists, error: %s", role, err.Error())
+			return model.PaymentRequest{}, err
+		}
+		if !exists {
+			return model.PaymentRequest{}, schemas.ErrorUserBalanceNotFound{
+				Message: fmt.Sprintf("user balance of %s %v not found", role, userId),
+			}
+		}
+	}
+
+	request := model.PaymentRequest{
+		Id:          uuid.New(),
+		RequesterId: requesterId,
+		PayerId:     payerId,
+		Amount:      roundCents(amount),
+		Note:        note,
+		Status:      model.PaymentRequestPending,
+		ExpiresAt:   expiry,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.paymentRequestRepo.Create(ctx, request); err != nil {
+			return err
+		}
+
+		return s.recordStatusChange(ctx, request, "", &requesterId, "")
+	})
+	if err != nil {
+		log.Errorf("could not create payment request, error: %s", err.Error())
+		return model.PaymentRequest{}, err
+	}
+
+	return request, nil
+}
+
+// GetPaymentRequest returns a payment request with its history.
+func (s PaymentRequestService) GetPaymentRequest(ctx context.Context, id uuid.UUID) (model.PaymentRequest, error) {
+	request, err := s.paymentRequestRepo.Get(ctx, id)
+	if err != nil {
+		return model.PaymentRequest{}, paymentRequestError(id, err)
+	}
+
+	request.Events, err = s.paymentRequestRepo.GetEvents(ctx, id)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("could not get history of payment request %v, error: %s", id, err.Error())
+		return model.PaymentRequest{}, err
+	}
+
+	return request, nil
+}
+
+// GetPaymentRequests returns the requests matching filter, the latest first.
+func (s PaymentRequestService) GetPaymentRequests(ctx context.Context, filter model.PaymentRequestFilter,
+	pageNum int, pageSize int) ([]model.PaymentRequest, error) {
+	if filter.Status != "" && !validPaymentRequestStatus(filter.Status) {
+		return nil, schemas.ErrorInvalidPaymentRequest{
+			Message: fmt.Sprintf("unknown payment request status %q", filter.Status),
+		}
+	}
+
+	requests, err := s.paymentRequestRepo.GetAll(ctx, filter, pageNum, pageSize)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("could not get payment requests, error: %s", err.Error())
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// AcceptPaymentRequest pays a pending request from the payer to the requester. The request stays
+// pending when the transfer fails, so the payer can accept it again once it can pay.
+func (s PaymentRequestService) AcceptPaymentRequest(ctx context.Context, id uuid.UUID, payerId uuid.UUID) (
+	model.PaymentRequest, error) {
+	return s.changeStatus(ctx, id, payerId, model.PaymentRequestAccepted, "",
+		func(ctx context.Context, request model.PaymentRequest) error {
+			return s.userBalance.ApplyTransaction(ctx, request.PayerId, request.RequesterId, request.Amount)
+		})
+}
+
+// DeclinePaymentRequest refuses a pending request on behalf of its payer.
+func (s PaymentRequestService) DeclinePaymentRequest(ctx context.Context, id uuid.UUID, payerId uuid.UUID,
+	reason string) (model.PaymentRequest, error) {
+	return s.changeStatus(ctx, id, payerId, model.PaymentRequestDeclined, reason, nil)
+}
+
+// CancelPaymentRequest withdraws a pending request on behalf of its requester.
+func (s PaymentRequestService) CancelPaymentRequest(ctx context.Context, id uuid.UUID, requesterId uuid.UUID,
+	reason string) (model.PaymentRequest, error) {
+	return s.changeStatus(ctx, id, requesterId, model.PaymentRequestCancelled, reason, nil)
+}
+
+// changeStatus moves a pending request to status on behalf of actorId, the payer unless the request is
+// cancelled, running apply first with the request locked.
+func (s PaymentRequestService) changeStatus(ctx context.Context, id uuid.UUID, actorId uuid.UUID, status string,
+	reason string, apply func(ctx context.Context, request model.PaymentRequest) error) (model.PaymentRequest,
+	error) {
+	log := s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"payment_request_id": id,
+		"actor_id":           actorId,
+	})
+
+	var request model.PaymentRequest
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		request, err = s.paymentRequestRepo.GetForUpdate(ctx, id)
+		if err != nil {
+			return paymentRequestError(id, err)
+		}
+
+		party, role := request.PayerId, "payer"
+		if status == model.PaymentRequestCancelled {
+			party, role = request.RequesterId, "requester"
+		}
+		if actorId != party {
+			return schemas.ErrorInvalidPaymentRequest{
+				Message: fmt.Sprintf("only the %s of payment request %v can make it %s", role, id, status),
+			}
+		}
+
+		now := s.now()
+		if request.Status != model.PaymentRequestPending {
+			return schemas.ErrorInvalidPaymentRequest{
+				Message: fmt.Sprintf("payment request %v is %s and can not be %s", id, request.Status, status),
+			}
+		}
+		// the expiry job may not have marked it yet
+		if !request.ExpiresAt.After(now) {
+			return schemas.ErrorInvalidPaymentRequest{
+				Message: fmt.Sprintf("payment request %v expired at %v", id, request.ExpiresAt.Format(time.RFC3339)),
+			}
+		}
+
+		if apply != nil {
+			if err = apply(ctx, request); err != nil {
+				return err
+			}
+		}
+
+		from := request.Status
+		request.Status = status
+		request.UpdatedAt = now
+		if err = s.paymentRequestRepo.UpdateStatus(ctx, id, status, now); err != nil {
+			return err
+		}
+
+		return s.recordStatusChange(ctx, request, from, &actorId, strings.TrimSpace(reason))
+	})
+	if err != nil {
+		log.Warnf("could not make payment request %s, error: %s", status, err.Error())
+		return model.PaymentRequest{}, err
+	}
+
+	return request, nil
+}
+
+// recordStatusChange adds the change of the status of request from the given one to its history.
+func (s PaymentRequestService) recordStatusChange(ctx context.Context, request model.PaymentRequest, from string,
+	actorId *uuid.UUID, reason string) error {
+	err := s.paymentRequestRepo.CreateEvent(ctx, model.PaymentRequestEvent{
+		PaymentRequestId: request.Id,
+		FromStatus:       from,
+		ToStatus:         request.Status,
+		ActorId:          actorId,
+		Reason:           reason,
+		CreatedAt:        request.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"payment_request_id": request.Id,
+		"requester_id":       request.RequesterId,
+		"payer_id":           request.PayerId,
+	}).Infof("payment request of %v is %s", request.Amount, request.Status)
+	return nil
+}
+
+func paymentRequestError(id uuid.UUID, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return schemas.ErrorPaymentRequestNotFound{
+			Message: fmt.Sprintf("payment request %v not found", id),
+		}
+	}
+
+	return err
+}
+
+func validPaymentRequestStatus(status string) bool {
+	switch status {
+	case model.PaymentRequestPending, model.PaymentRequestAccepted, model.PaymentRequestDeclined,
+		model.PaymentRequestCancelled, model.PaymentRequestExpired:
+		return true
+	}
+
+	return false
+}
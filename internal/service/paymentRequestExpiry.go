@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+)
+
+// PaymentRequestExpiryJob marks pending payment requests past their expiry expired. A batch is expired
+// in one transaction with its requests locked, skipping the ones a user is acting on, so a request is
+// never both accepted and expired.
+type PaymentRequestExpiryJob struct {
+	paymentRequestRepo repository.PaymentRequest
+	transactor         repository.Transactor
+	cfg                config.PaymentRequestsConfig
+	logger             logger.Logger
+	now                func() time.Time
+}
+
+func NewPaymentRequestExpiryJob(paymentRequestRepo repository.PaymentRequest, transactor repository.Transactor,
+	cfg config.PaymentRequestsConfig, logger logger.Logger) *PaymentRequestExpiryJob {
+	return &PaymentRequestExpiryJob{
+		paymentRequestRepo: paymentRequestRepo,
+		transactor:         transactor,
+		cfg:                cfg,
+		logger:             logger,
+		now:                time.Now,
+	}
+}
+
+// Run expires due requests every poll interval until ctx is cancelled.
+func (j *PaymentRequestExpiryJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.ExpireDue(ctx); err != nil && ctx.Err() == nil {
+			j.logger.Errorf("could not expire payment requests, error: %s", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExpireDue expires up to a batch of due requests and returns how many it expired.
+func (j *PaymentRequestExpiryJob) ExpireDue(ctx context.Context) (int, error) {
+	now := j.now()
+	var expired int
+
+	err := j.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		requests, err := j.paymentRequestRepo.GetDue(ctx, now, j.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, request := range requests {
+			if err = j.paymentRequestRepo.UpdateStatus(ctx, request.Id, model.PaymentRequestExpired, now); err != nil {
+				return err
+			}
+
+			err = j.paymentRequestRepo.CreateEvent(ctx, model.PaymentRequestEvent{
+				PaymentRequestId: request.Id,
+				FromStatus:       request.Status,
+				ToStatus:         model.PaymentRequestExpired,
+				CreatedAt:        now,
+			})
+			if err != nil {
+				return err
+			}
+
+			j.logger.WithContext(ctx).WithFields(logger.Fields{
+				"payment_request_id": request.Id,
+				"requester_id":       request.RequesterId,
+				"payer_id":           request.PayerId,
+			}).Infof("payment request of %v is %s", request.Amount, model.PaymentRequestExpired)
+		}
+
+		expired = len(requests)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, nil
+}
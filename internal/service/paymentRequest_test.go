@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePaymentRequestRepo struct {
+	requests map[uuid.UUID]model.PaymentRequest
+	events   []model.PaymentRequestEvent
+}
+
+func (r *fakePaymentRequestRepo) Create(_ context.Context, request model.PaymentRequest) error {
+	r.requests[request.Id] = request
+	return nil
+}
+
+func (r *fakePaymentRequestRepo) Get(_ context.Context, id uuid.UUID) (model.PaymentRequest, error) {
+	request, ok := r.requests[id]
+	if !ok {
+		return model.PaymentRequest{}, sql.ErrNoRows
+	}
+	return request, nil
+}
+
+func (r *fakePaymentRequestRepo) GetForUpdate(ctx context.Context, id uuid.UUID) (model.PaymentRequest, error) {
+	return r.Get(ctx, id)
+}
+
+func (r *fakePaymentRequestRepo) GetAll(_ context.Context, filter model.PaymentRequestFilter, _ int, _ int) (
+	[]model.PaymentRequest, error) {
+	var requests []model.PaymentRequest
+	for _, request := range r.requests {
+		if (filter.PayerId == nil || request.PayerId == *filter.PayerId) &&
+			(filter.RequesterId == nil || request.RequesterId == *filter.RequesterId) &&
+			(filter.Status == "" || request.Status == filter.Status) {
+			requests = append(requests, request)
+		}
+	}
+	return requests, nil
+}
+
+func (r *fakePaymentRequestRepo) GetDue(_ context.Context, now time.Time, limit int) (
+	[]model.PaymentRequest, error) {
+	var due []model.PaymentRequest
+	for _, request := range r.requests {
+		if request.Status == model.PaymentRequestPending && !request.ExpiresAt.After(now) && len(due) < limit {
+			due = append(due, request)
+		}
+	}
+	return due, nil
+}
+
+func (r *fakePaymentRequestRepo) UpdateStatus(_ context.Context, id uuid.UUID, status string,
+	updatedAt time.Time) error {
+	request := r.requests[id]
+	request.Status = status
+	request.UpdatedAt = updatedAt
+	r.requests[id] = request
+	return nil
+}
+
+func (r *fakePaymentRequestRepo) CreateEvent(_ context.Context, event model.PaymentRequestEvent) error {
+	event.Id = int64(len(r.events) + 1)
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *fakePaymentRequestRepo) GetEvents(_ context.Context, id uuid.UUID) ([]model.PaymentRequestEvent, error) {
+	var events []model.PaymentRequestEvent
+	for _, event := range r.events {
+		if event.PaymentRequestId == id {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+var testPaymentRequestsConfig = config.PaymentRequestsConfig{
+	DefaultExpiry: 24 * time.Hour,
+	MaxExpiry:     7 * 24 * time.Hour,
+	BatchSize:     10,
+}
+
+// paymentRequestService builds a payment request service paying through the balance service.
+func (ts *testServices) paymentRequestService() (*PaymentRequestService, *fakePaymentRequestRepo) {
+	requestRepo := &fakePaymentRequestRepo{requests: map[uuid.UUID]model.PaymentRequest{}}
+
+	return NewPaymentRequestService(requestRepo, ts.balanceRepo, ts.build(), fakeTransactor{},
+		testPaymentRequestsConfig, logger.NewDefault()), requestRepo
+}
+
+func TestPaymentRequestService_CreatePaymentRequest(t *testing.T) {
+	requester, payer := uuid.New(), uuid.New()
+	ctx := context.Background()
+	s, requestRepo := newTestServices(map[uuid.UUID]float64{requester: 0, payer: 100}).paymentRequestService()
+
+	request, err := s.CreatePaymentRequest(ctx, requester, payer, 25.5, " dinner ", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, model.PaymentRequestPending, request.Status)
+	assert.Equal(t, "dinner", request.Note)
+	assert.Equal(t, request.CreatedAt.Add(24*time.Hour), request.ExpiresAt)
+
+	assert.Len(t, requestRepo.events, 1)
+	assert.Equal(t, "", requestRepo.events[0].FromStatus)
+	assert.Equal(t, model.PaymentRequestPending, requestRepo.events[0].ToStatus)
+	assert.Equal(t, requester, *requestRepo.events[0].ActorId)
+
+	tooLate := time.Now().Add(8 * 24 * time.Hour)
+	past := time.Now().Add(-time.Minute)
+	invalid := []struct {
+		payerId   uuid.UUID
+		amount    float64
+		expiresAt *time.Time
+	}{
+		{payerId: requester, amount: 10},
+		{payerId: payer, amount: 0},
+		{payerId: payer, amount: 10, expiresAt: &tooLate},
+		{payerId: payer, amount: 10, expiresAt: &past},
+	}
+	for _, tt := range invalid {
+		_, err = s.CreatePaymentRequest(ctx, requester, tt.payerId, tt.amount, "", tt.expiresAt)
+		assert.IsType(t, schemas.ErrorInvalidPaymentRequest{}, err)
+	}
+
+	_, err = s.CreatePaymentRequest(ctx, requester, uuid.New(), 10, "", nil)
+	assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+	assert.Len(t, requestRepo.requests, 1)
+}
+
+func TestPaymentRequestService_AcceptPaymentRequest(t *testing.T) {
+	requester, payer := uuid.New(), uuid.New()
+	ctx := context.Background()
+	ts := newTestServices(map[uuid.UUID]float64{requester: 0, payer: 20})
+	s, requestRepo := ts.paymentRequestService()
+
+	request, err := s.CreatePaymentRequest(ctx, requester, payer, 30, "", nil)
+	assert.NoError(t, err)
+
+	// only the payer can accept it
+	_, err = s.AcceptPaymentRequest(ctx, request.Id, requester)
+	assert.IsType(t, schemas.ErrorInvalidPaymentRequest{}, err)
+
+	// a failed transfer leaves it pending
+	_, err = s.AcceptPaymentRequest(ctx, request.Id, payer)
+	assert.IsType(t, schemas.ErrorNotEnoughFunds{}, err)
+	assert.Equal(t, model.PaymentRequestPending, requestRepo.requests[request.Id].Status)
+
+	ts.balanceRepo.balances[payer] = 50
+	accepted, err := s.AcceptPaymentRequest(ctx, request.Id, payer)
+	assert.NoError(t, err)
+	assert.Equal(t, model.PaymentRequestAccepted, accepted.Status)
+	assert.Equal(t, map[uuid.UUID]float64{requester: 30, payer: 20}, ts.balanceRepo.balances)
+
+	_, err = s.CancelPaymentRequest(ctx, request.Id, requester, "")
+	assert.IsType(t, schemas.ErrorInvalidPaymentRequest{}, err)
+
+	got, err := s.GetPaymentRequest(ctx, request.Id)
+	assert.NoError(t, err)
+	assert.Len(t, got.Events, 2)
+	assert.Equal(t, model.PaymentRequestPending, got.Events[1].FromStatus)
+	assert.Equal(t, model.PaymentRequestAccepted, got.Events[1].ToStatus)
+	assert.Equal(t, payer, *got.Events[1].ActorId)
+
+	_, err = s.GetPaymentRequest(ctx, uuid.New())
+	assert.IsType(t, schemas.ErrorPaymentRequestNotFound{}, err)
+}
+
+func TestPaymentRequestService_DeclineAndCancel(t *testing.T) {
+	requester, payer := uuid.New(), uuid.New()
+	ctx := context.Background()
+	ts := newTestServices(map[uuid.UUID]float64{requester: 0, payer: 100})
+	s, requestRepo := ts.paymentRequestService()
+
+	declined, err := s.CreatePaymentRequest(ctx, requester, payer, 10, "", nil)
+	assert.NoError(t, err)
+	cancelled, err := s.CreatePaymentRequest(ctx, requester, payer, 20, "", nil)
+	assert.NoError(t, err)
+
+	_, err = s.DeclinePaymentRequest(ctx, declined.Id, requester, "")
+	assert.IsType(t, schemas.ErrorInvalidPaymentRequest{}, err)
+	_, err = s.CancelPaymentRequest(ctx, cancelled.Id, payer, "")
+	assert.IsType(t, schemas.ErrorInvalidPaymentRequest{}, err)
+
+	request, err := s.DeclinePaymentRequest(ctx, declined.Id, payer, " not mine ")
+	assert.NoError(t, err)
+	assert.Equal(t, model.PaymentRequestDeclined, request.Status)
+	request, err = s.CancelPaymentRequest(ctx, cancelled.Id, requester, "")
+	assert.NoError(t, err)
+	assert.Equal(t, model.PaymentRequestCancelled, request.Status)
+
+	_, err = s.AcceptPaymentRequest(ctx, declined.Id, payer)
+	assert.IsType(t, schemas.ErrorInvalidPaymentRequest{}, err)
+	assert.Equal(t, 100.0, ts.balanceRepo.balances[payer])
+
+	last := requestRepo.events[len(requestRepo.events)-2]
+	assert.Equal(t, model.PaymentRequestDeclined, last.ToStatus)
+	assert.Equal(t, "not mine", last.Reason)
+
+	incoming, err := s.GetPaymentRequests(ctx, model.PaymentRequestFilter{PayerId: &payer,
+		Status: model.PaymentRequestDeclined}, 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, incoming, 1)
+	_, err = s.GetPaymentRequests(ctx, model.PaymentRequestFilter{PayerId: &payer, Status: "paid"}, 0, 10)
+	assert.IsType(t, schemas.ErrorInvalidPaymentRequest{}, err)
+}
+
+func TestPaymentRequestExpiryJob_ExpireDue(t *testing.T) {
+	requester, payer := uuid.New(), uuid.New()
+	ctx := context.Background()
+	s, requestRepo := newTestServices(map[uuid.UUID]float64{requester: 0, payer: 100}).paymentRequestService()
+
+	due, err := s.CreatePaymentRequest(ctx, requester, payer, 10, "", nil)
+	assert.NoError(t, err)
+	pending, err := s.CreatePaymentRequest(ctx, requester, payer, 20, "", nil)
+	assert.NoError(t, err)
+
+	request := requestRepo.requests[due.Id]
+	request.ExpiresAt = time.Now().Add(-time.Minute)
+	requestRepo.requests[due.Id] = request
+
+	// a request past its expiry can not be accepted while it waits for the job
+	_, err = s.AcceptPaymentRequest(ctx, due.Id, payer)
+	assert.IsType(t, schemas.ErrorInvalidPaymentRequest{}, err)
+
+	job := NewPaymentRequestExpiryJob(requestRepo, fakeTransactor{}, testPaymentRequestsConfig, logger.NewDefault())
+	expired, err := job.ExpireDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	assert.Equal(t, model.PaymentRequestExpired, requestRepo.requests[due.Id].Status)
+	assert.Equal(t, model.PaymentRequestPending, requestRepo.requests[pending.Id].Status)
+
+	last := requestRepo.events[len(requestRepo.events)-1]
+	assert.Equal(t, due.Id, last.PaymentRequestId)
+	assert.Equal(t, model.PaymentRequestExpired, last.ToStatus)
+	assert.Nil(t, last.ActorId)
+
+	expired, err = job.ExpireDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, expired)
+}
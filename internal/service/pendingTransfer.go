@@ -0,0 +1,366 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+// maxPendingTransferReference bounds the reference a caller attaches to a pending transfer.
+const maxPendingTransferReference = 255
+
+// pendingTransferExpired is the void reason of the transfers voided once their TTL is over.
+const pendingTransferExpired = "expired"
+
+// AuthorizeTransfer holds amount and the fee quoted for it from the real funds of the sender for a transfer
+// to the receiver. Nothing moves until the transfer is captured, the hold is released if it is voided or
+// expires first.
+func (s UserBalanceService) AuthorizeTransfer(ctx context.Context, senderId uuid.UUID, receiverId uuid.UUID,
+	amount float64, reference string) (model.PendingTransfer, error) {
+	log := s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"sender_id":   senderId,
+		"receiver_id": receiverId,
+	})
+
+	reference = strings.TrimSpace(reference)
+
+	if senderId == receiverId {
+		return model.PendingTransfer{}, schemas.ErrorInvalidPendingTransfer{
+			Message: "sender and receiver must differ",
+		}
+	}
+	if amount <= 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return model.PendingTransfer{}, schemas.ErrorInvalidPendingTransfer{
+			Message: fmt.Sprintf("amount must be positive, got %v", amount),
+		}
+	}
+	if len(reference) > maxPendingTransferReference {
+		return model.PendingTransfer{}, schemas.ErrorInvalidPendingTransfer{
+			Message: fmt.Sprintf("reference can be at most %d characters long", maxPendingTransferReference),
+		}
+	}
+	amount = roundCents(amount)
+
+	for i, userId := range []uuid.UUID{senderId, receiverId} {
+		role := "sender"
+		if i == 1 {
+			role = "receiver"
+		}
+		exists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, userId)
+		if err != nil {
+			log.Errorf("could not check if %s exists, error: %s", role, err.Error())
+			return model.PendingTransfer{}, err
+		}
+		if !exists {
+			return model.PendingTransfer{}, schemas.ErrorUserBalanceNotFound{
+				Message: fmt.Sprintf("user balance of %s %v not found", role, userId),
+			}
+		}
+	}
+
+	now := time.Now()
+	transfer := model.PendingTransfer{
+		Id:         uuid.New(),
+		SenderId:   senderId,
+		ReceiverId: receiverId,
+		Amount:     amount,
+		Reference:  reference,
+		Status:     model.PendingTransferAuthorized,
+		ExpiresAt:  now.Add(s.pending.TTL),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		quote, err := s.quoteFee(ctx, model.FeeOperationTransfer, senderId, amount)
+		if err != nil {
+			return err
+		}
+
+		balances, err := s.lockPayer(ctx, quote, senderId, receiverId)
+		if err != nil {
+			log.Errorf("could not lock balances of sender and receiver, error: %s", err.Error())
+			return err
+		}
+		sender, receiver := balances[senderId], balances[receiverId]
+
+		if err = checkCanDebit(sender); err != nil {
+			log.Warnf("sender can not send money, error: %s", err.Error())
+			return err
+		}
+		if err = checkCanCredit(receiver); err != nil {
+			log.Warnf("receiver can not receive money, error: %s", err.Error())
+			return err
+		}
+		if err = checkSameCurrency(sender, receiver); err != nil {
+			log.Warnf("transfer between currencies refused, error: %s", err.Error())
+			return err
+		}
+		pending, count, err := s.pendingRepo.SumOutgoing(ctx, senderId)
+		if err != nil {
+			log.Errorf("could not sum pending transfers of sender, error: %s", err.Error())
+			return err
+		}
+		// the limits are not checked again on capture, so what is authorized already counts as sent
+		if err = s.limits.CheckAuthorization(ctx, senderId, amount, pending, count); err != nil {
+			log.Warnf("transfer exceeds a limit of sender, error: %s", err.Error())
+			return err
+		}
+		if err = s.limits.CheckCredit(ctx, receiverId, receiver.Balance+amount); err != nil {
+			log.Warnf("transfer exceeds a limit of receiver, error: %s", err.Error())
+			return err
+		}
+
+		grants, err := s.bonusGrants(ctx, senderId)
+		if err != nil {
+			log.Errorf("could not get bonus grants of sender, error: %s", err.Error())
+			return err
+		}
+		// bonus could expire while it is held, so the amount and the fee are held out of real funds
+		if quote.Total > spendableBalance(sender, grants, false, now) {
+			log.Warnf("sender can not afford the transfer and its fee")
+			return schemas.ErrorNotEnoughFunds{
+				Message: fmt.Sprintf("User %v has less money than %v out of bonus, the amount and its fee of %v",
+					senderId, quote.Total, quote.Fee),
+			}
+		}
+
+		if _, err = s.userBalanceRepo.UpdateHeldByUserId(ctx, senderId, quote.Total); err != nil {
+			log.Errorf("could not hold money of sender, error: %s", err.Error())
+			return err
+		}
+
+		transfer.Fee = quote.Fee
+		transfer.HeldAmount = quote.Total
+		return s.pendingRepo.Create(ctx, transfer)
+	})
+	if err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	log.WithField("pending_transfer_id", transfer.Id).Infof("authorized transfer of %v holding %v until %v",
+		amount, transfer.HeldAmount, transfer.ExpiresAt.Format(time.RFC3339))
+	return transfer, nil
+}
+
+// GetPendingTransfer returns a pending transfer whatever its status.
+func (s UserBalanceService) GetPendingTransfer(ctx context.Context, id uuid.UUID) (model.PendingTransfer, error) {
+	transfer, err := s.pendingRepo.Get(ctx, id)
+	if err != nil {
+		return model.PendingTransfer{}, pendingTransferError(id, err)
+	}
+
+	return transfer, nil
+}
+
+// CaptureTransfer completes an authorized transfer with amount, the whole authorized amount when amount
+// is zero. The hold is released in full and amount is transferred with the fee quoted at authorization, in
+// proportion to what is captured, so capturing less than was authorized gives the rest back to the sender.
+// The sender was checked against its limits and funds when the money was held and is not checked again.
+func (s UserBalanceService) CaptureTransfer(ctx context.Context, id uuid.UUID, amount float64) (
+	model.PendingTransfer, error) {
+	log := s.logger.WithContext(ctx).WithField("pending_transfer_id", id)
+
+	if amount < 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return model.PendingTransfer{}, schemas.ErrorInvalidPendingTransfer{
+			Message: fmt.Sprintf("amount can not be negative, got %v", amount),
+		}
+	}
+
+	var transfer model.PendingTransfer
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		transfer, err = s.pendingRepo.GetForUpdate(ctx, id)
+		if err != nil {
+			return pendingTransferError(id, err)
+		}
+		if transfer.Status != model.PendingTransferAuthorized {
+			return schemas.ErrorInvalidPendingTransfer{
+				Message: fmt.Sprintf("pending transfer %v is %s and can not be captured", id, transfer.Status),
+			}
+		}
+		// the expiry job may not have voided it yet
+		if !transfer.ExpiresAt.After(time.Now()) {
+			return schemas.ErrorInvalidPendingTransfer{
+				Message: fmt.Sprintf("pending transfer %v expired at %v", id, transfer.ExpiresAt.Format(time.RFC3339)),
+			}
+		}
+
+		captured := transfer.Amount
+		if amount > 0 {
+			captured = roundCents(amount)
+		}
+		if captured > transfer.Amount {
+			return schemas.ErrorInvalidPendingTransfer{
+				Message: fmt.Sprintf("can not capture %v of pending transfer %v authorized for %v", captured, id,
+					transfer.Amount),
+			}
+		}
+
+		sender, err := s.userBalanceRepo.GetByUserId(ctx, transfer.SenderId)
+		if err != nil {
+			log.Errorf("could not get account of sender, error: %s", err.Error())
+			return err
+		}
+		quote := captureQuote(transfer, captured, sender.Currency)
+
+		// lock the balances before the hold is released, the transfer locks them again in the same order
+		balances, err := s.lockPayer(ctx, quote, transfer.SenderId, transfer.ReceiverId)
+		if err != nil {
+			log.Errorf("could not lock balances of sender and receiver, error: %s", err.Error())
+			return err
+		}
+		if err = checkCanDebit(balances[transfer.SenderId]); err != nil {
+			log.Warnf("sender can not send money, error: %s", err.Error())
+			return err
+		}
+		receiver := balances[transfer.ReceiverId]
+		if err = checkCanCredit(receiver); err != nil {
+			log.Warnf("receiver can not receive money, error: %s", err.Error())
+			return err
+		}
+		if err = s.limits.CheckCredit(ctx, transfer.ReceiverId, receiver.Balance+captured); err != nil {
+			log.Warnf("transfer exceeds a limit of receiver, error: %s", err.Error())
+			return err
+		}
+
+		if _, err = s.userBalanceRepo.UpdateHeldByUserId(ctx, transfer.SenderId, -transfer.HeldAmount); err != nil {
+			log.Errorf("could not release money held from sender, error: %s", err.Error())
+			return err
+		}
+
+		sent, err := s.moveTransfer(ctx, quote, transfer.ReceiverId, false)
+		if err != nil {
+			return err
+		}
+
+		transfer.Status = model.PendingTransferCaptured
+		transfer.CapturedAmount = captured
+		transfer.TransactionLogId = &sent.Id
+		transfer.UpdatedAt = time.Now()
+		return s.pendingRepo.Update(ctx, transfer)
+	})
+	if err != nil {
+		log.Warnf("could not capture pending transfer, error: %s", err.Error())
+		return model.PendingTransfer{}, err
+	}
+
+	log.Infof("captured %v of pending transfer of %v", transfer.CapturedAmount, transfer.Amount)
+	return transfer, nil
+}
+
+// VoidTransfer cancels an authorized transfer and releases the money held from the sender.
+func (s UserBalanceService) VoidTransfer(ctx context.Context, id uuid.UUID, reason string) (
+	model.PendingTransfer, error) {
+	var transfer model.PendingTransfer
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		transfer, err = s.voidTransfer(ctx, id, strings.TrimSpace(reason), nil)
+		return err
+	})
+	if err != nil {
+		s.logger.WithContext(ctx).WithField("pending_transfer_id", id).
+			Warnf("could not void pending transfer, error: %s", err.Error())
+		return model.PendingTransfer{}, err
+	}
+
+	return transfer, nil
+}
+
+// ExpirePendingTransfer voids an authorized transfer expired by the given time, it reports false when the
+// transfer was captured or voided in the meantime.
+func (s UserBalanceService) ExpirePendingTransfer(ctx context.Context, id uuid.UUID, at time.Time) (bool, error) {
+	expired := true
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.voidTransfer(ctx, id, pendingTransferExpired, &at)
+		if errors.As(err, &schemas.ErrorInvalidPendingTransfer{}) {
+			expired = false
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return expired, nil
+}
+
+// voidTransfer voids an authorized transfer within the transaction of ctx, one expired by dueAt unless
+// dueAt is nil.
+func (s UserBalanceService) voidTransfer(ctx context.Context, id uuid.UUID, reason string, dueAt *time.Time) (
+	model.PendingTransfer, error) {
+	transfer, err := s.pendingRepo.GetForUpdate(ctx, id)
+	if err != nil {
+		return model.PendingTransfer{}, pendingTransferError(id, err)
+	}
+	if transfer.Status != model.PendingTransferAuthorized {
+		return model.PendingTransfer{}, schemas.ErrorInvalidPendingTransfer{
+			Message: fmt.Sprintf("pending transfer %v is %s and can not be voided", id, transfer.Status),
+		}
+	}
+	if dueAt != nil && transfer.ExpiresAt.After(*dueAt) {
+		return model.PendingTransfer{}, schemas.ErrorInvalidPendingTransfer{
+			Message: fmt.Sprintf("pending transfer %v expires at %v", id, transfer.ExpiresAt.Format(time.RFC3339)),
+		}
+	}
+
+	if _, err = s.lockBalances(ctx, transfer.SenderId); err != nil {
+		return model.PendingTransfer{}, err
+	}
+	if _, err = s.userBalanceRepo.UpdateHeldByUserId(ctx, transfer.SenderId, -transfer.HeldAmount); err != nil {
+		s.logger.WithContext(ctx).WithField("user_id", transfer.SenderId).
+			Errorf("could not release money held from sender, error: %s", err.Error())
+		return model.PendingTransfer{}, err
+	}
+
+	transfer.Status = model.PendingTransferVoided
+	transfer.VoidReason = reason
+	transfer.UpdatedAt = time.Now()
+	if err = s.pendingRepo.Update(ctx, transfer); err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"pending_transfer_id": id,
+		"sender_id":           transfer.SenderId,
+	}).Infof("voided pending transfer of %v, released the %v held", transfer.Amount, transfer.HeldAmount)
+	return transfer, nil
+}
+
+// captureQuote returns the fee quote of capturing captured of a transfer, the fee quoted at authorization in
+// proportion to what is captured.
+func captureQuote(transfer model.PendingTransfer, captured float64, currency string) model.FeeQuote {
+	fee := transfer.Fee
+	if captured < transfer.Amount {
+		fee = roundCents(transfer.Fee * captured / transfer.Amount)
+	}
+
+	return model.FeeQuote{
+		Operation: model.FeeOperationTransfer,
+		UserId:    transfer.SenderId,
+		Amount:    captured,
+		Fee:       fee,
+		Total:     roundCents(captured + fee),
+		Currency:  currency,
+	}
+}
+
+func pendingTransferError(id uuid.UUID, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return schemas.ErrorPendingTransferNotFound{
+			Message: fmt.Sprintf("pending transfer %v not found", id),
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+)
+
+// PendingTransferExpiryJob voids authorized transfers past their TTL and releases what they hold. Each
+// transfer is voided in its own transaction with it locked, so a transfer captured meanwhile is left as is.
+type PendingTransferExpiryJob struct {
+	pendingTransferRepo repository.PendingTransfer
+	pendingTransfers    PendingTransfers
+	cfg                 config.PendingTransfersConfig
+	logger              logger.Logger
+	now                 func() time.Time
+}
+
+func NewPendingTransferExpiryJob(pendingTransferRepo repository.PendingTransfer, pendingTransfers PendingTransfers,
+	cfg config.PendingTransfersConfig, logger logger.Logger) *PendingTransferExpiryJob {
+	return &PendingTransferExpiryJob{
+		pendingTransferRepo: pendingTransferRepo,
+		pendingTransfers:    pendingTransfers,
+		cfg:                 cfg,
+		logger:              logger,
+		now:                 time.Now,
+	}
+}
+
+// Run voids due transfers every poll interval until ctx is cancelled.
+func (j *PendingTransferExpiryJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.ExpireDue(ctx); err != nil && ctx.Err() == nil {
+			j.logger.Errorf("could not expire pending transfers, error: %s", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExpireDue voids up to a batch of due transfers and returns how many it voided.
+func (j *PendingTransferExpiryJob) ExpireDue(ctx context.Context) (int, error) {
+	now := j.now()
+
+	ids, err := j.pendingTransferRepo.GetDueIds(ctx, now, j.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var voided int
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return voided, ctx.Err()
+		}
+
+		expired, err := j.pendingTransfers.ExpirePendingTransfer(ctx, id, now)
+		if err != nil {
+			return voided, err
+		}
+		if expired {
+			voided++
+		}
+	}
+
+	return voided, nil
+}
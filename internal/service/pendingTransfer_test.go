@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePendingTransferRepo struct {
+	transfers map[uuid.UUID]model.PendingTransfer
+}
+
+func newFakePendingTransferRepo() *fakePendingTransferRepo {
+	return &fakePendingTransferRepo{transfers: map[uuid.UUID]model.PendingTransfer{}}
+}
+
+func (r *fakePendingTransferRepo) Create(_ context.Context, transfer model.PendingTransfer) error {
+	r.transfers[transfer.Id] = transfer
+	return nil
+}
+
+func (r *fakePendingTransferRepo) Get(_ context.Context, id uuid.UUID) (model.PendingTransfer, error) {
+	transfer, ok := r.transfers[id]
+	if !ok {
+		return model.PendingTransfer{}, sql.ErrNoRows
+	}
+	return transfer, nil
+}
+
+func (r *fakePendingTransferRepo) GetForUpdate(ctx context.Context, id uuid.UUID) (model.PendingTransfer, error) {
+	return r.Get(ctx, id)
+}
+
+func (r *fakePendingTransferRepo) Update(_ context.Context, transfer model.PendingTransfer) error {
+	r.transfers[transfer.Id] = transfer
+	return nil
+}
+
+func (r *fakePendingTransferRepo) GetDueIds(_ context.Context, now time.Time, limit int) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	for id, transfer := range r.transfers {
+		if transfer.Status == model.PendingTransferAuthorized && !transfer.ExpiresAt.After(now) && len(ids) < limit {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (r *fakePendingTransferRepo) SumIncoming(_ context.Context, receiverId uuid.UUID) (float64, error) {
+	var sum float64
+	for _, transfer := range r.transfers {
+		if transfer.ReceiverId == receiverId && transfer.Status == model.PendingTransferAuthorized {
+			sum += transfer.Amount
+		}
+	}
+	return sum, nil
+}
+
+func (r *fakePendingTransferRepo) SumOutgoing(_ context.Context, senderId uuid.UUID) (float64, int, error) {
+	var sum float64
+	var count int
+	for _, transfer := range r.transfers {
+		if transfer.SenderId == senderId && transfer.Status == model.PendingTransferAuthorized {
+			sum += transfer.Amount
+			count++
+		}
+	}
+	return sum, count, nil
+}
+
+var testPendingTransfersConfig = config.PendingTransfersConfig{
+	TTL:       24 * time.Hour,
+	BatchSize: 10,
+}
+
+func TestUserBalanceService_AuthorizeTransfer(t *testing.T) {
+	sender, receiver := uuid.New(), uuid.New()
+	ctx := context.Background()
+	s, balanceRepo, logRepo, _ := newTestUserBalanceService(map[uuid.UUID]float64{sender: 100, receiver: 0})
+
+	transfer, err := s.AuthorizeTransfer(ctx, sender, receiver, 60, " order-1 ")
+	assert.NoError(t, err)
+	assert.Equal(t, model.PendingTransferAuthorized, transfer.Status)
+	assert.Equal(t, "order-1", transfer.Reference)
+	assert.Equal(t, transfer.CreatedAt.Add(24*time.Hour), transfer.ExpiresAt)
+
+	// the money is held, not moved
+	assert.Equal(t, map[uuid.UUID]float64{sender: 100, receiver: 0}, balanceRepo.balances)
+	assert.Equal(t, 60.0, balanceRepo.held[sender])
+	assert.Empty(t, logRepo.logs)
+
+	// what is held can not be spent nor held twice
+	assert.IsType(t, schemas.ErrorNotEnoughFunds{}, s.ApplyTransaction(ctx, sender, receiver, 50))
+	_, err = s.AuthorizeTransfer(ctx, sender, receiver, 50, "")
+	assert.IsType(t, schemas.ErrorNotEnoughFunds{}, err)
+
+	breakdown, err := s.GetBalanceBreakdown(ctx, sender)
+	assert.NoError(t, err)
+	assert.Equal(t, 60.0, breakdown.Pending)
+	breakdown, err = s.GetBalanceBreakdown(ctx, receiver)
+	assert.NoError(t, err)
+	assert.Equal(t, 60.0, breakdown.PendingIncoming)
+
+	for _, tt := range []struct {
+		receiverId uuid.UUID
+		amount     float64
+	}{
+		{receiverId: sender, amount: 10},
+		{receiverId: receiver, amount: 0},
+		{receiverId: receiver, amount: -5},
+	} {
+		_, err = s.AuthorizeTransfer(ctx, sender, tt.receiverId, tt.amount, "")
+		assert.IsType(t, schemas.ErrorInvalidPendingTransfer{}, err)
+	}
+	_, err = s.AuthorizeTransfer(ctx, sender, uuid.New(), 10, "")
+	assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+}
+
+func TestUserBalanceService_CaptureTransfer(t *testing.T) {
+	sender, receiver := uuid.New(), uuid.New()
+	ctx := context.Background()
+	s, balanceRepo, logRepo, _ := newTestUserBalanceService(map[uuid.UUID]float64{sender: 100, receiver: 0})
+
+	full, err := s.AuthorizeTransfer(ctx, sender, receiver, 30, "")
+	assert.NoError(t, err)
+	partial, err := s.AuthorizeTransfer(ctx, sender, receiver, 50, "")
+	assert.NoError(t, err)
+
+	_, err = s.CaptureTransfer(ctx, partial.Id, 60)
+	assert.IsType(t, schemas.ErrorInvalidPendingTransfer{}, err)
+
+	captured, err := s.CaptureTransfer(ctx, full.Id, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, model.PendingTransferCaptured, captured.Status)
+	assert.Equal(t, 30.0, captured.CapturedAmount)
+	assert.Equal(t, logRepo.logs[0].Id, *captured.TransactionLogId)
+	assert.Equal(t, model.OperationTransferOut, logRepo.logs[0].OperationType)
+
+	// capturing less gives the rest of the hold back to the sender
+	captured, err = s.CaptureTransfer(ctx, partial.Id, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, 20.0, captured.CapturedAmount)
+	assert.Equal(t, map[uuid.UUID]float64{sender: 50, receiver: 50}, balanceRepo.balances)
+	assert.Equal(t, 0.0, balanceRepo.held[sender])
+
+	_, err = s.CaptureTransfer(ctx, partial.Id, 0)
+	assert.IsType(t, schemas.ErrorInvalidPendingTransfer{}, err)
+	_, err = s.VoidTransfer(ctx, partial.Id, "")
+	assert.IsType(t, schemas.ErrorInvalidPendingTransfer{}, err)
+	_, err = s.CaptureTransfer(ctx, uuid.New(), 0)
+	assert.IsType(t, schemas.ErrorPendingTransferNotFound{}, err)
+}
+
+func TestUserBalanceService_VoidTransfer(t *testing.T) {
+	sender, receiver := uuid.New(), uuid.New()
+	ctx := context.Background()
+	s, balanceRepo, _, _ := newTestUserBalanceService(map[uuid.UUID]float64{sender: 100, receiver: 0})
+
+	transfer, err := s.AuthorizeTransfer(ctx, sender, receiver, 100, "")
+	assert.NoError(t, err)
+
+	// the sender can not close its account while money is held
+	_, err = s.CloseAccount(ctx, sender, nil, "user request", "operator")
+	assert.IsType(t, schemas.ErrorInvalidAccountStatus{}, err)
+
+	voided, err := s.VoidTransfer(ctx, transfer.Id, " cancelled order ")
+	assert.NoError(t, err)
+	assert.Equal(t, model.PendingTransferVoided, voided.Status)
+	assert.Equal(t, "cancelled order", voided.VoidReason)
+	assert.Equal(t, 0.0, balanceRepo.held[sender])
+	assert.Equal(t, map[uuid.UUID]float64{sender: 100, receiver: 0}, balanceRepo.balances)
+
+	_, err = s.CaptureTransfer(ctx, transfer.Id, 0)
+	assert.IsType(t, schemas.ErrorInvalidPendingTransfer{}, err)
+	assert.NoError(t, s.ApplyTransaction(ctx, sender, receiver, 100))
+}
+
+func TestPendingTransferExpiryJob_ExpireDue(t *testing.T) {
+	sender, receiver := uuid.New(), uuid.New()
+	ctx := context.Background()
+	s, balanceRepo, _, _ := newTestUserBalanceService(map[uuid.UUID]float64{sender: 100, receiver: 0})
+	pendingRepo := s.pendingRepo.(*fakePendingTransferRepo)
+
+	due, err := s.AuthorizeTransfer(ctx, sender, receiver, 40, "")
+	assert.NoError(t, err)
+	pending, err := s.AuthorizeTransfer(ctx, sender, receiver, 10, "")
+	assert.NoError(t, err)
+
+	transfer := pendingRepo.transfers[due.Id]
+	transfer.ExpiresAt = time.Now().Add(-time.Minute)
+	pendingRepo.transfers[due.Id] = transfer
+
+	// a transfer past its TTL can not be captured while it waits for the job
+	_, err = s.CaptureTransfer(ctx, due.Id, 0)
+	assert.IsType(t, schemas.ErrorInvalidPendingTransfer{}, err)
+
+	job := NewPendingTransferExpiryJob(pendingRepo, s, testPendingTransfersConfig, logger.NewDefault())
+	voided, err := job.ExpireDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, voided)
+	assert.Equal(t, model.PendingTransferVoided, pendingRepo.transfers[due.Id].Status)
+	assert.Equal(t, "expired", pendingRepo.transfers[due.Id].VoidReason)
+	assert.Equal(t, model.PendingTransferAuthorized, pendingRepo.transfers[pending.Id].Status)
+	assert.Equal(t, 10.0, balanceRepo.held[sender])
+
+	// one that is not due is left alone
+	expired, err := s.ExpirePendingTransfer(ctx, pending.Id, time.Now())
+	assert.NoError(t, err)
+	assert.False(t, expired)
+
+	voided, err = job.ExpireDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, voided)
+}
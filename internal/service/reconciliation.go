@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+// ReconciliationService compares balances with the net of their logged movements and records the
+// accounts where they differ. A mismatch is only corrected once an operator approves it, by a correcting
+// entry bringing the log in line with the balance.
+type ReconciliationService struct {
+	reconciliationRepo repository.Reconciliation
+	userBalanceRepo    repository.UserBalance
+	transactionLogRepo repository.TransactionLog
+	transactor         repository.Transactor
+	cfg                config.ReconciliationConfig
+	logger             logger.Logger
+	now                func() time.Time
+}
+
+func NewReconciliationService(reconciliationRepo repository.Reconciliation, userBalanceRepo repository.UserBalance,
+	transactionLogRepo repository.TransactionLog, transactor repository.Transactor, cfg config.ReconciliationConfig,
+	logger logger.Logger) *ReconciliationService {
+	return &ReconciliationService{
+		reconciliationRepo: reconciliationRepo,
+		userBalanceRepo:    userBalanceRepo,
+		transactionLogRepo: transactionLogRepo,
+		transactor:         transactor,
+		cfg:                cfg,
+		logger:             logger,
+		now:                time.Now,
+	}
+}
+
+// Reconcile compares every account, or sampleSize random accounts when it is positive, and returns the
+// report of the run.
+func (s ReconciliationService) Reconcile(ctx context.Context, trigger string, sampleSize int) (
+	model.ReconciliationReport, error) {
+	if sampleSize < 0 {
+		return model.ReconciliationReport{}, schemas.ErrorInvalidReconciliation{
+			Message: fmt.Sprintf("sample size must not be negative, got %v", sampleSize),
+		}
+	}
+
+	run := s.newRun(trigger, sampleSize)
+	if err := s.reconciliationRepo.CreateRun(ctx, run); err != nil {
+		return model.ReconciliationReport{}, err
+	}
+
+	return s.execute(ctx, run)
+}
+
+func (s ReconciliationService) newRun(trigger string, sampleSize int) model.ReconciliationRun {
+	return model.ReconciliationRun{
+		Id:         uuid.New(),
+		Trigger:    trigger,
+		SampleSize: sampleSize,
+		Status:     model.ReconciliationRunning,
+		StartedAt:  s.now(),
+	}
+}
+
+// execute compares the accounts of a created run and records its outcome, a failed run keeps the
+// mismatches found until it failed.
+func (s ReconciliationService) execute(ctx context.Context, run model.ReconciliationRun) (
+	model.ReconciliationReport, error) {
+	log := s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"reconciliation_id": run.Id,
+		"trigger":           run.Trigger,
+	})
+
+	report := model.ReconciliationReport{Mismatches: []model.ReconciliationMismatch{}}
+	err := s.compare(ctx, run.SampleSize, func(comparison model.BalanceComparison) error {
+		run.Checked++
+
+		difference := roundCents(comparison.Balance - comparison.LoggedBalance)
+		if difference == 0 {
+			return nil
+		}
+
+		mismatch := model.ReconciliationMismatch{
+			RunId:         run.Id,
+			UserId:        comparison.UserId,
+			Balance:       comparison.Balance,
+			LoggedBalance: comparison.LoggedBalance,
+			Difference:    difference,
+			Status:        model.MismatchOpen,
+		}
+		id, err := s.reconciliationRepo.CreateMismatch(ctx, mismatch)
+		if err != nil {
+			return err
+		}
+		mismatch.Id = id
+
+		log.WithField("user_id", comparison.UserId).Warnf("balance %v differs from logged balance %v by %v",
+			comparison.Balance, comparison.LoggedBalance, difference)
+		run.Mismatched++
+		run.TotalDifference = roundCents(run.TotalDifference + difference)
+		report.Mismatches = append(report.Mismatches, mismatch)
+		return nil
+	})
+
+	finishedAt := s.now()
+	run.FinishedAt = &finishedAt
+	run.Status = model.ReconciliationCompleted
+	if err != nil {
+		log.Errorf("reconciliation failed after checking %d accounts, error: %s", run.Checked, err.Error())
+		run.Status = model.ReconciliationFailed
+		run.Error = err.Error()
+	} else {
+		log.Infof("reconciliation checked %d accounts, %d mismatched", run.Checked, run.Mismatched)
+	}
+
+	// the outcome is recorded even when the run was cancelled
+	updateCtx := logger.ContextWithRequestID(context.Background(), logger.RequestID(ctx))
+	if updateErr := s.reconciliationRepo.UpdateRun(updateCtx, run); updateErr != nil && err == nil {
+		err = updateErr
+	}
+	report.Run = run
+
+	return report, err
+}
+
+// compare hands the comparisons of the accounts of a run to fn, batch by batch.
+func (s ReconciliationService) compare(ctx context.Context, sampleSize int,
+	fn func(comparison model.BalanceComparison) error) error {
+	if sampleSize > 0 {
+		comparisons, err := s.reconciliationRepo.SampleBalances(ctx, sampleSize)
+		if err != nil {
+			return err
+		}
+		for _, comparison := range comparisons {
+			if err := fn(comparison); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	after := uuid.Nil
+	for {
+		comparisons, err := s.reconciliationRepo.CompareBalances(ctx, after, s.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, comparison := range comparisons {
+			if err := fn(comparison); err != nil {
+				return err
+			}
+		}
+		if len(comparisons) < s.cfg.BatchSize {
+			return nil
+		}
+		after = comparisons[len(comparisons)-1].UserId
+	}
+}
+
+func (s ReconciliationService) GetReconciliation(ctx context.Context, id uuid.UUID) (
+	model.ReconciliationReport, error) {
+	run, err := s.reconciliationRepo.GetRun(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.ReconciliationReport{}, schemas.ErrorReconciliationNotFound{
+			Message: fmt.Sprintf("reconciliation %v not found", id),
+		}
+	}
+	if err != nil {
+		return model.ReconciliationReport{}, err
+	}
+
+	mismatches, err := s.reconciliationRepo.GetMismatches(ctx, id)
+	if err != nil {
+		return model.ReconciliationReport{}, err
+	}
+	if mismatches == nil {
+		mismatches = []model.ReconciliationMismatch{}
+	}
+
+	return model.ReconciliationReport{Run: run, Mismatches: mismatches}, nil
+}
+
+func (s ReconciliationService) GetReconciliations(ctx context.Context, pageNum int, pageSize int) (
+	[]model.ReconciliationRun, error) {
+	return s.reconciliationRepo.GetRuns(ctx, pageNum, pageSize)
+}
+
+// ApproveCorrection writes the correcting entry of an open mismatch. The account is compared again
+// first: a mismatch gone meanwhile is resolved without an entry, one that changed has to be
+// reconciled again.
+func (s ReconciliationService) ApproveCorrection(ctx context.Context, mismatchId int64, operatorId string) (
+	model.ReconciliationMismatch, error) {
+	log := s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"mismatch_id": mismatchId,
+		"operator_id": operatorId,
+	})
+
+	if strings.TrimSpace(operatorId) == "" {
+		return model.ReconciliationMismatch{}, schemas.ErrorInvalidReconciliation{
+			Message: "operatorId is required",
+		}
+	}
+
+	var mismatch model.ReconciliationMismatch
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		mismatch, err = s.reconciliationRepo.GetMismatchForUpdate(ctx, mismatchId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return schemas.ErrorReconciliationNotFound{
+				Message: fmt.Sprintf("mismatch %v not found", mismatchId),
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if mismatch.Status != model.MismatchOpen {
+			return schemas.ErrorInvalidCorrection{
+				Message: fmt.Sprintf("mismatch %v is already %s", mismatchId, mismatch.Status),
+			}
+		}
+
+		// the account is locked so that no operation changes the balance or the log meanwhile
+		ub, err := s.userBalanceRepo.GetByUserIdForUpdate(ctx, mismatch.UserId)
+		if err != nil {
+			return err
+		}
+		now := s.now()
+		logged, err := s.transactionLogRepo.SumChangesBetween(ctx, mismatch.UserId, time.Time{}, now)
+		if err != nil {
+			return err
+		}
+
+		difference := roundCents(ub.Balance - logged)
+		if difference != 0 && difference != mismatch.Difference {
+			return schemas.ErrorInvalidCorrection{
+				Message: fmt.Sprintf("balance of user %v now differs from its log by %v instead of %v, "+
+					"reconcile it again", mismatch.UserId, difference, mismatch.Difference),
+			}
+		}
+
+		mismatch.Status = model.MismatchResolved
+		mismatch.ResolvedBy = operatorId
+		mismatch.ResolvedAt = &now
+
+		if difference != 0 {
+			operationType := model.OperationCorrectionCredit
+			if difference < 0 {
+				operationType = model.OperationCorrectionDebit
+			}
+			id, err := s.transactionLogRepo.Create(ctx, model.TransactionLog{
+				UserId:        mismatch.UserId,
+				Date:          now,
+				Amount:        math.Abs(difference),
+				Commentary:    fmt.Sprintf("Correction of reconciliation %v approved by %s", mismatch.RunId, operatorId),
+				RequestId:     logger.RequestID(ctx),
+				OperationType: operationType,
+			})
+			if err != nil {
+				return err
+			}
+			mismatch.Status = model.MismatchCorrected
+			mismatch.CorrectionLogId = &id
+		}
+
+		return s.reconciliationRepo.UpdateMismatch(ctx, mismatch)
+	})
+	if err != nil {
+		log.Warnf("could not approve correction, error: %s", err.Error())
+		return model.ReconciliationMismatch{}, err
+	}
+
+	log.WithField("user_id", mismatch.UserId).Infof("mismatch of %v %s", mismatch.Difference, mismatch.Status)
+
+	return mismatch, nil
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/model"
+)
+
+// reconciliationLockKey is the advisory lock letting a single instance start a scheduled run.
+const reconciliationLockKey int64 = 7301003
+
+// Run starts a scheduled reconciliation whenever one is due, checking every poll interval until ctx is
+// cancelled.
+func (s ReconciliationService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.RunDue(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Errorf("could not run scheduled reconciliation, error: %s", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue runs a scheduled reconciliation when none started within the interval and reports whether it
+// did. The run is created under an advisory lock, so with several instances only one of them runs it.
+func (s ReconciliationService) RunDue(ctx context.Context) (bool, error) {
+	var run *model.ReconciliationRun
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		locked, err := s.transactor.TryAdvisoryLock(ctx, reconciliationLockKey)
+		if err != nil || !locked {
+			return err
+		}
+
+		latest, err := s.reconciliationRepo.GetLatestRun(ctx, model.ReconciliationTriggerSchedule)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err == nil && s.now().Before(latest.StartedAt.Add(s.cfg.Interval)) {
+			return nil
+		}
+
+		created := s.newRun(model.ReconciliationTriggerSchedule, s.cfg.SampleSize)
+		if err := s.reconciliationRepo.CreateRun(ctx, created); err != nil {
+			return err
+		}
+		run = &created
+		return nil
+	})
+	if err != nil || run == nil {
+		return false, err
+	}
+
+	_, err = s.execute(ctx, *run)
+	return true, err
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeReconciliationRepo compares the balances of balances with the entries of logs.
+type fakeReconciliationRepo struct {
+	balances   *fakeUserBalanceRepo
+	logs       *fakeTransactionLogRepo
+	runs       []model.ReconciliationRun
+	mismatches []model.ReconciliationMismatch
+}
+
+func (r *fakeReconciliationRepo) CreateRun(_ context.Context, run model.ReconciliationRun) error {
+	r.runs = append(r.runs, run)
+	return nil
+}
+
+func (r *fakeReconciliationRepo) UpdateRun(_ context.Context, run model.ReconciliationRun) error {
+	for i := range r.runs {
+		if r.runs[i].Id == run.Id {
+			r.runs[i] = run
+		}
+	}
+	return nil
+}
+
+func (r *fakeReconciliationRepo) GetRun(_ context.Context, id uuid.UUID) (model.ReconciliationRun, error) {
+	for _, run := range r.runs {
+		if run.Id == id {
+			return run, nil
+		}
+	}
+	return model.ReconciliationRun{}, sql.ErrNoRows
+}
+
+func (r *fakeReconciliationRepo) GetLatestRun(_ context.Context, trigger string) (model.ReconciliationRun, error) {
+	for i := len(r.runs) - 1; i >= 0; i-- {
+		if r.runs[i].Trigger == trigger {
+			return r.runs[i], nil
+		}
+	}
+	return model.ReconciliationRun{}, sql.ErrNoRows
+}
+
+func (r *fakeReconciliationRepo) GetRuns(context.Context, int, int) ([]model.ReconciliationRun, error) {
+	return r.runs, nil
+}
+
+func (r *fakeReconciliationRepo) compare(userId uuid.UUID) model.BalanceComparison {
+	logged, _ := r.logs.SumChangesBetween(context.Background(), userId, time.Time{}, time.Now())
+	return model.BalanceComparison{UserId: userId, Balance: r.balances.balances[userId], LoggedBalance: logged}
+}
+
+func (r *fakeReconciliationRepo) userIds() []uuid.UUID {
+	var ids []uuid.UUID
+	for id := range r.balances.balances {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+	return ids
+}
+
+func (r *fakeReconciliationRepo) CompareBalances(_ context.Context, afterUserId uuid.UUID, limit int) (
+	[]model.BalanceComparison, error) {
+	var comparisons []model.BalanceComparison
+	for _, id := range r.userIds() {
+		if bytes.Compare(id[:], afterUserId[:]) > 0 && len(comparisons) < limit {
+			comparisons = append(comparisons, r.compare(id))
+		}
+	}
+	return comparisons, nil
+}
+
+func (r *fakeReconciliationRepo) SampleBalances(_ context.Context, size int) ([]model.BalanceComparison, error) {
+	var comparisons []model.BalanceComparison
+	for _, id := range r.userIds()[:size] {
+		comparisons = append(comparisons, r.compare(id))
+	}
+	return comparisons, nil
+}
+
+func (r *fakeReconciliationRepo) CreateMismatch(_ context.Context, mismatch model.ReconciliationMismatch) (
+	int64, error) {
+	mismatch.Id = int64(len(r.mismatches) + 1)
+	r.mismatches = append(r.mismatches, mismatch)
+	return mismatch.Id, nil
+}
+
+func (r *fakeReconciliationRepo) GetMismatches(_ context.Context, runId uuid.UUID) (
+	[]model.ReconciliationMismatch, error) {
+	var mismatches []model.ReconciliationMismatch
+	for _, mismatch := range r.mismatches {
+		if mismatch.RunId == runId {
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+	return mismatches, nil
+}
+
+func (r *fakeReconciliationRepo) GetMismatchForUpdate(_ context.Context, id int64) (
+	model.ReconciliationMismatch, error) {
+	if id < 1 || int(id) > len(r.mismatches) {
+		return model.ReconciliationMismatch{}, sql.ErrNoRows
+	}
+	return r.mismatches[id-1], nil
+}
+
+func (r *fakeReconciliationRepo) UpdateMismatch(_ context.Context, mismatch model.ReconciliationMismatch) error {
+	r.mismatches[mismatch.Id-1] = mismatch
+	return nil
+}
+
+// reconciliationService builds a reconciliation service comparing the balances with the logs.
+func (ts *testServices) reconciliationService() (*ReconciliationService, *fakeReconciliationRepo) {
+	repo := &fakeReconciliationRepo{balances: ts.balanceRepo, logs: ts.logRepo}
+
+	s := NewReconciliationService(repo, ts.balanceRepo, ts.logRepo, fakeTransactor{}, config.ReconciliationConfig{
+		Interval:  24 * time.Hour,
+		BatchSize: 1,
+	}, logger.NewDefault())
+
+	return s, repo
+}
+
+func TestReconciliationService_Reconcile(t *testing.T) {
+	matching, drifted, overdrawn := uuid.New(), uuid.New(), uuid.New()
+	date := time.Now().Add(-time.Hour)
+	ts := newTestServices(map[uuid.UUID]float64{matching: 100, drifted: 50, overdrawn: -5})
+	ts.logRepo.logs = []model.TransactionLog{
+		{UserId: matching, Date: date, Amount: 100, OperationType: model.OperationCredit},
+		{UserId: drifted, Date: date, Amount: 40, OperationType: model.OperationCredit},
+		{UserId: overdrawn, Date: date, Amount: 5, OperationType: model.OperationCredit},
+		{UserId: overdrawn, Date: date, Amount: 5, OperationType: model.OperationTransferOut},
+	}
+	s, repo := ts.reconciliationService()
+	ctx := context.Background()
+
+	report, err := s.Reconcile(ctx, model.ReconciliationTriggerAdmin, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ReconciliationCompleted, report.Run.Status)
+	assert.Equal(t, 3, report.Run.Checked)
+	assert.Equal(t, 2, report.Run.Mismatched)
+	assert.Equal(t, 5.0, report.Run.TotalDifference)
+	assert.NotNil(t, report.Run.FinishedAt)
+	assert.Len(t, report.Mismatches, 2)
+
+	differences := map[uuid.UUID]float64{}
+	for _, mismatch := range report.Mismatches {
+		assert.Equal(t, model.MismatchOpen, mismatch.Status)
+		differences[mismatch.UserId] = mismatch.Difference
+	}
+	assert.Equal(t, map[uuid.UUID]float64{drifted: 10, overdrawn: -5}, differences)
+
+	stored, err := s.GetReconciliation(ctx, report.Run.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, report, stored)
+
+	sampled, err := s.Reconcile(ctx, model.ReconciliationTriggerCLI, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sampled.Run.Checked)
+	assert.Equal(t, 1, sampled.Run.SampleSize)
+
+	_, err = s.Reconcile(ctx, model.ReconciliationTriggerCLI, -1)
+	assert.IsType(t, schemas.ErrorInvalidReconciliation{}, err)
+	_, err = s.GetReconciliation(ctx, uuid.New())
+	assert.IsType(t, schemas.ErrorReconciliationNotFound{}, err)
+	assert.Len(t, repo.runs, 2)
+}
+
+func TestReconciliationService_ApproveCorrection(t *testing.T) {
+	drifted, overdrawn, changed := uuid.New(), uuid.New(), uuid.New()
+	date := time.Now().Add(-time.Hour)
+	ts := newTestServices(map[uuid.UUID]float64{drifted: 50, overdrawn: -5, changed: 20})
+	ts.logRepo.logs = []model.TransactionLog{
+		{UserId: drifted, Date: date, Amount: 40, OperationType: model.OperationCredit},
+		{UserId: changed, Date: date, Amount: 10, OperationType: model.OperationCredit},
+	}
+	s, repo := ts.reconciliationService()
+	ctx := context.Background()
+
+	report, err := s.Reconcile(ctx, model.ReconciliationTriggerAdmin, 0)
+	assert.NoError(t, err)
+	mismatches := map[uuid.UUID]int64{}
+	for _, mismatch := range report.Mismatches {
+		mismatches[mismatch.UserId] = mismatch.Id
+	}
+	assert.Len(t, mismatches, 3)
+
+	mismatch, err := s.ApproveCorrection(ctx, mismatches[drifted], "operator-1")
+	assert.NoError(t, err)
+	assert.Equal(t, model.MismatchCorrected, mismatch.Status)
+	assert.Equal(t, "operator-1", mismatch.ResolvedBy)
+	correction, _ := repo.logs.GetById(ctx, *mismatch.CorrectionLogId)
+	assert.Equal(t, model.OperationCorrectionCredit, correction.OperationType)
+	assert.Equal(t, 10.0, correction.Amount)
+	assert.Equal(t, 50.0, repo.balances.balances[drifted], "a correction leaves the balance as it is")
+
+	mismatch, err = s.ApproveCorrection(ctx, mismatches[overdrawn], "operator-1")
+	assert.NoError(t, err)
+	correction, _ = repo.logs.GetById(ctx, *mismatch.CorrectionLogId)
+	assert.Equal(t, model.OperationCorrectionDebit, correction.OperationType)
+	assert.Equal(t, 5.0, correction.Amount)
+
+	_, err = s.ApproveCorrection(ctx, mismatches[drifted], "operator-1")
+	assert.IsType(t, schemas.ErrorInvalidCorrection{}, err)
+
+	// the drift of the last account grows before the approval, then disappears
+	repo.balances.balances[changed] = 25
+	_, err = s.ApproveCorrection(ctx, mismatches[changed], "operator-1")
+	assert.IsType(t, schemas.ErrorInvalidCorrection{}, err)
+	repo.balances.balances[changed] = 10
+	mismatch, err = s.ApproveCorrection(ctx, mismatches[changed], "operator-1")
+	assert.NoError(t, err)
+	assert.Equal(t, model.MismatchResolved, mismatch.Status)
+	assert.Nil(t, mismatch.CorrectionLogId)
+
+	report, err = s.Reconcile(ctx, model.ReconciliationTriggerAdmin, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Run.Mismatched)
+
+	_, err = s.ApproveCorrection(ctx, mismatches[drifted], "")
+	assert.IsType(t, schemas.ErrorInvalidReconciliation{}, err)
+	_, err = s.ApproveCorrection(ctx, 100, "operator-1")
+	assert.IsType(t, schemas.ErrorReconciliationNotFound{}, err)
+}
+
+func TestReconciliationService_RunDue(t *testing.T) {
+	s, repo := newTestServices(map[uuid.UUID]float64{uuid.New(): 10}).reconciliationService()
+	now := time.Date(2021, 3, 1, 3, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	ran, err := s.RunDue(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ran)
+	assert.Equal(t, model.ReconciliationTriggerSchedule, repo.runs[0].Trigger)
+	assert.Equal(t, 1, repo.runs[0].Mismatched)
+
+	now = now.Add(23 * time.Hour)
+	ran, err = s.RunDue(ctx)
+	assert.NoError(t, err)
+	assert.False(t, ran, "a run started within the interval")
+
+	// another instance holds the lock
+	now = now.Add(time.Hour)
+	s.transactor = lockedTransactor{}
+	ran, err = s.RunDue(ctx)
+	assert.NoError(t, err)
+	assert.False(t, ran)
+
+	s.transactor = fakeTransactor{}
+	ran, err = s.RunDue(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ran)
+	assert.Len(t, repo.runs, 2)
+}
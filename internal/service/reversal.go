@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+)
+
+// ReverseOperation reverses amount of the operation recorded by a transaction log entry, all that is
+// left of it when amount is zero. Compensating entries referencing the reversed entries are written
+// and the reversed entries are marked as partially or fully reversed. Reversing either entry of a
+// transfer moves the money back from the receiver to the sender.
+func (s UserBalanceService) ReverseOperation(ctx context.Context, logId int32, amount float64,
+	reason string) (model.Reversal, error) {
+	if amount < 0 {
+		return model.Reversal{}, schemas.ErrorInvalidReversal{
+			Message: fmt.Sprintf("amount to reverse must be positive, got %v", amount),
+		}
+	}
+
+	var reversal model.Reversal
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		reversal, err = s.reverseOperation(ctx, logId, amount, reason)
+		return err
+	})
+	if err != nil {
+		s.logger.WithContext(ctx).WithField("transaction_log_id", logId).
+			Warnf("could not reverse operation, error: %s", err.Error())
+		return model.Reversal{}, err
+	}
+
+	return reversal, nil
+}
+
+func (s UserBalanceService) reverseOperation(ctx context.Context, logId int32, amount float64,
+	reason string) (model.Reversal, error) {
+	entries, err := s.lockReversedEntries(ctx, logId)
+	if err != nil {
+		return model.Reversal{}, err
+	}
+
+	// the entries of a transfer are reversed together, so the first one tells what is left
+	left := roundCents(entries[0].Amount - entries[0].ReversedAmount)
+	if left <= 0 {
+		return model.Reversal{}, schemas.ErrorInvalidReversal{
+			Message: fmt.Sprintf("operation %v is already reversed", logId),
+		}
+	}
+	if amount == 0 {
+		amount = left
+	}
+	if roundCents(amount) > left {
+		return model.Reversal{}, schemas.ErrorInvalidReversal{
+			Message: fmt.Sprintf("can not reverse %v of operation %v, only %v is left to reverse",
+				amount, logId, left),
+		}
+	}
+
+	commentary := fmt.Sprintf("Reversed %v rubles of operation %v", amount, logId)
+	if reason != "" {
+		commentary += ": " + reason
+	}
+
+	compensating, err := s.compensate(ctx, entries, amount, commentary)
+	if err != nil {
+		return model.Reversal{}, err
+	}
+
+	reversal := model.Reversal{Entries: compensating}
+	for _, entry := range entries {
+		updated, err := s.transactionLogRepo.AddReversedAmount(ctx, entry.Id, amount)
+		if err != nil {
+			return model.Reversal{}, err
+		}
+		if updated.Id == logId {
+			reversal.Original = updated
+		}
+	}
+
+	original := reversal.Original
+	err = s.publishEvent(ctx, original.UserId, model.EventOperationReversed, model.OperationReversedEvent{
+		TransactionLogId: original.Id,
+		Amount:           amount,
+		ReversedAmount:   original.ReversedAmount,
+		ReversalStatus:   original.ReversalStatus,
+		Reason:           reason,
+	})
+	if err != nil {
+		return model.Reversal{}, err
+	}
+
+	return reversal, nil
+}
+
+// lockReversedEntries locks the entry with id and, for a transfer, its other entry. The outgoing
+// entry of a transfer is always locked and returned first, so concurrent reversals of the same
+// transfer can not deadlock. A fee and its revenue entry are reversed together like a transfer,
+// which refunds the fee. The share of a receiver of a split transfer is reversed together with the
+// outgoing entry, moving that share back to the sender.
+func (s UserBalanceService) lockReversedEntries(ctx context.Context, id int32) ([]model.TransactionLog, error) {
+	entry, err := s.transactionLogRepo.GetById(ctx, id)
+	if err != nil {
+		return nil, transactionLogError(id, err)
+	}
+
+	ids := []int32{id}
+	switch entry.OperationType {
+	case model.OperationCredit, model.OperationDebit:
+	case model.OperationTransferOut, model.OperationFee:
+		incoming, err := s.transactionLogRepo.GetByRelatedLogId(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, notReversible(id, "it has no linked incoming entry")
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, incoming.Id)
+	case model.OperationTransferIn, model.OperationFeeRevenue:
+		if entry.RelatedLogId == nil {
+			return nil, notReversible(id, "it has no linked outgoing entry")
+		}
+		ids = []int32{*entry.RelatedLogId, id}
+	case model.OperationSplitOut:
+		return nil, notReversible(id, "it is split between receivers, reverse their split_in entries instead")
+	case model.OperationSplitIn:
+		if entry.RelatedLogId == nil {
+			return nil, notReversible(id, "it has no linked outgoing entry")
+		}
+		// a share of a split transfer is reversed with its own entry first, which tells what is left of it
+		ids = []int32{id, *entry.RelatedLogId}
+	case model.OperationReversalCredit, model.OperationReversalDebit:
+		return nil, notReversible(id, "it is a reversal itself")
+	case model.OperationPayout:
+		return nil, notReversible(id, "it paid out a closed account")
+	case model.OperationCorrectionCredit, model.OperationCorrectionDebit:
+		return nil, notReversible(id, "it corrects the log after a reconciliation")
+	case model.OperationBonusCredit, model.OperationBonusExpiry:
+		return nil, notReversible(id, "it granted or took back a bonus")
+	case model.OperationEscrowFund, model.OperationEscrowHold, model.OperationEscrowPayout,
+		model.OperationEscrowRelease, model.OperationEscrowRefund:
+		return nil, notReversible(id, "it moved escrowed money, settle the escrow instead")
+	default:
+		return nil, notReversible(id, "its type is unknown")
+	}
+
+	entries := make([]model.TransactionLog, 0, len(ids))
+	for _, id := range ids {
+		entry, err := s.transactionLogRepo.GetByIdForUpdate(ctx, id)
+		if err != nil {
+			return nil, transactionLogError(id, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// compensate moves amount back for the reversed entries and writes a compensating entry for each.
+func (s UserBalanceService) compensate(ctx context.Context, entries []model.TransactionLog, amount float64,
+	commentary string) ([]model.TransactionLog, error) {
+	if len(entries) == 2 {
+		if _, err := s.lockBalances(ctx, entries[0].UserId, entries[1].UserId); err != nil {
+			return nil, err
+		}
+	}
+
+	// money is taken back first, so a balance that can not afford the reversal fails it before any write
+	ordered := append([]model.TransactionLog(nil), entries...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Credit() && !ordered[j].Credit()
+	})
+
+	compensating := make([]model.TransactionLog, 0, len(ordered))
+	for _, entry := range ordered {
+		reversed := entry.Id
+		compensation := model.TransactionLog{
+			UserId:         entry.UserId,
+			Amount:         amount,
+			Commentary:     commentary,
+			CounterpartyId: entry.CounterpartyId,
+			ReversalOf:     &reversed,
+		}
+
+		var balance float64
+		var eventType string
+		var err error
+		if entry.Credit() {
+			compensation.OperationType = model.OperationReversalDebit
+			eventType = model.EventBalanceDebited
+			// what was credited is taken back from real funds, the bonus of the user is left alone
+			balance, err = s.debitBalance(ctx, entry.UserId, -amount, false)
+		} else {
+			compensation.OperationType = model.OperationReversalCredit
+			eventType = model.EventBalanceCredited
+			balance, err = s.addBalance(ctx, entry.UserId, amount)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		compensation, err = s.recordBalanceChange(ctx, compensation, eventType, balance)
+		if err != nil {
+			return nil, err
+		}
+		compensating = append(compensating, compensation)
+	}
+
+	return compensating, nil
+}
+
+func transactionLogError(id int32, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return schemas.ErrorTransactionLogNotFound{
+			Message: fmt.Sprintf("operation %v not found", id),
+		}
+	}
+
+	return err
+}
+
+func notReversible(id int32, why string) error {
+	return schemas.ErrorInvalidReversal{
+		Message: fmt.Sprintf("operation %v can not be reversed, %s", id, why),
+	}
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserBalanceService_ReverseOperation(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name             string
+		operation        func(s *UserBalanceService) error
+		reverseLogId     int32
+		amounts          []float64
+		expectedErr      error
+		expectedBalances map[uuid.UUID]float64
+		expectedStatus   string
+		expectedReversed float64
+	}{
+		{
+			name: "Credit fully",
+			operation: func(s *UserBalanceService) error {
+				_, err := s.ChangeUserBalanceByUserId(context.Background(), alice, 30)
+				return err
+			},
+			reverseLogId:     1,
+			amounts:          []float64{0},
+			expectedBalances: map[uuid.UUID]float64{alice: 100, bob: 0},
+			expectedStatus:   model.ReversalStatusReversed,
+			expectedReversed: 30,
+		},
+		{
+			name: "Debit partially",
+			operation: func(s *UserBalanceService) error {
+				_, err := s.ChangeUserBalanceByUserId(context.Background(), alice, -50)
+				return err
+			},
+			reverseLogId:     1,
+			amounts:          []float64{20},
+			expectedBalances: map[uuid.UUID]float64{alice: 70, bob: 0},
+			expectedStatus:   model.ReversalStatusPartial,
+			expectedReversed: 20,
+		},
+		{
+			name: "Transfer in two parts through its incoming entry",
+			operation: func(s *UserBalanceService) error {
+				return s.ApplyTransaction(context.Background(), alice, bob, 60)
+			},
+			reverseLogId:     2,
+			amounts:          []float64{25, 35},
+			expectedBalances: map[uuid.UUID]float64{alice: 100, bob: 0},
+			expectedStatus:   model.ReversalStatusReversed,
+			expectedReversed: 60,
+		},
+		{
+			name: "More than the original",
+			operation: func(s *UserBalanceService) error {
+				return s.ApplyTransaction(context.Background(), alice, bob, 60)
+			},
+			reverseLogId:     1,
+			amounts:          []float64{50, 20},
+			expectedErr:      schemas.ErrorInvalidReversal{},
+			expectedBalances: map[uuid.UUID]float64{alice: 90, bob: 10},
+			expectedStatus:   model.ReversalStatusPartial,
+			expectedReversed: 50,
+		},
+		{
+			name: "Receiver can not afford it",
+			operation: func(s *UserBalanceService) error {
+				if err := s.ApplyTransaction(context.Background(), alice, bob, 60); err != nil {
+					return err
+				}
+				_, err := s.ChangeUserBalanceByUserId(context.Background(), bob, -50)
+				return err
+			},
+			reverseLogId:     1,
+			amounts:          []float64{20},
+			expectedErr:      schemas.ErrorNotEnoughFunds{},
+			expectedBalances: map[uuid.UUID]float64{alice: 40, bob: 10},
+		},
+		{
+			name: "Unknown operation",
+			operation: func(s *UserBalanceService) error {
+				return nil
+			},
+			reverseLogId:     1,
+			amounts:          []float64{10},
+			expectedErr:      schemas.ErrorTransactionLogNotFound{},
+			expectedBalances: map[uuid.UUID]float64{alice: 100, bob: 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, balanceRepo, logRepo, _ := newTestUserBalanceService(map[uuid.UUID]float64{alice: 100, bob: 0})
+			assert.NoError(t, tt.operation(s))
+
+			var err error
+			for _, amount := range tt.amounts {
+				_, err = s.ReverseOperation(context.Background(), tt.reverseLogId, amount, "refund")
+			}
+			if tt.expectedErr != nil {
+				assert.IsType(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.expectedBalances, balanceRepo.balances)
+			if tt.expectedStatus != "" {
+				original := logRepo.logs[tt.reverseLogId-1]
+				assert.Equal(t, tt.expectedStatus, original.ReversalStatus)
+				assert.Equal(t, tt.expectedReversed, original.ReversedAmount)
+			}
+		})
+	}
+}
+
+func TestUserBalanceService_ReverseOperation_Transfer(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	s, _, logRepo, outboxRepo := newTestUserBalanceService(map[uuid.UUID]float64{alice: 100, bob: 0})
+	ctx := context.Background()
+
+	assert.NoError(t, s.ApplyTransaction(ctx, alice, bob, 60))
+
+	reversal, err := s.ReverseOperation(ctx, 1, 0, "duplicate")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), reversal.Original.Id)
+	assert.Equal(t, model.ReversalStatusReversed, reversal.Original.ReversalStatus)
+	assert.Equal(t, model.ReversalStatusReversed, logRepo.logs[1].ReversalStatus)
+
+	assert.Len(t, reversal.Entries, 2)
+	taken, returned := reversal.Entries[0], reversal.Entries[1]
+	assert.Equal(t, bob, taken.UserId)
+	assert.Equal(t, model.OperationReversalDebit, taken.OperationType)
+	assert.Equal(t, int32(2), *taken.ReversalOf)
+	assert.Equal(t, alice, returned.UserId)
+	assert.Equal(t, model.OperationReversalCredit, returned.OperationType)
+	assert.Equal(t, int32(1), *returned.ReversalOf)
+	assert.Equal(t, 60.0, returned.Amount)
+	assert.Equal(t, "Reversed 60 rubles of operation 1: duplicate", returned.Commentary)
+
+	last := outboxRepo.events[len(outboxRepo.events)-1]
+	assert.Equal(t, model.EventOperationReversed, last.EventType)
+
+	_, err = s.ReverseOperation(ctx, 2, 0, "")
+	assert.IsType(t, schemas.ErrorInvalidReversal{}, err, "a reversed transfer can not be reversed again")
+	_, err = s.ReverseOperation(ctx, taken.Id, 0, "")
+	assert.IsType(t, schemas.ErrorInvalidReversal{}, err, "a reversal can not be reversed")
+}
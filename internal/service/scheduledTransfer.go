@@ -0,0 +1,263 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+type ScheduledTransferService struct {
+	scheduledTransferRepo repository.ScheduledTransfer
+	userBalanceRepo       repository.UserBalance
+	transactor            repository.Transactor
+	logger                logger.Logger
+}
+
+func NewScheduledTransferService(scheduledTransferRepo repository.ScheduledTransfer,
+	userBalanceRepo repository.UserBalance, transactor repository.Transactor,
+	logger logger.Logger) *ScheduledTransferService {
+	return &ScheduledTransferService{
+		scheduledTransferRepo: scheduledTransferRepo,
+		userBalanceRepo:       userBalanceRepo,
+		transactor:            transactor,
+		logger:                logger,
+	}
+}
+
+func (s ScheduledTransferService) CreateScheduledTransfer(ctx context.Context, senderId uuid.UUID,
+	receiverId uuid.UUID, amount float64, startAt time.Time, recurrence string, dayOfMonth int) (
+	model.ScheduledTransfer, error) {
+	if recurrence == "" {
+		recurrence = model.RecurrenceOnce
+	}
+	if recurrence == model.RecurrenceMonthly && dayOfMonth == 0 {
+		dayOfMonth = startAt.Day()
+	}
+
+	if senderId == receiverId {
+		return model.ScheduledTransfer{}, schemas.ErrorInvalidScheduledTransfer{
+			Message: "sender and receiver must differ",
+		}
+	}
+	if err := validateSchedule(amount, recurrence, dayOfMonth); err != nil {
+		return model.ScheduledTransfer{}, err
+	}
+	amount = roundCents(amount)
+
+	for i, userId := range []uuid.UUID{senderId, receiverId} {
+		role := "sender"
+		if i == 1 {
+			role = "receiver"
+		}
+		exists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, userId)
+		if err != nil {
+			s.logger.WithContext(ctx).WithField("user_id", userId).
+				Errorf("could not check if %s exists, error: %s", role, err.Error())
+			return model.ScheduledTransfer{}, err
+		}
+		if !exists {
+			return model.ScheduledTransfer{}, schemas.ErrorUserBalanceNotFound{
+				Message: fmt.Sprintf("user balance of %s %v not found", role, userId),
+			}
+		}
+	}
+
+	now := time.Now()
+	transfer := model.ScheduledTransfer{
+		Id:         uuid.New(),
+		SenderId:   senderId,
+		ReceiverId: receiverId,
+		Amount:     amount,
+		Recurrence: recurrence,
+		DayOfMonth: dayOfMonth,
+		NextRunAt:  startAt,
+		Status:     model.ScheduledTransferActive,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	if err := s.scheduledTransferRepo.Create(ctx, transfer); err != nil {
+		s.logger.WithContext(ctx).Errorf("could not create scheduled transfer, error: %s", err.Error())
+		return model.ScheduledTransfer{}, err
+	}
+
+	return transfer, nil
+}
+
+func (s ScheduledTransferService) GetScheduledTransfer(ctx context.Context, id uuid.UUID) (
+	model.ScheduledTransfer, error) {
+	transfer, err := s.scheduledTransferRepo.Get(ctx, id)
+	if err != nil {
+		return model.ScheduledTransfer{}, scheduledTransferError(id, err)
+	}
+
+	return transfer, nil
+}
+
+func (s ScheduledTransferService) GetAllScheduledTransfers(ctx context.Context, pageNum int, pageSize int) (
+	[]model.ScheduledTransfer, error) {
+	transfers, err := s.scheduledTransferRepo.GetAll(ctx, pageNum, pageSize)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("could not get scheduled transfers, error: %s", err.Error())
+		return nil, err
+	}
+
+	return transfers, nil
+}
+
+// UpdateScheduledTransfer changes the amount and schedule of an active or paused transfer. The
+// transfer stays locked while it is changed, so it can not race with its execution.
+func (s ScheduledTransferService) UpdateScheduledTransfer(ctx context.Context, id uuid.UUID, amount float64,
+	recurrence string, dayOfMonth int, nextRunAt *time.Time, active *bool) (model.ScheduledTransfer, error) {
+	var transfer model.ScheduledTransfer
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		transfer, err = s.scheduledTransferRepo.GetForUpdate(ctx, id)
+		if err != nil {
+			return scheduledTransferError(id, err)
+		}
+
+		if transfer.Status != model.ScheduledTransferActive && transfer.Status != model.ScheduledTransferPaused {
+			return schemas.ErrorInvalidScheduledTransfer{
+				Message: fmt.Sprintf("scheduled transfer %v is %s and can not be changed", id, transfer.Status),
+			}
+		}
+
+		if recurrence == "" {
+			recurrence = transfer.Recurrence
+		}
+		if nextRunAt != nil {
+			transfer.NextRunAt = *nextRunAt
+			transfer.RetryAt = nil
+			transfer.Retries = 0
+		}
+		if recurrence == model.RecurrenceMonthly && dayOfMonth == 0 {
+			dayOfMonth = transfer.NextRunAt.Day()
+		}
+		if err := validateSchedule(amount, recurrence, dayOfMonth); err != nil {
+			return err
+		}
+
+		transfer.Amount = roundCents(amount)
+		transfer.Recurrence = recurrence
+		transfer.DayOfMonth = dayOfMonth
+		if active != nil && *active {
+			transfer.Status = model.ScheduledTransferActive
+		} else if active != nil {
+			transfer.Status = model.ScheduledTransferPaused
+		}
+		transfer.UpdatedAt = time.Now()
+
+		return s.scheduledTransferRepo.Update(ctx, transfer)
+	})
+	if err != nil {
+		s.logger.WithContext(ctx).Warnf("could not update scheduled transfer %v, error: %s", id, err.Error())
+		return model.ScheduledTransfer{}, err
+	}
+
+	return transfer, nil
+}
+
+func (s ScheduledTransferService) DeleteScheduledTransfer(ctx context.Context, id uuid.UUID) error {
+	deleted, err := s.scheduledTransferRepo.Delete(ctx, id)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("could not delete scheduled transfer %v, error: %s", id, err.Error())
+		return err
+	}
+
+	if !deleted {
+		return schemas.ErrorScheduledTransferNotFound{
+			Message: fmt.Sprintf("scheduled transfer %v not found", id),
+		}
+	}
+
+	return nil
+}
+
+// GetScheduledTransferExecutions returns the execution attempts of a transfer, newest first.
+func (s ScheduledTransferService) GetScheduledTransferExecutions(ctx context.Context, id uuid.UUID, pageNum int,
+	pageSize int) ([]model.ScheduledTransferExecution, error) {
+	if _, err := s.GetScheduledTransfer(ctx, id); err != nil {
+		return nil, err
+	}
+
+	executions, err := s.scheduledTransferRepo.GetExecutions(ctx, id, pageNum, pageSize)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("could not get executions of scheduled transfer %v, error: %s",
+			id, err.Error())
+		return nil, err
+	}
+
+	return executions, nil
+}
+
+func scheduledTransferError(id uuid.UUID, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return schemas.ErrorScheduledTransferNotFound{
+			Message: fmt.Sprintf("scheduled transfer %v not found", id),
+		}
+	}
+
+	return err
+}
+
+func validateSchedule(amount float64, recurrence string, dayOfMonth int) error {
+	if math.IsNaN(amount) || math.IsInf(amount, 0) || roundCents(amount) <= 0 {
+		return schemas.ErrorInvalidScheduledTransfer{
+			Message: fmt.Sprintf("amount must be at least a cent, got %v", amount),
+		}
+	}
+
+	switch recurrence {
+	case model.RecurrenceOnce, model.RecurrenceDaily, model.RecurrenceWeekly:
+		if dayOfMonth != 0 {
+			return schemas.ErrorInvalidScheduledTransfer{
+				Message: "dayOfMonth is only allowed for monthly transfers",
+			}
+		}
+	case model.RecurrenceMonthly:
+		if dayOfMonth < 1 || dayOfMonth > 31 {
+			return schemas.ErrorInvalidScheduledTransfer{
+				Message: fmt.Sprintf("dayOfMonth must be between 1 and 31, got %v", dayOfMonth),
+			}
+		}
+	default:
+		return schemas.ErrorInvalidScheduledTransfer{
+			Message: fmt.Sprintf("unknown recurrence %q, must be once, daily, weekly or monthly", recurrence),
+		}
+	}
+
+	return nil
+}
+
+// nextOccurrence returns the occurrence of a recurring transfer following the one at previous.
+// Monthly transfers run on their day of month, or on the last day of months shorter than that.
+func nextOccurrence(transfer model.ScheduledTransfer, previous time.Time) time.Time {
+	switch transfer.Recurrence {
+	case model.RecurrenceDaily:
+		return previous.AddDate(0, 0, 1)
+	case model.RecurrenceWeekly:
+		return previous.AddDate(0, 0, 7)
+	default:
+		year, month, _ := previous.Date()
+		month++
+
+		day := transfer.DayOfMonth
+		if last := time.Date(year, month+1, 0, 0, 0, 0, 0, previous.Location()).Day(); day > last {
+			day = last
+		}
+
+		return time.Date(year, month, day, previous.Hour(), previous.Minute(), previous.Second(),
+			previous.Nanosecond(), previous.Location())
+	}
+}
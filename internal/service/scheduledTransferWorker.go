@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+)
+
+const insufficientFundsRetry = "retry"
+
+// ScheduledTransferWorker executes due scheduled transfers through ApplyTransaction. Every transfer
+// is executed in its own transaction together with its execution record and the advance of its
+// schedule, and stays locked meanwhile, so each occurrence runs once even with several instances.
+// The transfer itself runs in a savepoint, so a failed one leaves nothing behind but its record.
+type ScheduledTransferWorker struct {
+	scheduledTransferRepo repository.ScheduledTransfer
+	userBalance           UserBalance
+	transactor            repository.Transactor
+	cfg                   config.SchedulerConfig
+	logger                logger.Logger
+	now                   func() time.Time
+}
+
+func NewScheduledTransferWorker(scheduledTransferRepo repository.ScheduledTransfer, userBalance UserBalance,
+	transactor repository.Transactor, cfg config.SchedulerConfig, logger logger.Logger) *ScheduledTransferWorker {
+	return &ScheduledTransferWorker{
+		scheduledTransferRepo: scheduledTransferRepo,
+		userBalance:           userBalance,
+		transactor:            transactor,
+		cfg:                   cfg,
+		logger:                logger,
+		now:                   time.Now,
+	}
+}
+
+// Run executes due transfers every poll interval until ctx is cancelled.
+func (w *ScheduledTransferWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.ExecuteDue(ctx); err != nil && ctx.Err() == nil {
+			w.logger.Errorf("could not execute scheduled transfers, error: %s", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExecuteDue executes up to a batch of due transfers and returns how many were attempted.
+func (w *ScheduledTransferWorker) ExecuteDue(ctx context.Context) (int, error) {
+	executed := 0
+
+	for executed < w.cfg.BatchSize && ctx.Err() == nil {
+		found := false
+
+		err := w.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			transfers, err := w.scheduledTransferRepo.GetDue(ctx, w.now(), 1)
+			if err != nil || len(transfers) == 0 {
+				return err
+			}
+
+			found = true
+			return w.execute(ctx, transfers[0])
+		})
+		if err != nil {
+			return executed, err
+		}
+		if !found {
+			break
+		}
+
+		executed++
+	}
+
+	return executed, nil
+}
+
+func (w *ScheduledTransferWorker) execute(ctx context.Context, transfer model.ScheduledTransfer) error {
+	log := w.logger.WithFields(logger.Fields{
+		"scheduled_transfer_id": transfer.Id,
+		"sender_id":             transfer.SenderId,
+		"receiver_id":           transfer.ReceiverId,
+	})
+
+	// the transaction logs of the transfer carry the scheduled transfer as their request id
+	ctx = logger.ContextWithRequestID(ctx, "scheduled-transfer-"+transfer.Id.String())
+
+	now := w.now()
+	execution := model.ScheduledTransferExecution{
+		ScheduledTransferId: transfer.Id,
+		ScheduledFor:        transfer.NextRunAt,
+		ExecutedAt:          now,
+	}
+
+	err := w.transactor.WithinSavepoint(ctx, func(ctx context.Context) error {
+		return w.userBalance.ApplyTransaction(ctx, transfer.SenderId, transfer.ReceiverId, transfer.Amount)
+	})
+	switch {
+	case err == nil:
+		log.Infof("executed scheduled transfer of %v", transfer.Amount)
+		execution.Status = model.ExecutionSucceeded
+		transfer.LastError = ""
+		w.advance(&transfer, true, now)
+	case errors.As(err, &schemas.ErrorNotEnoughFunds{}):
+		execution.Error = err.Error()
+		transfer.LastError = err.Error()
+
+		if w.cfg.InsufficientFunds == insufficientFundsRetry && transfer.Retries < w.cfg.MaxRetries {
+			log.Infof("not enough funds for scheduled transfer, retrying in %v", w.cfg.RetryInterval)
+			execution.Status = model.ExecutionRetrying
+			retryAt := now.Add(w.cfg.RetryInterval)
+			transfer.RetryAt = &retryAt
+			transfer.Retries++
+		} else {
+			log.Warnf("not enough funds for scheduled transfer, skipping the occurrence")
+			execution.Status = model.ExecutionSkipped
+			w.advance(&transfer, false, now)
+		}
+	case isBusinessError(err):
+		log.Warnf("scheduled transfer failed, error: %s", err.Error())
+		execution.Status = model.ExecutionFailed
+		execution.Error = err.Error()
+		transfer.LastError = err.Error()
+		w.advance(&transfer, false, now)
+	default:
+		// nothing is recorded, the transaction is rolled back and the transfer is retried next round
+		log.Errorf("could not execute scheduled transfer, error: %s", err.Error())
+		return err
+	}
+
+	if err := w.scheduledTransferRepo.CreateExecution(ctx, execution); err != nil {
+		return err
+	}
+
+	transfer.UpdatedAt = now
+	return w.scheduledTransferRepo.Update(ctx, transfer)
+}
+
+// advance moves a transfer past its current occurrence. One-off transfers end, recurring ones move
+// to their next occurrence after now, so occurrences missed while no instance ran are not caught up.
+func (w *ScheduledTransferWorker) advance(transfer *model.ScheduledTransfer, succeeded bool, now time.Time) {
+	transfer.RetryAt = nil
+	transfer.Retries = 0
+
+	if transfer.Recurrence == model.RecurrenceOnce {
+		if succeeded {
+			transfer.Status = model.ScheduledTransferCompleted
+		} else {
+			transfer.Status = model.ScheduledTransferFailed
+		}
+		return
+	}
+
+	next := nextOccurrence(*transfer, transfer.NextRunAt)
+	for !next.After(now) {
+		next = nextOccurrence(*transfer, next)
+	}
+	transfer.NextRunAt = next
+}
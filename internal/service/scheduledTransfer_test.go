@@ -0,0 +1,328 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeScheduledTransferRepo struct {
+	transfers  map[uuid.UUID]model.ScheduledTransfer
+	executions []model.ScheduledTransferExecution
+}
+
+func newFakeScheduledTransferRepo(transfers ...model.ScheduledTransfer) *fakeScheduledTransferRepo {
+	r := &fakeScheduledTransferRepo{transfers: map[uuid.UUID]model.ScheduledTransfer{}}
+	for _, transfer := range transfers {
+		r.transfers[transfer.Id] = transfer
+	}
+	return r
+}
+
+func (r *fakeScheduledTransferRepo) Create(_ context.Context, transfer model.ScheduledTransfer) error {
+	r.transfers[transfer.Id] = transfer
+	return nil
+}
+
+func (r *fakeScheduledTransferRepo) Get(_ context.Context, id uuid.UUID) (model.ScheduledTransfer, error) {
+	transfer, ok := r.transfers[id]
+	if !ok {
+		return model.ScheduledTransfer{}, sql.ErrNoRows
+	}
+	return transfer, nil
+}
+
+func (r *fakeScheduledTransferRepo) GetForUpdate(ctx context.Context, id uuid.UUID) (model.ScheduledTransfer, error) {
+	return r.Get(ctx, id)
+}
+
+func (r *fakeScheduledTransferRepo) GetAll(context.Context, int, int) ([]model.ScheduledTransfer, error) {
+	var transfers []model.ScheduledTransfer
+	for _, transfer := range r.transfers {
+		transfers = append(transfers, transfer)
+	}
+	return transfers, nil
+}
+
+func (r *fakeScheduledTransferRepo) GetDue(_ context.Context, now time.Time, limit int) (
+	[]model.ScheduledTransfer, error) {
+	var due []model.ScheduledTransfer
+	for _, transfer := range r.transfers {
+		dueAt := transfer.NextRunAt
+		if transfer.RetryAt != nil {
+			dueAt = *transfer.RetryAt
+		}
+		if transfer.Status == model.ScheduledTransferActive && !dueAt.After(now) && len(due) < limit {
+			due = append(due, transfer)
+		}
+	}
+	return due, nil
+}
+
+func (r *fakeScheduledTransferRepo) Update(_ context.Context, transfer model.ScheduledTransfer) error {
+	r.transfers[transfer.Id] = transfer
+	return nil
+}
+
+func (r *fakeScheduledTransferRepo) Delete(_ context.Context, id uuid.UUID) (bool, error) {
+	_, ok := r.transfers[id]
+	delete(r.transfers, id)
+	return ok, nil
+}
+
+func (r *fakeScheduledTransferRepo) CreateExecution(_ context.Context,
+	execution model.ScheduledTransferExecution) error {
+	execution.Id = int64(len(r.executions) + 1)
+	r.executions = append(r.executions, execution)
+	return nil
+}
+
+func (r *fakeScheduledTransferRepo) GetExecutions(_ context.Context, id uuid.UUID, _ int, _ int) (
+	[]model.ScheduledTransferExecution, error) {
+	var executions []model.ScheduledTransferExecution
+	for _, execution := range r.executions {
+		if execution.ScheduledTransferId == id {
+			executions = append(executions, execution)
+		}
+	}
+	return executions, nil
+}
+
+func TestNextOccurrence(t *testing.T) {
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name       string
+		recurrence string
+		dayOfMonth int
+		previous   time.Time
+		expected   time.Time
+	}{
+		{name: "Daily", recurrence: model.RecurrenceDaily, previous: at(2021, 12, 31), expected: at(2022, 1, 1)},
+		{name: "Weekly", recurrence: model.RecurrenceWeekly, previous: at(2021, 10, 29), expected: at(2021, 11, 5)},
+		{
+			name:       "Monthly",
+			recurrence: model.RecurrenceMonthly,
+			dayOfMonth: 15,
+			previous:   at(2021, 12, 15),
+			expected:   at(2022, 1, 15),
+		},
+		{
+			name:       "Monthly on a day missing in the next month",
+			recurrence: model.RecurrenceMonthly,
+			dayOfMonth: 31,
+			previous:   at(2022, 1, 31),
+			expected:   at(2022, 2, 28),
+		},
+		{
+			name:       "Monthly back on its day after a short month",
+			recurrence: model.RecurrenceMonthly,
+			dayOfMonth: 31,
+			previous:   at(2022, 2, 28),
+			expected:   at(2022, 3, 31),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transfer := model.ScheduledTransfer{Recurrence: tt.recurrence, DayOfMonth: tt.dayOfMonth}
+			assert.Equal(t, tt.expected, nextOccurrence(transfer, tt.previous))
+		})
+	}
+}
+
+func TestScheduledTransferWorker_ExecuteDue(t *testing.T) {
+	now := time.Date(2021, 10, 20, 12, 0, 0, 0, time.UTC)
+	sender, receiver := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name              string
+		policy            string
+		recurrence        string
+		retries           int
+		balance           float64
+		fee               float64
+		expectedExecution string
+		expectedStatus    string
+		expectedRetries   int
+		expectedNextRunAt time.Time
+		expectedRetryAt   *time.Time
+		expectedBalances  map[uuid.UUID]float64
+	}{
+		{
+			name:              "Once succeeded",
+			policy:            "retry",
+			recurrence:        model.RecurrenceOnce,
+			balance:           100,
+			expectedExecution: model.ExecutionSucceeded,
+			expectedStatus:    model.ScheduledTransferCompleted,
+			expectedNextRunAt: now.Add(-time.Minute),
+			expectedBalances:  map[uuid.UUID]float64{sender: 70, receiver: 30},
+		},
+		{
+			name:              "Daily succeeded skips missed occurrences",
+			policy:            "retry",
+			recurrence:        model.RecurrenceDaily,
+			balance:           100,
+			expectedExecution: model.ExecutionSucceeded,
+			expectedStatus:    model.ScheduledTransferActive,
+			expectedNextRunAt: now.Add(-time.Minute).AddDate(0, 0, 1),
+			expectedBalances:  map[uuid.UUID]float64{sender: 70, receiver: 30},
+		},
+		{
+			name:              "Not enough funds retried",
+			policy:            "retry",
+			recurrence:        model.RecurrenceDaily,
+			balance:           10,
+			expectedExecution: model.ExecutionRetrying,
+			expectedStatus:    model.ScheduledTransferActive,
+			expectedRetries:   1,
+			expectedNextRunAt: now.Add(-time.Minute),
+			expectedRetryAt:   timePtr(now.Add(time.Hour)),
+			expectedBalances:  map[uuid.UUID]float64{sender: 10, receiver: 0},
+		},
+		{
+			name:              "Not enough funds after the last retry",
+			policy:            "retry",
+			recurrence:        model.RecurrenceDaily,
+			retries:           2,
+			balance:           10,
+			expectedExecution: model.ExecutionSkipped,
+			expectedStatus:    model.ScheduledTransferActive,
+			expectedNextRunAt: now.Add(-time.Minute).AddDate(0, 0, 1),
+			expectedBalances:  map[uuid.UUID]float64{sender: 10, receiver: 0},
+		},
+		{
+			name:              "Fee not paid after the transfer is written retried",
+			policy:            "retry",
+			recurrence:        model.RecurrenceDaily,
+			balance:           31,
+			fee:               2,
+			expectedExecution: model.ExecutionRetrying,
+			expectedStatus:    model.ScheduledTransferActive,
+			expectedRetries:   1,
+			expectedNextRunAt: now.Add(-time.Minute),
+			expectedRetryAt:   timePtr(now.Add(time.Hour)),
+			expectedBalances:  map[uuid.UUID]float64{sender: 31, receiver: 0},
+		},
+		{
+			name:              "Not enough funds skipped",
+			policy:            "skip",
+			recurrence:        model.RecurrenceOnce,
+			balance:           10,
+			expectedExecution: model.ExecutionSkipped,
+			expectedStatus:    model.ScheduledTransferFailed,
+			expectedNextRunAt: now.Add(-time.Minute),
+			expectedBalances:  map[uuid.UUID]float64{sender: 10, receiver: 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transfer := model.ScheduledTransfer{
+				Id:         uuid.New(),
+				SenderId:   sender,
+				ReceiverId: receiver,
+				Amount:     30,
+				Recurrence: tt.recurrence,
+				NextRunAt:  now.Add(-time.Minute),
+				Retries:    tt.retries,
+				Status:     model.ScheduledTransferActive,
+			}
+			notDue := model.ScheduledTransfer{
+				Id:         uuid.New(),
+				Recurrence: model.RecurrenceOnce,
+				NextRunAt:  now.Add(time.Minute),
+				Status:     model.ScheduledTransferActive,
+			}
+			repo := newFakeScheduledTransferRepo(transfer, notDue)
+			ledger := newFakeLedger(map[uuid.UUID]float64{sender: tt.balance, receiver: 0})
+			ledger.fee = tt.fee
+
+			worker := NewScheduledTransferWorker(repo, ledger, ledger, config.SchedulerConfig{
+				BatchSize:         10,
+				InsufficientFunds: tt.policy,
+				RetryInterval:     time.Hour,
+				MaxRetries:        2,
+			}, logger.NewDefault())
+			worker.now = func() time.Time { return now }
+
+			executed, err := worker.ExecuteDue(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 1, executed)
+
+			assert.Len(t, repo.executions, 1)
+			assert.Equal(t, tt.expectedExecution, repo.executions[0].Status)
+			assert.Equal(t, transfer.NextRunAt, repo.executions[0].ScheduledFor)
+
+			got := repo.transfers[transfer.Id]
+			assert.Equal(t, tt.expectedStatus, got.Status)
+			assert.Equal(t, tt.expectedRetries, got.Retries)
+			assert.Equal(t, tt.expectedNextRunAt, got.NextRunAt)
+			assert.Equal(t, tt.expectedRetryAt, got.RetryAt)
+			assert.Equal(t, tt.expectedBalances, ledger.balances)
+			assert.Equal(t, model.ScheduledTransferActive, repo.transfers[notDue.Id].Status)
+		})
+	}
+}
+
+func TestScheduledTransferService_CreateScheduledTransfer(t *testing.T) {
+	sender, receiver := uuid.New(), uuid.New()
+	s := NewScheduledTransferService(newFakeScheduledTransferRepo(),
+		newFakeUserBalanceRepo(map[uuid.UUID]float64{sender: 0, receiver: 0}), fakeTransactor{}, logger.NewDefault())
+	ctx := context.Background()
+	startAt := time.Date(2021, 10, 31, 9, 0, 0, 0, time.UTC)
+
+	transfer, err := s.CreateScheduledTransfer(ctx, sender, receiver, 10.005, startAt, model.RecurrenceMonthly, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 31, transfer.DayOfMonth)
+	assert.Equal(t, 10.01, transfer.Amount)
+	assert.Equal(t, model.ScheduledTransferActive, transfer.Status)
+
+	transfer, err = s.CreateScheduledTransfer(ctx, sender, receiver, 10, startAt, "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, model.RecurrenceOnce, transfer.Recurrence)
+
+	updated, err := s.UpdateScheduledTransfer(ctx, transfer.Id, 12.344, "", 0, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 12.34, updated.Amount)
+
+	for _, tt := range []struct {
+		receiverId uuid.UUID
+		amount     float64
+		recurrence string
+		dayOfMonth int
+	}{
+		{receiverId: sender, amount: 10},
+		{receiverId: receiver, amount: 10, recurrence: "yearly"},
+		{receiverId: receiver, amount: 10, recurrence: model.RecurrenceWeekly, dayOfMonth: 3},
+		{receiverId: receiver, amount: 0.004},
+		{receiverId: receiver, amount: math.NaN()},
+		{receiverId: receiver, amount: math.Inf(1)},
+	} {
+		_, err = s.CreateScheduledTransfer(ctx, sender, tt.receiverId, tt.amount, startAt, tt.recurrence,
+			tt.dayOfMonth)
+		assert.IsType(t, schemas.ErrorInvalidScheduledTransfer{}, err)
+	}
+
+	// accounts that do not exist are refused up front, not when the transfer runs
+	_, err = s.CreateScheduledTransfer(ctx, sender, uuid.New(), 10, startAt, "", 0)
+	assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+	_, err = s.CreateScheduledTransfer(ctx, uuid.New(), receiver, 10, startAt, "", 0)
+	assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -1,34 +1,233 @@
 package service
 
 import (
-	"log"
+	"context"
+	"time"
 
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
 	"github.com/Feokrat/user-balance-api/internal/model"
 
 	"github.com/Feokrat/user-balance-api/internal/repository"
+	"github.com/Feokrat/user-balance-api/internal/sink"
 	"github.com/google/uuid"
 )
 
 type UserBalance interface {
-	GetBalanceByUserId(userId uuid.UUID) (float64, error)
-	ChangeUserBalanceByUserId(userId uuid.UUID, changeAmount float64) (bool, error)
-	ApplyTransaction(senderId uuid.UUID, receiverId uuid.UUID, amount float64) error
-	GetExchangeRate(fromCurrency string, toCurrency string) (float64, error)
+	GetBalanceByUserId(ctx context.Context, userId uuid.UUID) (float64, error)
+	ChangeUserBalanceByUserId(ctx context.Context, userId uuid.UUID, changeAmount float64) (bool, error)
+	ApplyTransaction(ctx context.Context, senderId uuid.UUID, receiverId uuid.UUID, amount float64) error
+}
+
+type SplitTransfer interface {
+	ApplySplitTransaction(ctx context.Context, senderId uuid.UUID, amount float64, shares []model.SplitShare,
+		excludeBonus bool) (model.SplitTransfer, error)
+}
+
+type Reversal interface {
+	ReverseOperation(ctx context.Context, logId int32, amount float64, reason string) (model.Reversal, error)
+}
+
+type Bonus interface {
+	GrantBonus(ctx context.Context, userId uuid.UUID, amount float64, expiresAt time.Time, reason string) (
+		model.BonusGrant, error)
+	GetBalanceBreakdown(ctx context.Context, userId uuid.UUID) (model.BalanceBreakdown, error)
+	ApplyTransactionExcludingBonus(ctx context.Context, senderId uuid.UUID, receiverId uuid.UUID,
+		amount float64) error
+	ExpireBonuses(ctx context.Context, userId uuid.UUID, at time.Time) (float64, error)
+}
+
+type AccountStatus interface {
+	ChangeAccountStatus(ctx context.Context, userId uuid.UUID, status string, reason string, operatorId string) (
+		model.UserBalance, error)
+	GetAccountStatusHistory(ctx context.Context, userId uuid.UUID, pageNum int, pageSize int) (
+		[]model.AccountStatusChange, error)
+}
+
+type Accounts interface {
+	OpenAccount(ctx context.Context, userId uuid.UUID, currency string, metadata model.AccountMetadata) (
+		model.UserBalance, error)
+	GetAccount(ctx context.Context, userId uuid.UUID) (model.UserBalance, error)
+	SearchAccounts(ctx context.Context, filter model.AccountFilter, sortField string, descending bool, cursor string,
+		pageSize int) (model.AccountPage, error)
+	CloseAccount(ctx context.Context, userId uuid.UUID, payoutTo *uuid.UUID, reason string, operatorId string) (
+		model.AccountClosure, error)
+}
+
+type Overdraft interface {
+	GetAccountBalance(ctx context.Context, userId uuid.UUID) (model.UserBalance, error)
+	SetOverdraftLimit(ctx context.Context, userId uuid.UUID, overdraftLimit float64, operatorId string) (
+		model.UserBalance, error)
+}
+
+type BalanceHistory interface {
+	GetBalanceAt(ctx context.Context, userId uuid.UUID, at time.Time) (model.HistoricalBalance, error)
+	GetDailyBalances(ctx context.Context, userId uuid.UUID, from time.Time, to time.Time) (model.BalanceSeries, error)
+}
+
+type Reconciliation interface {
+	Reconcile(ctx context.Context, trigger string, sampleSize int) (model.ReconciliationReport, error)
+	GetReconciliation(ctx context.Context, id uuid.UUID) (model.ReconciliationReport, error)
+	GetReconciliations(ctx context.Context, pageNum int, pageSize int) ([]model.ReconciliationRun, error)
+	ApproveCorrection(ctx context.Context, mismatchId int64, operatorId string) (model.ReconciliationMismatch, error)
+}
+
+type Audit interface {
+	RecordAuditEntry(ctx context.Context, entry model.AuditEntry) error
+	GetAuditEntries(ctx context.Context, filter model.AuditFilter, pageNum int, pageSize int) (
+		[]model.AuditEntry, error)
+}
+
+type Limits interface {
+	CheckDebit(ctx context.Context, userId uuid.UUID, amount float64, transfer bool) error
+	CheckAuthorization(ctx context.Context, userId uuid.UUID, amount float64, pending float64, count int) error
+	CheckCredit(ctx context.Context, userId uuid.UUID, balance float64) error
+	GetAccountLimits(ctx context.Context, userId uuid.UUID) (model.AccountLimits, error)
+	UpdateAccountLimits(ctx context.Context, userId uuid.UUID, overrides model.UserLimits) (model.AccountLimits, error)
+}
+
+type Fees interface {
+	QuoteFee(ctx context.Context, operation string, userId uuid.UUID, amount float64) (model.FeeQuote, error)
+	RevenueAccount(currency string) (uuid.UUID, error)
+}
+
+type ExchangeRate interface {
+	GetExchangeRate(ctx context.Context, fromCurrency string, toCurrency string) (float64, error)
 }
 
 type TransactionLog interface {
-	GetAllUserLogs(userId uuid.UUID, sortField string, pageNum int, pageSize int) ([]model.TransactionLog, error)
-	CountUserLogs(userId uuid.UUID) (int, error)
+	GetAllUserLogs(ctx context.Context, userId uuid.UUID, sortField string, pageNum int, pageSize int) (
+		[]model.TransactionLog, error)
+	CountUserLogs(ctx context.Context, userId uuid.UUID) (int, error)
+}
+
+// Webhook manages webhook subscriptions, it is also the outbox sink queueing their deliveries.
+type Webhook interface {
+	sink.Sink
+	CreateSubscription(ctx context.Context, url string, eventTypes []string, secret string) (
+		model.WebhookSubscription, error)
+	GetSubscription(ctx context.Context, id uuid.UUID) (model.WebhookSubscription, error)
+	GetAllSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error)
+	UpdateSubscription(ctx context.Context, id uuid.UUID, url string, eventTypes []string, active *bool) (
+		model.WebhookSubscription, error)
+	DeleteSubscription(ctx context.Context, id uuid.UUID) error
+	GetDeliveries(ctx context.Context, subscriptionId uuid.UUID, pageNum int, pageSize int) (
+		[]model.WebhookDelivery, error)
+}
+
+type Batch interface {
+	ExecuteBatch(ctx context.Context, idempotencyKey string, operations []model.BatchOperation) (model.Batch, error)
+}
+
+type ScheduledTransfer interface {
+	CreateScheduledTransfer(ctx context.Context, senderId uuid.UUID, receiverId uuid.UUID, amount float64,
+		startAt time.Time, recurrence string, dayOfMonth int) (model.ScheduledTransfer, error)
+	GetScheduledTransfer(ctx context.Context, id uuid.UUID) (model.ScheduledTransfer, error)
+	GetAllScheduledTransfers(ctx context.Context, pageNum int, pageSize int) ([]model.ScheduledTransfer, error)
+	UpdateScheduledTransfer(ctx context.Context, id uuid.UUID, amount float64, recurrence string, dayOfMonth int,
+		nextRunAt *time.Time, active *bool) (model.ScheduledTransfer, error)
+	DeleteScheduledTransfer(ctx context.Context, id uuid.UUID) error
+	GetScheduledTransferExecutions(ctx context.Context, id uuid.UUID, pageNum int, pageSize int) (
+		[]model.ScheduledTransferExecution, error)
+}
+
+type PaymentRequest interface {
+	CreatePaymentRequest(ctx context.Context, requesterId uuid.UUID, payerId uuid.UUID, amount float64, note string,
+		expiresAt *time.Time) (model.PaymentRequest, error)
+	GetPaymentRequest(ctx context.Context, id uuid.UUID) (model.PaymentRequest, error)
+	GetPaymentRequests(ctx context.Context, filter model.PaymentRequestFilter, pageNum int, pageSize int) (
+		[]model.PaymentRequest, error)
+	AcceptPaymentRequest(ctx context.Context, id uuid.UUID, payerId uuid.UUID) (model.PaymentRequest, error)
+	DeclinePaymentRequest(ctx context.Context, id uuid.UUID, payerId uuid.UUID, reason string) (
+		model.PaymentRequest, error)
+	CancelPaymentRequest(ctx context.Context, id uuid.UUID, requesterId uuid.UUID, reason string) (
+		model.PaymentRequest, error)
+}
+
+type PendingTransfers interface {
+	AuthorizeTransfer(ctx context.Context, senderId uuid.UUID, receiverId uuid.UUID, amount float64,
+		reference string) (model.PendingTransfer, error)
+	GetPendingTransfer(ctx context.Context, id uuid.UUID) (model.PendingTransfer, error)
+	CaptureTransfer(ctx context.Context, id uuid.UUID, amount float64) (model.PendingTransfer, error)
+	VoidTransfer(ctx context.Context, id uuid.UUID, reason string) (model.PendingTransfer, error)
+	ExpirePendingTransfer(ctx context.Context, id uuid.UUID, at time.Time) (bool, error)
+}
+
+type Escrow interface {
+	FundEscrow(ctx context.Context, dealId string, buyerId uuid.UUID, sellerId uuid.UUID, amount float64) (
+		model.Escrow, error)
+	GetEscrow(ctx context.Context, id uuid.UUID) (model.Escrow, error)
+	GetEscrowByDealId(ctx context.Context, dealId string) (model.Escrow, error)
+	ReleaseEscrow(ctx context.Context, id uuid.UUID) (model.Escrow, error)
+	RefundEscrow(ctx context.Context, id uuid.UUID) (model.Escrow, error)
+	SplitEscrow(ctx context.Context, id uuid.UUID, sellerAmount float64) (model.Escrow, error)
+}
+
+type Health interface {
+	Readiness(ctx context.Context) (bool, map[string]model.ComponentHealth)
+	SetShuttingDown()
 }
 
 type Services struct {
 	UserBalance
+	SplitTransfer
+	Reversal
+	Bonus
+	AccountStatus
+	Accounts
+	Overdraft
+	BalanceHistory
+	Reconciliation
+	Audit
+	Limits
+	Fees
 	TransactionLog
+	ExchangeRate
+	Webhook
+	Batch
+	ScheduledTransfer
+	PaymentRequest
+	PendingTransfers
+	Escrow
+	Health
 }
 
-func NewServices(repos *repository.Repository, logger *log.Logger) *Services {
+func NewServices(repos *repository.Repository, cfg *config.Config, logger logger.Logger) *Services {
+	exchangeRate := NewExchangeRateService(cfg.ExchangeRate, logger)
+	limits := NewLimitService(repos.Limit, repos.UserBalance, repos.TransactionLog, cfg.Limits, logger)
+	fees := NewFeeService(repos.UserBalance, limits, cfg.Fees, logger)
+	userBalance := NewUserBalanceService(repos.UserBalance, repos.TransactionLog, repos.Outbox, repos.Bonus,
+		repos.PendingTransfer, repos.Escrow, limits, fees, cfg.Bonus, cfg.PendingTransfers, cfg.Escrow, cfg.Accounts,
+		cfg.Overdraft, repos.Transactor, logger)
+	balanceHistory := NewBalanceHistoryService(repos.UserBalance, repos.TransactionLog, repos.BalanceSnapshot,
+		cfg.Accounts, cfg.Snapshots, logger)
+	reconciliation := NewReconciliationService(repos.Reconciliation, repos.UserBalance, repos.TransactionLog,
+		repos.Transactor, cfg.Reconciliation, logger)
+
 	return &Services{
-		UserBalance:    NewUserBalanceService(repos.UserBalance, repos.TransactionLog, logger),
+		UserBalance:    userBalance,
+		SplitTransfer:  userBalance,
+		Reversal:       userBalance,
+		Bonus:          userBalance,
+		AccountStatus:  userBalance,
+		Accounts:       userBalance,
+		Overdraft:      userBalance,
+		BalanceHistory: balanceHistory,
+		Reconciliation: reconciliation,
+		Audit:          NewAuditService(repos.Audit, logger),
+		Limits:         limits,
+		Fees:           fees,
 		TransactionLog: NewTransactionLogService(repos.TransactionLog, logger),
+		ExchangeRate:   exchangeRate,
+		Webhook:        NewWebhookService(repos.Webhook, logger),
+		Batch: NewBatchService(userBalance, repos.UserBalance, repos.Batch, repos.Transactor, fees, cfg.Batch,
+			cfg.Accounts, logger),
+		ScheduledTransfer: NewScheduledTransferService(repos.ScheduledTransfer, repos.UserBalance, repos.Transactor,
+			logger),
+		PaymentRequest: NewPaymentRequestService(repos.PaymentRequest, repos.UserBalance, userBalance,
+			repos.Transactor, cfg.PaymentRequests, logger),
+		PendingTransfers: userBalance,
+		Escrow:           userBalance,
+		Health:           NewHealthService(repos.Health, exchangeRate, cfg.Health.CheckTimeout, logger),
 	}
 }
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+// maxSplitReceivers bounds the balances a split transfer locks at once.
+const maxSplitReceivers = 100
+
+// ApplySplitTransaction debits the sender once and credits each receiver its share, all in one transaction.
+// A share is either a fixed amount or a percentage of amount, which may be zero when every share is fixed.
+// The shares must add up to amount, what percentages lose to rounding goes to the last percentage share.
+func (s UserBalanceService) ApplySplitTransaction(ctx context.Context, senderId uuid.UUID, amount float64,
+	shares []model.SplitShare, excludeBonus bool) (model.SplitTransfer, error) {
+	log := s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"sender_id": senderId,
+		"receivers": len(shares),
+	})
+
+	credits, total, err := splitShares(senderId, amount, shares)
+	if err != nil {
+		log.Warnf("invalid split transfer, error: %s", err.Error())
+		return model.SplitTransfer{}, err
+	}
+
+	var split model.SplitTransfer
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		split, err = s.applySplitTransaction(ctx, senderId, total, credits, !excludeBonus)
+		return err
+	})
+	if err != nil {
+		return model.SplitTransfer{}, err
+	}
+
+	log.WithField("correlation_id", split.CorrelationId).Infof("split %v rubles between %d users",
+		split.Amount, len(split.Credits))
+	return split, nil
+}
+
+func (s UserBalanceService) applySplitTransaction(ctx context.Context, senderId uuid.UUID, total float64,
+	credits []model.SplitCredit, spendBonus bool) (model.SplitTransfer, error) {
+	log := s.logger.WithContext(ctx).WithField("sender_id", senderId)
+
+	userIds := make([]uuid.UUID, 0, len(credits)+1)
+	userIds = append(userIds, senderId)
+	for _, credit := range credits {
+		userIds = append(userIds, credit.ReceiverId)
+	}
+
+	for i, userId := range userIds {
+		exists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, userId)
+		if err != nil {
+			log.Errorf("could not check if user %v exists, error: %s", userId, err.Error())
+			return model.SplitTransfer{}, err
+		}
+		if !exists {
+			role := "receiver"
+			if i == 0 {
+				role = "sender"
+			}
+			log.Warnf("%s %v does not exist to split money", role, userId)
+			return model.SplitTransfer{}, schemas.ErrorUserBalanceNotFound{
+				Message: fmt.Sprintf("user balance of %s %v not found", role, userId),
+			}
+		}
+	}
+
+	quote, err := s.quoteFee(ctx, model.FeeOperationTransfer, senderId, total)
+	if err != nil {
+		return model.SplitTransfer{}, err
+	}
+
+	balances, err := s.lockPayer(ctx, quote, userIds...)
+	if err != nil {
+		log.Errorf("could not lock balances of sender and receivers, error: %s", err.Error())
+		return model.SplitTransfer{}, err
+	}
+
+	if err = checkCanDebit(balances[senderId]); err != nil {
+		log.Warnf("sender can not send money, error: %s", err.Error())
+		return model.SplitTransfer{}, err
+	}
+	for _, credit := range credits {
+		receiver := balances[credit.ReceiverId]
+		if err = checkCanCredit(receiver); err != nil {
+			log.Warnf("receiver %v can not receive money, error: %s", credit.ReceiverId, err.Error())
+			return model.SplitTransfer{}, err
+		}
+		if err = checkSameCurrency(balances[senderId], receiver); err != nil {
+			log.Warnf("transfer between currencies refused, error: %s", err.Error())
+			return model.SplitTransfer{}, err
+		}
+		if err = s.limits.CheckCredit(ctx, credit.ReceiverId, receiver.Balance+credit.Amount); err != nil {
+			log.Warnf("transfer exceeds a limit of receiver %v, error: %s", credit.ReceiverId, err.Error())
+			return model.SplitTransfer{}, err
+		}
+	}
+	if err = s.limits.CheckDebit(ctx, senderId, total, true); err != nil {
+		log.Warnf("transfer exceeds a limit of sender, error: %s", err.Error())
+		return model.SplitTransfer{}, err
+	}
+	if err = s.checkCanAfford(ctx, balances[senderId], quote, spendBonus); err != nil {
+		log.Warnf("sender can not afford the transfer and its fee, error: %s", err.Error())
+		return model.SplitTransfer{}, err
+	}
+
+	correlationId := uuid.New()
+
+	senderBalance, err := s.debitBalance(ctx, senderId, -total, spendBonus)
+	if err != nil {
+		log.Errorf("could not receive money from sender for split transfer, error: %s", err.Error())
+		return model.SplitTransfer{}, err
+	}
+
+	sent, err := s.recordBalanceChange(ctx, model.TransactionLog{
+		UserId:        senderId,
+		Amount:        total,
+		Commentary:    fmt.Sprintf("Sended %v rubles split between %d users", total, len(credits)),
+		OperationType: model.OperationSplitOut,
+		CorrelationId: &correlationId,
+	}, model.EventBalanceDebited, senderBalance)
+	if err != nil {
+		log.Errorf("could not log info about sender, error: %s", err.Error())
+		return model.SplitTransfer{}, err
+	}
+
+	for i, credit := range credits {
+		receiverBalance, err := s.addBalance(ctx, credit.ReceiverId, credit.Amount)
+		if err != nil {
+			log.Errorf("could not send money to receiver %v, error: %s", credit.ReceiverId, err.Error())
+			return model.SplitTransfer{}, err
+		}
+
+		received, err := s.recordBalanceChange(ctx, model.TransactionLog{
+			UserId:         credit.ReceiverId,
+			Amount:         credit.Amount,
+			Commentary:     fmt.Sprintf("Received %v rubles from user %v", credit.Amount, senderId),
+			OperationType:  model.OperationSplitIn,
+			CounterpartyId: &senderId,
+			RelatedLogId:   &sent.Id,
+			CorrelationId:  &correlationId,
+		}, model.EventBalanceCredited, receiverBalance)
+		if err != nil {
+			log.Errorf("could not log info about receiver %v, error: %s", credit.ReceiverId, err.Error())
+			return model.SplitTransfer{}, err
+		}
+		credits[i].TransactionLogId = received.Id
+	}
+
+	if err = s.chargeFee(ctx, quote, sent); err != nil {
+		return model.SplitTransfer{}, err
+	}
+
+	err = s.publishEvent(ctx, senderId, model.EventSplitCompleted, model.SplitCompletedEvent{
+		CorrelationId: correlationId,
+		SenderId:      senderId,
+		Amount:        total,
+		Fee:           quote.Fee,
+		Credits:       credits,
+	})
+	if err != nil {
+		return model.SplitTransfer{}, err
+	}
+
+	return model.SplitTransfer{
+		CorrelationId:    correlationId,
+		SenderId:         senderId,
+		Amount:           total,
+		Fee:              quote.Fee,
+		TransactionLogId: sent.Id,
+		Credits:          credits,
+	}, nil
+}
+
+// splitShares turns the shares of a split transfer into what each receiver gets and returns them with
+// the total sent.
+func splitShares(senderId uuid.UUID, amount float64, shares []model.SplitShare) ([]model.SplitCredit, float64,
+	error) {
+	invalid := func(format string, args ...interface{}) ([]model.SplitCredit, float64, error) {
+		return nil, 0, schemas.ErrorInvalidSplitTransfer{Message: fmt.Sprintf(format, args...)}
+	}
+
+	if len(shares) == 0 {
+		return invalid("a split transfer needs at least one receiver")
+	}
+	if len(shares) > maxSplitReceivers {
+		return invalid("a split transfer can have at most %d receivers, got %d", maxSplitReceivers, len(shares))
+	}
+	if amount < 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return invalid("amount of a split transfer can not be negative, got %v", amount)
+	}
+	amount = roundCents(amount)
+
+	credits := make([]model.SplitCredit, len(shares))
+	seen := make(map[uuid.UUID]bool, len(shares))
+	lastPercent := -1
+	var sum float64
+	for i, share := range shares {
+		if share.ReceiverId == senderId {
+			return invalid("user %v can not receive a share of its own transfer", senderId)
+		}
+		if seen[share.ReceiverId] {
+			return invalid("user %v receives more than one share", share.ReceiverId)
+		}
+		seen[share.ReceiverId] = true
+
+		if share.Amount < 0 || share.Percent < 0 || math.IsNaN(share.Amount) || math.IsNaN(share.Percent) ||
+			math.IsInf(share.Amount, 0) || math.IsInf(share.Percent, 0) {
+			return invalid("share of user %v can not be negative", share.ReceiverId)
+		}
+		if (share.Amount > 0) == (share.Percent > 0) {
+			return invalid("share of user %v needs either an amount or a percent", share.ReceiverId)
+		}
+
+		credits[i].ReceiverId = share.ReceiverId
+		if share.Amount > 0 {
+			credits[i].Amount = roundCents(share.Amount)
+		} else {
+			if amount == 0 {
+				return invalid("share of user %v is a percent of an amount that is not given", share.ReceiverId)
+			}
+			credits[i].Amount = roundCents(amount * share.Percent / 100)
+			lastPercent = i
+		}
+		sum += credits[i].Amount
+	}
+	sum = roundCents(sum)
+
+	// without an amount the shares are all fixed and the transfer is what they add up to
+	if amount != 0 {
+		// percentages rarely divide the amount into whole cents, the last one takes what rounding left over
+		if diff := roundCents(amount - sum); diff != 0 && lastPercent >= 0 &&
+			math.Abs(diff) <= 0.01*float64(len(shares)) {
+			credits[lastPercent].Amount = roundCents(credits[lastPercent].Amount + diff)
+			sum = amount
+		}
+		if sum != amount {
+			return invalid("shares add up to %v, not the amount of %v", sum, amount)
+		}
+	}
+	for _, credit := range credits {
+		if credit.Amount <= 0 {
+			return invalid("share of user %v is less than a cent", credit.ReceiverId)
+		}
+	}
+
+	return credits, sum, nil
+}
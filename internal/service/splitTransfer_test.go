@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserBalanceService_ApplySplitTransaction(t *testing.T) {
+	sender, first, second, third := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	ctx := context.Background()
+
+	t.Run("Amounts", func(t *testing.T) {
+		s, balanceRepo, logRepo, outboxRepo := newTestUserBalanceService(
+			map[uuid.UUID]float64{sender: 100, first: 0, second: 5})
+
+		split, err := s.ApplySplitTransaction(ctx, sender, 0, []model.SplitShare{
+			{ReceiverId: first, Amount: 30},
+			{ReceiverId: second, Amount: 20.5},
+		}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 50.5, split.Amount)
+		assert.Equal(t, map[uuid.UUID]float64{sender: 49.5, first: 30, second: 25.5}, balanceRepo.balances)
+
+		assert.Len(t, logRepo.logs, 3)
+		sent := logRepo.logs[0]
+		assert.Equal(t, model.OperationSplitOut, sent.OperationType)
+		assert.Equal(t, 50.5, sent.Amount)
+		assert.Equal(t, sent.Id, split.TransactionLogId)
+		for i, received := range logRepo.logs[1:] {
+			assert.Equal(t, model.OperationSplitIn, received.OperationType)
+			assert.Equal(t, split.CorrelationId, *received.CorrelationId)
+			assert.Equal(t, sent.Id, *received.RelatedLogId)
+			assert.Equal(t, received.Id, split.Credits[i].TransactionLogId)
+		}
+		assert.Equal(t, split.CorrelationId, *sent.CorrelationId)
+
+		last := outboxRepo.events[len(outboxRepo.events)-1]
+		assert.Equal(t, model.EventSplitCompleted, last.EventType)
+	})
+
+	t.Run("Percentages", func(t *testing.T) {
+		s, balanceRepo, _, _ := newTestUserBalanceService(
+			map[uuid.UUID]float64{sender: 100, first: 0, second: 0, third: 0})
+
+		split, err := s.ApplySplitTransaction(ctx, sender, 10, []model.SplitShare{
+			{ReceiverId: first, Amount: 1},
+			{ReceiverId: second, Percent: 45},
+			{ReceiverId: third, Percent: 45},
+		}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{1, 4.5, 4.5},
+			[]float64{split.Credits[0].Amount, split.Credits[1].Amount, split.Credits[2].Amount})
+		assert.Equal(t, 90.0, balanceRepo.balances[sender])
+
+		// the last percentage takes the cent rounding left over
+		split, err = s.ApplySplitTransaction(ctx, sender, 10, []model.SplitShare{
+			{ReceiverId: first, Percent: 33.33},
+			{ReceiverId: second, Percent: 33.33},
+			{ReceiverId: third, Percent: 33.34},
+		}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{3.33, 3.33, 3.34},
+			[]float64{split.Credits[0].Amount, split.Credits[1].Amount, split.Credits[2].Amount})
+		assert.Equal(t, 80.0, balanceRepo.balances[sender])
+	})
+
+	t.Run("Invalid shares", func(t *testing.T) {
+		s, balanceRepo, logRepo, _ := newTestUserBalanceService(
+			map[uuid.UUID]float64{sender: 100, first: 0, second: 0})
+
+		invalid := [][]model.SplitShare{
+			nil,
+			{{ReceiverId: sender, Amount: 10}},
+			{{ReceiverId: first, Amount: 10}, {ReceiverId: first, Amount: 10}},
+			{{ReceiverId: first}},
+			{{ReceiverId: first, Amount: 10, Percent: 10}},
+			{{ReceiverId: first, Amount: -10}},
+			{{ReceiverId: first, Percent: 50}, {ReceiverId: second, Percent: 40}},
+			{{ReceiverId: first, Amount: 60}, {ReceiverId: second, Amount: 60}},
+		}
+		for _, shares := range invalid {
+			_, err := s.ApplySplitTransaction(ctx, sender, 100, shares, false)
+			assert.IsType(t, schemas.ErrorInvalidSplitTransfer{}, err)
+		}
+
+		_, err := s.ApplySplitTransaction(ctx, sender, 0, []model.SplitShare{{ReceiverId: first, Percent: 100}},
+			false)
+		assert.IsType(t, schemas.ErrorInvalidSplitTransfer{}, err)
+
+		// fixed shares without an amount still have to be worth a cent
+		_, err = s.ApplySplitTransaction(ctx, sender, 0, []model.SplitShare{
+			{ReceiverId: first, Amount: 10}, {ReceiverId: second, Amount: 0.004},
+		}, false)
+		assert.IsType(t, schemas.ErrorInvalidSplitTransfer{}, err)
+
+		assert.Equal(t, 100.0, balanceRepo.balances[sender])
+		assert.Empty(t, logRepo.logs)
+	})
+
+	t.Run("Missing receiver", func(t *testing.T) {
+		s, balanceRepo, logRepo, _ := newTestUserBalanceService(map[uuid.UUID]float64{sender: 100, first: 0})
+
+		_, err := s.ApplySplitTransaction(ctx, sender, 0, []model.SplitShare{
+			{ReceiverId: first, Amount: 10},
+			{ReceiverId: uuid.New(), Amount: 10},
+		}, false)
+		assert.IsType(t, schemas.ErrorUserBalanceNotFound{}, err)
+		assert.Equal(t, map[uuid.UUID]float64{sender: 100, first: 0}, balanceRepo.balances)
+		assert.Empty(t, logRepo.logs)
+	})
+
+	t.Run("Not enough funds", func(t *testing.T) {
+		s, balanceRepo, logRepo, _ := newTestUserBalanceService(
+			map[uuid.UUID]float64{sender: 10, first: 0, second: 0})
+
+		_, err := s.ApplySplitTransaction(ctx, sender, 0, []model.SplitShare{
+			{ReceiverId: first, Amount: 6},
+			{ReceiverId: second, Amount: 6},
+		}, false)
+		assert.IsType(t, schemas.ErrorNotEnoughFunds{}, err)
+		assert.Equal(t, map[uuid.UUID]float64{sender: 10, first: 0, second: 0}, balanceRepo.balances)
+		assert.Empty(t, logRepo.logs)
+	})
+
+	t.Run("Reverse a share", func(t *testing.T) {
+		s, balanceRepo, logRepo, _ := newTestUserBalanceService(
+			map[uuid.UUID]float64{sender: 100, first: 0, second: 0})
+
+		split, err := s.ApplySplitTransaction(ctx, sender, 0, []model.SplitShare{
+			{ReceiverId: first, Amount: 30},
+			{ReceiverId: second, Amount: 20},
+		}, false)
+		assert.NoError(t, err)
+
+		_, err = s.ReverseOperation(ctx, split.TransactionLogId, 0, "")
+		assert.IsType(t, schemas.ErrorInvalidReversal{}, err)
+
+		_, err = s.ReverseOperation(ctx, split.Credits[0].TransactionLogId, 0, "wrong receiver")
+		assert.NoError(t, err)
+		assert.Equal(t, map[uuid.UUID]float64{sender: 80, first: 0, second: 20}, balanceRepo.balances)
+		assert.Equal(t, 30.0, logRepo.logs[0].ReversedAmount)
+	})
+}
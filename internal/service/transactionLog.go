@@ -1,8 +1,9 @@
 package service
 
 import (
-	"log"
+	"context"
 
+	"github.com/Feokrat/user-balance-api/internal/logger"
 	"github.com/Feokrat/user-balance-api/internal/model"
 	"github.com/Feokrat/user-balance-api/internal/repository"
 	"github.com/google/uuid"
@@ -10,30 +11,32 @@ import (
 
 type TransactionLogService struct {
 	transactionLogRepo repository.TransactionLog
-	logger             *log.Logger
+	logger             logger.Logger
 }
 
-func NewTransactionLogService(transactionLogRepo repository.TransactionLog, logger *log.Logger) *TransactionLogService {
+func NewTransactionLogService(transactionLogRepo repository.TransactionLog, logger logger.Logger) *TransactionLogService {
 	return &TransactionLogService{transactionLogRepo: transactionLogRepo, logger: logger}
 }
 
-func (t TransactionLogService) GetAllUserLogs(userId uuid.UUID, sortField string, pageNum int,
+func (t TransactionLogService) GetAllUserLogs(ctx context.Context, userId uuid.UUID, sortField string, pageNum int,
 	pageSize int) ([]model.TransactionLog, error) {
-	transactionLogs, err := t.transactionLogRepo.GetAllByUserId(userId, sortField, pageNum, pageSize)
+	transactionLogs, err := t.transactionLogRepo.GetAllByUserId(ctx, userId, sortField, pageNum, pageSize)
 	if err != nil {
-		t.logger.Printf("could not get all transaction logs of user %v", userId)
+		t.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not get all transaction logs of user")
 		return nil, err
 	}
 
 	return transactionLogs, nil
 }
 
-func (t TransactionLogService) CountUserLogs(userId uuid.UUID) (int, error) {
-	count, err := t.transactionLogRepo.CountByUserId(userId)
+func (t TransactionLogService) CountUserLogs(ctx context.Context, userId uuid.UUID) (int, error) {
+	count, err := t.transactionLogRepo.CountByUserId(ctx, userId)
 	if err != nil {
-		t.logger.Printf("could not count transaction logs of user %v, error: %s", userId, err.Error())
+		t.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not count transaction logs of user, error: %s", err.Error())
 		return 0, err
 	}
 
 	return count, nil
-}
\ No newline at end of file
+}
@@ -1,14 +1,17 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
 	"math"
-	"net/http"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
 	"github.com/Feokrat/user-balance-api/internal/schemas"
 
 	"github.com/Feokrat/user-balance-api/internal/model"
@@ -18,263 +21,652 @@ import (
 	"github.com/Feokrat/user-balance-api/internal/repository"
 )
 
-const (
-	ECHANGE_RATE_URL      = "https://free.currconv.com/api/v7/convert?"
-	EXCHANGE_RATE_API_KEY = "620c10be1a7ef027dcd9"
-	BASE_CURRENCY         = "RUB"
-)
-
 type UserBalanceService struct {
 	userBalanceRepo    repository.UserBalance
 	transactionLogRepo repository.TransactionLog
-	logger             *log.Logger
+	outboxRepo         repository.Outbox
+	bonusRepo          repository.Bonus
+	pendingRepo        repository.PendingTransfer
+	escrowRepo         repository.Escrow
+	limits             Limits
+	fees               Fees
+	bonus              config.BonusConfig
+	pending            config.PendingTransfersConfig
+	escrowAccounts     map[string]uuid.UUID
+	accounts           config.AccountsConfig
+	overdraft          config.OverdraftConfig
+	transactor         repository.Transactor
+	logger             logger.Logger
 }
 
 func NewUserBalanceService(userBalanceRepo repository.UserBalance, transactionLogRepo repository.TransactionLog,
-	logger *log.Logger) *UserBalanceService {
-	return &UserBalanceService{userBalanceRepo: userBalanceRepo, transactionLogRepo: transactionLogRepo, logger: logger}
+	outboxRepo repository.Outbox, bonusRepo repository.Bonus, pendingRepo repository.PendingTransfer,
+	escrowRepo repository.Escrow, limits Limits, fees Fees, bonus config.BonusConfig,
+	pending config.PendingTransfersConfig, escrow config.EscrowConfig, accounts config.AccountsConfig,
+	overdraft config.OverdraftConfig, transactor repository.Transactor, logger logger.Logger) *UserBalanceService {
+	// currencies are keys of the configuration, which lowercases them
+	escrowAccounts := make(map[string]uuid.UUID, len(escrow.Accounts))
+	for currency, accountId := range escrow.Accounts {
+		if id, err := uuid.Parse(accountId); err == nil {
+			escrowAccounts[strings.ToUpper(currency)] = id
+		}
+	}
+
+	return &UserBalanceService{
+		userBalanceRepo:    userBalanceRepo,
+		transactionLogRepo: transactionLogRepo,
+		outboxRepo:         outboxRepo,
+		bonusRepo:          bonusRepo,
+		pendingRepo:        pendingRepo,
+		escrowRepo:         escrowRepo,
+		limits:             limits,
+		fees:               fees,
+		bonus:              bonus,
+		pending:            pending,
+		escrowAccounts:     escrowAccounts,
+		accounts:           accounts,
+		overdraft:          overdraft,
+		transactor:         transactor,
+		logger:             logger,
+	}
 }
 
-func (s UserBalanceService) GetBalanceByUserId(userId uuid.UUID) (float64, error) {
-	ubExists, err := s.userBalanceRepo.CheckIfExistsByUserId(userId)
+func (s UserBalanceService) GetBalanceByUserId(ctx context.Context, userId uuid.UUID) (float64, error) {
+	log := s.logger.WithContext(ctx).WithField("user_id", userId)
+
+	ubExists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, userId)
 	if err != nil {
-		s.logger.Printf("could not check if user with id %v exists, error: %s",
-			userId, err.Error())
+		log.Errorf("could not check if user exists, error: %s", err.Error())
 		return 0, err
 	}
 
+	if !ubExists && !s.accounts.ImplicitCreate {
+		log.Infof("user does not exist")
+		return 0, accountNotFound(userId)
+	}
 	if !ubExists {
-		s.logger.Printf("user with id %v does not exist",
-			userId)
+		log.Infof("user does not exist")
 		return 0, nil
 	}
 
-	ub, err := s.userBalanceRepo.GetByUserId(userId)
+	ub, err := s.userBalanceRepo.GetByUserId(ctx, userId)
 	if err != nil {
-		s.logger.Printf("could not get user balance info of user with id %v, error: %s",
-			userId, err.Error())
+		log.Errorf("could not get user balance info of user, error: %s", err.Error())
 		return 0, err
 	}
 
 	return ub.Balance, nil
 }
 
-func (s UserBalanceService) ChangeUserBalanceByUserId(userId uuid.UUID, changeAmount float64) (bool, error) {
-	ubExists, err := s.userBalanceRepo.CheckIfExistsByUserId(userId)
+func (s UserBalanceService) ChangeUserBalanceByUserId(ctx context.Context, userId uuid.UUID,
+	changeAmount float64) (bool, error) {
+	var created bool
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		created, err = s.changeUserBalance(ctx, userId, changeAmount)
+		return err
+	})
+
+	return created, err
+}
+
+func (s UserBalanceService) changeUserBalance(ctx context.Context, userId uuid.UUID,
+	changeAmount float64) (bool, error) {
+	log := s.logger.WithContext(ctx).WithField("user_id", userId)
+
+	ubExists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, userId)
 	if err != nil {
-		s.logger.Printf("could not check if user %v exists, error: %s",
-			userId, err.Error())
+		log.Errorf("could not check if user exists, error: %s", err.Error())
 		return false, err
 	}
 
 	if changeAmount > 0 {
-		s.logger.Printf("trying to add balance to user %v",
-			userId)
+		log.Infof("trying to add balance to user")
 
-		if !ubExists {
-			s.logger.Printf("user %v does not exist, trying to create him with balance %v",
-				userId, changeAmount)
+		var balance float64
+		created := !ubExists
+		if created && !s.accounts.ImplicitCreate {
+			log.Warnf("user does not exist to add to his balance")
+			return false, accountNotFound(userId)
+		}
+		if created {
+			log.Infof("user does not exist, trying to create him with balance %v", changeAmount)
 
-			err = s.userBalanceRepo.Create(model.UserBalance{
-				UserId:  userId,
-				Balance: changeAmount,
-			})
-			if err != nil {
-				s.logger.Printf("could not create user balance of user %v and balance %v",
-					userId, changeAmount)
+			if err = s.limits.CheckCredit(ctx, userId, changeAmount); err != nil {
+				log.Warnf("could not add balance to user, error: %s", err.Error())
 				return false, err
 			}
 
-			err = s.logBalanceInfo(userId, changeAmount, fmt.Sprintf("Added %v rubles",
-				changeAmount))
+			account := model.UserBalance{
+				UserId:    userId,
+				Balance:   changeAmount,
+				Status:    model.AccountActive,
+				Currency:  s.accounts.DefaultCurrency,
+				Metadata:  model.AccountMetadata{},
+				CreatedAt: time.Now(),
+			}
+			err = s.userBalanceRepo.Create(ctx, account)
 			if err != nil {
-				s.logger.Printf("could not log info about user %v, error: %s",
-					userId, err.Error())
+				log.Errorf("could not create user balance with balance %v", changeAmount)
 				return false, err
 			}
+			balance = changeAmount
 
-			return true, nil
+			err = s.publishEvent(ctx, userId, model.EventAccountCreated, account)
+			if err != nil {
+				return false, err
+			}
 		} else {
-			err = s.logBalanceInfo(userId, changeAmount, fmt.Sprintf("Added %v rubles",
-				changeAmount))
+			ub, err := s.userBalanceRepo.GetByUserIdForUpdate(ctx, userId)
+			if err != nil {
+				return false, err
+			}
+			if err = s.limits.CheckCredit(ctx, userId, ub.Balance+changeAmount); err != nil {
+				log.Warnf("could not add balance to user, error: %s", err.Error())
+				return false, err
+			}
+
+			balance, err = s.addBalance(ctx, userId, changeAmount)
 			if err != nil {
-				s.logger.Printf("could not log info about user %v, error: %s",
-					userId, err.Error())
 				return false, err
 			}
+		}
 
-			return false, s.addBalance(userId, changeAmount)
+		_, err = s.recordBalanceChange(ctx, model.TransactionLog{
+			UserId:        userId,
+			Amount:        changeAmount,
+			Commentary:    fmt.Sprintf("Added %v rubles", changeAmount),
+			OperationType: model.OperationCredit,
+		}, model.EventBalanceCredited, balance)
+		if err != nil {
+			log.Errorf("could not log info about user, error: %s", err.Error())
+			return false, err
 		}
+
+		return created, nil
 	} else {
-		s.logger.Printf("trying to sub balance of user %v",
-			userId)
+		log.Infof("trying to sub balance of user")
 
 		if !ubExists {
-			s.logger.Printf("user %v does not exist to sub his balance",
-				userId)
+			log.Warnf("user does not exist to sub his balance")
 			return false, schemas.ErrorUserBalanceNotFound{
 				Message: fmt.Sprintf("user balance of user with id %v not found",
 					userId),
 			}
-		} else {
-			err = s.logBalanceInfo(userId, changeAmount, fmt.Sprintf("Substracted %v rubles",
-				math.Abs(changeAmount)))
-			if err != nil {
-				s.logger.Printf("could not log info about user %v, error: %s",
-					userId, err.Error())
-				return false, err
-			}
+		}
 
-			return false, s.subBalance(userId, changeAmount)
+		quote, err := s.quoteFee(ctx, model.FeeOperationWithdrawal, userId, math.Abs(changeAmount))
+		if err != nil {
+			return false, err
+		}
+
+		// the balance stays locked from the limit check to the debit
+		balances, err := s.lockPayer(ctx, quote, userId)
+		if err != nil {
+			return false, err
+		}
+		if err = s.limits.CheckDebit(ctx, userId, math.Abs(changeAmount), false); err != nil {
+			log.Warnf("could not sub balance of user, error: %s", err.Error())
+			return false, err
+		}
+		if err = s.checkCanAfford(ctx, balances[userId], quote, true); err != nil {
+			log.Warnf("could not sub balance of user, error: %s", err.Error())
+			return false, err
+		}
+
+		balance, err := s.subBalance(ctx, userId, changeAmount)
+		if err != nil {
+			return false, err
+		}
+
+		debited, err := s.recordBalanceChange(ctx, model.TransactionLog{
+			UserId:        userId,
+			Amount:        changeAmount,
+			Commentary:    fmt.Sprintf("Substracted %v rubles", math.Abs(changeAmount)),
+			OperationType: model.OperationDebit,
+		}, model.EventBalanceDebited, balance)
+		if err != nil {
+			log.Errorf("could not log info about user, error: %s", err.Error())
+			return false, err
 		}
+
+		return false, s.chargeFee(ctx, quote, debited)
 	}
 }
 
-func (s UserBalanceService) ApplyTransaction(senderId uuid.UUID, receiverId uuid.UUID, amount float64) error {
-	senderAbExists, err := s.userBalanceRepo.CheckIfExistsByUserId(senderId)
-	if err != nil {
-		s.logger.Printf("could not check if sender %v exists, error: %s",
-			senderId, err.Error())
+func (s UserBalanceService) ApplyTransaction(ctx context.Context, senderId uuid.UUID, receiverId uuid.UUID,
+	amount float64) error {
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.applyTransaction(ctx, senderId, receiverId, amount, true)
 		return err
+	})
+}
+
+// applyTransaction transfers amount from the sender to the receiver, spending the bonus of the sender
+// first unless spendBonus is false, and returns the outgoing entry of the transfer.
+func (s UserBalanceService) applyTransaction(ctx context.Context, senderId uuid.UUID, receiverId uuid.UUID,
+	amount float64, spendBonus bool) (model.TransactionLog, error) {
+	log := s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"sender_id":   senderId,
+		"receiver_id": receiverId,
+	})
+
+	senderAbExists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, senderId)
+	if err != nil {
+		log.Errorf("could not check if sender exists, error: %s", err.Error())
+		return model.TransactionLog{}, err
 	}
 
-	receiverAbExists, err := s.userBalanceRepo.CheckIfExistsByUserId(receiverId)
+	receiverAbExists, err := s.userBalanceRepo.CheckIfExistsByUserId(ctx, receiverId)
 	if err != nil {
-		s.logger.Printf("could not check if receiver %v exists, error: %s",
-			senderId, err.Error())
-		return err
+		log.Errorf("could not check if receiver exists, error: %s", err.Error())
+		return model.TransactionLog{}, err
 	}
 
 	if !senderAbExists {
-		s.logger.Printf("sender %v does not exist to sub his balance",
-			senderId)
-		return schemas.ErrorUserBalanceNotFound{
+		log.Warnf("sender does not exist to sub his balance")
+		return model.TransactionLog{}, schemas.ErrorUserBalanceNotFound{
 			Message: fmt.Sprintf("user balance of sender %v not found",
 				senderId),
 		}
 	}
 
 	if !receiverAbExists {
-		s.logger.Printf("receiver %v does not exist to add to his balance",
-			senderId)
-		return schemas.ErrorUserBalanceNotFound{
+		log.Warnf("receiver does not exist to add to his balance")
+		return model.TransactionLog{}, schemas.ErrorUserBalanceNotFound{
 			Message: fmt.Sprintf("user balance of receiver %v not found",
-				senderId),
+				receiverId),
 		}
 	}
 
-	err = s.subBalance(senderId, -amount)
+	quote, err := s.quoteFee(ctx, model.FeeOperationTransfer, senderId, amount)
 	if err != nil {
-		s.logger.Printf("could not receive money from user %v for transaction to user %v balance, error: %s",
-			senderId, receiverId, err.Error())
-		return err
+		return model.TransactionLog{}, err
 	}
 
-	err = s.logBalanceInfo(senderId, amount, fmt.Sprintf("Sended %v rubles to user %v",
-		amount, receiverId))
+	// lock both balances in a stable order so that opposite transfers can not deadlock
+	balances, err := s.lockPayer(ctx, quote, senderId, receiverId)
 	if err != nil {
-		s.logger.Printf("could not log info about user %v, error: %s",
-			senderId, err.Error())
-		return err
+		log.Errorf("could not lock balances of sender and receiver, error: %s", err.Error())
+		return model.TransactionLog{}, err
 	}
 
-	err = s.addBalance(receiverId, amount)
+	if err = checkCanDebit(balances[senderId]); err != nil {
+		log.Warnf("sender can not send money, error: %s", err.Error())
+		return model.TransactionLog{}, err
+	}
+	if err = checkCanCredit(balances[receiverId]); err != nil {
+		log.Warnf("receiver can not receive money, error: %s", err.Error())
+		return model.TransactionLog{}, err
+	}
+	if err = checkSameCurrency(balances[senderId], balances[receiverId]); err != nil {
+		log.Warnf("transfer between currencies refused, error: %s", err.Error())
+		return model.TransactionLog{}, err
+	}
+	if err = s.limits.CheckDebit(ctx, senderId, amount, true); err != nil {
+		log.Warnf("transfer exceeds a limit of sender, error: %s", err.Error())
+		return model.TransactionLog{}, err
+	}
+	if err = s.limits.CheckCredit(ctx, receiverId, balances[receiverId].Balance+amount); err != nil {
+		log.Warnf("transfer exceeds a limit of receiver, error: %s", err.Error())
+		return model.TransactionLog{}, err
+	}
+	if err = s.checkCanAfford(ctx, balances[senderId], quote, spendBonus); err != nil {
+		log.Warnf("sender can not afford the transfer and its fee, error: %s", err.Error())
+		return model.TransactionLog{}, err
+	}
+
+	return s.moveTransfer(ctx, quote, receiverId, spendBonus)
+}
+
+// moveTransfer moves the amount of quote from the user quoted to the receiver and charges its fee, once the
+// balances are locked and the transfer checked. It returns the outgoing entry of the transfer.
+func (s UserBalanceService) moveTransfer(ctx context.Context, quote model.FeeQuote, receiverId uuid.UUID,
+	spendBonus bool) (model.TransactionLog, error) {
+	senderId, amount := quote.UserId, quote.Amount
+	log := s.logger.WithContext(ctx).WithFields(logger.Fields{
+		"sender_id":   senderId,
+		"receiver_id": receiverId,
+	})
+
+	senderBalance, err := s.debitBalance(ctx, senderId, -amount, spendBonus)
 	if err != nil {
-		s.logger.Printf("could not send money to user %v, trying to return money to user %v, error: %v",
-			receiverId, senderId, err.Error())
-		err = s.addBalance(senderId, amount)
+		log.Errorf("could not receive money from sender for transaction to receiver balance, error: %s",
+			err.Error())
+		return model.TransactionLog{}, err
+	}
+
+	sent, err := s.recordBalanceChange(ctx, model.TransactionLog{
+		UserId:         senderId,
+		Amount:         amount,
+		Commentary:     fmt.Sprintf("Sended %v rubles to user %v", amount, receiverId),
+		OperationType:  model.OperationTransferOut,
+		CounterpartyId: &receiverId,
+	}, model.EventBalanceDebited, senderBalance)
+	if err != nil {
+		log.Errorf("could not log info about sender, error: %s", err.Error())
+		return model.TransactionLog{}, err
+	}
+
+	receiverBalance, err := s.addBalance(ctx, receiverId, amount)
+	if err != nil {
+		log.Errorf("could not send money to receiver, error: %v", err.Error())
+		return model.TransactionLog{}, err
+	}
+
+	_, err = s.recordBalanceChange(ctx, model.TransactionLog{
+		UserId:         receiverId,
+		Amount:         amount,
+		Commentary:     fmt.Sprintf("Received %v rubles from user %v", amount, senderId),
+		OperationType:  model.OperationTransferIn,
+		CounterpartyId: &senderId,
+		RelatedLogId:   &sent.Id,
+	}, model.EventBalanceCredited, receiverBalance)
+	if err != nil {
+		log.Errorf("could not log info about receiver, error: %s", err.Error())
+		return model.TransactionLog{}, err
+	}
+
+	if err = s.chargeFee(ctx, quote, sent); err != nil {
+		return model.TransactionLog{}, err
+	}
+
+	err = s.publishEvent(ctx, senderId, model.EventTransferCompleted, model.TransferCompletedEvent{
+		SenderId:   senderId,
+		ReceiverId: receiverId,
+		Amount:     amount,
+		Fee:        quote.Fee,
+	})
+
+	return sent, err
+}
+
+// lockBalances locks the balances of the users in a stable order and returns them.
+func (s UserBalanceService) lockBalances(ctx context.Context, userIds ...uuid.UUID) (
+	map[uuid.UUID]model.UserBalance, error) {
+	return lockBalances(ctx, s.userBalanceRepo, userIds...)
+}
+
+// lockBalances locks the balances of the users in a stable order, so that operations locking some of the
+// same balances can not deadlock, and returns them.
+func lockBalances(ctx context.Context, userBalanceRepo repository.UserBalance, userIds ...uuid.UUID) (
+	map[uuid.UUID]model.UserBalance, error) {
+	sort.Slice(userIds, func(i, j int) bool {
+		return bytes.Compare(userIds[i][:], userIds[j][:]) < 0
+	})
+
+	balances := make(map[uuid.UUID]model.UserBalance, len(userIds))
+	for _, userId := range userIds {
+		ub, err := userBalanceRepo.GetByUserIdForUpdate(ctx, userId)
 		if err != nil {
-			s.logger.Printf("could not return money to user %v, error: %s",
-				senderId, err.Error())
+			return nil, err
 		}
-		return err
+		balances[userId] = ub
+	}
+
+	return balances, nil
+}
+
+// quoteFee quotes the fee of an operation, nothing is charged for an empty amount.
+func (s UserBalanceService) quoteFee(ctx context.Context, operation string, userId uuid.UUID, amount float64) (
+	model.FeeQuote, error) {
+	if amount <= 0 {
+		return model.FeeQuote{Operation: operation, UserId: userId, Amount: amount, Total: amount}, nil
 	}
 
-	err = s.logBalanceInfo(receiverId, amount, fmt.Sprintf("Received %v rubles from user %v",
-		amount, senderId))
+	quote, err := s.fees.QuoteFee(ctx, operation, userId, amount)
 	if err != nil {
-		s.logger.Printf("could not log info about user %v, error: %s",
-			receiverId, err.Error())
-		return err
+		s.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not quote %s fee, error: %s", operation, err.Error())
+		return model.FeeQuote{}, err
 	}
 
-	return nil
+	return quote, nil
 }
 
-func (s UserBalanceService) GetExchangeRate(fromCurrency string, toCurrency string) (float64, error) {
-	if fromCurrency == "" {
-		fromCurrency = BASE_CURRENCY
+// lockPayer locks the balances of the users of an operation together with the revenue account its fee
+// goes to, so fees charged concurrently can not deadlock with operations of the revenue account.
+func (s UserBalanceService) lockPayer(ctx context.Context, quote model.FeeQuote, userIds ...uuid.UUID) (
+	map[uuid.UUID]model.UserBalance, error) {
+	if quote.Fee > 0 {
+		revenueAccountId, err := s.fees.RevenueAccount(quote.Currency)
+		if err != nil {
+			s.logger.WithContext(ctx).WithField("user_id", quote.UserId).
+				Errorf("could not charge %s fee, error: %s", quote.Operation, err.Error())
+			return nil, err
+		}
+		userIds = append(userIds, revenueAccountId)
 	}
 
-	currencies := fmt.Sprintf("%s_%s", fromCurrency, toCurrency)
-	exchangerURL := fmt.Sprintf("%sq=%s&compact=ultra&apiKey=%s",
-		ECHANGE_RATE_URL, currencies, EXCHANGE_RATE_API_KEY)
-	resp, err := http.Get(exchangerURL)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		s.logger.Printf("could not get exchange rates, status code: %v, error: %s",
-			resp.StatusCode, err.Error())
-		return 0, err
+	return s.lockBalances(ctx, userIds...)
+}
+
+// chargeFee moves the fee of quote from the user to the revenue account, with a fee entry linked to the
+// entry of the operation and a revenue entry linked to the fee entry.
+func (s UserBalanceService) chargeFee(ctx context.Context, quote model.FeeQuote, of model.TransactionLog) error {
+	if quote.Fee <= 0 {
+		return nil
 	}
-	defer resp.Body.Close()
+	log := s.logger.WithContext(ctx).WithField("user_id", quote.UserId)
 
-	body, err := io.ReadAll(resp.Body)
+	revenueAccountId, err := s.fees.RevenueAccount(quote.Currency)
 	if err != nil {
-		s.logger.Printf("could not read response body, error: %s",
-			err.Error())
-		return 0, err
+		return err
 	}
 
-	respBody := map[string]float64{}
-	err = json.Unmarshal(body, &respBody)
+	balance, err := s.subBalance(ctx, quote.UserId, -quote.Fee)
 	if err != nil {
-		s.logger.Printf("could not unmarshal response body, error: %s",
-			err.Error())
-		return 0, err
+		log.Warnf("could not take %s fee from user, error: %s", quote.Operation, err.Error())
+		return err
 	}
 
-	return respBody[currencies], nil
-}
+	fee, err := s.recordBalanceChange(ctx, model.TransactionLog{
+		UserId:         quote.UserId,
+		Amount:         quote.Fee,
+		Commentary:     fmt.Sprintf("Fee of %v rubles for %s %v", quote.Fee, quote.Operation, of.Id),
+		OperationType:  model.OperationFee,
+		CounterpartyId: &revenueAccountId,
+		FeeOf:          &of.Id,
+	}, model.EventBalanceDebited, balance)
+	if err != nil {
+		log.Errorf("could not log fee of user, error: %s", err.Error())
+		return err
+	}
 
-func (s UserBalanceService) addBalance(userId uuid.UUID, changeAmount float64) error {
-	err := s.userBalanceRepo.UpdateByUserId(userId, changeAmount)
+	revenueBalance, err := s.addBalance(ctx, revenueAccountId, quote.Fee)
 	if err != nil {
-		s.logger.Printf("could not add balance to user %v, error: %s",
-			userId, err.Error())
+		log.Errorf("could not add fee to revenue account %v, error: %s", revenueAccountId, err.Error())
 		return err
 	}
 
-	return nil
+	_, err = s.recordBalanceChange(ctx, model.TransactionLog{
+		UserId: revenueAccountId,
+		Amount: quote.Fee,
+		Commentary: fmt.Sprintf("Fee of %v rubles from user %v for %s %v", quote.Fee, quote.UserId,
+			quote.Operation, of.Id),
+		OperationType:  model.OperationFeeRevenue,
+		CounterpartyId: &quote.UserId,
+		RelatedLogId:   &fee.Id,
+	}, model.EventBalanceCredited, revenueBalance)
+	if err != nil {
+		log.Errorf("could not log fee revenue, error: %s", err.Error())
+	}
+
+	return err
 }
 
-func (s UserBalanceService) subBalance(userId uuid.UUID, changeAmount float64) error {
-	ub, err := s.userBalanceRepo.GetByUserId(userId)
+// checkCanAfford checks that what the user can spend covers an operation together with its fee, the
+// operation alone out of its real funds when spendBonus is false. The fee may always be paid with bonus.
+func (s UserBalanceService) checkCanAfford(ctx context.Context, ub model.UserBalance, quote model.FeeQuote,
+	spendBonus bool) error {
+	grants, err := s.bonusGrants(ctx, ub.UserId)
 	if err != nil {
 		return err
 	}
-	if math.Abs(changeAmount) > ub.Balance {
-		s.logger.Printf("Not enough funds in user %v balance", userId)
+	now := time.Now()
+
+	if quote.Total > spendableBalance(ub, grants, true, now) {
+		return schemas.ErrorNotEnoughFunds{
+			Message: fmt.Sprintf("User %v has less money than %v, the amount and its fee of %v",
+				ub.UserId, quote.Total, quote.Fee),
+		}
+	}
+	if !spendBonus && quote.Amount > spendableBalance(ub, grants, false, now) {
 		return schemas.ErrorNotEnoughFunds{
+			Message: fmt.Sprintf("User %v has less money than %v out of bonus", ub.UserId, quote.Amount),
+		}
+	}
+
+	return nil
+}
+
+// spendableBalance returns how much can be taken from a balance at the given time: the balance may go below
+// zero down to the overdraft limit of the account, but neither held money nor expired bonus waiting to be
+// taken back can be spent, and bonus not at all when spendBonus is false.
+func spendableBalance(ub model.UserBalance, grants []model.BonusGrant, spendBonus bool, at time.Time) float64 {
+	spendable, due := splitBonus(grants, at)
+
+	available := ub.Available() - due
+	if !spendBonus {
+		available -= spendable
+	}
+
+	return roundCents(available)
+}
+
+func (s UserBalanceService) addBalance(ctx context.Context, userId uuid.UUID, changeAmount float64) (float64, error) {
+	log := s.logger.WithContext(ctx).WithField("user_id", userId)
+
+	ub, err := s.userBalanceRepo.GetByUserIdForUpdate(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+	if err = checkCanCredit(ub); err != nil {
+		log.Warnf("can not add balance to user, error: %s", err.Error())
+		return 0, err
+	}
+
+	balance, err := s.userBalanceRepo.UpdateByUserId(ctx, userId, changeAmount)
+	if err != nil {
+		log.Errorf("could not add balance to user, error: %s", err.Error())
+		return 0, err
+	}
+
+	return balance, nil
+}
+
+// subBalance takes changeAmount from the balance of a user, spending its bonus first.
+func (s UserBalanceService) subBalance(ctx context.Context, userId uuid.UUID, changeAmount float64) (float64, error) {
+	return s.debitBalance(ctx, userId, changeAmount, true)
+}
+
+// debitBalance takes changeAmount from the balance of a user, from its bonus grants first in their spending
+// order and then from its real funds, or from its real funds only when spendBonus is false.
+func (s UserBalanceService) debitBalance(ctx context.Context, userId uuid.UUID, changeAmount float64,
+	spendBonus bool) (float64, error) {
+	log := s.logger.WithContext(ctx).WithField("user_id", userId)
+
+	ub, err := s.userBalanceRepo.GetByUserIdForUpdate(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+	if err = checkCanDebit(ub); err != nil {
+		log.Warnf("can not sub balance of user, error: %s", err.Error())
+		return 0, err
+	}
+
+	grants, err := s.bonusGrants(ctx, userId)
+	if err != nil {
+		log.Errorf("could not get bonus grants of user, error: %s", err.Error())
+		return 0, err
+	}
+	now := time.Now()
+
+	if math.Abs(changeAmount) > spendableBalance(ub, grants, spendBonus, now) {
+		log.Warnf("Not enough funds in user balance")
+		return 0, schemas.ErrorNotEnoughFunds{
 			Message: fmt.Sprintf("User %v has less money than %v",
 				userId, math.Abs(changeAmount)),
 		}
 	}
-	err = s.userBalanceRepo.UpdateByUserId(userId, changeAmount)
+	balance, err := s.userBalanceRepo.UpdateByUserId(ctx, userId, -math.Abs(changeAmount))
 	if err != nil {
-		s.logger.Printf("could not sub balance of a user %v, error: %s",
-			userId, err.Error())
-		return err
+		log.Errorf("could not sub balance of a user, error: %s", err.Error())
+		return 0, err
 	}
 
-	return nil
+	if spendBonus {
+		if err = s.spendBonus(ctx, grants, math.Abs(changeAmount), now); err != nil {
+			log.Errorf("could not spend bonus of user, error: %s", err.Error())
+			return 0, err
+		}
+	}
+
+	return balance, nil
 }
 
-func (s UserBalanceService) logBalanceInfo(userId uuid.UUID, amount float64, commentary string) error {
-	_, err := s.transactionLogRepo.Create(model.TransactionLog{
-		UserId:     userId,
-		Date:       time.Now(),
-		Amount:     math.Abs(amount),
-		Commentary: commentary,
+// recordBalanceChange writes the transaction log entry of a balance change together with its outbox event
+// and returns the written entry.
+func (s UserBalanceService) recordBalanceChange(ctx context.Context, entry model.TransactionLog, eventType string,
+	balance float64) (model.TransactionLog, error) {
+	if balance < 0 {
+		graceEndsAt, err := s.graceEndsAt(ctx, entry.UserId)
+		if err != nil {
+			return model.TransactionLog{}, err
+		}
+		entry.GraceEndsAt = graceEndsAt
+	}
+
+	entry, err := s.logBalanceInfo(ctx, entry)
+	if err != nil {
+		return model.TransactionLog{}, err
+	}
+
+	err = s.publishEvent(ctx, entry.UserId, eventType, model.BalanceChangedEvent{
+		UserId:           entry.UserId,
+		Amount:           entry.Amount,
+		Balance:          balance,
+		TransactionLogId: entry.Id,
+		Commentary:       entry.Commentary,
 	})
 
+	return entry, err
+}
+
+func (s UserBalanceService) logBalanceInfo(ctx context.Context, entry model.TransactionLog) (
+	model.TransactionLog, error) {
+	entry.Date = time.Now()
+	entry.Amount = math.Abs(entry.Amount)
+	entry.RequestId = logger.RequestID(ctx)
+
+	id, err := s.transactionLogRepo.Create(ctx, entry)
+	if err != nil {
+		return model.TransactionLog{}, err
+	}
+	entry.Id = id
+
+	return entry, nil
+}
+
+func (s UserBalanceService) publishEvent(ctx context.Context, userId uuid.UUID, eventType string,
+	payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.outboxRepo.Create(ctx, model.OutboxEvent{
+		UserId:    userId,
+		EventType: eventType,
+		Payload:   body,
+		RequestId: logger.RequestID(ctx),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		s.logger.WithContext(ctx).WithField("user_id", userId).
+			Errorf("could not write %s event to outbox, error: %s", eventType, err.Error())
+	}
+
 	return err
 }
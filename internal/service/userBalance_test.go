@@ -0,0 +1,407 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeUserBalanceRepo struct {
+	balances       map[uuid.UUID]float64
+	statuses       map[uuid.UUID]string
+	currencies     map[uuid.UUID]string
+	overdrafts     map[uuid.UUID]float64
+	overdrawnSince map[uuid.UUID]time.Time
+	held           map[uuid.UUID]float64
+	changes        []model.AccountStatusChange
+	// locked lists the balances locked, in the order they were
+	locked []uuid.UUID
+}
+
+func newFakeUserBalanceRepo(balances map[uuid.UUID]float64) *fakeUserBalanceRepo {
+	return &fakeUserBalanceRepo{
+		balances:       balances,
+		statuses:       map[uuid.UUID]string{},
+		currencies:     map[uuid.UUID]string{},
+		overdrafts:     map[uuid.UUID]float64{},
+		overdrawnSince: map[uuid.UUID]time.Time{},
+		held:           map[uuid.UUID]float64{},
+	}
+}
+
+func (r *fakeUserBalanceRepo) GetByUserId(_ context.Context, userId uuid.UUID) (model.UserBalance, error) {
+	balance, ok := r.balances[userId]
+	if !ok {
+		return model.UserBalance{}, sql.ErrNoRows
+	}
+	status, ok := r.statuses[userId]
+	if !ok {
+		status = model.AccountActive
+	}
+	currency, ok := r.currencies[userId]
+	if !ok {
+		currency = "RUB"
+	}
+	ub := model.UserBalance{
+		UserId:         userId,
+		Balance:        balance,
+		Status:         status,
+		Currency:       currency,
+		OverdraftLimit: r.overdrafts[userId],
+		Held:           r.held[userId],
+	}
+	if since, ok := r.overdrawnSince[userId]; ok {
+		ub.OverdrawnSince = &since
+	}
+	return ub, nil
+}
+
+func (r *fakeUserBalanceRepo) GetByUserIdForUpdate(ctx context.Context, userId uuid.UUID) (model.UserBalance, error) {
+	r.locked = append(r.locked, userId)
+	return r.GetByUserId(ctx, userId)
+}
+
+func (r *fakeUserBalanceRepo) UpdateByUserId(_ context.Context, userId uuid.UUID, changeAmount float64) (
+	float64, error) {
+	r.balances[userId] += changeAmount
+	if r.balances[userId] < -r.overdrafts[userId] {
+		return 0, errors.New("user_balance_balance_check violated")
+	}
+	if _, ok := r.overdrawnSince[userId]; r.balances[userId] >= 0 {
+		delete(r.overdrawnSince, userId)
+	} else if !ok {
+		r.overdrawnSince[userId] = time.Now()
+	}
+	return r.balances[userId], nil
+}
+
+func (r *fakeUserBalanceRepo) UpdateHeldByUserId(_ context.Context, userId uuid.UUID, changeAmount float64) (
+	float64, error) {
+	r.held[userId] += changeAmount
+	if r.held[userId] < 0 {
+		return 0, errors.New("user_balance_held_check violated")
+	}
+	return r.held[userId], nil
+}
+
+func (r *fakeUserBalanceRepo) CheckIfExistsByUserId(_ context.Context, userId uuid.UUID) (bool, error) {
+	_, ok := r.balances[userId]
+	return ok, nil
+}
+
+func (r *fakeUserBalanceRepo) Create(_ context.Context, userBalance model.UserBalance) error {
+	r.balances[userBalance.UserId] = userBalance.Balance
+	r.currencies[userBalance.UserId] = userBalance.Currency
+	return nil
+}
+
+func (r *fakeUserBalanceRepo) UpdateStatusByUserId(_ context.Context, userId uuid.UUID, status string) error {
+	r.statuses[userId] = status
+	return nil
+}
+
+func (r *fakeUserBalanceRepo) UpdateOverdraftLimitByUserId(_ context.Context, userId uuid.UUID,
+	overdraftLimit float64) error {
+	r.overdrafts[userId] = overdraftLimit
+	return nil
+}
+
+func (r *fakeUserBalanceRepo) CreateStatusChange(_ context.Context, change model.AccountStatusChange) error {
+	change.Id = int64(len(r.changes) + 1)
+	r.changes = append(r.changes, change)
+	return nil
+}
+
+func (r *fakeUserBalanceRepo) GetStatusChanges(_ context.Context, userId uuid.UUID, _ int, _ int) (
+	[]model.AccountStatusChange, error) {
+	var changes []model.AccountStatusChange
+	for i := len(r.changes) - 1; i >= 0; i-- {
+		if r.changes[i].UserId == userId {
+			changes = append(changes, r.changes[i])
+		}
+	}
+	return changes, nil
+}
+
+// Search supports filtering by balance and status and sorting by balance.
+func (r *fakeUserBalanceRepo) Search(ctx context.Context, query model.AccountQuery) ([]model.UserBalance, error) {
+	var accounts []model.UserBalance
+	for userId := range r.balances {
+		ub, _ := r.GetByUserId(ctx, userId)
+		if matchesFilter(ub, query.Filter) {
+			accounts = append(accounts, ub)
+		}
+	}
+
+	less := func(a, b model.UserBalance) bool {
+		if a.Balance != b.Balance {
+			return a.Balance < b.Balance
+		}
+		return bytes.Compare(a.UserId[:], b.UserId[:]) < 0
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return less(accounts[i], accounts[j]) != query.Descending
+	})
+
+	var page []model.UserBalance
+	for _, ub := range accounts {
+		after := query.After == nil
+		if !after {
+			cursor := model.UserBalance{Balance: query.After.Balance, UserId: query.After.UserId}
+			after = less(cursor, ub) != query.Descending && ub.UserId != cursor.UserId
+		}
+		if after && len(page) < query.Limit {
+			page = append(page, ub)
+		}
+	}
+	return page, nil
+}
+
+func (r *fakeUserBalanceRepo) Totals(ctx context.Context, filter model.AccountFilter) ([]model.AccountTotals, error) {
+	totals := map[string]*model.AccountTotals{}
+	for userId := range r.balances {
+		ub, _ := r.GetByUserId(ctx, userId)
+		if !matchesFilter(ub, filter) {
+			continue
+		}
+		t, ok := totals[ub.Currency]
+		if !ok {
+			t = &model.AccountTotals{Currency: ub.Currency, MinBalance: ub.Balance, MaxBalance: ub.Balance}
+			totals[ub.Currency] = t
+		}
+		t.Count++
+		t.TotalBalance += ub.Balance
+		t.MinBalance = math.Min(t.MinBalance, ub.Balance)
+		t.MaxBalance = math.Max(t.MaxBalance, ub.Balance)
+	}
+
+	var result []model.AccountTotals
+	for _, t := range totals {
+		result = append(result, *t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Currency < result[j].Currency })
+	return result, nil
+}
+
+func matchesFilter(ub model.UserBalance, filter model.AccountFilter) bool {
+	return (filter.MinBalance == nil || ub.Balance >= *filter.MinBalance) &&
+		(filter.MaxBalance == nil || ub.Balance <= *filter.MaxBalance) &&
+		(filter.Status == "" || ub.Status == filter.Status) &&
+		(filter.Currency == "" || ub.Currency == filter.Currency)
+}
+
+type fakeTransactionLogRepo struct {
+	logs []model.TransactionLog
+}
+
+func (r *fakeTransactionLogRepo) GetAllByUserId(_ context.Context, userId uuid.UUID, _ string, _ int, _ int) (
+	[]model.TransactionLog, error) {
+	var logs []model.TransactionLog
+	for _, log := range r.logs {
+		if log.UserId == userId {
+			logs = append(logs, log)
+		}
+	}
+	return logs, nil
+}
+
+func (r *fakeTransactionLogRepo) CountByUserId(ctx context.Context, userId uuid.UUID) (int, error) {
+	logs, err := r.GetAllByUserId(ctx, userId, "", 0, 0)
+	return len(logs), err
+}
+
+func (r *fakeTransactionLogRepo) Create(_ context.Context, transactionLog model.TransactionLog) (int32, error) {
+	transactionLog.Id = int32(len(r.logs) + 1)
+	r.logs = append(r.logs, transactionLog)
+	return transactionLog.Id, nil
+}
+
+func (r *fakeTransactionLogRepo) GetById(_ context.Context, id int32) (model.TransactionLog, error) {
+	if id < 1 || int(id) > len(r.logs) {
+		return model.TransactionLog{}, sql.ErrNoRows
+	}
+	return r.logs[id-1], nil
+}
+
+func (r *fakeTransactionLogRepo) GetByIdForUpdate(ctx context.Context, id int32) (model.TransactionLog, error) {
+	return r.GetById(ctx, id)
+}
+
+func (r *fakeTransactionLogRepo) GetByRelatedLogId(_ context.Context, relatedLogId int32) (
+	model.TransactionLog, error) {
+	for _, log := range r.logs {
+		if log.RelatedLogId != nil && *log.RelatedLogId == relatedLogId {
+			return log, nil
+		}
+	}
+	return model.TransactionLog{}, sql.ErrNoRows
+}
+
+func (r *fakeTransactionLogRepo) AddReversedAmount(_ context.Context, id int32, amount float64) (
+	model.TransactionLog, error) {
+	log := &r.logs[id-1]
+	log.ReversedAmount += amount
+	log.ReversalStatus = model.ReversalStatusPartial
+	if log.ReversedAmount >= log.Amount {
+		log.ReversalStatus = model.ReversalStatusReversed
+	}
+	return *log, nil
+}
+
+// outgoingOperation tells whether an operation counts against the outgoing limits, the way the repository does.
+func outgoingOperation(operationType string) bool {
+	switch operationType {
+	case model.OperationDebit, model.OperationTransferOut, model.OperationSplitOut, model.OperationEscrowFund:
+		return true
+	}
+	return false
+}
+
+func (r *fakeTransactionLogRepo) SumOutgoingSince(_ context.Context, userId uuid.UUID, since time.Time) (
+	float64, error) {
+	var sum float64
+	for _, log := range r.logs {
+		if log.UserId == userId && outgoingOperation(log.OperationType) && !log.Date.Before(since) {
+			sum += log.Amount
+		}
+	}
+	return sum, nil
+}
+
+func (r *fakeTransactionLogRepo) CountTransfersSince(_ context.Context, userId uuid.UUID, since time.Time) (
+	int, *time.Time, error) {
+	var count int
+	var first *time.Time
+	for _, log := range r.logs {
+		transfer := log.OperationType != model.OperationDebit && outgoingOperation(log.OperationType)
+		if log.UserId == userId && transfer && log.Date.After(since) {
+			count++
+			if first == nil || log.Date.Before(*first) {
+				date := log.Date
+				first = &date
+			}
+		}
+	}
+	return count, first, nil
+}
+
+func (r *fakeTransactionLogRepo) SumChangesBetween(_ context.Context, userId uuid.UUID, from time.Time,
+	to time.Time) (float64, error) {
+	var sum float64
+	for _, log := range r.logs {
+		if log.UserId != userId || !log.Date.After(from) || log.Date.After(to) {
+			continue
+		}
+		if log.Credit() {
+			sum += log.Amount
+		} else {
+			sum -= log.Amount
+		}
+	}
+	return sum, nil
+}
+
+type fakeLimitRepo struct {
+	limits map[uuid.UUID]model.UserLimits
+}
+
+func (r *fakeLimitRepo) GetByUserId(_ context.Context, userId uuid.UUID) (model.UserLimits, error) {
+	limits, ok := r.limits[userId]
+	if !ok {
+		return model.UserLimits{}, sql.ErrNoRows
+	}
+	return limits, nil
+}
+
+func (r *fakeLimitRepo) Upsert(_ context.Context, limits model.UserLimits) error {
+	r.limits[limits.UserId] = limits
+	return nil
+}
+
+// testServices builds the services of a test over fakes. A test changes the configuration it needs before build
+// and asserts on the fakes afterwards.
+type testServices struct {
+	balanceRepo *fakeUserBalanceRepo
+	logRepo     *fakeTransactionLogRepo
+	outboxRepo  *fakeOutboxRepo
+	bonusRepo   *fakeBonusRepo
+	pendingRepo *fakePendingTransferRepo
+	escrowRepo  *fakeEscrowRepo
+	limitRepo   *fakeLimitRepo
+
+	limitsConfig config.LimitsConfig
+	feesConfig   config.FeesConfig
+	bonusConfig  config.BonusConfig
+	// now is the clock of the fees, the real one when nil
+	now func() time.Time
+
+	limits *LimitService
+	fees   *FeeService
+}
+
+func newTestServices(balances map[uuid.UUID]float64) *testServices {
+	return &testServices{
+		balanceRepo: newFakeUserBalanceRepo(balances),
+		logRepo:     &fakeTransactionLogRepo{},
+		outboxRepo:  &fakeOutboxRepo{},
+		bonusRepo:   &fakeBonusRepo{},
+		pendingRepo: newFakePendingTransferRepo(),
+		escrowRepo:  newFakeEscrowRepo(),
+		limitRepo:   &fakeLimitRepo{limits: map[uuid.UUID]model.UserLimits{}},
+		limitsConfig: config.LimitsConfig{
+			DefaultTier: "standard",
+			Timezone:    "UTC",
+			Tiers:       map[string]config.LimitTierConfig{"standard": {}},
+		},
+		bonusConfig: config.BonusConfig{SpendOrder: model.BonusSpendExpiringFirst},
+	}
+}
+
+// build builds the limits and the fees, then the balance service using them.
+func (ts *testServices) build() *UserBalanceService {
+	ts.limits = NewLimitService(ts.limitRepo, ts.balanceRepo, ts.logRepo, ts.limitsConfig, logger.NewDefault())
+	ts.fees = NewFeeService(ts.balanceRepo, ts.limits, ts.feesConfig, logger.NewDefault())
+	if ts.now != nil {
+		ts.fees.now = ts.now
+	}
+
+	return NewUserBalanceService(ts.balanceRepo, ts.logRepo, ts.outboxRepo, ts.bonusRepo, ts.pendingRepo,
+		ts.escrowRepo, ts.limits, ts.fees, ts.bonusConfig, testPendingTransfersConfig, testEscrowConfig,
+		config.AccountsConfig{DefaultCurrency: "RUB"}, config.OverdraftConfig{GracePeriod: 30 * 24 * time.Hour},
+		fakeTransactor{}, logger.NewDefault())
+}
+
+func newTestUserBalanceService(balances map[uuid.UUID]float64) (*UserBalanceService, *fakeUserBalanceRepo,
+	*fakeTransactionLogRepo, *fakeOutboxRepo) {
+	ts := newTestServices(balances)
+	return ts.build(), ts.balanceRepo, ts.logRepo, ts.outboxRepo
+}
+
+func TestUserBalanceService_ApplyTransaction(t *testing.T) {
+	sender, receiver := uuid.New(), uuid.New()
+	s, balanceRepo, logRepo, _ := newTestUserBalanceService(map[uuid.UUID]float64{sender: 100, receiver: 0})
+
+	err := s.ApplyTransaction(context.Background(), sender, receiver, 40)
+	assert.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]float64{sender: 60, receiver: 40}, balanceRepo.balances)
+
+	assert.Len(t, logRepo.logs, 2)
+	sent, received := logRepo.logs[0], logRepo.logs[1]
+	assert.Equal(t, model.OperationTransferOut, sent.OperationType)
+	assert.Equal(t, receiver, *sent.CounterpartyId)
+	assert.Nil(t, sent.RelatedLogId)
+	assert.Equal(t, model.OperationTransferIn, received.OperationType)
+	assert.Equal(t, sender, *received.CounterpartyId)
+	assert.Equal(t, sent.Id, *received.RelatedLogId)
+}
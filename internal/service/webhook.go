@@ -0,0 +1,271 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+	"github.com/Feokrat/user-balance-api/internal/schemas"
+	"github.com/google/uuid"
+)
+
+// WebhookService manages webhook subscriptions and, as an outbox sink, queues a delivery
+// for every subscription matching a published event.
+type WebhookService struct {
+	webhookRepo repository.Webhook
+	logger      logger.Logger
+}
+
+func NewWebhookService(webhookRepo repository.Webhook, logger logger.Logger) *WebhookService {
+	return &WebhookService{webhookRepo: webhookRepo, logger: logger}
+}
+
+func (s WebhookService) CreateSubscription(ctx context.Context, rawURL string, eventTypes []string,
+	secret string) (model.WebhookSubscription, error) {
+	if err := validateSubscription(rawURL, eventTypes); err != nil {
+		return model.WebhookSubscription{}, err
+	}
+
+	if secret == "" {
+		generated, err := generateSecret()
+		if err != nil {
+			s.logger.WithContext(ctx).Errorf("could not generate webhook secret, error: %s", err.Error())
+			return model.WebhookSubscription{}, err
+		}
+		secret = generated
+	}
+
+	now := time.Now()
+	subscription := model.WebhookSubscription{
+		Id:         uuid.New(),
+		URL:        rawURL,
+		EventTypes: eventTypes,
+		Secret:     secret,
+		Active:     true,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if subscription.EventTypes == nil {
+		subscription.EventTypes = []string{}
+	}
+
+	if err := s.webhookRepo.CreateSubscription(ctx, subscription); err != nil {
+		s.logger.WithContext(ctx).Errorf("could not create webhook subscription, error: %s", err.Error())
+		return model.WebhookSubscription{}, err
+	}
+
+	return subscription, nil
+}
+
+func (s WebhookService) GetSubscription(ctx context.Context, id uuid.UUID) (model.WebhookSubscription, error) {
+	subscription, err := s.getSubscription(ctx, id)
+	if err != nil {
+		return model.WebhookSubscription{}, err
+	}
+
+	subscription.Secret = ""
+	return subscription, nil
+}
+
+func (s WebhookService) GetAllSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error) {
+	subscriptions, err := s.webhookRepo.GetAllSubscriptions(ctx)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("could not get webhook subscriptions, error: %s", err.Error())
+		return nil, err
+	}
+
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+
+	return subscriptions, nil
+}
+
+func (s WebhookService) UpdateSubscription(ctx context.Context, id uuid.UUID, rawURL string, eventTypes []string,
+	active *bool) (model.WebhookSubscription, error) {
+	if err := validateSubscription(rawURL, eventTypes); err != nil {
+		return model.WebhookSubscription{}, err
+	}
+
+	subscription, err := s.getSubscription(ctx, id)
+	if err != nil {
+		return model.WebhookSubscription{}, err
+	}
+
+	subscription.URL = rawURL
+	subscription.EventTypes = eventTypes
+	if subscription.EventTypes == nil {
+		subscription.EventTypes = []string{}
+	}
+	if active != nil {
+		subscription.Active = *active
+	}
+	subscription.UpdatedAt = time.Now()
+
+	if err := s.webhookRepo.UpdateSubscription(ctx, subscription); err != nil {
+		s.logger.WithContext(ctx).Errorf("could not update webhook subscription %v, error: %s", id, err.Error())
+		return model.WebhookSubscription{}, err
+	}
+
+	subscription.Secret = ""
+	return subscription, nil
+}
+
+func (s WebhookService) DeleteSubscription(ctx context.Context, id uuid.UUID) error {
+	deleted, err := s.webhookRepo.DeleteSubscription(ctx, id)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("could not delete webhook subscription %v, error: %s", id, err.Error())
+		return err
+	}
+
+	if !deleted {
+		return schemas.ErrorWebhookSubscriptionNotFound{
+			Message: fmt.Sprintf("webhook subscription %v not found", id),
+		}
+	}
+
+	return nil
+}
+
+// GetDeliveries returns the deliveries of a subscription, newest first, with their attempt history.
+func (s WebhookService) GetDeliveries(ctx context.Context, subscriptionId uuid.UUID, pageNum int,
+	pageSize int) ([]model.WebhookDelivery, error) {
+	if _, err := s.getSubscription(ctx, subscriptionId); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := s.webhookRepo.GetDeliveriesBySubscription(ctx, subscriptionId, pageNum, pageSize)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("could not get deliveries of webhook subscription %v, error: %s",
+			subscriptionId, err.Error())
+		return nil, err
+	}
+
+	if len(deliveries) == 0 {
+		return deliveries, nil
+	}
+
+	ids := make([]int64, 0, len(deliveries))
+	byId := make(map[int64]int, len(deliveries))
+	for i, delivery := range deliveries {
+		ids = append(ids, delivery.Id)
+		byId[delivery.Id] = i
+	}
+
+	attempts, err := s.webhookRepo.GetAttemptsByDeliveryIds(ctx, ids)
+	if err != nil {
+		s.logger.WithContext(ctx).Errorf("could not get delivery attempts of webhook subscription %v, error: %s",
+			subscriptionId, err.Error())
+		return nil, err
+	}
+
+	for _, attempt := range attempts {
+		i := byId[attempt.DeliveryId]
+		deliveries[i].AttemptHistory = append(deliveries[i].AttemptHistory, attempt)
+	}
+
+	return deliveries, nil
+}
+
+// Publish queues a delivery of event to every active subscription interested in it. It runs
+// within the transaction of the outbox dispatcher, so queued deliveries and the dispatched mark
+// are committed together.
+func (s WebhookService) Publish(ctx context.Context, event model.OutboxEvent) error {
+	subscriptions, err := s.webhookRepo.GetActiveSubscriptionsByEventType(ctx, event.EventType)
+	if err != nil {
+		return err
+	}
+
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(model.WebhookPayload{
+		EventId:   event.Id,
+		EventType: event.EventType,
+		UserId:    event.UserId,
+		CreatedAt: event.CreatedAt,
+		Data:      event.Payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, subscription := range subscriptions {
+		err = s.webhookRepo.CreateDelivery(ctx, model.WebhookDelivery{
+			SubscriptionId: subscription.Id,
+			EventId:        event.Id,
+			EventType:      event.EventType,
+			Payload:        payload,
+			Status:         model.WebhookDeliveryPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		})
+		if err != nil {
+			s.logger.WithContext(ctx).Errorf("could not queue event %v for webhook subscription %v, error: %s",
+				event.Id, subscription.Id, err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s WebhookService) getSubscription(ctx context.Context, id uuid.UUID) (model.WebhookSubscription, error) {
+	subscription, err := s.webhookRepo.GetSubscription(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.WebhookSubscription{}, schemas.ErrorWebhookSubscriptionNotFound{
+			Message: fmt.Sprintf("webhook subscription %v not found", id),
+		}
+	} else if err != nil {
+		s.logger.WithContext(ctx).Errorf("could not get webhook subscription %v, error: %s", id, err.Error())
+		return model.WebhookSubscription{}, err
+	}
+
+	return subscription, nil
+}
+
+func validateSubscription(rawURL string, eventTypes []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return schemas.ErrorInvalidWebhookSubscription{
+			Message: fmt.Sprintf("url %q must be an absolute http or https url", rawURL),
+		}
+	}
+
+	for _, eventType := range eventTypes {
+		known := false
+		for _, t := range model.EventTypes {
+			if t == eventType {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return schemas.ErrorInvalidWebhookSubscription{
+				Message: fmt.Sprintf("unknown event type %q", eventType),
+			}
+		}
+	}
+
+	return nil
+}
+
+func generateSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/Feokrat/user-balance-api/internal/repository"
+	"github.com/google/uuid"
+)
+
+const (
+	WebhookIdHeader        = "X-Webhook-Id"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+
+	webhookSignaturePrefix = "sha256="
+)
+
+// WebhookDeliverer posts queued webhook deliveries, retrying failures with exponential backoff
+// until the attempts run out and the delivery is moved to the dead state.
+type WebhookDeliverer struct {
+	webhookRepo repository.Webhook
+	transactor  repository.Transactor
+	client      *http.Client
+	cfg         config.WebhookConfig
+	logger      logger.Logger
+}
+
+func NewWebhookDeliverer(webhookRepo repository.Webhook, transactor repository.Transactor,
+	cfg config.WebhookConfig, logger logger.Logger) *WebhookDeliverer {
+	return &WebhookDeliverer{
+		webhookRepo: webhookRepo,
+		transactor:  transactor,
+		client:      &http.Client{Timeout: cfg.RequestTimeout},
+		cfg:         cfg,
+		logger:      logger,
+	}
+}
+
+// Run delivers due webhooks every poll interval until ctx is cancelled.
+func (d *WebhookDeliverer) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+			d.logger.Errorf("could not deliver webhooks, error: %s", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue sends one batch of due deliveries and returns how many succeeded. The batch is claimed
+// for as long as it may take to send it, so several instances never post the same delivery at once,
+// and no transaction stays open while the receivers are called.
+func (d *WebhookDeliverer) DeliverDue(ctx context.Context) (int, error) {
+	lease := time.Duration(d.cfg.BatchSize+1) * d.cfg.RequestTimeout
+	deliveries, err := d.webhookRepo.ClaimDueDeliveries(ctx, d.cfg.BatchSize, time.Now().Add(lease))
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	subscriptions := map[uuid.UUID]model.WebhookSubscription{}
+	for _, delivery := range deliveries {
+		subscription, ok := subscriptions[delivery.SubscriptionId]
+		if !ok {
+			subscription, err = d.webhookRepo.GetSubscription(ctx, delivery.SubscriptionId)
+			if err != nil {
+				return delivered, err
+			}
+			subscriptions[delivery.SubscriptionId] = subscription
+		}
+
+		ok, err := d.deliver(ctx, subscription, delivery)
+		if err != nil {
+			return delivered, err
+		}
+		if ok {
+			delivered++
+		}
+	}
+
+	return delivered, nil
+}
+
+// deliver sends a claimed delivery and records the outcome of the attempt in its own transaction.
+func (d *WebhookDeliverer) deliver(ctx context.Context, subscription model.WebhookSubscription,
+	delivery model.WebhookDelivery) (bool, error) {
+	log := d.logger.WithFields(logger.Fields{
+		"subscription_id": subscription.Id,
+		"delivery_id":     delivery.Id,
+		"event_id":        delivery.EventId,
+	})
+
+	start := time.Now()
+	statusCode, sendErr := d.send(ctx, subscription, delivery, start)
+
+	attempt := model.WebhookDeliveryAttempt{
+		DeliveryId:  delivery.Id,
+		AttemptedAt: start,
+		StatusCode:  statusCode,
+		DurationMs:  time.Since(start).Milliseconds(),
+	}
+	if sendErr != nil {
+		attempt.Error = sendErr.Error()
+	}
+
+	delivery.Attempts++
+	switch {
+	case sendErr == nil:
+		now := time.Now()
+		delivery.Status = model.WebhookDeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+	case delivery.Attempts >= d.cfg.MaxAttempts:
+		log.Warnf("webhook delivery is dead after %v attempts, error: %s", delivery.Attempts, sendErr.Error())
+		delivery.Status = model.WebhookDeliveryDead
+		delivery.LastError = sendErr.Error()
+	default:
+		log.Infof("webhook delivery attempt %v failed, error: %s", delivery.Attempts, sendErr.Error())
+		delivery.NextAttemptAt = time.Now().Add(d.backoff(delivery.Attempts))
+		delivery.LastError = sendErr.Error()
+	}
+
+	err := d.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := d.webhookRepo.CreateAttempt(ctx, attempt); err != nil {
+			return err
+		}
+		return d.webhookRepo.UpdateDelivery(ctx, delivery)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return sendErr == nil, nil
+}
+
+func (d *WebhookDeliverer) send(ctx context.Context, subscription model.WebhookSubscription,
+	delivery model.WebhookDelivery, now time.Time) (int, error) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIdHeader, strconv.FormatInt(delivery.Id, 10))
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(subscription.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with status %v", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the attempt following attempts failed ones.
+func (d *WebhookDeliverer) backoff(attempts int) time.Duration {
+	delay := d.cfg.InitialBackoff
+	for i := 1; i < attempts && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > d.cfg.MaxBackoff {
+		delay = d.cfg.MaxBackoff
+	}
+
+	return delay
+}
+
+// SignWebhookPayload returns the X-Webhook-Signature value: the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the subscription secret.
+func SignWebhookPayload(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return webhookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks a signature made by SignWebhookPayload, receivers should also
+// reject timestamps too far from their clock to prevent replays.
+func VerifyWebhookSignature(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhookPayload(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,314 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Feokrat/user-balance-api/internal/config"
+	"github.com/Feokrat/user-balance-api/internal/logger"
+	"github.com/Feokrat/user-balance-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeWebhookRepo struct {
+	subscriptions map[uuid.UUID]model.WebhookSubscription
+	deliveries    []model.WebhookDelivery
+	attempts      []model.WebhookDeliveryAttempt
+}
+
+func newFakeWebhookRepo() *fakeWebhookRepo {
+	return &fakeWebhookRepo{subscriptions: map[uuid.UUID]model.WebhookSubscription{}}
+}
+
+func (r *fakeWebhookRepo) CreateSubscription(_ context.Context, subscription model.WebhookSubscription) error {
+	r.subscriptions[subscription.Id] = subscription
+	return nil
+}
+
+func (r *fakeWebhookRepo) GetSubscription(_ context.Context, id uuid.UUID) (model.WebhookSubscription, error) {
+	subscription, ok := r.subscriptions[id]
+	if !ok {
+		return model.WebhookSubscription{}, sql.ErrNoRows
+	}
+	return subscription, nil
+}
+
+func (r *fakeWebhookRepo) GetAllSubscriptions(context.Context) ([]model.WebhookSubscription, error) {
+	var subscriptions []model.WebhookSubscription
+	for _, subscription := range r.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+func (r *fakeWebhookRepo) GetActiveSubscriptionsByEventType(_ context.Context, eventType string) (
+	[]model.WebhookSubscription, error) {
+	var subscriptions []model.WebhookSubscription
+	for _, subscription := range r.subscriptions {
+		if subscription.Active && subscription.Matches(eventType) {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	return subscriptions, nil
+}
+
+func (r *fakeWebhookRepo) UpdateSubscription(_ context.Context, subscription model.WebhookSubscription) error {
+	r.subscriptions[subscription.Id] = subscription
+	return nil
+}
+
+func (r *fakeWebhookRepo) DeleteSubscription(_ context.Context, id uuid.UUID) (bool, error) {
+	_, ok := r.subscriptions[id]
+	delete(r.subscriptions, id)
+	return ok, nil
+}
+
+func (r *fakeWebhookRepo) CreateDelivery(_ context.Context, delivery model.WebhookDelivery) error {
+	for _, d := range r.deliveries {
+		if d.SubscriptionId == delivery.SubscriptionId && d.EventId == delivery.EventId {
+			return nil
+		}
+	}
+	delivery.Id = int64(len(r.deliveries) + 1)
+	r.deliveries = append(r.deliveries, delivery)
+	return nil
+}
+
+func (r *fakeWebhookRepo) ClaimDueDeliveries(_ context.Context, limit int, leaseUntil time.Time) (
+	[]model.WebhookDelivery, error) {
+	var due []model.WebhookDelivery
+	for i, delivery := range r.deliveries {
+		if delivery.Status == model.WebhookDeliveryPending && !delivery.NextAttemptAt.After(time.Now()) &&
+			len(due) < limit {
+			r.deliveries[i].NextAttemptAt = leaseUntil
+			delivery.NextAttemptAt = leaseUntil
+			due = append(due, delivery)
+		}
+	}
+	return due, nil
+}
+
+func (r *fakeWebhookRepo) UpdateDelivery(_ context.Context, delivery model.WebhookDelivery) error {
+	r.deliveries[delivery.Id-1] = delivery
+	return nil
+}
+
+func (r *fakeWebhookRepo) CreateAttempt(_ context.Context, attempt model.WebhookDeliveryAttempt) error {
+	attempt.Id = int64(len(r.attempts) + 1)
+	r.attempts = append(r.attempts, attempt)
+	return nil
+}
+
+func (r *fakeWebhookRepo) GetDeliveriesBySubscription(_ context.Context, subscriptionId uuid.UUID, _ int,
+	_ int) ([]model.WebhookDelivery, error) {
+	var deliveries []model.WebhookDelivery
+	for _, delivery := range r.deliveries {
+		if delivery.SubscriptionId == subscriptionId {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (r *fakeWebhookRepo) GetAttemptsByDeliveryIds(_ context.Context, ids []int64) (
+	[]model.WebhookDeliveryAttempt, error) {
+	var attempts []model.WebhookDeliveryAttempt
+	for _, attempt := range r.attempts {
+		for _, id := range ids {
+			if attempt.DeliveryId == id {
+				attempts = append(attempts, attempt)
+			}
+		}
+	}
+	return attempts, nil
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func TestWebhook_EndToEnd(t *testing.T) {
+	log := logger.NewDefault()
+	ctx := context.Background()
+
+	var (
+		mu       sync.Mutex
+		received []receivedWebhook
+		failing  = true
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, receivedWebhook{header: r.Header.Clone(), body: body})
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	webhookRepo := newFakeWebhookRepo()
+	webhooks := NewWebhookService(webhookRepo, log)
+	deliverer := NewWebhookDeliverer(webhookRepo, fakeTransactor{}, config.WebhookConfig{
+		BatchSize:      10,
+		RequestTimeout: time.Second,
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}, log)
+
+	credits, err := webhooks.CreateSubscription(ctx, receiver.URL, []string{model.EventBalanceCredited}, "")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, credits.Secret)
+	_, err = webhooks.CreateSubscription(ctx, receiver.URL, []string{model.EventBalanceDebited}, "other")
+	assert.NoError(t, err)
+
+	_, err = webhooks.CreateSubscription(ctx, "ftp://example.com", nil, "")
+	assert.Error(t, err)
+
+	userId := uuid.New()
+	outboxRepo := &fakeOutboxRepo{}
+	_, _ = outboxRepo.Create(ctx, model.OutboxEvent{
+		UserId:    userId,
+		EventType: model.EventBalanceCredited,
+		Payload:   json.RawMessage(`{"amount":100}`),
+		CreatedAt: time.Now(),
+	})
+	dispatcher := NewOutboxDispatcher(outboxRepo, fakeTransactor{}, webhooks, config.OutboxConfig{BatchSize: 10}, log)
+
+	_, err = dispatcher.DispatchPending(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, webhookRepo.deliveries, 1, "only the credits subscription matches the event")
+
+	delivered, err := deliverer.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+	assert.Equal(t, 1, webhookRepo.deliveries[0].Attempts)
+	assert.Equal(t, model.WebhookDeliveryPending, webhookRepo.deliveries[0].Status)
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+
+	delivered, err = deliverer.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, model.WebhookDeliveryDelivered, webhookRepo.deliveries[0].Status)
+
+	assert.Len(t, received, 2)
+	last := received[1]
+	assert.True(t, VerifyWebhookSignature(credits.Secret, last.header.Get(WebhookTimestampHeader), last.body,
+		last.header.Get(WebhookSignatureHeader)))
+	assert.False(t, VerifyWebhookSignature("other", last.header.Get(WebhookTimestampHeader), last.body,
+		last.header.Get(WebhookSignatureHeader)))
+	assert.Equal(t, model.EventBalanceCredited, last.header.Get(WebhookEventHeader))
+
+	var payload model.WebhookPayload
+	assert.NoError(t, json.Unmarshal(last.body, &payload))
+	assert.Equal(t, userId, payload.UserId)
+	assert.JSONEq(t, `{"amount":100}`, string(payload.Data))
+
+	history, err := webhooks.GetDeliveries(ctx, credits.Id, 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Len(t, history[0].AttemptHistory, 2)
+	assert.Equal(t, http.StatusServiceUnavailable, history[0].AttemptHistory[0].StatusCode)
+	assert.Equal(t, http.StatusOK, history[0].AttemptHistory[1].StatusCode)
+}
+
+func TestWebhookDeliverer_DeadLetter(t *testing.T) {
+	log := logger.NewDefault()
+	ctx := context.Background()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	webhookRepo := newFakeWebhookRepo()
+	webhooks := NewWebhookService(webhookRepo, log)
+	deliverer := NewWebhookDeliverer(webhookRepo, fakeTransactor{}, config.WebhookConfig{
+		BatchSize:      10,
+		RequestTimeout: time.Second,
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}, log)
+
+	_, err := webhooks.CreateSubscription(ctx, receiver.URL, nil, "")
+	assert.NoError(t, err)
+	assert.NoError(t, webhooks.Publish(ctx, model.OutboxEvent{Id: 1, EventType: model.EventBalanceDebited}))
+
+	for i := 0; i < 3; i++ {
+		_, err = deliverer.DeliverDue(ctx)
+		assert.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.Equal(t, model.WebhookDeliveryDead, webhookRepo.deliveries[0].Status)
+	assert.Equal(t, 2, webhookRepo.deliveries[0].Attempts)
+	assert.Len(t, webhookRepo.attempts, 2)
+}
+
+// trackingTransactor reports whether a transaction is open.
+type trackingTransactor struct {
+	fakeTransactor
+	open *int32
+}
+
+func (t trackingTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	atomic.AddInt32(t.open, 1)
+	defer atomic.AddInt32(t.open, -1)
+	return fn(ctx)
+}
+
+func TestWebhookDeliverer_NoTransactionWhileSending(t *testing.T) {
+	log := logger.NewDefault()
+	ctx := context.Background()
+
+	var open, openWhileSending int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&openWhileSending, atomic.LoadInt32(&open))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	webhookRepo := newFakeWebhookRepo()
+	webhooks := NewWebhookService(webhookRepo, log)
+	deliverer := NewWebhookDeliverer(webhookRepo, trackingTransactor{open: &open}, config.WebhookConfig{
+		BatchSize:      10,
+		RequestTimeout: time.Second,
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}, log)
+
+	_, err := webhooks.CreateSubscription(ctx, receiver.URL, nil, "")
+	assert.NoError(t, err)
+	assert.NoError(t, webhooks.Publish(ctx, model.OutboxEvent{Id: 1, EventType: model.EventBalanceDebited}))
+	assert.NoError(t, webhooks.Publish(ctx, model.OutboxEvent{Id: 2, EventType: model.EventBalanceCredited}))
+
+	delivered, err := deliverer.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, delivered)
+	assert.Zero(t, atomic.LoadInt32(&openWhileSending), "receivers must be called outside of a transaction")
+	assert.Len(t, webhookRepo.attempts, 2)
+
+	delivered, err = deliverer.DeliverDue(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, delivered, "delivered webhooks are not claimed again")
+}
@@ -0,0 +1,91 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/Feokrat/user-balance-api/internal/model"
+)
+
+const (
+	TypeStdout = "stdout"
+	TypeFile   = "file"
+)
+
+// Sink receives outbox events. Delivery is at least once, so a sink may see an event again
+// after a failure and has to tolerate duplicates by the event id.
+type Sink interface {
+	Publish(ctx context.Context, event model.OutboxEvent) error
+}
+
+// Multi publishes every event to each of its sinks in turn and stops at the first failure.
+type Multi []Sink
+
+func (m Multi) Publish(ctx context.Context, event model.OutboxEvent) error {
+	for _, s := range m {
+		if err := s.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriterSink writes every event as a JSON line, it is meant for local use.
+type WriterSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// New builds the writer sink selected by sinkType.
+func New(sinkType string, filePath string) (*WriterSink, error) {
+	switch sinkType {
+	case TypeStdout:
+		return NewStdoutSink(), nil
+	case TypeFile:
+		return NewFileSink(filePath)
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", sinkType)
+	}
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{encoder: json.NewEncoder(w)}
+}
+
+func NewStdoutSink() *WriterSink {
+	return NewWriterSink(os.Stdout)
+}
+
+// NewFileSink appends events to the file at path, creating it when needed.
+func NewFileSink(path string) (*WriterSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewWriterSink(file)
+	s.closer = file
+
+	return s, nil
+}
+
+func (s *WriterSink) Publish(_ context.Context, event model.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.encoder.Encode(event)
+}
+
+func (s *WriterSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+
+	return s.closer.Close()
+}
@@ -0,0 +1,221 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: userbalance/v1/user_balance.proto
+
+package userbalancev1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// UserBalanceServiceClient is the client API for UserBalanceService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type UserBalanceServiceClient interface {
+	// GetBalance returns the balance of a user, optionally converted to another currency.
+	GetBalance(ctx context.Context, in *GetBalanceRequest, opts ...grpc.CallOption) (*GetBalanceResponse, error)
+	// ChangeBalance credits a positive or debits a negative amount, a credit creates a missing balance.
+	ChangeBalance(ctx context.Context, in *ChangeBalanceRequest, opts ...grpc.CallOption) (*ChangeBalanceResponse, error)
+	// Transfer moves money from one user to another.
+	Transfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (*TransferResponse, error)
+	// ListTransactionLogs returns a page of the transaction logs of a user.
+	ListTransactionLogs(ctx context.Context, in *ListTransactionLogsRequest, opts ...grpc.CallOption) (*ListTransactionLogsResponse, error)
+}
+
+type userBalanceServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewUserBalanceServiceClient(cc grpc.ClientConnInterface) UserBalanceServiceClient {
+	return &userBalanceServiceClient{cc}
+}
+
+func (c *userBalanceServiceClient) GetBalance(ctx context.Context, in *GetBalanceRequest, opts ...grpc.CallOption) (*GetBalanceResponse, error) {
+	out := new(GetBalanceResponse)
+	err := c.cc.Invoke(ctx, "/userbalance.v1.UserBalanceService/GetBalance", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userBalanceServiceClient) ChangeBalance(ctx context.Context, in *ChangeBalanceRequest, opts ...grpc.CallOption) (*ChangeBalanceResponse, error) {
+	out := new(ChangeBalanceResponse)
+	err := c.cc.Invoke(ctx, "/userbalance.v1.UserBalanceService/ChangeBalance", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userBalanceServiceClient) Transfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (*TransferResponse, error) {
+	out := new(TransferResponse)
+	err := c.cc.Invoke(ctx, "/userbalance.v1.UserBalanceService/Transfer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userBalanceServiceClient) ListTransactionLogs(ctx context.Context, in *ListTransactionLogsRequest, opts ...grpc.CallOption) (*ListTransactionLogsResponse, error) {
+	out := new(ListTransactionLogsResponse)
+	err := c.cc.Invoke(ctx, "/userbalance.v1.UserBalanceService/ListTransactionLogs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UserBalanceServiceServer is the server API for UserBalanceService service.
+// All implementations must embed UnimplementedUserBalanceServiceServer
+// for forward compatibility
+type UserBalanceServiceServer interface {
+	// GetBalance returns the balance of a user, optionally converted to another currency.
+	GetBalance(context.Context, *GetBalanceRequest) (*GetBalanceResponse, error)
+	// ChangeBalance credits a positive or debits a negative amount, a credit creates a missing balance.
+	ChangeBalance(context.Context, *ChangeBalanceRequest) (*ChangeBalanceResponse, error)
+	// Transfer moves money from one user to another.
+	Transfer(context.Context, *TransferRequest) (*TransferResponse, error)
+	// ListTransactionLogs returns a page of the transaction logs of a user.
+	ListTransactionLogs(context.Context, *ListTransactionLogsRequest) (*ListTransactionLogsResponse, error)
+	mustEmbedUnimplementedUserBalanceServiceServer()
+}
+
+// UnimplementedUserBalanceServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedUserBalanceServiceServer struct {
+}
+
+func (UnimplementedUserBalanceServiceServer) GetBalance(context.Context, *GetBalanceRequest) (*GetBalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBalance not implemented")
+}
+func (UnimplementedUserBalanceServiceServer) ChangeBalance(context.Context, *ChangeBalanceRequest) (*ChangeBalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ChangeBalance not implemented")
+}
+func (UnimplementedUserBalanceServiceServer) Transfer(context.Context, *TransferRequest) (*TransferResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Transfer not implemented")
+}
+func (UnimplementedUserBalanceServiceServer) ListTransactionLogs(context.Context, *ListTransactionLogsRequest) (*ListTransactionLogsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTransactionLogs not implemented")
+}
+func (UnimplementedUserBalanceServiceServer) mustEmbedUnimplementedUserBalanceServiceServer() {}
+
+// UnsafeUserBalanceServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to UserBalanceServiceServer will
+// result in compilation errors.
+type UnsafeUserBalanceServiceServer interface {
+	mustEmbedUnimplementedUserBalanceServiceServer()
+}
+
+func RegisterUserBalanceServiceServer(s grpc.ServiceRegistrar, srv UserBalanceServiceServer) {
+	s.RegisterService(&UserBalanceService_ServiceDesc, srv)
+}
+
+func _UserBalanceService_GetBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserBalanceServiceServer).GetBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userbalance.v1.UserBalanceService/GetBalance",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserBalanceServiceServer).GetBalance(ctx, req.(*GetBalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserBalanceService_ChangeBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChangeBalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserBalanceServiceServer).ChangeBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userbalance.v1.UserBalanceService/ChangeBalance",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserBalanceServiceServer).ChangeBalance(ctx, req.(*ChangeBalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserBalanceService_Transfer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserBalanceServiceServer).Transfer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userbalance.v1.UserBalanceService/Transfer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserBalanceServiceServer).Transfer(ctx, req.(*TransferRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserBalanceService_ListTransactionLogs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTransactionLogsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserBalanceServiceServer).ListTransactionLogs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userbalance.v1.UserBalanceService/ListTransactionLogs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserBalanceServiceServer).ListTransactionLogs(ctx, req.(*ListTransactionLogsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// UserBalanceService_ServiceDesc is the grpc.ServiceDesc for UserBalanceService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var UserBalanceService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "userbalance.v1.UserBalanceService",
+	HandlerType: (*UserBalanceServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetBalance",
+			Handler:    _UserBalanceService_GetBalance_Handler,
+		},
+		{
+			MethodName: "ChangeBalance",
+			Handler:    _UserBalanceService_ChangeBalance_Handler,
+		},
+		{
+			MethodName: "Transfer",
+			Handler:    _UserBalanceService_Transfer_Handler,
+		},
+		{
+			MethodName: "ListTransactionLogs",
+			Handler:    _UserBalanceService_ListTransactionLogs_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "userbalance/v1/user_balance.proto",
+}
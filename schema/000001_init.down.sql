@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS transaction_log;
+DROP TABLE IF EXISTS user_balance;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS user_balance
+(
+    user_id uuid PRIMARY KEY,
+    balance numeric(14, 2) NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS transaction_log
+(
+    id         serial PRIMARY KEY,
+    user_id    uuid           NOT NULL,
+    date       timestamptz    NOT NULL DEFAULT now(),
+    amount     numeric(14, 2) NOT NULL,
+    commentary text           NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS transaction_log_user_id_idx ON transaction_log (user_id);
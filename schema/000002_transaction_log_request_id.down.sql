@@ -0,0 +1,2 @@
+ALTER TABLE transaction_log
+    DROP COLUMN IF EXISTS request_id;
@@ -0,0 +1,2 @@
+ALTER TABLE transaction_log
+    ADD COLUMN IF NOT EXISTS request_id varchar(64) NOT NULL DEFAULT '';
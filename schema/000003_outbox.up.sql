@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS outbox_event
+(
+    id            bigserial PRIMARY KEY,
+    user_id       uuid        NOT NULL,
+    event_type    varchar(64) NOT NULL,
+    payload       jsonb       NOT NULL,
+    request_id    varchar(64) NOT NULL DEFAULT '',
+    created_at    timestamptz NOT NULL DEFAULT now(),
+    dispatched_at timestamptz,
+    attempts      integer     NOT NULL DEFAULT 0,
+    last_error    text        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS outbox_event_pending_idx ON outbox_event (id) WHERE dispatched_at IS NULL;
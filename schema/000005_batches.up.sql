@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS batch
+(
+    id              uuid PRIMARY KEY,
+    idempotency_key varchar(255) UNIQUE,
+    request_hash    char(64)    NOT NULL,
+    result          jsonb       NOT NULL DEFAULT '[]',
+    created_at      timestamptz NOT NULL DEFAULT now()
+);
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS scheduled_transfer
+(
+    id           uuid PRIMARY KEY,
+    sender_id    uuid           NOT NULL,
+    receiver_id  uuid           NOT NULL,
+    amount       numeric(14, 2) NOT NULL,
+    recurrence   varchar(16)    NOT NULL DEFAULT 'once',
+    day_of_month integer        NOT NULL DEFAULT 0,
+    next_run_at  timestamptz    NOT NULL,
+    retry_at     timestamptz,
+    retries      integer        NOT NULL DEFAULT 0,
+    status       varchar(16)    NOT NULL DEFAULT 'active',
+    last_error   text           NOT NULL DEFAULT '',
+    created_at   timestamptz    NOT NULL DEFAULT now(),
+    updated_at   timestamptz    NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_transfer_due_idx ON scheduled_transfer (COALESCE(retry_at, next_run_at))
+    WHERE status = 'active';
+
+CREATE TABLE IF NOT EXISTS scheduled_transfer_execution
+(
+    id                    bigserial PRIMARY KEY,
+    scheduled_transfer_id uuid        NOT NULL REFERENCES scheduled_transfer (id) ON DELETE CASCADE,
+    scheduled_for         timestamptz NOT NULL,
+    executed_at           timestamptz NOT NULL DEFAULT now(),
+    status                varchar(16) NOT NULL,
+    error                 text        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_transfer_execution_transfer_id_idx
+    ON scheduled_transfer_execution (scheduled_transfer_id);
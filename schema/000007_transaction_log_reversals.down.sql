@@ -0,0 +1,7 @@
+ALTER TABLE transaction_log
+    DROP COLUMN IF EXISTS reversal_status,
+    DROP COLUMN IF EXISTS reversed_amount,
+    DROP COLUMN IF EXISTS reversal_of,
+    DROP COLUMN IF EXISTS related_log_id,
+    DROP COLUMN IF EXISTS counterparty_id,
+    DROP COLUMN IF EXISTS operation_type;
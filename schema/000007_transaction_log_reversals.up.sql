@@ -0,0 +1,16 @@
+ALTER TABLE transaction_log
+    ADD COLUMN IF NOT EXISTS operation_type  varchar(24)    NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS counterparty_id uuid,
+    ADD COLUMN IF NOT EXISTS related_log_id  integer REFERENCES transaction_log (id),
+    ADD COLUMN IF NOT EXISTS reversal_of     integer REFERENCES transaction_log (id),
+    ADD COLUMN IF NOT EXISTS reversed_amount numeric(14, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS reversal_status varchar(24)    NOT NULL DEFAULT '';
+
+-- entries written before operation types existed are classified by their commentary
+UPDATE transaction_log SET operation_type = 'credit' WHERE operation_type = '' AND commentary LIKE 'Added %';
+UPDATE transaction_log SET operation_type = 'debit' WHERE operation_type = '' AND commentary LIKE 'Substracted %';
+UPDATE transaction_log SET operation_type = 'transfer_out' WHERE operation_type = '' AND commentary LIKE 'Sended %';
+UPDATE transaction_log SET operation_type = 'transfer_in' WHERE operation_type = '' AND commentary LIKE 'Received %';
+
+CREATE INDEX IF NOT EXISTS transaction_log_related_log_id_idx ON transaction_log (related_log_id);
+CREATE INDEX IF NOT EXISTS transaction_log_reversal_of_idx ON transaction_log (reversal_of);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS account_status_change;
+
+ALTER TABLE user_balance
+    DROP COLUMN IF EXISTS status;
@@ -0,0 +1,15 @@
+ALTER TABLE user_balance
+    ADD COLUMN IF NOT EXISTS status varchar(16) NOT NULL DEFAULT 'active';
+
+CREATE TABLE IF NOT EXISTS account_status_change
+(
+    id          bigserial PRIMARY KEY,
+    user_id     uuid         NOT NULL REFERENCES user_balance (user_id),
+    from_status varchar(16)  NOT NULL,
+    to_status   varchar(16)  NOT NULL,
+    reason      text         NOT NULL,
+    operator_id varchar(255) NOT NULL,
+    changed_at  timestamptz  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS account_status_change_user_id_idx ON account_status_change (user_id, changed_at);
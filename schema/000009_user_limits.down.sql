@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS transaction_log_user_id_date_idx;
+
+DROP TABLE IF EXISTS user_limit;
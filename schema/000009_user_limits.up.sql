@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS user_limit
+(
+    user_id            uuid PRIMARY KEY REFERENCES user_balance (user_id),
+    tier               varchar(64),
+    max_transfer       numeric(14, 2),
+    daily_outgoing     numeric(14, 2),
+    monthly_outgoing   numeric(14, 2),
+    max_balance        numeric(14, 2),
+    transfers_per_hour integer,
+    updated_by         varchar(255) NOT NULL DEFAULT '',
+    updated_at         timestamptz  NOT NULL DEFAULT now()
+);
+
+-- outgoing totals and transfer counts are summed over recent entries of a user
+CREATE INDEX IF NOT EXISTS transaction_log_user_id_date_idx ON transaction_log (user_id, date);
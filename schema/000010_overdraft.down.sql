@@ -0,0 +1,8 @@
+ALTER TABLE transaction_log
+    DROP COLUMN IF EXISTS grace_ends_at;
+
+ALTER TABLE user_balance
+    DROP CONSTRAINT IF EXISTS user_balance_balance_check,
+    DROP CONSTRAINT IF EXISTS user_balance_overdraft_limit_check,
+    DROP COLUMN IF EXISTS overdrawn_since,
+    DROP COLUMN IF EXISTS overdraft_limit;
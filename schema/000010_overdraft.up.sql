@@ -0,0 +1,8 @@
+ALTER TABLE user_balance
+    ADD COLUMN IF NOT EXISTS overdraft_limit numeric(14, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS overdrawn_since timestamptz,
+    ADD CONSTRAINT user_balance_overdraft_limit_check CHECK (overdraft_limit >= 0),
+    ADD CONSTRAINT user_balance_balance_check CHECK (balance >= -overdraft_limit);
+
+ALTER TABLE transaction_log
+    ADD COLUMN IF NOT EXISTS grace_ends_at timestamptz;
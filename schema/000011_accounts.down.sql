@@ -0,0 +1,4 @@
+ALTER TABLE user_balance
+    DROP COLUMN IF EXISTS created_at,
+    DROP COLUMN IF EXISTS metadata,
+    DROP COLUMN IF EXISTS currency;
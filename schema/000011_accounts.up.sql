@@ -0,0 +1,4 @@
+ALTER TABLE user_balance
+    ADD COLUMN IF NOT EXISTS currency   varchar(3)  NOT NULL DEFAULT 'RUB',
+    ADD COLUMN IF NOT EXISTS metadata   jsonb       NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now();
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS user_balance_created_at_idx;
+DROP INDEX IF EXISTS user_balance_balance_idx;
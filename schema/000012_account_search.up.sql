@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS user_balance_balance_idx ON user_balance (balance, user_id);
+CREATE INDEX IF NOT EXISTS user_balance_created_at_idx ON user_balance (created_at, user_id);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS balance_snapshot
+(
+    user_id    uuid           NOT NULL REFERENCES user_balance (user_id),
+    day        date           NOT NULL,
+    as_of      timestamptz    NOT NULL,
+    balance    numeric(14, 2) NOT NULL,
+    created_at timestamptz    NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS balance_snapshot_as_of_idx ON balance_snapshot (user_id, as_of);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS reconciliation_mismatch;
+DROP TABLE IF EXISTS reconciliation_run;
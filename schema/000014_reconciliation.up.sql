@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS reconciliation_run
+(
+    id               uuid PRIMARY KEY,
+    trigger          varchar(16)    NOT NULL,
+    sample_size      integer        NOT NULL DEFAULT 0,
+    status           varchar(16)    NOT NULL,
+    checked          integer        NOT NULL DEFAULT 0,
+    mismatched       integer        NOT NULL DEFAULT 0,
+    total_difference numeric(14, 2) NOT NULL DEFAULT 0,
+    error            text           NOT NULL DEFAULT '',
+    started_at       timestamptz    NOT NULL,
+    finished_at      timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS reconciliation_run_started_at_idx ON reconciliation_run (trigger, started_at);
+
+CREATE TABLE IF NOT EXISTS reconciliation_mismatch
+(
+    id                bigserial PRIMARY KEY,
+    run_id            uuid           NOT NULL REFERENCES reconciliation_run (id) ON DELETE CASCADE,
+    user_id           uuid           NOT NULL REFERENCES user_balance (user_id),
+    balance           numeric(14, 2) NOT NULL,
+    logged_balance    numeric(14, 2) NOT NULL,
+    difference        numeric(14, 2) NOT NULL,
+    status            varchar(16)    NOT NULL DEFAULT 'open',
+    resolved_by       varchar(255)   NOT NULL DEFAULT '',
+    resolved_at       timestamptz,
+    correction_log_id integer REFERENCES transaction_log (id)
+);
+
+CREATE INDEX IF NOT EXISTS reconciliation_mismatch_run_id_idx ON reconciliation_mismatch (run_id, id);
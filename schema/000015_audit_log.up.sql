@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id                  bigserial PRIMARY KEY,
+    protocol            varchar(8)   NOT NULL,
+    method              varchar(64)  NOT NULL,
+    route               varchar(255) NOT NULL,
+    path                text         NOT NULL,
+    caller_id           varchar(255) NOT NULL DEFAULT '',
+    admin               boolean      NOT NULL DEFAULT false,
+    source_ip           varchar(64)  NOT NULL DEFAULT '',
+    user_agent          text         NOT NULL DEFAULT '',
+    request_id          varchar(64)  NOT NULL DEFAULT '',
+    body                jsonb,
+    status              integer      NOT NULL,
+    transaction_log_ids jsonb        NOT NULL DEFAULT '[]',
+    created_at          timestamptz  NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS audit_log_caller_id_idx ON audit_log (caller_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_log_request_id_idx ON audit_log (request_id);
+CREATE INDEX IF NOT EXISTS audit_log_transaction_log_ids_idx ON audit_log USING gin (transaction_log_ids);
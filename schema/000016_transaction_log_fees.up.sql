@@ -0,0 +1,4 @@
+ALTER TABLE transaction_log
+    ADD COLUMN IF NOT EXISTS fee_of integer REFERENCES transaction_log (id);
+
+CREATE INDEX IF NOT EXISTS transaction_log_fee_of_idx ON transaction_log (fee_of);
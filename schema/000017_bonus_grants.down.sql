@@ -0,0 +1 @@
+DROP TABLE IF EXISTS bonus_grant;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS bonus_grant
+(
+    id                 bigserial PRIMARY KEY,
+    user_id            uuid           NOT NULL REFERENCES user_balance (user_id),
+    amount             numeric(14, 2) NOT NULL CHECK (amount > 0),
+    remaining          numeric(14, 2) NOT NULL CHECK (remaining >= 0),
+    reason             text           NOT NULL DEFAULT '',
+    expires_at         timestamptz    NOT NULL,
+    created_at         timestamptz    NOT NULL DEFAULT now(),
+    transaction_log_id integer        NOT NULL REFERENCES transaction_log (id),
+    expired_at         timestamptz,
+    expired_amount     numeric(14, 2) NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS bonus_grant_user_id_idx ON bonus_grant (user_id) WHERE expired_at IS NULL;
+CREATE INDEX IF NOT EXISTS bonus_grant_expires_at_idx ON bonus_grant (expires_at) WHERE expired_at IS NULL;
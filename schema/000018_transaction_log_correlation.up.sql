@@ -0,0 +1,5 @@
+ALTER TABLE transaction_log
+    ADD COLUMN IF NOT EXISTS correlation_id uuid;
+
+CREATE INDEX IF NOT EXISTS transaction_log_correlation_id_idx ON transaction_log (correlation_id)
+    WHERE correlation_id IS NOT NULL;
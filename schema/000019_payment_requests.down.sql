@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS payment_request_event;
+DROP TABLE IF EXISTS payment_request;
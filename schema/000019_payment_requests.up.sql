@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS payment_request
+(
+    id           uuid PRIMARY KEY,
+    requester_id uuid           NOT NULL REFERENCES user_balance (user_id),
+    payer_id     uuid           NOT NULL REFERENCES user_balance (user_id),
+    amount       numeric(14, 2) NOT NULL CHECK (amount > 0),
+    note         text           NOT NULL DEFAULT '',
+    status       varchar(16)    NOT NULL DEFAULT 'pending',
+    expires_at   timestamptz    NOT NULL,
+    created_at   timestamptz    NOT NULL DEFAULT now(),
+    updated_at   timestamptz    NOT NULL DEFAULT now(),
+    CHECK (requester_id <> payer_id)
+);
+
+CREATE INDEX IF NOT EXISTS payment_request_payer_id_idx ON payment_request (payer_id, created_at);
+CREATE INDEX IF NOT EXISTS payment_request_requester_id_idx ON payment_request (requester_id, created_at);
+CREATE INDEX IF NOT EXISTS payment_request_expires_at_idx ON payment_request (expires_at) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS payment_request_event
+(
+    id                 bigserial PRIMARY KEY,
+    payment_request_id uuid        NOT NULL REFERENCES payment_request (id) ON DELETE CASCADE,
+    from_status        varchar(16) NOT NULL DEFAULT '',
+    to_status          varchar(16) NOT NULL,
+    actor_id           uuid,
+    reason             text        NOT NULL DEFAULT '',
+    created_at         timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS payment_request_event_request_id_idx ON payment_request_event (payment_request_id);
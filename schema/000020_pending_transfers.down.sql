@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS pending_transfer;
+
+ALTER TABLE user_balance
+    DROP COLUMN IF EXISTS held;
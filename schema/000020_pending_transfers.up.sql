@@ -0,0 +1,24 @@
+ALTER TABLE user_balance
+    ADD COLUMN IF NOT EXISTS held numeric(14, 2) NOT NULL DEFAULT 0 CHECK (held >= 0);
+
+CREATE TABLE IF NOT EXISTS pending_transfer
+(
+    id                 uuid PRIMARY KEY,
+    sender_id          uuid           NOT NULL REFERENCES user_balance (user_id),
+    receiver_id        uuid           NOT NULL REFERENCES user_balance (user_id),
+    amount             numeric(14, 2) NOT NULL CHECK (amount > 0),
+    captured_amount    numeric(14, 2) NOT NULL DEFAULT 0 CHECK (captured_amount >= 0 AND captured_amount <= amount),
+    reference          text           NOT NULL DEFAULT '',
+    status             varchar(16)    NOT NULL DEFAULT 'authorized',
+    void_reason        text           NOT NULL DEFAULT '',
+    transaction_log_id integer REFERENCES transaction_log (id),
+    expires_at         timestamptz    NOT NULL,
+    created_at         timestamptz    NOT NULL DEFAULT now(),
+    updated_at         timestamptz    NOT NULL DEFAULT now(),
+    CHECK (sender_id <> receiver_id)
+);
+
+CREATE INDEX IF NOT EXISTS pending_transfer_receiver_id_idx ON pending_transfer (receiver_id)
+    WHERE status = 'authorized';
+CREATE INDEX IF NOT EXISTS pending_transfer_expires_at_idx ON pending_transfer (expires_at)
+    WHERE status = 'authorized';
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS escrow
+(
+    id              uuid PRIMARY KEY,
+    deal_id         varchar(255)   NOT NULL UNIQUE,
+    buyer_id        uuid           NOT NULL REFERENCES user_balance (user_id),
+    seller_id       uuid           NOT NULL REFERENCES user_balance (user_id),
+    account_id      uuid           NOT NULL REFERENCES user_balance (user_id),
+    amount          numeric(14, 2) NOT NULL CHECK (amount > 0),
+    released_amount numeric(14, 2) NOT NULL DEFAULT 0 CHECK (released_amount >= 0),
+    refunded_amount numeric(14, 2) NOT NULL DEFAULT 0 CHECK (refunded_amount >= 0),
+    status          varchar(16)    NOT NULL DEFAULT 'funded',
+    funding_log_id  integer REFERENCES transaction_log (id),
+    created_at      timestamptz    NOT NULL DEFAULT now(),
+    updated_at      timestamptz    NOT NULL DEFAULT now(),
+    settled_at      timestamptz,
+    CHECK (buyer_id <> seller_id),
+    CHECK (released_amount + refunded_amount <= amount)
+);
+
+CREATE INDEX IF NOT EXISTS escrow_buyer_id_idx ON escrow (buyer_id);
+CREATE INDEX IF NOT EXISTS escrow_seller_id_idx ON escrow (seller_id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS balance_snapshot_day
+(
+    day       date        NOT NULL PRIMARY KEY,
+    snapshots integer     NOT NULL,
+    taken_at  timestamptz NOT NULL DEFAULT now()
+);
+
+INSERT INTO balance_snapshot_day (day, snapshots)
+SELECT bs.day, COUNT(*) FROM balance_snapshot AS bs GROUP BY bs.day
+ON CONFLICT (day) DO NOTHING;
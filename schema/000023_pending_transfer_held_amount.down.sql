@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS pending_transfer_sender_id_idx;
+
+ALTER TABLE pending_transfer
+    DROP COLUMN IF EXISTS held_amount,
+    DROP COLUMN IF EXISTS fee;
@@ -0,0 +1,14 @@
+ALTER TABLE pending_transfer
+    ADD COLUMN IF NOT EXISTS fee         numeric(14, 2) NOT NULL DEFAULT 0 CHECK (fee >= 0),
+    ADD COLUMN IF NOT EXISTS held_amount numeric(14, 2) NOT NULL DEFAULT 0;
+
+-- transfers authorized so far held their amount alone
+UPDATE pending_transfer
+SET held_amount = amount
+WHERE held_amount = 0;
+
+ALTER TABLE pending_transfer
+    ADD CHECK (held_amount = amount + fee);
+
+CREATE INDEX IF NOT EXISTS pending_transfer_sender_id_idx ON pending_transfer (sender_id)
+    WHERE status = 'authorized';
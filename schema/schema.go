@@ -0,0 +1,35 @@
+// Package schema holds the database migrations applied with golang-migrate.
+package schema
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var Migrations embed.FS
+
+// ExpectedVersion returns the version of the latest migration shipped with the binary.
+func ExpectedVersion() (uint, error) {
+	files, err := fs.Glob(Migrations, "*.up.sql")
+	if err != nil {
+		return 0, err
+	}
+
+	var expected uint
+	for _, file := range files {
+		prefix := strings.SplitN(file, "_", 2)[0]
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("migration %s has no numeric version: %w", file, err)
+		}
+		if uint(version) > expected {
+			expected = uint(version)
+		}
+	}
+
+	return expected, nil
+}